@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+// Collects the anomaly events of a scan for a report written afterwards
+type anomalyCollector struct {
+	mu        sync.Mutex
+	Anomalies []yaffs.Event
+}
+
+func (c *anomalyCollector) Handle(e yaffs.Event) {
+	if e.Type != yaffs.EVENT_ANOMALY {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Anomalies = append(c.Anomalies, e)
+}
+
+type anomalyRecord struct {
+	Kind     yaffs.AnomalyKind `json:"kind"`
+	Chunk    int               `json:"chunk"`
+	Offset   int64             `json:"offset"`
+	ObjectID uint32            `json:"object_id,omitempty"`
+	Message  string            `json:"message"`
+}
+
+// Write all collected anomalies as a JSON array or as CSV with a header row
+func (c *anomalyCollector) Write(w io.Writer, format string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	records := make([]anomalyRecord, 0, len(c.Anomalies))
+	for _, e := range c.Anomalies {
+		records = append(records, anomalyRecord{Kind: e.Anomaly, Chunk: e.Chunk, Offset: e.Offset, ObjectID: e.ObjectID, Message: e.Message})
+	}
+
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	case "csv":
+		cw := csv.NewWriter(w)
+		err := cw.Write([]string{"kind", "chunk", "offset", "object_id", "message"})
+		if err != nil {
+			return err
+		}
+		for _, r := range records {
+			objectID := ""
+			if r.ObjectID != 0 {
+				objectID = strconv.FormatUint(uint64(r.ObjectID), 10)
+			}
+			err = cw.Write([]string{string(r.Kind), strconv.Itoa(r.Chunk), strconv.FormatInt(r.Offset, 10), objectID, r.Message})
+			if err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return fmt.Errorf("unknown anomaly report format %q, expected json or csv", format)
+}
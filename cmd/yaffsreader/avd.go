@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+// Name of the virtual device written by -avd
+const AVD_NAME = "yaffsreader"
+
+// System images before Jelly Bean mount a YAFFS2 data partition from the
+// emulated NAND, later ones an ext4 image
+const AVD_MINIMUM_API = 16
+
+// Platform version in /data/system/packages.xml: sdkVersion of the
+// internal volume since Android 4.2, last-platform-version before
+var packagesSDKVersion = regexp.MustCompile(`<version[^>]*\bsdkVersion="(\d+)"|<last-platform-version[^>]*\binternal="(\d+)"`)
+
+// CPU architecture of the emulator per system image ABI
+var avdCPUArchs = map[string]string{
+	"armeabi-v7a": "arm",
+	"arm64-v8a":   "arm64",
+	"x86":         "x86",
+	"x86_64":      "x86_64",
+}
+
+func avdABINames() []string {
+	var names []string
+	for abi := range avdCPUArchs {
+		names = append(names, abi)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Write an Android Virtual Device to dir, an AVD home directory as set by
+// ANDROID_AVD_HOME: the live tree as the ext4 data partition and a
+// configuration booting it with the system image of apiLevel and abi from
+// the SDK. A zero apiLevel is taken from the packages.xml of the dump.
+func writeAVD(dir string, result *yaffs.ScanResult, apiLevel int, abi string, size int64) error {
+	cpuArch, ok := avdCPUArchs[abi]
+	if !ok {
+		return fmt.Errorf("unknown ABI %q, expected armeabi-v7a, arm64-v8a, x86 or x86_64", abi)
+	}
+	if apiLevel == 0 {
+		var err error
+		apiLevel, err = dumpAPILevel(result)
+		if err != nil {
+			return fmt.Errorf("%w, give the API level with -avd-api", err)
+		}
+	}
+	if apiLevel < AVD_MINIMUM_API {
+		return fmt.Errorf("API level %d: system images before API %d expect a YAFFS2 data partition, not ext4", apiLevel, AVD_MINIMUM_API)
+	}
+
+	avdDir := filepath.Join(dir, AVD_NAME+".avd")
+	err := os.MkdirAll(avdDir, 0777)
+	if err != nil {
+		return err
+	}
+	userdata := filepath.Join(avdDir, "userdata-qemu.img")
+	err = writeExt4(userdata, result, size)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(userdata)
+	if err != nil {
+		return err
+	}
+
+	absolute, err := filepath.Abs(avdDir)
+	if err != nil {
+		return err
+	}
+	target := fmt.Sprintf("android-%d", apiLevel)
+	err = writeINI(filepath.Join(dir, AVD_NAME+".ini"), [][2]string{
+		{"avd.ini.encoding", "UTF-8"},
+		{"path", absolute},
+		{"path.rel", "avd/" + AVD_NAME + ".avd"},
+		{"target", target},
+	})
+	if err != nil {
+		return err
+	}
+	return writeINI(filepath.Join(avdDir, "config.ini"), [][2]string{
+		{"avd.ini.encoding", "UTF-8"},
+		{"AvdId", AVD_NAME},
+		{"avd.ini.displayname", AVD_NAME},
+		{"abi.type", abi},
+		{"hw.cpu.arch", cpuArch},
+		{"image.sysdir.1", fmt.Sprintf("system-images/%s/default/%s/", target, abi)},
+		{"tag.id", "default"},
+		{"tag.display", "Default"},
+		{"target", target},
+		{"disk.dataPartition.size", strconv.FormatInt(info.Size(), 10)},
+		{"PlayStore.enabled", "false"},
+	})
+}
+
+// API level the dump was last booted with, from the package manager state
+func dumpAPILevel(result *yaffs.ScanResult) (int, error) {
+	id, ok := findObject(result.Tree, "/system/packages.xml")
+	if !ok {
+		return 0, fmt.Errorf("no /system/packages.xml to take the API level from")
+	}
+	content, err := result.ObjectContent(id)
+	if err != nil {
+		return 0, err
+	}
+	match := packagesSDKVersion.FindSubmatch(content)
+	if match == nil {
+		return 0, fmt.Errorf("no platform version in /system/packages.xml")
+	}
+	level := string(match[1]) + string(match[2])
+	return strconv.Atoi(level)
+}
+
+func writeINI(name string, entries [][2]string) error {
+	var b strings.Builder
+	for _, entry := range entries {
+		fmt.Fprintf(&b, "%s=%s\n", entry[0], entry[1])
+	}
+	return os.WriteFile(name, []byte(b.String()), 0666)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+// Write a TSK 3.x bodyfile line per object in its current state, for
+// mactime and Plaso. Like fls -m, the MD5 column is 0 and deleted names
+// carry a (deleted) suffix. YAFFS records no creation time, crtime is 0.
+func writeBodyfile(w io.Writer, entries []yaffs.ListEntry, byteOrder binary.ByteOrder) error {
+	for k := range entries {
+		entry := &entries[k]
+		if entry.Obsolete {
+			continue
+		}
+		m := entry.Metadata(byteOrder)
+		name := m.Path
+		if m.Alias != "" && entry.Header.ObjectType == yaffs.YAFFS_OBJECT_TYPE_SYMLINK {
+			name += " -> " + m.Alias
+		}
+		if m.Deleted {
+			name += " (deleted)"
+		}
+		_, err := fmt.Fprintf(w, "0|%s|%d|%s|%d|%d|%d|%d|%d|%d|0\n",
+			bodyfileEscape(name), m.ObjectID, bodyfileMode(entry.Header), m.UID, m.GID, m.Size, m.AccessTime, m.ModTime, m.CreateTime)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Mode in the fls notation of name and metadata type, e.g. r/rrw-r--r--
+func bodyfileMode(header *yaffs.ObjectHeader) string {
+	mode := []byte(modeString(header))
+	switch mode[0] {
+	case '-', 'h':
+		mode[0] = 'r'
+	case '?':
+		mode[0] = '-'
+	}
+	return string(mode[0]) + "/" + string(mode)
+}
+
+// Pipes separate the fields and newlines the records, so both are escaped
+// in names the way fls does
+func bodyfileEscape(name string) string {
+	var b []byte
+	for i := 0; i < len(name); i++ {
+		switch name[i] {
+		case '|':
+			b = append(b, `\|`...)
+		case '\n':
+			b = append(b, `\n`...)
+		default:
+			b = append(b, name[i])
+		}
+	}
+	return string(b)
+}
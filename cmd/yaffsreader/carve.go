@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+// Number of consecutive non-YAFFS pages that end a region. Erased pages
+// are free space and never end a region.
+const REGION_GAP_PAGES = 64
+
+type Region struct {
+	Start     int64
+	End       int64
+	Pages     int
+	Headers   []string
+	Names     []string
+	Partition string
+}
+
+// Walk the whole image with the given geometry and collect every run of
+// pages carrying valid tags
+func findRegions(image io.ReadSeeker, settings *yaffs.Settings) ([]*Region, error) {
+
+	_, err := image.Seek(0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var regions []*Region
+	var current *Region
+	var foreign int
+
+	chunkSize := int64(settings.PageSize + settings.SpareSize)
+
+	it := yaffs.NewChunkIterator(image, settings)
+	for it.Next() {
+		chunk := it.Chunk()
+		page := int64(chunk.Index)
+
+		if chunk.Erased {
+			continue
+		}
+
+		spare := chunk.Spare
+		if spare == nil {
+			foreign++
+			if current != nil && foreign >= REGION_GAP_PAGES {
+				regions = append(regions, current)
+				current = nil
+			}
+			continue
+		}
+
+		foreign = 0
+		if current == nil {
+			current = &Region{Start: page * chunkSize}
+		}
+		current.End = (page + 1) * chunkSize
+		current.Pages++
+
+		if spare.ChunkID == 0 && yaffs.LooksLikeHeader(chunk.Data, settings.ByteOrder) {
+			header, err := yaffs.ParseHeader(chunk.Data, settings.ByteOrder)
+			if err != nil {
+				return nil, err
+			}
+			current.Headers = append(current.Headers, header.Line(timeFormat))
+			current.Names = append(current.Names, yaffs.CToGoString(header.Name[:]))
+		}
+	}
+	if it.Err() != nil {
+		return nil, it.Err()
+	}
+
+	if current != nil {
+		regions = append(regions, current)
+	}
+
+	return regions, nil
+}
+
+// Guess the Android partition a region held from well-known entries
+func identifyPartition(region *Region) string {
+	var has = make(map[string]bool)
+	for _, name := range region.Names {
+		has[name] = true
+	}
+
+	switch {
+	case has["build.prop"]:
+		return "system"
+	case has["dalvik-cache"] || (has["app"] && has["data"] && has["misc"]):
+		return "userdata"
+	case has["recovery"]:
+		return "cache"
+	}
+	return ""
+}
+
+// Write one subdirectory per region containing a report and a TSK config
+// for use with the region offset
+func writePartitionReports(outDir string, regions []*Region, settings *yaffs.Settings) error {
+
+	var used = make(map[string]int)
+
+	for i, region := range regions {
+		name := identifyPartition(region)
+		if name == "" {
+			name = fmt.Sprintf("partition%d", i)
+		}
+		used[name]++
+		if used[name] > 1 {
+			name = fmt.Sprintf("%s-%d", name, used[name])
+		}
+		region.Partition = name
+
+		dir := filepath.Join(outDir, name)
+		err := os.MkdirAll(dir, 0777)
+		if err != nil {
+			return err
+		}
+
+		report := fmt.Sprintf("Partition: %s\nStart offset: %d\nEnd offset: %d\nPages: %d\nHeaders: %d\n\n%s\n",
+			name, region.Start, region.End, region.Pages, len(region.Headers), strings.Join(region.Headers, "\n"))
+
+		err = ioutil.WriteFile(filepath.Join(dir, "report.txt"), []byte(report), 0666)
+		if err != nil {
+			return err
+		}
+
+		err = ioutil.WriteFile(filepath.Join(dir, "yaffs2.config"), []byte(yaffs.TSKConfig(settings)), 0666)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Region %d at offset %d (%d pages) written to %s", i, region.Start, region.Pages, dir)
+	}
+
+	return nil
+}
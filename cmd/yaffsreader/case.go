@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+var caseContext = map[string]string{
+	"kb":             "http://example.org/kb/",
+	"uco-core":       "https://ontology.unifiedcyberontology.org/uco/core/",
+	"uco-observable": "https://ontology.unifiedcyberontology.org/uco/observable/",
+	"uco-types":      "https://ontology.unifiedcyberontology.org/uco/types/",
+	"uco-vocabulary": "https://ontology.unifiedcyberontology.org/uco/vocabulary/",
+	"xsd":            "http://www.w3.org/2001/XMLSchema#",
+}
+
+type caseNode map[string]interface{}
+
+// Name based UUID, so exporting the same dump twice yields the same
+// identifiers and graphs of several dumps do not collide
+func caseID(kind, imagePath string, objectID uint32, facet string) string {
+	sum := sha1.Sum([]byte(fmt.Sprintf("%s\x00%d\x00%s", imagePath, objectID, facet)))
+	sum[6] = sum[6]&0x0f | 0x50
+	sum[8] = sum[8]&0x3f | 0x80
+	return fmt.Sprintf("kb:%s-%x-%x-%x-%x-%x", kind, sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+func caseTime(timestamp uint32) caseNode {
+	return caseNode{"@type": "xsd:dateTime", "@value": time.Unix(int64(timestamp), 0).UTC().Format(time.RFC3339)}
+}
+
+// Write the objects in their current state as CASE/UCO observable File
+// objects in one JSON-LD graph: a FileFacet with name, path, size,
+// timestamps and allocation status, and for files a ContentDataFacet with
+// the SHA-256 of the content
+func writeCASE(w io.Writer, imagePath string, entries []yaffs.ListEntry, result *yaffs.ScanResult, byteOrder binary.ByteOrder) error {
+	var ids []uint32
+	for k := range entries {
+		if !entries[k].Obsolete {
+			ids = append(ids, entries[k].ObjectID)
+		}
+	}
+	hashes, err := hashObjects(result, ids)
+	if err != nil {
+		return err
+	}
+
+	graph := []caseNode{}
+	for k := range entries {
+		entry := &entries[k]
+		if entry.Obsolete {
+			continue
+		}
+		m := entry.Metadata(byteOrder)
+		status := "Allocated"
+		if m.Deleted {
+			status = "Unallocated"
+		}
+		fileFacet := caseNode{
+			"@id":                               caseID("file-facet", imagePath, m.ObjectID, "file"),
+			"@type":                             "uco-observable:FileFacet",
+			"uco-observable:fileName":           path.Base(m.Path),
+			"uco-observable:filePath":           m.Path,
+			"uco-observable:extension":          path.Ext(m.Path),
+			"uco-observable:fileSystemType":     "YAFFS2",
+			"uco-observable:isDirectory":        entry.Header.ObjectType == yaffs.YAFFS_OBJECT_TYPE_DIRECTORY,
+			"uco-observable:sizeInBytes":        m.Size,
+			"uco-observable:allocationStatus":   status,
+			"uco-observable:modifiedTime":       caseTime(m.ModTime),
+			"uco-observable:accessedTime":       caseTime(m.AccessTime),
+			"uco-observable:metadataChangeTime": caseTime(m.CreateTime),
+		}
+		if path.Ext(m.Path) == "" {
+			delete(fileFacet, "uco-observable:extension")
+		}
+		facets := []caseNode{fileFacet}
+
+		if hash := hashes[m.ObjectID]; hash != "" && hash != "-" {
+			facets = append(facets, caseNode{
+				"@id":                        caseID("content-data-facet", imagePath, m.ObjectID, "content"),
+				"@type":                      "uco-observable:ContentDataFacet",
+				"uco-observable:sizeInBytes": m.Size,
+				"uco-observable:hash": []caseNode{{
+					"@id":                  caseID("hash", imagePath, m.ObjectID, "sha256"),
+					"@type":                "uco-types:Hash",
+					"uco-types:hashMethod": caseNode{"@type": "uco-vocabulary:HashNameVocab", "@value": "SHA256"},
+					"uco-types:hashValue":  caseNode{"@type": "xsd:hexBinary", "@value": hash},
+				}},
+			})
+		}
+
+		graph = append(graph, caseNode{
+			"@id":               caseID("file", imagePath, m.ObjectID, ""),
+			"@type":             "uco-observable:File",
+			"uco-core:hasFacet": facets,
+		})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(caseNode{"@context": caseContext, "@graph": graph})
+}
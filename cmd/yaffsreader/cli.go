@@ -0,0 +1,349 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A subcommand stands for flags of the classic interface, so both spellings
+// keep working: "cat /etc/hosts IMAGE" is "-cat /etc/hosts IMAGE".
+type subcommand struct {
+	Name    string
+	Usage   string // operands after the flags
+	Summary string
+	Flags   []string // accepted besides the input flags
+
+	// Classic flags the command stands for, each %s taking the next operand
+	Args []string
+	// Flags replacing the operands of Args when given, e.g. an archive
+	// instead of a directory
+	Replacing []string
+	// Classic flags by the first operand, e.g. the report kind
+	Kinds map[string]commandKind
+}
+
+type commandKind struct {
+	Usage string // operands after the kind
+	Args  []string
+}
+
+// Flags every command accepts, controlling how images are read and scanned
+var inputFlags = []string{
+	"anomalies", "anomaly-format", "assume-header-at", "byte-order", "detect-jobs", "ecc", "events", "hash-jobs",
+	"header-decoder", "ignore-encryption", "inband", "index-only", "invalid-spares", "jobs",
+	"layout-config", "layout-plugin", "log-format", "max-memory", "max-open-files", "object-ids",
+	"object-space", "oob", "oob-profile", "pages-per-block", "prefetch", "quiet", "s3-cache", "script",
+	"spare-decoder", "spare-map", "spare-offsets", "special-ids", "tag-byte-order",
+	"time-format", "time-zone", "timeout", "transform", "tsk-config", "yaffs1",
+}
+
+var subcommands = []*subcommand{
+	{
+		Name:    "info",
+		Usage:   "IMAGE",
+		Summary: "show the dump format, geometry, ECC scheme and space usage",
+		Args:    []string{"-detect", "-space"},
+	},
+	{
+		Name:    "detect",
+		Usage:   "IMAGE",
+		Summary: "show the dump format, geometry and ECC scheme without scanning",
+		Args:    []string{"-detect"},
+	},
+	{
+		Name:    "scan",
+		Usage:   "IMAGE",
+		Summary: "scan the image and show counts of objects, anomalies and ECC corrections",
+		Args:    []string{"-summary"},
+	},
+	{
+		Name:    "ls",
+		Usage:   "IMAGE",
+		Summary: "list objects like ls -l",
+		Flags: []string{"all-versions", "color", "escape", "format", "max-depth", "max-size", "min-size", "newer-than",
+			"older-than", "reverse", "sort", "type"},
+	},
+	{
+		Name:    "cat",
+		Usage:   "PATH|ID IMAGE",
+		Summary: "write the content of a file to stdout",
+		Args:    []string{"-cat=%s"},
+	},
+	{
+		Name:    "stat",
+		Usage:   "PATH|ID IMAGE",
+		Summary: "show the metadata of an object and where it is stored",
+		Args:    []string{"-stat=%s"},
+	},
+	{
+		Name:    "extract",
+		Usage:   "DIR IMAGE, or -tar FILE IMAGE, or -zip FILE IMAGE",
+		Summary: "write the live tree to a directory or into a tar or ZIP archive",
+		Flags: []string{"dedup", "extract-jobs", "max-size", "min-size", "newer-than", "older-than", "resume", "rewrite-symlinks",
+			"symlink-prefix", "tar", "zip"},
+		Args:      []string{"-extract=%s"},
+		Replacing: []string{"tar", "zip"},
+	},
+	{
+		Name:    "verify",
+		Usage:   "DIR IMAGE",
+		Summary: "compare an extracted tree against the image, one PASS / FAIL line per object",
+		Args:    []string{"-verify=%s"},
+	},
+	{
+		Name:    "mount",
+		Usage:   "MOUNTPOINT IMAGE",
+		Summary: "mount the live tree read-only through FUSE, needs root",
+		Flags:   []string{"mount-deleted", "mount-versions"},
+		Args:    []string{"-mount=%s"},
+	},
+	{
+		Name:    "serve",
+		Usage:   "ADDR IMAGE",
+		Summary: "serve the live tree read-only over HTTP, e.g. at localhost:8080",
+		Flags:   []string{"mount-deleted", "mount-versions"},
+		Args:    []string{"-serve=%s"},
+	},
+	{
+		Name:    "convert",
+		Usage:   "FORMAT FILE IMAGE",
+		Summary: "write the live tree as a filesystem image, emulator device or mtree(8) specification",
+		Flags:   []string{"avd-abi", "avd-api", "ext4-size"},
+		Kinds: map[string]commandKind{
+			"avd":      {"DIR", []string{"-avd=%s"}},
+			"squashfs": {"FILE", []string{"-squashfs=%s"}},
+			"ext4":     {"FILE", []string{"-ext4=%s"}},
+			"mtree":    {"FILE", []string{"-mtree=%s"}},
+		},
+	},
+	{
+		Name:    "report",
+		Usage:   "KIND [ARG] IMAGE...",
+		Summary: "write one of the analysis reports",
+		Flags:   []string{"dedup", "diff-versions", "escape", "grep-binary", "grep-path", "versions-dir"},
+		Kinds: map[string]commandKind{
+			"anomalies":      {"", []string{"-anomalies=-"}},
+			"bodyfile":       {"", []string{"-bodyfile=-"}},
+			"case":           {"", []string{"-case=-"}},
+			"chunk-map":      {"", []string{"-chunk-map=-"}},
+			"damaged":        {"", []string{"-damaged-files"}},
+			"database":       {"FILE", []string{"-sqlite-db=%s"}},
+			"dfxml":          {"", []string{"-dfxml=-"}},
+			"deleted":        {"DIR", []string{"-recover-deleted=%s"}},
+			"diff":           {"PATH|ID", []string{"-diff=%s"}},
+			"encryption":     {"DIR", []string{"-extract-crypto=%s"}},
+			"grep":           {"PATTERN", []string{"-grep=%s"}},
+			"headers":        {"", []string{"-dump-headers=-"}},
+			"history":        {"DIR", []string{"-export-versions=%s"}},
+			"manifest":       {"", []string{"-manifest=-"}},
+			"orphans":        {"DIR", []string{"-recover-orphans=%s"}},
+			"recoverability": {"", []string{"-recoverability"}},
+			"series":         {"", []string{"-series"}},
+			"space":          {"", []string{"-space"}},
+			"sqlite":         {"", []string{"-sqlite"}},
+			"track":          {"PATH|ID", []string{"-track=%s"}},
+			"versions":       {"PATH|ID", []string{"-versions=%s"}},
+		},
+	},
+	{
+		Name:    "completion",
+		Usage:   "SHELL",
+		Summary: "print a completion script for bash, zsh or fish",
+		Kinds:   completionKinds(),
+	},
+}
+
+func lookupSubcommand(name string) *subcommand {
+	for _, command := range subcommands {
+		if command.Name == name {
+			return command
+		}
+	}
+	return nil
+}
+
+// Flags selecting the output of a run. main acts on the first one set and
+// ignores the rest, so they must not be combined, except for the flags
+// grouped together here.
+var outputFlagGroups = [][]string{
+	{"track"}, {"series"}, {"detect", "space"}, {"chunk-map", "chunk-map-png"}, {"carve"}, {"anomalies"},
+	{"summary"}, {"extract-crypto"}, {"dump-headers"}, {"cat"}, {"stat"}, {"diff"}, {"export-versions"},
+	{"versions"}, {"tar"}, {"zip"}, {"extract"}, {"mount"}, {"serve"}, {"verify"}, {"grep"},
+	{"recover-deleted"}, {"recover-orphans"}, {"damaged-files"}, {"recoverability"}, {"ext4"}, {"avd"},
+	{"squashfs"}, {"mtree"}, {"sqlite-db"}, {"manifest"}, {"bodyfile"}, {"dfxml"}, {"case"}, {"sqlite"},
+}
+
+// Refuse output flags of different groups given together
+func checkOutputFlags(set *flag.FlagSet) error {
+	groups := make(map[string]int)
+	for k, group := range outputFlagGroups {
+		for _, name := range group {
+			groups[name] = k
+		}
+	}
+	var first string
+	var err error
+	// Subcommand flags share the values, but are not visited as set
+	set.VisitAll(func(f *flag.Flag) {
+		k, ok := groups[f.Name]
+		// An anomaly report written to a file accompanies any output
+		value := f.Value.String()
+		if !ok || err != nil || value == f.DefValue || f.Name == "anomalies" && value != "-" {
+			return
+		}
+		switch {
+		case first == "":
+			first = f.Name
+		case groups[first] != k:
+			err = fmt.Errorf("-%s and -%s cannot be combined, run %s once for each", first, f.Name, programName())
+		}
+	})
+	return err
+}
+
+// Turn a subcommand line into the classic flags and image arguments.
+// Arguments not starting with a subcommand or help are returned as they
+// are.
+func parseSubcommand(args []string) ([]string, error) {
+	if len(args) == 0 {
+		return args, nil
+	}
+	if args[0] == "help" {
+		if len(args) > 1 {
+			command := lookupSubcommand(args[1])
+			if command == nil {
+				return nil, fmt.Errorf("unknown command %q", args[1])
+			}
+			command.WriteHelp(os.Stdout)
+		} else {
+			writeCommandList(os.Stdout)
+		}
+		os.Exit(0)
+	}
+	command := lookupSubcommand(args[0])
+	if command == nil {
+		return args, nil
+	}
+
+	// Flags of the command share their values with the classic flags
+	set := flag.NewFlagSet(command.Name, flag.ContinueOnError)
+	set.SetOutput(io.Discard)
+	for _, name := range append(append([]string{}, inputFlags...), command.Flags...) {
+		f := flag.Lookup(name)
+		set.Var(f.Value, f.Name, f.Usage)
+	}
+	// Flags may follow operands, e.g. the report kind, up to a "--"
+	var operands []string
+	for rest := args[1:]; len(rest) > 0; {
+		err := set.Parse(rest)
+		if errors.Is(err, flag.ErrHelp) {
+			command.WriteHelp(os.Stdout)
+			os.Exit(0)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w, see %s help %s", command.Name, err, programName(), command.Name)
+		}
+		remaining := set.Args()
+		if parsed := len(rest) - len(remaining); parsed > 0 && rest[parsed-1] == "--" {
+			operands = append(operands, remaining...)
+			break
+		}
+		if len(remaining) > 0 {
+			operands = append(operands, remaining[0])
+			remaining = remaining[1:]
+		}
+		rest = remaining
+	}
+	template := command.Args
+	set.Visit(func(f *flag.Flag) {
+		for _, name := range command.Replacing {
+			if f.Name == name {
+				template = nil
+			}
+		}
+	})
+	if command.Kinds != nil {
+		if len(operands) == 0 {
+			return nil, fmt.Errorf("usage: %s %s [flags] %s", programName(), command.Name, command.Usage)
+		}
+		kind, ok := command.Kinds[operands[0]]
+		template = kind.Args
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown kind %q, expected one of %s", command.Name, operands[0], strings.Join(command.kindNames(), ", "))
+		}
+		operands = operands[1:]
+	}
+
+	var classic []string
+	for _, arg := range template {
+		if strings.Contains(arg, "%s") {
+			if len(operands) == 0 {
+				return nil, fmt.Errorf("usage: %s %s [flags] %s", programName(), command.Name, command.Usage)
+			}
+			arg = fmt.Sprintf(arg, operands[0])
+			operands = operands[1:]
+		}
+		classic = append(classic, arg)
+	}
+	return append(append(classic, "--"), operands...), nil
+}
+
+func (c *subcommand) kindNames() []string {
+	var names []string
+	for name := range c.Kinds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *subcommand) WriteHelp(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s %s [flags] %s\n\n%s\n", programName(), c.Name, c.Usage, c.Summary)
+	if len(c.Kinds) > 0 {
+		fmt.Fprintln(w, "\nKinds:")
+		for _, name := range c.kindNames() {
+			fmt.Fprintln(w, strings.TrimRight("  "+name+" "+c.Kinds[name].Usage, " "))
+		}
+	}
+	if len(c.Flags) > 0 {
+		fmt.Fprintln(w, "\nFlags:")
+		writeFlags(w, c.Flags)
+	}
+	fmt.Fprintln(w, "\nInput flags:")
+	writeFlags(w, inputFlags)
+}
+
+func writeFlags(w io.Writer, names []string) {
+	set := flag.NewFlagSet("", flag.ContinueOnError)
+	for _, name := range names {
+		f := flag.Lookup(name)
+		set.Var(f.Value, f.Name, f.Usage)
+		set.Lookup(name).DefValue = f.DefValue
+	}
+	set.SetOutput(w)
+	set.PrintDefaults()
+}
+
+func writeCommandList(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s COMMAND [flags] ARGS...\n       %s [flags] IMAGE...\n\nCommands:\n", programName(), programName())
+	width := 0
+	for _, command := range subcommands {
+		if len(command.Name) > width {
+			width = len(command.Name)
+		}
+	}
+	for _, command := range subcommands {
+		fmt.Fprintf(w, "  %-*s %s\n", width, command.Name, command.Summary)
+	}
+	fmt.Fprintf(w, "\nRun %s help COMMAND for the flags of a command, or %s -h for all flags.\n", programName(), programName())
+}
+
+func programName() string {
+	return filepath.Base(os.Args[0])
+}
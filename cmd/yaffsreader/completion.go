@@ -0,0 +1,191 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Shells a completion script can be printed for
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// Fixed choices of string flags, recorded where the flags are defined and
+// offered when completing their value. Flags without choices complete
+// file names.
+var flagChoices = map[string][]string{}
+
+// Define a string flag taking one of choices
+func choiceFlag(name, value string, choices []string, usage string) *string {
+	flagChoices[name] = choices
+	return flag.String(name, value, usage)
+}
+
+// Kinds of the completion command, one per shell
+func completionKinds() map[string]commandKind {
+	kinds := make(map[string]commandKind)
+	for _, shell := range completionShells {
+		kinds[shell] = commandKind{Args: []string{"-completion=" + shell}}
+	}
+	return kinds
+}
+
+func completionFlags() []*flag.Flag {
+	var flags []*flag.Flag
+	flag.VisitAll(func(f *flag.Flag) {
+		flags = append(flags, f)
+	})
+	sort.Slice(flags, func(i, j int) bool { return flags[i].Name < flags[j].Name })
+	return flags
+}
+
+func commandNames() []string {
+	var names []string
+	for _, command := range subcommands {
+		names = append(names, command.Name)
+	}
+	return names
+}
+
+// Boolean flags take no value and must not consume the next word
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+func writeCompletion(w io.Writer, shell, program string) error {
+	switch shell {
+	case "bash":
+		return writeBashCompletion(w, program)
+	case "zsh":
+		return writeZshCompletion(w, program)
+	case "fish":
+		return writeFishCompletion(w, program)
+	}
+	return fmt.Errorf("unknown shell %q, expected bash, zsh or fish", shell)
+}
+
+func writeBashCompletion(w io.Writer, program string) error {
+	var names, cases, kindCases []string
+	for _, f := range completionFlags() {
+		names = append(names, "-"+f.Name)
+		if values, ok := flagChoices[f.Name]; ok {
+			cases = append(cases, fmt.Sprintf("\t\t-%s) COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;", f.Name, strings.Join(values, " ")))
+		} else if !isBoolFlag(f) {
+			cases = append(cases, fmt.Sprintf("\t\t-%s) COMPREPLY=($(compgen -f -- \"$cur\")); return ;;", f.Name))
+		}
+	}
+	for _, command := range subcommands {
+		if len(command.Kinds) > 0 {
+			kindCases = append(kindCases, fmt.Sprintf("\t\t%s) COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;", command.Name, strings.Join(command.kindNames(), " ")))
+		}
+	}
+
+	fn := "_" + strings.ReplaceAll(program, "-", "_")
+	_, err := fmt.Fprintf(w, `%s() {
+	local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}
+	case "$prev" in
+%s
+	esac
+	if [[ $cur == -* ]]; then
+		COMPREPLY=($(compgen -W %q -- "$cur"))
+		return
+	fi
+	if [[ $COMP_CWORD -eq 1 ]]; then
+		COMPREPLY=($(compgen -W %q -- "$cur") $(compgen -f -- "$cur"))
+		return
+	fi
+	if [[ $COMP_CWORD -eq 2 ]]; then
+		case "$prev" in
+%s
+		esac
+	fi
+	COMPREPLY=($(compgen -f -- "$cur"))
+}
+complete -o filenames -F %s %s
+`, fn, strings.Join(cases, "\n"), strings.Join(names, " "), strings.Join(commandNames(), " "),
+		strings.Join(kindCases, "\n"), fn, program)
+	return err
+}
+
+func writeZshCompletion(w io.Writer, program string) error {
+	escape := strings.NewReplacer("[", "(", "]", ")", "'", "")
+	var specs, commands, kindCases []string
+	for _, f := range completionFlags() {
+		usage := escape.Replace(f.Usage)
+		switch values, ok := flagChoices[f.Name]; {
+		case ok:
+			specs = append(specs, fmt.Sprintf("\t'-%s[%s]:value:(%s)'", f.Name, usage, strings.Join(values, " ")))
+		case isBoolFlag(f):
+			specs = append(specs, fmt.Sprintf("\t'-%s[%s]'", f.Name, usage))
+		default:
+			specs = append(specs, fmt.Sprintf("\t'-%s[%s]:file:_files'", f.Name, usage))
+		}
+	}
+	for _, command := range subcommands {
+		commands = append(commands, fmt.Sprintf("\t'%s:%s'", command.Name, escape.Replace(command.Summary)))
+		if len(command.Kinds) > 0 {
+			kindCases = append(kindCases, fmt.Sprintf("\t%s) kinds=(%s) ;;", command.Name, strings.Join(command.kindNames(), " ")))
+		}
+	}
+
+	_, err := fmt.Fprintf(w, `#compdef %s
+local context state state_descr line
+typeset -A opt_args
+local -a commands kinds
+commands=(
+%s
+)
+case $words[2] in
+%s
+esac
+_arguments \
+%s \
+	'1: :->first' \
+	'2: :->second' \
+	'*:image:_files'
+case $state in
+first)
+	_describe command commands
+	_files
+	;;
+second)
+	if (( $#kinds )); then
+		_describe kind kinds
+	else
+		_files
+	fi
+	;;
+esac
+`, program, strings.Join(commands, "\n"), strings.Join(kindCases, "\n"), strings.Join(specs, " \\\n"))
+	return err
+}
+
+func writeFishCompletion(w io.Writer, program string) error {
+	var lines []string
+	for _, f := range completionFlags() {
+		line := fmt.Sprintf("complete -c %s -o %s -d %q", program, f.Name, f.Usage)
+		if values, ok := flagChoices[f.Name]; ok {
+			line += fmt.Sprintf(" -x -a %q", strings.Join(values, " "))
+		} else if !isBoolFlag(f) {
+			line += " -r -F"
+		}
+		lines = append(lines, line)
+	}
+	for _, command := range subcommands {
+		lines = append(lines, fmt.Sprintf("complete -c %s -n __fish_use_subcommand -a %s -d %q", program, command.Name, command.Summary))
+		if len(command.Kinds) > 0 {
+			kinds := strings.Join(command.kindNames(), " ")
+			lines = append(lines, fmt.Sprintf("complete -c %s -n %q -x -a %q", program,
+				fmt.Sprintf("__fish_seen_subcommand_from %s; and not __fish_seen_subcommand_from %s", command.Name, kinds), kinds))
+		}
+	}
+	for _, line := range lines {
+		_, err := fmt.Fprintln(w, line)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
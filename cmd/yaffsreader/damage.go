@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+// Why a byte range of a file is unreliable
+const (
+	DAMAGE_BAD_BLOCK     = "bad block"
+	DAMAGE_UNCORRECTABLE = "uncorrectable pages in block"
+	DAMAGE_MISSING       = "missing"
+)
+
+// Bytes [Start, End) of a file read from a damaged block, or missing and
+// read as zeros
+type DamageRange struct {
+	Start, End uint64
+	Reason     string
+	Block      int // -1 for missing chunks
+}
+
+type AffectedFile struct {
+	ObjectID uint32
+	Path     string
+	Size     uint64
+	Ranges   []DamageRange
+}
+
+// Blocks holding pages marked bad or pages whose tags could not be decoded,
+// by block number. Erased pages are not damage.
+func damagedBlocks(chunkMap *yaffs.ChunkMap) map[int]string {
+	pagesPerBlock := chunkMap.Settings.BlockPages()
+	blocks := make(map[int]string)
+	for index, class := range chunkMap.Classes {
+		block := index / pagesPerBlock
+		switch class {
+		case yaffs.CHUNK_BAD:
+			blocks[block] = DAMAGE_BAD_BLOCK
+		case yaffs.CHUNK_INVALID:
+			if blocks[block] == "" {
+				blocks[block] = DAMAGE_UNCORRECTABLE
+			}
+		}
+	}
+	return blocks
+}
+
+// Cross-reference the damaged blocks with the chunks of every live file.
+// Chunks missing from a file are listed too, as they may have been lost in
+// a block that could not be read at all.
+func findAffectedFiles(result *yaffs.ScanResult, chunkMap *yaffs.ChunkMap) []*AffectedFile {
+	blocks := damagedBlocks(chunkMap)
+	pagesPerBlock := chunkMap.Settings.BlockPages()
+	chunkSize := uint64(result.ChunkDataSize)
+
+	var affected []*AffectedFile
+	tree := result.Tree
+	for _, id := range tree.SortedIDs() {
+		header := tree.Objects[id].Header
+		if header.ObjectType != yaffs.YAFFS_OBJECT_TYPE_FILE || tree.Deleted(id) {
+			continue
+		}
+
+		chunks := result.DataChunks(id)
+		file := &AffectedFile{ObjectID: id, Path: tree.Path(id), Size: result.ContentSize(header.FileSize(result.ByteOrder), chunks)}
+		for start := uint64(0); start < file.Size; start += chunkSize {
+			end := start + chunkSize
+			if end > file.Size {
+				end = file.Size
+			}
+
+			damage := DamageRange{Start: start, End: end, Block: -1}
+			chunk, ok := chunks[uint32(start/chunkSize)+1]
+			if ok {
+				damage.Block = chunk.Index / pagesPerBlock
+				damage.Reason = blocks[damage.Block]
+			} else {
+				damage.Reason = DAMAGE_MISSING
+			}
+			if damage.Reason == "" {
+				continue
+			}
+
+			// Merge with the previous range if it continues it
+			if n := len(file.Ranges); n > 0 {
+				last := &file.Ranges[n-1]
+				if last.End == start && last.Reason == damage.Reason && last.Block == damage.Block {
+					last.End = end
+					continue
+				}
+			}
+			file.Ranges = append(file.Ranges, damage)
+		}
+
+		if len(file.Ranges) > 0 {
+			affected = append(affected, file)
+		}
+	}
+
+	return affected
+}
+
+func writeAffectedFiles(w io.Writer, chunkMap *yaffs.ChunkMap, affected []*AffectedFile) error {
+	blocks := damagedBlocks(chunkMap)
+	var numbers []int
+	for block := range blocks {
+		numbers = append(numbers, block)
+	}
+	sort.Ints(numbers)
+	for _, block := range numbers {
+		fmt.Fprintf(w, "# block %d: %s\n", block, blocks[block])
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "OBJECT\tPATH\tSIZE\tBYTES\tBLOCK\tREASON")
+	for _, file := range affected {
+		for _, damage := range file.Ranges {
+			block := "-"
+			if damage.Block >= 0 {
+				block = fmt.Sprint(damage.Block)
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%d\t%d-%d\t%s\t%s\n", file.ObjectID, file.Path, file.Size, damage.Start, damage.End-1, block, damage.Reason)
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+// Schema of the -sqlite-db export. Timestamps are seconds since the epoch,
+// e.g. for files modified in a time window:
+//
+//	SELECT path FROM objects WHERE NOT obsolete AND type = 'file'
+//	  AND mtime BETWEEN strftime('%s', '2020-09-01') AND strftime('%s', '2020-10-01');
+var databaseTables = []struct {
+	name string
+	sql  string
+}{
+	{"objects", `CREATE TABLE objects (
+  object_id INTEGER, -- 0 if unknown
+  parent_id INTEGER,
+  path TEXT, -- name if the tree could not be reconstructed
+  name TEXT,
+  type TEXT, -- file, directory, symlink, hardlink or special
+  mode INTEGER,
+  uid INTEGER,
+  gid INTEGER,
+  size INTEGER,
+  atime INTEGER,
+  mtime INTEGER,
+  ctime INTEGER,
+  alias TEXT, -- symlink target
+  rdev INTEGER,
+  equiv_id INTEGER, -- hardlink target
+  seq_number INTEGER, -- of the block holding the header
+  status TEXT, -- live, deleted or obsolete
+  deleted INTEGER,
+  obsolete INTEGER -- replaced by a newer header of the object
+)`},
+	{"chunks", `CREATE TABLE chunks (
+  chunk INTEGER, -- index in the image
+  offset INTEGER,
+  seq_number INTEGER,
+  object_id INTEGER,
+  chunk_id INTEGER, -- 0 for headers
+  bytes INTEGER,
+  obsolete INTEGER -- replaced by a newer chunk or beyond the end of the file
+)`},
+	{"anomalies", `CREATE TABLE anomalies (
+  kind TEXT,
+  chunk INTEGER,
+  offset INTEGER,
+  object_id INTEGER, -- NULL if unknown
+  message TEXT
+)`},
+}
+
+// Write every header version, every chunk with valid tags and the
+// anomalies of a scan to a new SQLite database
+func writeDatabase(name string, result *yaffs.ScanResult, anomalies *anomalyCollector) error {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	db := newSQLiteDB(f)
+
+	objects := db.CreateTable(databaseTables[0].name, databaseTables[0].sql)
+	for k := range result.Entries {
+		entry := &result.Entries[k]
+		header := entry.Header
+		m := entry.Metadata(result.ByteOrder)
+		var equivID interface{}
+		if header.ObjectType == yaffs.YAFFS_OBJECT_TYPE_HARDLINK {
+			equivID = int64(header.EquivID)
+		}
+		err = objects.Insert(m.ObjectID, m.ParentID, entry.Name(), m.Name, m.Type, m.Mode, m.UID, m.GID, m.Size,
+			m.AccessTime, m.ModTime, m.CreateTime, m.Alias, header.RDev, equivID, entry.SeqNumber, entryStatus(entry),
+			m.Deleted, entry.Obsolete)
+		if err != nil {
+			return err
+		}
+	}
+	err = objects.Close()
+	if err != nil {
+		return err
+	}
+
+	chunks := db.CreateTable(databaseTables[1].name, databaseTables[1].sql)
+	for k := range result.Chunks {
+		chunk := &result.Chunks[k]
+		spare := chunk.Spare
+		err = chunks.Insert(chunk.Index, chunk.Offset, spare.SeqNumber, spare.ObjectID, spare.ChunkID, spare.NumberBytes, chunk.Obsolete)
+		if err != nil {
+			return err
+		}
+	}
+	err = chunks.Close()
+	if err != nil {
+		return err
+	}
+
+	table := db.CreateTable(databaseTables[2].name, databaseTables[2].sql)
+	anomalies.mu.Lock()
+	defer anomalies.mu.Unlock()
+	for _, e := range anomalies.Anomalies {
+		var objectID interface{}
+		if e.ObjectID != 0 {
+			objectID = e.ObjectID
+		}
+		err = table.Insert(string(e.Anomaly), e.Chunk, e.Offset, objectID, e.Message)
+		if err != nil {
+			return err
+		}
+	}
+	err = table.Close()
+	if err != nil {
+		return err
+	}
+
+	err = db.Close()
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/sha256"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+// Content addressed store of written files. A file with the content and
+// attributes of an earlier one is replaced by a hardlink to it, so
+// duplicate APKs and media take their space once. Files may be closed
+// concurrently by extraction workers.
+type dedupStore struct {
+	mu    sync.Mutex
+	paths map[dedupKey]string // first file written with each content
+	saved int64
+}
+
+// Files sharing an inode share its attributes, so setting those of one
+// output must not change another's
+type dedupKey struct {
+	sum                               [sha256.Size]byte
+	mode, uid, gid, accessTime, mtime uint32
+}
+
+// Set by -dedup for every directory export
+var outputStore *dedupStore
+
+func newDedupStore() *dedupStore {
+	return &dedupStore{paths: make(map[dedupKey]string)}
+}
+
+type dedupFile struct {
+	*os.File
+	store  *dedupStore
+	header *yaffs.ObjectHeader
+	hash   hash.Hash
+	size   int64
+}
+
+func (f *dedupFile) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	f.hash.Write(p[:n])
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *dedupFile) Close() error {
+	err := f.File.Close()
+	if err != nil {
+		return err
+	}
+
+	var key dedupKey
+	copy(key.sum[:], f.hash.Sum(nil))
+	if f.header != nil {
+		key.mode, key.uid, key.gid = f.header.Mode, f.header.UID, f.header.GID
+		key.accessTime, key.mtime = f.header.AccessTime, f.header.ModTime
+	}
+	f.store.mu.Lock()
+	defer f.store.mu.Unlock()
+	existing, ok := f.store.paths[key]
+	if !ok {
+		f.store.paths[key] = f.Name()
+		return nil
+	}
+
+	// Linked beside the copy and renamed over it, so the copy stays where
+	// the filesystem refuses the link
+	temp := filepath.Join(filepath.Dir(f.Name()), ".yaffsreader-link-"+filepath.Base(f.Name()))
+	err = os.Link(existing, temp)
+	if err == nil {
+		err = os.Rename(temp, f.Name())
+		if err != nil {
+			os.Remove(temp)
+		}
+	}
+	if err != nil {
+		log.Printf("Keeping %s as a copy: %v", f.Name(), err)
+		return nil
+	}
+	f.store.saved += f.size
+	return nil
+}
+
+// Create an output file of a directory export, deduplicated on Close with
+// -dedup against outputs of the same content and header attributes, which
+// are none for a nil header
+func createOutput(name string, header *yaffs.ObjectHeader) (io.WriteCloser, error) {
+	return openOutput(name, header, os.O_TRUNC)
+}
+
+// Like createOutput, but failing if name exists, so extracted objects never
+// replace each other or write through a symlink
+func createNewOutput(name string, header *yaffs.ObjectHeader) (io.WriteCloser, error) {
+	return openOutput(name, header, os.O_EXCL)
+}
+
+func openOutput(name string, header *yaffs.ObjectHeader, flag int) (io.WriteCloser, error) {
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|openNoFollow|flag, 0666)
+	if err != nil {
+		return nil, err
+	}
+	if outputStore == nil {
+		return file, nil
+	}
+	return &dedupFile{File: file, store: outputStore, header: header, hash: sha256.New()}, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+// Write every deleted file below dir at its original path, a ~ID suffix
+// telling apart files deleted from the same path, with a manifest written
+// to w. Lost chunks are zero filled.
+func recoverDeleted(r *yaffs.ScanResult, w io.Writer, files []*yaffs.DeletedFile, dir string) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "OBJECT\tSIZE\tCHUNKS\tMISSING\tSTALE\tSHA256\tPATH\tFILE")
+
+	for k, file := range files {
+		if interrupted() {
+			log.Printf("Interrupted after %d of %d deleted files", k, len(files))
+			break
+		}
+		name := file.Original
+		local, err := extractPath(dir, name)
+		if err != nil {
+			log.Printf("Skipping %q: %v", name, err)
+			continue
+		}
+		if _, err := os.Lstat(local); !errors.Is(err, os.ErrNotExist) {
+			name = fmt.Sprintf("%s~%d", name, file.ObjectID)
+			local += fmt.Sprintf("~%d", file.ObjectID)
+		}
+		err = os.MkdirAll(filepath.Dir(local), 0777)
+		if err != nil {
+			return err
+		}
+		out, err := createOutput(local, r.Tree.Objects[file.ObjectID].Header)
+		if err != nil {
+			return err
+		}
+
+		hash := sha256.New()
+		_, err = r.WriteChunks(io.MultiWriter(out, hash), file.Size, file.Chunks)
+		closeErr := out.Close()
+		if err != nil {
+			return err
+		}
+		if closeErr != nil {
+			return closeErr
+		}
+		err = setAttributes(local, r.Tree.Objects[file.ObjectID].Header)
+		if err != nil {
+			log.Printf("Setting attributes of %s failed: %v", name, err)
+		}
+
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%d\t%x\t%s\t%s\n", file.ObjectID, file.Size, len(file.Chunks), file.Missing, file.Stale,
+			hash.Sum(nil), file.Path, name)
+	}
+
+	return tw.Flush()
+}
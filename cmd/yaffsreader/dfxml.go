@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/xml"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+type dfxmlDocument struct {
+	XMLName     xml.Name          `xml:"dfxml"`
+	Namespace   string            `xml:"xmlns,attr"`
+	Version     string            `xml:"xmloutputversion,attr"`
+	Program     string            `xml:"creator>program"`
+	ImageName   string            `xml:"source>image_filename"`
+	FileObjects []dfxmlFileObject `xml:"fileobject"`
+}
+
+type dfxmlFileObject struct {
+	Filename string     `xml:"filename"`
+	Filesize uint64     `xml:"filesize"`
+	Alloc    int        `xml:"alloc"`
+	Inode    uint32     `xml:"inode"`
+	MetaType int        `xml:"meta_type"`
+	NameType string     `xml:"name_type"`
+	Mode     uint32     `xml:"mode"`
+	UID      uint32     `xml:"uid"`
+	GID      uint32     `xml:"gid"`
+	Mtime    dfxmlTime  `xml:"mtime"`
+	Atime    dfxmlTime  `xml:"atime"`
+	Ctime    dfxmlTime  `xml:"ctime"`
+	ByteRuns *dfxmlRuns `xml:"byte_runs"`
+}
+
+type dfxmlRuns struct {
+	Runs []dfxmlRun `xml:"byte_run"`
+}
+
+type dfxmlTime struct {
+	Prec  string `xml:"prec,attr"`
+	Value string `xml:",chardata"`
+}
+
+type dfxmlRun struct {
+	FileOffset uint64 `xml:"file_offset,attr"`
+	ImgOffset  int64  `xml:"img_offset,attr"`
+	Len        uint64 `xml:"len,attr"`
+}
+
+// TSK metadata types by the fls name type
+var dfxmlMetaTypes = map[byte]int{'r': 1, 'd': 2, 'p': 3, 'c': 4, 'b': 5, 'l': 6, 's': 8}
+
+func newDFXMLTime(timestamp uint32) dfxmlTime {
+	return dfxmlTime{Prec: "1", Value: time.Unix(int64(timestamp), 0).UTC().Format(time.RFC3339)}
+}
+
+// Physical runs of the current content of a file: one per data chunk,
+// merged where the pages follow each other in the dump, as in data-only
+// images
+func dfxmlByteRuns(result *yaffs.ScanResult, id uint32) []dfxmlRun {
+	target, header, err := result.FileObject(id)
+	if err != nil {
+		return nil
+	}
+	chunks := result.DataChunks(target)
+	size := result.ContentSize(header.FileSize(result.ByteOrder), chunks)
+	chunkIDs := make([]uint32, 0, len(chunks))
+	for chunkID := range chunks {
+		chunkIDs = append(chunkIDs, chunkID)
+	}
+	sort.Slice(chunkIDs, func(i, j int) bool { return chunkIDs[i] < chunkIDs[j] })
+
+	var runs []dfxmlRun
+	for _, chunkID := range chunkIDs {
+		chunk := chunks[chunkID]
+		fileOffset := uint64(chunkID-1) * uint64(result.ChunkDataSize)
+		if fileOffset >= size {
+			break
+		}
+		length := uint64(chunk.Spare.NumberBytes)
+		if remaining := size - fileOffset; length > remaining {
+			length = remaining
+		}
+		if length == 0 {
+			continue
+		}
+		if n := len(runs); n > 0 {
+			last := &runs[n-1]
+			if last.FileOffset+last.Len == fileOffset && last.ImgOffset+int64(last.Len) == chunk.Offset {
+				last.Len += length
+				continue
+			}
+		}
+		runs = append(runs, dfxmlRun{FileOffset: fileOffset, ImgOffset: chunk.Offset, Len: length})
+	}
+	return runs
+}
+
+// Write a DFXML fileobject per object in its current state, deleted ones
+// unallocated, with byte runs mapping file content to offsets in the dump
+func writeDFXML(w io.Writer, imagePath string, entries []yaffs.ListEntry, result *yaffs.ScanResult, byteOrder binary.ByteOrder) error {
+	document := dfxmlDocument{
+		Namespace: "http://www.forensicswiki.org/wiki/Category:Digital_Forensics_XML",
+		Version:   "1.0",
+		Program:   programName(),
+		ImageName: imagePath,
+	}
+	for k := range entries {
+		entry := &entries[k]
+		if entry.Obsolete {
+			continue
+		}
+		m := entry.Metadata(byteOrder)
+		nameType := bodyfileMode(entry.Header)[0]
+		object := dfxmlFileObject{
+			Filename: strings.TrimPrefix(m.Path, "/"),
+			Filesize: m.Size,
+			Alloc:    1,
+			Inode:    m.ObjectID,
+			MetaType: dfxmlMetaTypes[nameType],
+			NameType: string(nameType),
+			Mode:     m.Mode & 07777,
+			UID:      m.UID,
+			GID:      m.GID,
+			Mtime:    newDFXMLTime(m.ModTime),
+			Atime:    newDFXMLTime(m.AccessTime),
+			Ctime:    newDFXMLTime(m.CreateTime),
+		}
+		if m.Deleted {
+			object.Alloc = 0
+		}
+		if nameType == 'r' {
+			if runs := dfxmlByteRuns(result, m.ObjectID); len(runs) > 0 {
+				object.ByteRuns = &dfxmlRuns{runs}
+			}
+		}
+		document.FileObjects = append(document.FileObjects, object)
+	}
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	err = encoder.Encode(document)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+// Magic of struct crypt_mnt_ftr, the Android full disk encryption footer
+const CRYPT_MNT_MAGIC = 0xD0B5B1C4
+
+const (
+	// Bits per byte above which a chunk counts as ciphertext or compressed
+	HIGH_ENTROPY_BITS = 7.9
+	// Share of high entropy chunks or encrypted looking names that
+	// indicates encryption, and the minimum sample for either
+	ENCRYPTED_SHARE   = 0.9
+	ENCRYPTION_SAMPLE = 16
+)
+
+type EncryptionReport struct {
+	FooterOffsets []int64 // crypt_mnt_ftr candidates in the transformed image
+
+	DataChunks        int
+	HighEntropyChunks int
+
+	Names          int
+	EncryptedNames int
+}
+
+func (e *EncryptionReport) HighEntropy() bool {
+	return e.DataChunks >= ENCRYPTION_SAMPLE && float64(e.HighEntropyChunks) >= ENCRYPTED_SHARE*float64(e.DataChunks)
+}
+
+func (e *EncryptionReport) NamesEncrypted() bool {
+	return e.Names >= ENCRYPTION_SAMPLE && float64(e.EncryptedNames) >= ENCRYPTED_SHARE*float64(e.Names)
+}
+
+// High entropy alone may as well be compressed content like APKs or media,
+// so it is only an indicator next to a footer or encrypted names
+func (e *EncryptionReport) Encrypted() bool {
+	return len(e.FooterOffsets) > 0 || e.NamesEncrypted()
+}
+
+func (e *EncryptionReport) Indicators() []string {
+	var indicators []string
+	for _, offset := range e.FooterOffsets {
+		indicators = append(indicators, fmt.Sprintf("full disk encryption footer at offset %d", offset))
+	}
+	if e.HighEntropy() {
+		indicators = append(indicators, fmt.Sprintf("%d of %d data chunks have high entropy", e.HighEntropyChunks, e.DataChunks))
+	}
+	if e.NamesEncrypted() {
+		indicators = append(indicators, fmt.Sprintf("%d of %d names look like file based encryption names", e.EncryptedNames, e.Names))
+	}
+	return indicators
+}
+
+// Look for Android FDE / FBE indicators in the image and the scan result
+func detectEncryption(image io.ReadSeeker, result *yaffs.ScanResult) (*EncryptionReport, error) {
+	report := &EncryptionReport{}
+
+	offsets, err := findCryptoFooters(image)
+	if err != nil {
+		return nil, err
+	}
+	report.FooterOffsets = offsets
+
+	for _, id := range result.Tree.SortedIDs() {
+		object := result.Tree.Objects[id]
+		if object.Synthesized || result.Tree.Deleted(id) {
+			continue
+		}
+		report.Names++
+		if looksEncryptedName(yaffs.CToGoString(object.Header.Name[:])) {
+			report.EncryptedNames++
+		}
+
+		if object.Header.ObjectType != yaffs.YAFFS_OBJECT_TYPE_FILE {
+			continue
+		}
+		for _, chunk := range result.DataChunks(id) {
+			// Data skipped by an index-only scan is not read just for sampling
+			if chunk.Data == nil {
+				continue
+			}
+			page, err := result.ChunkPage(chunk)
+			if err != nil {
+				continue
+			}
+			// Short chunks say little about their entropy
+			if chunk.Spare.NumberBytes < 256 || int(chunk.Spare.NumberBytes) > len(page) {
+				continue
+			}
+			report.DataChunks++
+			if entropy(page[:chunk.Spare.NumberBytes]) > HIGH_ENTROPY_BITS {
+				report.HighEntropyChunks++
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// Search the whole image for crypt_mnt_ftr structures with a plausible
+// version, size and key size
+func findCryptoFooters(image io.ReadSeeker) ([]int64, error) {
+	_, err := image.Seek(0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	magic := make([]byte, 4)
+	binary.LittleEndian.PutUint32(magic, CRYPT_MNT_MAGIC)
+
+	const window = 1 << 20
+	const keep = 20 // magic and the fixed fields checked below
+	var offsets []int64
+	buf := make([]byte, window+keep)
+	var base int64
+	carried := 0
+
+	for {
+		n, err := io.ReadFull(image, buf[carried:])
+		n += carried
+		for i := 0; ; {
+			j := bytes.Index(buf[i:n], magic)
+			if j < 0 || i+j+keep > n {
+				break
+			}
+			if validCryptoFooter(buf[i+j:]) {
+				offsets = append(offsets, base+int64(i+j))
+			}
+			i += j + 1
+		}
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return offsets, nil
+			}
+			return nil, err
+		}
+		carried = copy(buf, buf[n-keep:n])
+		base += int64(n - keep)
+	}
+}
+
+func validCryptoFooter(b []byte) bool {
+	majorVersion := binary.LittleEndian.Uint16(b[4:])
+	footerSize := binary.LittleEndian.Uint32(b[8:])
+	keySize := binary.LittleEndian.Uint32(b[16:])
+	return majorVersion == 1 && footerSize >= 0x60 && footerSize <= 0x1000 &&
+		(keySize == 16 || keySize == 32 || keySize == 64)
+}
+
+// Shannon entropy in bits per byte
+func entropy(data []byte) float64 {
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+	var bits float64
+	for _, count := range counts {
+		if count == 0 {
+			continue
+		}
+		p := float64(count) / float64(len(data))
+		bits -= p * math.Log2(p)
+	}
+	return bits
+}
+
+// File based encryption stores names as ciphertext, which shows up as long
+// base64 names without extension or as unprintable bytes
+func looksEncryptedName(name string) bool {
+	if len(name) < 16 {
+		return false
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7F || r == 0xFFFD {
+			return true
+		}
+	}
+	return !strings.ContainsAny(name, ". ") && strings.Trim(name, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+,_-") == ""
+}
+
+// Bytes written per footer. crypt_mnt_ftr is followed by the persistent data
+// and key material within the 16K Android reserves for the footer.
+const CRYPT_FOOTER_SIZE = 0x4000
+
+// Files holding key material or lock screen secrets needed to decrypt FDE
+// and FBE userdata, as paths or directory prefixes within userdata
+var keyBlobPaths = []string{
+	"/misc/keystore/",
+	"/misc/vold/",
+	"/unencrypted/",
+	"/system/locksettings.db",
+	"/system/gatekeeper.password.key",
+	"/system/gatekeeper.pattern.key",
+	"/system/password.key",
+	"/system/gesture.key",
+	"/system_de/",
+}
+
+func isKeyBlobPath(objectPath string) bool {
+	// Userdata may be listed from its root or as /data
+	objectPath = strings.TrimPrefix(objectPath, "/data")
+	for _, blob := range keyBlobPaths {
+		if objectPath == blob || strings.HasSuffix(blob, "/") && strings.HasPrefix(objectPath, blob) {
+			return true
+		}
+	}
+	return false
+}
+
+// Write every footer found and every current key blob file to dir, with a
+// manifest listing their origin
+func extractCryptoMaterial(dir string, image io.ReadSeeker, imageSize int64, report *EncryptionReport, result *yaffs.ScanResult) error {
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := os.Create(filepath.Join(dir, "manifest.txt"))
+	if err != nil {
+		return err
+	}
+	defer manifest.Close()
+
+	for _, offset := range report.FooterOffsets {
+		size := imageSize - offset
+		if size > CRYPT_FOOTER_SIZE {
+			size = CRYPT_FOOTER_SIZE
+		}
+		name := fmt.Sprintf("footer-%d.bin", offset)
+		_, err = image.Seek(offset, io.SeekStart)
+		if err != nil {
+			return err
+		}
+		err = writeFile(filepath.Join(dir, name), io.LimitReader(image, size))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(manifest, "%s\tfooter at offset %d, %d bytes\n", name, offset, size)
+	}
+
+	tree := result.Tree
+	for _, id := range tree.SortedIDs() {
+		if interrupted() {
+			log.Println("Interrupted, remaining key blobs not extracted")
+			break
+		}
+		objectPath := tree.Path(id)
+		if tree.Objects[id].Header.ObjectType != yaffs.YAFFS_OBJECT_TYPE_FILE || tree.Deleted(id) || !isKeyBlobPath(objectPath) {
+			continue
+		}
+		name := filepath.Join("keys", filepath.FromSlash(objectPath))
+		err = os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0777)
+		if err != nil {
+			return err
+		}
+		var content bytes.Buffer
+		_, err = result.WriteObject(&content, id)
+		if err != nil {
+			return err
+		}
+		err = writeFile(filepath.Join(dir, name), &content)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(manifest, "%s\tobject %d\n", filepath.ToSlash(name), id)
+	}
+
+	log.Printf("Crypto material written to %s", dir)
+	return nil
+}
+
+func writeFile(name string, r io.Reader) error {
+	f, err := createOutput(name, nil)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
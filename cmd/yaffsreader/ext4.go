@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+// Room for metadata beyond the file content of an ext4 image
+const (
+	EXT4_BLOCK_SIZE    = 4096
+	EXT4_MINIMUM_SIZE  = 8 << 20
+	EXT4_OBJECT_BLOCKS = 2
+)
+
+// Build an ext4 image of the live tree for emulators and devices. The
+// filesystem is created by mkfs.ext4 and populated by a debugfs script
+// from e2fsprogs, which sets owners, modes, times, device numbers and
+// extended attributes without root privileges.
+func writeExt4(imagePath string, result *yaffs.ScanResult, size int64) error {
+	staging, err := os.MkdirTemp("", "yaffsreader-ext4-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+
+	script, content, objects, err := ext4Script(result, staging)
+	if err != nil {
+		return err
+	}
+
+	if size == 0 {
+		size = content + int64(objects)*EXT4_OBJECT_BLOCKS*EXT4_BLOCK_SIZE
+		size += size/4 + EXT4_MINIMUM_SIZE
+		size -= size % EXT4_BLOCK_SIZE
+	}
+
+	image, err := os.Create(imagePath)
+	if err != nil {
+		return err
+	}
+	err = image.Truncate(size)
+	closeErr := image.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+
+	mkfs := exec.Command("mkfs.ext4", "-q", "-F", "-b", fmt.Sprint(EXT4_BLOCK_SIZE),
+		"-N", fmt.Sprint(objects+64), "-E", "root_owner=0:0", imagePath)
+	output, err := mkfs.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("mkfs.ext4: %w: %s", err, bytes.TrimSpace(output))
+	}
+
+	scriptPath := filepath.Join(staging, "script")
+	err = os.WriteFile(scriptPath, script, 0600)
+	if err != nil {
+		return err
+	}
+
+	var stderr bytes.Buffer
+	debugfs := exec.Command("debugfs", "-w", "-f", scriptPath, imagePath)
+	debugfs.Stderr = &stderr
+	err = debugfs.Run()
+	if err != nil {
+		return fmt.Errorf("debugfs: %w", err)
+	}
+
+	// debugfs reports failed commands on stderr only, after its banner.
+	// The image is kept, as everything else was written.
+	failures := 0
+	lines := bufio.NewScanner(&stderr)
+	for lines.Scan() {
+		if line := lines.Text(); line != "" && !strings.HasPrefix(line, "debugfs ") {
+			log.Printf("debugfs: %s", line)
+			failures++
+		}
+	}
+	if failures > 0 {
+		return fmt.Errorf("%s is incomplete, %d debugfs errors", imagePath, failures)
+	}
+	return nil
+}
+
+// Quote an argument for the debugfs command parser
+func debugfsQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// Commands creating the live tree breadth first, so parents exist before
+// their children. File content and attribute values are staged as files.
+// Returns the script, the content size and the number of objects.
+func ext4Script(result *yaffs.ScanResult, staging string) ([]byte, int64, int, error) {
+	tree := result.Tree
+	children := tree.Children()
+
+	var script bytes.Buffer
+	var content int64
+	objects := 0
+
+	// Path of the inode created for each file, and its link count
+	created := make(map[uint32]string)
+	links := make(map[uint32]int)
+
+	queue := append(append([]uint32{}, children[yaffs.YAFFS_OBJECTID_ROOT]...), children[yaffs.YAFFS_OBJECTID_LOSTNFOUND]...)
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+
+		header := tree.Objects[id].Header
+		objectPath := tree.Path(id)
+		if strings.ContainsAny(objectPath, "\n\r") {
+			log.Printf("Skipping %q, debugfs cannot take line breaks in names", objectPath)
+			continue
+		}
+		quoted := debugfsQuote(objectPath)
+		objects++
+
+		var typeBits uint32
+		switch header.ObjectType {
+		case yaffs.YAFFS_OBJECT_TYPE_DIRECTORY:
+			fmt.Fprintf(&script, "mkdir %s\n", quoted)
+			queue = append(queue, children[id]...)
+			typeBits = 0040000
+
+		case yaffs.YAFFS_OBJECT_TYPE_FILE, yaffs.YAFFS_OBJECT_TYPE_HARDLINK:
+			target, targetHeader, err := result.FileObject(id)
+			if err != nil {
+				log.Printf("Skipping %s: %v", objectPath, err)
+				continue
+			}
+			links[target]++
+			if existing, ok := created[target]; ok {
+				fmt.Fprintf(&script, "ln %s %s\n", debugfsQuote(existing), quoted)
+				continue
+			}
+
+			staged := filepath.Join(staging, fmt.Sprint(target))
+			n, err := stageObject(result, target, staged)
+			if err != nil {
+				return nil, 0, 0, fmt.Errorf("%s: %w", objectPath, err)
+			}
+			content += n
+			fmt.Fprintf(&script, "write %s %s\n", debugfsQuote(staged), quoted)
+			created[target] = objectPath
+			header = targetHeader
+			id = target
+			typeBits = 0100000
+
+		case yaffs.YAFFS_OBJECT_TYPE_SYMLINK:
+			fmt.Fprintf(&script, "symlink %s %s\n", quoted, debugfsQuote(yaffs.CToGoString(header.Alias[:])))
+			typeBits = 0120000
+
+		case yaffs.YAFFS_OBJECT_TYPE_SPECIAL:
+			major, minor := (header.RDev>>8)&0xFFF, (header.RDev&0xFF)|((header.RDev>>12)&0xFFF00)
+			var node string
+			switch header.Mode & 0170000 {
+			case 0020000:
+				node = fmt.Sprintf("c %d %d", major, minor)
+			case 0060000:
+				node = fmt.Sprintf("b %d %d", major, minor)
+			case 0010000:
+				node = "p"
+			default:
+				log.Printf("Skipping %s, ext4 cannot hold special files of mode %o", objectPath, header.Mode)
+				continue
+			}
+			// mknod only takes names in the current directory
+			fmt.Fprintf(&script, "cd %s\nmknod %s %s\ncd /\n", debugfsQuote(path.Dir(objectPath)), debugfsQuote(path.Base(objectPath)), node)
+			typeBits = header.Mode & 0170000
+
+		default:
+			log.Printf("Skipping %s of type %s", objectPath, header.ObjectType)
+			continue
+		}
+
+		fmt.Fprintf(&script, "sif %s mode 0%o\n", quoted, typeBits|header.Mode&07777)
+		fmt.Fprintf(&script, "sif %s uid %d\nsif %s gid %d\n", quoted, header.UID, quoted, header.GID)
+		fmt.Fprintf(&script, "sif %s atime @%d\nsif %s mtime @%d\nsif %s ctime @%d\n",
+			quoted, header.AccessTime, quoted, header.ModTime, quoted, header.CreateTime)
+
+		for k, xattr := range result.Xattrs(id) {
+			staged := filepath.Join(staging, fmt.Sprintf("%d.xattr%d", id, k))
+			err := os.WriteFile(staged, xattr.Value, 0600)
+			if err != nil {
+				return nil, 0, 0, err
+			}
+			fmt.Fprintf(&script, "ea_set -f %s %s %s\n", debugfsQuote(staged), quoted, debugfsQuote(xattr.Name))
+		}
+	}
+
+	// debugfs ln leaves the link count alone
+	for target, count := range links {
+		if count > 1 {
+			fmt.Fprintf(&script, "sif %s links_count %d\n", debugfsQuote(created[target]), count)
+		}
+	}
+
+	return script.Bytes(), content, objects, nil
+}
+
+func stageObject(result *yaffs.ScanResult, id uint32, staged string) (int64, error) {
+	file, err := os.Create(staged)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.WriteObject(file, id)
+	closeErr := file.Close()
+	if err != nil {
+		return n, err
+	}
+	return n, closeErr
+}
+
+// Check for the e2fsprogs tools before scanning
+func checkExt4Tools() error {
+	for _, tool := range []string{"mkfs.ext4", "debugfs"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			return errors.New("mkfs.ext4 and debugfs from e2fsprogs are required for ext4 images")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,519 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+// Name of the file in the extraction directory recording the objects
+// written so far, removed once the extraction is complete
+const extractProgressName = ".yaffsreader-progress"
+
+type ExtractOptions struct {
+	Resume bool // skip objects recorded as written by an interrupted extraction
+
+	// Point absolute symlink targets and relative ones leaving the image
+	// root into the extraction directory, relative to the link, or below
+	// SymlinkPrefix if given
+	RewriteSymlinks bool
+	SymlinkPrefix   string
+
+	Jobs int // files written concurrently, one per CPU if zero
+
+	// Objects to write besides directories, which are all created
+	Filter ObjectFilter
+}
+
+// An object recorded as written in the progress file, and the path below
+// the extraction directory it was written to
+type extractProgress struct {
+	objectPath string
+	local      string
+}
+
+// An object written by extractTree, listed in walk order
+type extractedObject struct {
+	id         uint32
+	objectType yaffs.ObjectType
+	objectPath string
+	local      string
+	size       int64
+	note       string
+	err        error
+
+	// File and header holding the content of files and hardlinks
+	target uint32
+	header *yaffs.ObjectHeader
+
+	written bool // false if skipped by an interruption
+}
+
+// Write the live tree into dir: directories, files, symlinks and hardlinks,
+// the latter linked to the first extracted path of their target, or copied
+// from the image where the filesystem refuses the link. Special
+// files are skipped, as device nodes need root. Modes and modification
+// times are applied, owners are not. One line per object is written to w.
+//
+// Nothing is written through a symlink or over an existing path: objects
+// are created exclusively below directories made by the extraction, and
+// one whose name is taken, by a file in dir or an object of the same name,
+// is written as NAME~ID instead.
+//
+// The tree is walked first, creating directories and symlinks. Files are
+// then written by options.Jobs workers and hardlinks made once their
+// targets are complete, so an interruption never leaves a recorded link to
+// a partial file.
+func extractTree(dir string, result *yaffs.ScanResult, w io.Writer, options ExtractOptions) error {
+	tree := result.Tree
+	children := tree.Children()
+
+	progress, done, err := openExtractProgress(dir, options.Resume)
+	if err != nil {
+		return err
+	}
+	if len(done) > 0 {
+		log.Printf("Resuming extraction, %d objects already written", len(done))
+	}
+
+	var mu sync.Mutex
+	var progressErr error
+	record := func(object *extractedObject) {
+		mu.Lock()
+		defer mu.Unlock()
+		object.written = true
+		if object.err != nil {
+			return
+		}
+		local, _ := filepath.Rel(dir, object.local)
+		_, err := fmt.Fprintf(progress, "%d\t%s\t%s\n", object.id, object.objectPath, filepath.ToSlash(local))
+		if err != nil && progressErr == nil {
+			progressErr = err
+		}
+	}
+
+	var objects, files, links []*extractedObject
+	// Path of the file extracted for each hardlink target
+	created := make(map[uint32]string)
+	// Directory times are set last, writing their children changes them
+	var directories []uint32
+	// Local path of every directory, children are placed below it
+	locals := map[uint32]string{yaffs.YAFFS_OBJECTID_ROOT: dir}
+	// Paths given to an object, so objects of the same name do not replace
+	// each other
+	taken := make(map[string]bool)
+	for _, entry := range done {
+		taken[filepath.Join(dir, filepath.FromSlash(entry.local))] = true
+	}
+
+	// lost+found has no header of its own
+	if len(children[yaffs.YAFFS_OBJECTID_LOSTNFOUND]) > 0 {
+		local := filepath.Join(dir, yaffs.PseudoDirectoryNames[yaffs.YAFFS_OBJECTID_LOSTNFOUND])
+		err := os.Mkdir(local, 0777)
+		if err != nil && !errors.Is(err, os.ErrExist) {
+			return err
+		}
+		if !isDirectory(local) {
+			return fmt.Errorf("%s exists and is not a directory", local)
+		}
+		locals[yaffs.YAFFS_OBJECTID_LOSTNFOUND] = local
+	}
+
+	notWritten := 0
+	queue := append(append([]uint32{}, children[yaffs.YAFFS_OBJECTID_ROOT]...), children[yaffs.YAFFS_OBJECTID_LOSTNFOUND]...)
+	for len(queue) > 0 {
+		if interrupted() {
+			notWritten += len(queue)
+			break
+		}
+		id := queue[0]
+		queue = queue[1:]
+
+		header := tree.Objects[id].Header
+		objectPath := tree.Path(id)
+		name := yaffs.CToGoString(header.Name[:])
+		if err := checkName(name); err != nil {
+			log.Printf("Skipping %q: %v", objectPath, err)
+			continue
+		}
+		local := filepath.Join(locals[header.ParentObjectID], name)
+
+		// Written before the interruption; hardlinks may still need the
+		// file and directories their children
+		if entry, ok := done[id]; ok && entry.objectPath == objectPath {
+			local = filepath.Join(dir, filepath.FromSlash(entry.local))
+			switch header.ObjectType {
+			case yaffs.YAFFS_OBJECT_TYPE_DIRECTORY:
+				if !isDirectory(local) {
+					log.Printf("Skipping %s: %s is no longer a directory", objectPath, local)
+					continue
+				}
+				locals[id] = local
+				queue = append(queue, children[id]...)
+				directories = append(directories, id)
+			case yaffs.YAFFS_OBJECT_TYPE_FILE, yaffs.YAFFS_OBJECT_TYPE_HARDLINK:
+				target, _, err := result.FileObject(id)
+				if _, ok := created[target]; err == nil && !ok {
+					created[target] = local
+				}
+			}
+			continue
+		}
+		if !matchesTreeFilter(result, id, options.Filter) {
+			continue
+		}
+
+		// A resumed extraction finds its own partial objects in place
+		renamed := taken[local]
+		if !renamed && !options.Resume {
+			_, err := os.Lstat(local)
+			renamed = !errors.Is(err, os.ErrNotExist)
+		}
+		if renamed {
+			local += fmt.Sprintf("~%d", id)
+		}
+		taken[local] = true
+
+		// Partly written by the interrupted extraction, and possibly linked
+		// to a file that is complete
+		if options.Resume && header.ObjectType != yaffs.YAFFS_OBJECT_TYPE_DIRECTORY {
+			err := os.Remove(local)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.Printf("Extracting %s failed: %v", objectPath, err)
+				continue
+			}
+		}
+
+		object := &extractedObject{id: id, objectType: header.ObjectType, objectPath: objectPath, local: local}
+		if renamed {
+			object.note = "written as " + filepath.Base(local)
+		}
+		switch header.ObjectType {
+		case yaffs.YAFFS_OBJECT_TYPE_DIRECTORY:
+			object.err = os.Mkdir(local, 0700)
+			// Made by the interrupted extraction before it was recorded
+			if errors.Is(object.err, os.ErrExist) && options.Resume && isDirectory(local) {
+				object.err = nil
+			}
+			if object.err == nil {
+				locals[id] = local
+				queue = append(queue, children[id]...)
+				directories = append(directories, id)
+			}
+			record(object)
+
+		case yaffs.YAFFS_OBJECT_TYPE_FILE, yaffs.YAFFS_OBJECT_TYPE_HARDLINK:
+			object.target, object.header, object.err = result.FileObject(id)
+			if object.err != nil {
+				record(object)
+				break
+			}
+			if _, ok := created[object.target]; ok {
+				links = append(links, object)
+				break
+			}
+			created[object.target] = local
+			files = append(files, object)
+
+		case yaffs.YAFFS_OBJECT_TYPE_SYMLINK:
+			target := yaffs.CToGoString(header.Alias[:])
+			if options.RewriteSymlinks || options.SymlinkPrefix != "" {
+				if rewritten := rewriteSymlink(objectPath, target, options.SymlinkPrefix); rewritten != target {
+					object.note = strings.TrimPrefix(object.note+", target "+target, ", ")
+					target = rewritten
+				}
+			}
+			object.err = os.Symlink(target, local)
+			record(object)
+
+		default:
+			log.Printf("Skipping %s of type %s", objectPath, header.ObjectType)
+			continue
+		}
+		objects = append(objects, object)
+	}
+
+	work := make(chan *extractedObject)
+	var wg sync.WaitGroup
+	for k := 0; k < yaffs.WorkerCount(options.Jobs); k++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for object := range work {
+				if interrupted() {
+					continue
+				}
+				object.size, object.err = extractFile(result, object.target, object.local)
+				if object.err == nil {
+					object.err = setAttributes(object.local, object.header)
+				}
+				record(object)
+			}
+		}()
+	}
+	for _, object := range files {
+		work <- object
+	}
+	close(work)
+	wg.Wait()
+
+	// A failed file leaves its hardlinks to extract the content themselves
+	for _, object := range files {
+		if object.err != nil {
+			delete(created, object.target)
+		}
+	}
+	for _, object := range links {
+		if interrupted() {
+			break
+		}
+		existing, ok := created[object.target]
+		if !ok {
+			created[object.target] = object.local
+		} else if object.err = os.Link(existing, object.local); object.err == nil {
+			object.size = int64(result.ContentSize(object.header.FileSize(result.ByteOrder), result.DataChunks(object.target)))
+			record(object)
+			continue
+		} else {
+			// FAT and exFAT have no hardlinks, nor do links across
+			// volumes work
+			var linkErr *os.LinkError
+			if errors.As(object.err, &linkErr) {
+				object.err = linkErr.Err
+			}
+			object.note = strings.TrimPrefix(fmt.Sprintf("%s, copy of %s, link failed: %v", object.note, filepath.ToSlash(strings.TrimPrefix(existing, dir)), object.err), ", ")
+		}
+		object.size, object.err = extractFile(result, object.target, object.local)
+		if object.err == nil {
+			object.err = setAttributes(object.local, object.header)
+		} else if !ok {
+			delete(created, object.target)
+		}
+		record(object)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "OBJECT\tTYPE\tSIZE\tPATH\tNOTE")
+	for _, object := range objects {
+		switch {
+		case !object.written:
+			notWritten++
+		case object.err != nil:
+			log.Printf("Extracting %s failed: %v", object.objectPath, object.err)
+		default:
+			fmt.Fprintf(tw, "%d\t%s\t%d\t%s\t%s\n", object.id, object.objectType, object.size, object.objectPath, object.note)
+		}
+	}
+	if notWritten > 0 {
+		log.Printf("Extraction interrupted, %d objects not written, rerun with -resume to continue", notWritten)
+	}
+
+	// Children first, so restricting a parent's mode cannot block them
+	for k := len(directories) - 1; k >= 0; k-- {
+		id := directories[k]
+		err := setAttributes(locals[id], tree.Objects[id].Header)
+		if err != nil {
+			log.Printf("Setting attributes of %s failed: %v", tree.Path(id), err)
+		}
+	}
+
+	err = progress.Close()
+	if err == nil {
+		err = progressErr
+	}
+	if err != nil {
+		return err
+	}
+	if notWritten == 0 {
+		err = os.Remove(filepath.Join(dir, extractProgressName))
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+// Open the progress file of dir for appending, returning the path of every
+// object it records as written when resuming. Without resume, earlier
+// progress is discarded.
+func openExtractProgress(dir string, resume bool) (*os.File, map[uint32]extractProgress, error) {
+	name := filepath.Join(dir, extractProgressName)
+	done := make(map[uint32]extractProgress)
+	if !resume {
+		f, err := os.Create(name)
+		return f, done, err
+	}
+
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, nil, err
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 3 {
+			// A line cut short by the interruption
+			continue
+		}
+		n, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil || checkPath(fields[2]) != nil {
+			continue
+		}
+		done[uint32(n)] = extractProgress{objectPath: fields[1], local: fields[2]}
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	// Start a new line after a partial one
+	_, err = f.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = f.WriteString("\n")
+	}
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, done, nil
+}
+
+// Target of the symlink at objectPath resolved within the image, relative
+// to the link or joined to prefix if not empty. Relative targets staying
+// within the image are kept; ones climbing above its root are clamped to
+// it, as the kernel does at the root of a mounted filesystem.
+func rewriteSymlink(objectPath, target, prefix string) string {
+	resolved := path.Clean(target)
+	if !path.IsAbs(target) {
+		if inside := path.Join(path.Dir(objectPath)[1:], target); !strings.HasPrefix(inside+"/", "../") {
+			return target
+		}
+		resolved = path.Join(path.Dir(objectPath), target)
+	}
+	if prefix != "" {
+		return path.Join(prefix, resolved)
+	}
+
+	// Up to the root of the image from the directory of the link
+	up := strings.Count(path.Dir(objectPath), "/")
+	if path.Dir(objectPath) == "/" {
+		up = 0
+	}
+	relative := strings.TrimPrefix(resolved, "/")
+	if relative == "" {
+		relative = "."
+	}
+	return path.Join(strings.Repeat("../", up), relative)
+}
+
+// Whether a tree export writes an object. Directories are all written, so
+// the objects matching below them have a place, and hardlinks are judged
+// by their target.
+func matchesTreeFilter(result *yaffs.ScanResult, id uint32, filter ObjectFilter) bool {
+	header := result.Tree.Objects[id].Header
+	if header.ObjectType == yaffs.YAFFS_OBJECT_TYPE_DIRECTORY {
+		return true
+	}
+	if _, target, err := result.FileObject(id); err == nil {
+		header = target
+	}
+	return filter.Matches(header, result.ByteOrder)
+}
+
+// Refuse object names that would leave their directory
+func checkName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsRune(name, '/') || strings.ContainsRune(name, filepath.Separator) {
+		return fmt.Errorf("unsafe name %q", name)
+	}
+	return nil
+}
+
+// Refuse object paths with a name that would leave its directory
+func checkPath(objectPath string) error {
+	for _, name := range strings.Split(strings.TrimPrefix(objectPath, "/"), "/") {
+		if err := checkName(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Local path of an object, refusing names that would leave dir and
+// existing directories below dir that are symlinks
+func extractPath(dir, objectPath string) (string, error) {
+	err := checkPath(objectPath)
+	if err != nil {
+		return "", err
+	}
+	local := dir
+	for _, name := range strings.Split(path.Dir(strings.TrimPrefix(objectPath, "/")), "/") {
+		if name == "." {
+			break
+		}
+		local = filepath.Join(local, name)
+		info, err := os.Lstat(local)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("%s is a symlink", local)
+		}
+	}
+	return filepath.Join(dir, filepath.FromSlash(objectPath)), nil
+}
+
+// Whether local is a directory itself rather than a symlink to one
+func isDirectory(local string) bool {
+	info, err := os.Lstat(local)
+	return err == nil && info.IsDir()
+}
+
+func extractFile(result *yaffs.ScanResult, id uint32, local string) (int64, error) {
+	f, err := createNewOutput(local, result.Tree.Objects[id].Header)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.WriteObject(f, id)
+	closeErr := f.Close()
+	if err != nil {
+		return n, err
+	}
+	return n, closeErr
+}
+
+func setAttributes(local string, header *yaffs.ObjectHeader) error {
+	err := os.Chmod(local, os.FileMode(header.Mode&0777)|unixModeBits(header.Mode))
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(local, time.Unix(int64(header.AccessTime), 0), time.Unix(int64(header.ModTime), 0))
+}
+
+// setuid, setgid and sticky bits in the layout of os.FileMode
+func unixModeBits(mode uint32) os.FileMode {
+	var bits os.FileMode
+	if mode&04000 != 0 {
+		bits |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		bits |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		bits |= os.ModeSticky
+	}
+	return bits
+}
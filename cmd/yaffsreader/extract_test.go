@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+const testPageSize, testSpareSize = 2048, 64
+
+// Chunks of one block written like mkyaffs2image, data ahead of headers
+type testImage struct {
+	buf bytes.Buffer
+}
+
+func (img *testImage) chunk(objectID, chunkID, numberBytes uint32, data []byte) {
+	page := bytes.Repeat([]byte{0xFF}, testPageSize)
+	copy(page, data)
+	spare := bytes.Repeat([]byte{0xFF}, testSpareSize)
+	binary.LittleEndian.PutUint32(spare[0:], yaffs.YAFFS_LOWEST_SEQUENCE_NUMBER)
+	binary.LittleEndian.PutUint32(spare[4:], objectID)
+	binary.LittleEndian.PutUint32(spare[8:], chunkID)
+	binary.LittleEndian.PutUint32(spare[12:], numberBytes)
+	img.buf.Write(page)
+	img.buf.Write(spare)
+}
+
+func (img *testImage) header(id, parent uint32, objectType yaffs.ObjectType, name string, size uint32, alias string) {
+	header := yaffs.SynthesizeHeader(objectType, parent, name)
+	switch objectType {
+	case yaffs.YAFFS_OBJECT_TYPE_FILE:
+		header.Mode = 0100644
+	case yaffs.YAFFS_OBJECT_TYPE_SYMLINK:
+		header.Mode = 0120777
+	}
+	header.ModTime = 1600000000
+	binary.LittleEndian.PutUint32(header.FileSizeLow[:], size)
+	copy(header.Alias[:], alias)
+
+	var page bytes.Buffer
+	err := binary.Write(&page, binary.LittleEndian, header)
+	if err != nil {
+		panic(err)
+	}
+	img.chunk(id, 0, 0, page.Bytes())
+}
+
+func (img *testImage) file(id, parent uint32, name, content string) {
+	img.chunk(id, 1, uint32(len(content)), []byte(content))
+	img.header(id, parent, yaffs.YAFFS_OBJECT_TYPE_FILE, name, uint32(len(content)), "")
+}
+
+func (img *testImage) scan(t *testing.T) *yaffs.ScanResult {
+	t.Helper()
+	settings := &yaffs.Settings{PageSize: testPageSize, SpareSize: testSpareSize, ByteOrder: binary.LittleEndian}
+	result, err := yaffs.ScanImage(bytes.NewReader(img.buf.Bytes()), settings, int64(img.buf.Len()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+// Everything below dir except the progress file: directories with a
+// trailing slash, symlinks as -> TARGET and files by content
+func extractedTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	tree := make(map[string]string)
+	err := filepath.WalkDir(dir, func(local string, entry fs.DirEntry, err error) error {
+		if err != nil || local == dir || entry.Name() == extractProgressName {
+			return err
+		}
+		name, _ := filepath.Rel(dir, local)
+		name = filepath.ToSlash(name)
+		switch {
+		case entry.IsDir():
+			tree[name+"/"] = ""
+		case entry.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(local)
+			tree[name] = "-> " + target
+			return err
+		default:
+			content, err := os.ReadFile(local)
+			tree[name] = string(content)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+var extractTests = []struct {
+	name    string
+	build   func(img *testImage, outside string)
+	prepare func(dir, outside string) error
+	options ExtractOptions
+	want    map[string]string
+}{
+	{
+		name: "file after symlink of the same name",
+		build: func(img *testImage, outside string) {
+			img.header(300, yaffs.YAFFS_OBJECTID_ROOT, yaffs.YAFFS_OBJECT_TYPE_SYMLINK, "a", 0, filepath.Join(outside, "a"))
+			img.file(301, yaffs.YAFFS_OBJECTID_ROOT, "a", "escaped\n")
+		},
+		want: map[string]string{"a": "-> OUTSIDE/a", "a~301": "escaped\n"},
+	},
+	{
+		name: "directory after symlink of the same name",
+		build: func(img *testImage, outside string) {
+			img.header(300, yaffs.YAFFS_OBJECTID_ROOT, yaffs.YAFFS_OBJECT_TYPE_SYMLINK, "d", 0, outside)
+			img.header(301, yaffs.YAFFS_OBJECTID_ROOT, yaffs.YAFFS_OBJECT_TYPE_DIRECTORY, "d", 0, "")
+			img.file(302, 301, "f", "escaped\n")
+		},
+		want: map[string]string{"d": "-> OUTSIDE", "d~301/": "", "d~301/f": "escaped\n"},
+	},
+	{
+		name: "symlinked directory in the destination",
+		build: func(img *testImage, outside string) {
+			img.header(299, yaffs.YAFFS_OBJECTID_ROOT, yaffs.YAFFS_OBJECT_TYPE_DIRECTORY, "dir", 0, "")
+			img.file(300, 299, "f", "escaped\n")
+		},
+		prepare: func(dir, outside string) error {
+			return os.Symlink(outside, filepath.Join(dir, "dir"))
+		},
+		want: map[string]string{"dir": "-> OUTSIDE", "dir~299/": "", "dir~299/f": "escaped\n"},
+	},
+	{
+		name: "symlinked directory on resume",
+		build: func(img *testImage, outside string) {
+			img.header(299, yaffs.YAFFS_OBJECTID_ROOT, yaffs.YAFFS_OBJECT_TYPE_DIRECTORY, "dir", 0, "")
+			img.file(300, 299, "f", "escaped\n")
+		},
+		prepare: func(dir, outside string) error {
+			err := os.Symlink(outside, filepath.Join(dir, "dir"))
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(filepath.Join(dir, extractProgressName), []byte("299\t/dir\tdir\n"), 0666)
+		},
+		options: ExtractOptions{Resume: true},
+		want:    map[string]string{"dir": "-> OUTSIDE"},
+	},
+	{
+		name: "unsafe names",
+		build: func(img *testImage, outside string) {
+			img.file(300, yaffs.YAFFS_OBJECTID_ROOT, "..", "escaped\n")
+			img.file(301, yaffs.YAFFS_OBJECTID_ROOT, "../escaped", "escaped\n")
+			img.file(302, yaffs.YAFFS_OBJECTID_ROOT, "kept", "kept\n")
+		},
+		want: map[string]string{"kept": "kept\n"},
+	},
+	{
+		name: "same name twice",
+		build: func(img *testImage, outside string) {
+			img.file(300, yaffs.YAFFS_OBJECTID_ROOT, "a", "first\n")
+			img.file(301, yaffs.YAFFS_OBJECTID_ROOT, "a", "second\n")
+		},
+		want: map[string]string{"a": "first\n", "a~301": "second\n"},
+	},
+}
+
+func TestExtractTreeStaysInside(t *testing.T) {
+	for _, test := range extractTests {
+		dir, outside := t.TempDir(), t.TempDir()
+		img := &testImage{}
+		test.build(img, outside)
+		if test.prepare != nil {
+			if err := test.prepare(dir, outside); err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+		}
+
+		options := test.options
+		options.Filter = ObjectFilter{MinSize: -1, MaxSize: -1}
+		err := extractTree(dir, img.scan(t), io.Discard, options)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		tree := extractedTree(t, dir)
+		for name, entry := range tree {
+			tree[name] = strings.Replace(entry, outside, "OUTSIDE", 1)
+		}
+		if len(tree) != len(test.want) {
+			t.Errorf("%s: extracted %q, want %q", test.name, tree, test.want)
+		}
+		for name, want := range test.want {
+			if got, ok := tree[name]; !ok || got != want {
+				t.Errorf("%s: %s is %q, want %q", test.name, name, got, want)
+			}
+		}
+
+		if entries, _ := os.ReadDir(outside); len(entries) > 0 {
+			t.Errorf("%s: %d entries written outside the destination", test.name, len(entries))
+		}
+	}
+}
+
+func TestExtractPath(t *testing.T) {
+	dir, outside := t.TempDir(), t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "dir"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		objectPath string
+		safe       bool
+	}{
+		{"/a", true},
+		{"/dir/a", true},
+		{"/dir/.hidden", true},
+		{"/dir/missing/a", true},
+		{"/link", true},
+		{"/link/a", false},
+		{"/..", false},
+		{"/dir/../a", false},
+		{"/dir/./a", false},
+		{"/dir//a", false},
+		{"/", false},
+	} {
+		local, err := extractPath(dir, test.objectPath)
+		if (err == nil) != test.safe {
+			t.Errorf("%s: error %v, want safe %v", test.objectPath, err, test.safe)
+			continue
+		}
+		if err == nil && local != filepath.Join(dir, filepath.FromSlash(test.objectPath)) {
+			t.Errorf("%s: extracted to %s", test.objectPath, local)
+		}
+	}
+}
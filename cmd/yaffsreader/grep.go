@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"regexp"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+// Files with a NUL byte in the first chunk are skipped as binary
+func isBinary(r *yaffs.ScanResult, objectID uint32) bool {
+	objectID, _, err := r.FileObject(objectID)
+	if err != nil {
+		return false
+	}
+	chunk, ok := r.DataChunks(objectID)[1]
+	if !ok {
+		return false
+	}
+	page, err := r.ChunkPage(chunk)
+	if err != nil {
+		return false
+	}
+	n := chunk.Spare.NumberBytes
+	if int(n) > len(page) {
+		n = uint32(len(page))
+	}
+	return bytes.IndexByte(page[:n], 0) >= 0
+}
+
+type GrepOptions struct {
+	Glob   string // only search paths matching it, all if empty
+	Binary bool   // search files that look binary
+	Escape bool   // escape control characters in matched lines
+}
+
+// Search the content of every live file and write path, byte offset and
+// line of each match
+func grepFiles(w io.Writer, result *yaffs.ScanResult, pattern *regexp.Regexp, options GrepOptions) error {
+	tree := result.Tree
+	for _, id := range tree.SortedIDs() {
+		header := tree.Objects[id].Header
+		if header.ObjectType != yaffs.YAFFS_OBJECT_TYPE_FILE && header.ObjectType != yaffs.YAFFS_OBJECT_TYPE_HARDLINK {
+			continue
+		}
+		if tree.Deleted(id) {
+			continue
+		}
+
+		objectPath := tree.Path(id)
+		if options.Glob != "" {
+			matched, err := path.Match(options.Glob, objectPath)
+			if err != nil {
+				return err
+			}
+			if !matched {
+				continue
+			}
+		}
+		if !options.Binary && isBinary(result, id) {
+			continue
+		}
+
+		err := grepObject(w, result, id, objectPath, pattern, options.Escape)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func grepObject(w io.Writer, result *yaffs.ScanResult, id uint32, objectPath string, pattern *regexp.Regexp, escape bool) error {
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := result.WriteObject(pw, id)
+		pw.CloseWithError(err)
+	}()
+	defer pr.Close()
+
+	reader := bufio.NewReader(pr)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && pattern.Match(line) {
+			text, name := string(bytes.TrimRight(line, "\r\n")), objectPath
+			if escape {
+				text, name = escapeName(text), escapeName(name)
+			}
+			_, werr := fmt.Fprintf(w, "%s:%d:%s\n", name, offset, text)
+			if werr != nil {
+				return werr
+			}
+		}
+		offset += int64(len(line))
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			log.Printf("Reading %s failed: %v", objectPath, err)
+			return nil
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+// Every field of an on-flash header version, byte arrays hex encoded, for
+// reverse engineering vendor header changes
+type rawHeaderRecord struct {
+	Chunk     int    `json:"chunk"`
+	Offset    int64  `json:"offset"`
+	SeqNumber uint32 `json:"seq_number"`
+	ObjectID  uint32 `json:"object_id"`
+
+	ObjectType     uint32 `json:"object_type"`
+	ParentObjectID uint32 `json:"parent_object_id"`
+	Checksum       string `json:"checksum"`
+	Name           string `json:"name"`
+	Padding        string `json:"padding"`
+
+	Mode       uint32 `json:"mode"`
+	UID        uint32 `json:"uid"`
+	GID        uint32 `json:"gid"`
+	AccessTime uint32 `json:"atime"`
+	ModTime    uint32 `json:"mtime"`
+	CreateTime uint32 `json:"ctime"`
+
+	FileSizeLow string `json:"file_size_low"`
+	EquivID     int32  `json:"equiv_id"`
+	Alias       string `json:"alias"`
+	RDev        uint32 `json:"rdev"`
+
+	WinCreateTime uint64 `json:"win_ctime"`
+	WinAccessTime uint64 `json:"win_atime"`
+	WinModTime    uint64 `json:"win_mtime"`
+
+	InbandShadowedObjectID uint32 `json:"inband_shadowed_object_id"`
+	InbandIsShrink         uint32 `json:"inband_is_shrink"`
+	FileSizeHigh           string `json:"file_size_high"`
+	Reserved               uint32 `json:"reserved"`
+	ShadowsObject          int32  `json:"shadows_object"`
+	IsShrink               uint32 `json:"is_shrink"`
+
+	// Rest of the chunk after the header structure
+	Trailer string `json:"trailer"`
+}
+
+// Write one JSON object per header chunk in physical order, including
+// outdated versions
+func dumpRawHeaders(w io.Writer, result *yaffs.ScanResult) error {
+	encoder := json.NewEncoder(w)
+	headerSize := binary.Size(yaffs.ObjectHeader{})
+
+	for _, chunk := range result.Chunks {
+		if chunk.Spare.ChunkID != 0 {
+			continue
+		}
+
+		page, err := result.ChunkPage(&chunk)
+		if err != nil {
+			return err
+		}
+		header := &yaffs.ObjectHeader{}
+		err = binary.Read(bytes.NewReader(page), result.ByteOrder, header)
+		if err != nil {
+			return err
+		}
+
+		record := rawHeaderRecord{
+			Chunk:     chunk.Index,
+			Offset:    chunk.Offset,
+			SeqNumber: chunk.Spare.SeqNumber,
+			ObjectID:  chunk.Spare.ObjectID,
+
+			ObjectType:     uint32(header.ObjectType),
+			ParentObjectID: header.ParentObjectID,
+			Checksum:       hex.EncodeToString(header.Checksum[:]),
+			Name:           hex.EncodeToString(header.Name[:]),
+			Padding:        hex.EncodeToString(page[10+len(header.Name) : 12+len(header.Name)]),
+
+			Mode:       header.Mode,
+			UID:        header.UID,
+			GID:        header.GID,
+			AccessTime: header.AccessTime,
+			ModTime:    header.ModTime,
+			CreateTime: header.CreateTime,
+
+			FileSizeLow: hex.EncodeToString(header.FileSizeLow[:]),
+			EquivID:     header.EquivID,
+			Alias:       hex.EncodeToString(header.Alias[:]),
+			RDev:        header.RDev,
+
+			WinCreateTime: header.WinCreateTime,
+			WinAccessTime: header.WinAccessTime,
+			WinModTime:    header.WinModTime,
+
+			InbandShadowedObjectID: header.InbandShadowedObjectID,
+			InbandIsShrink:         header.InbandIsShrink,
+			FileSizeHigh:           hex.EncodeToString(header.FileSizeHigh[:]),
+			Reserved:               header.Reserved,
+			ShadowsObject:          header.ShadowsObject,
+			IsShrink:               header.IsShrink,
+
+			Trailer: hex.EncodeToString(page[headerSize:]),
+		}
+
+		err = encoder.Encode(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
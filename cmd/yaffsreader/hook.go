@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+const PLUGIN_OP_OBJECT = 'O'
+
+// Action is one of extract (the default), skip, rename or tag. Value holds
+// the new path for rename and the label for tag.
+type ObjectDecision struct {
+	Action string `json:"action"`
+	Value  string `json:"value"`
+}
+
+// Script deciding per object, run through the plugin protocol so it can be
+// written in any language with an interpreter on the analysis machine.
+// Starlark and Lua are not embedded, to keep to the standard library.
+var objectHook *yaffs.Plugin
+
+func decideObject(p *yaffs.Plugin, metadata yaffs.ObjectMetadata) (*ObjectDecision, error) {
+	payload, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	response, ok, err := p.Call(PLUGIN_OP_OBJECT, uint64(metadata.ObjectID), payload)
+	if err != nil {
+		return nil, err
+	}
+	decision := &ObjectDecision{Action: "extract"}
+	if !ok || len(response) == 0 {
+		return decision, nil
+	}
+
+	err = json.Unmarshal(response, decision)
+	if err != nil {
+		return nil, fmt.Errorf("plugin %q: invalid decision for object %d: %w", p.Command, metadata.ObjectID, err)
+	}
+
+	switch decision.Action {
+	case "extract", "skip":
+	case "rename", "tag":
+		if decision.Value == "" {
+			return nil, fmt.Errorf("plugin %q: %s decision for object %d without value", p.Command, decision.Action, metadata.ObjectID)
+		}
+	default:
+		return nil, fmt.Errorf("plugin %q: unknown action %q for object %d", p.Command, decision.Action, metadata.ObjectID)
+	}
+	return decision, nil
+}
+
+// Run the hook over every object before anything is written, so every
+// listing, report and export sees the decisions. Skipped objects are
+// dropped with everything below them. Renamed live objects move to the new
+// path, below synthesized directories where it leaves the tree; renamed
+// deleted objects only take the new name. Tags are returned per object
+// for listings.
+func applyObjectHook(hook *yaffs.Plugin, result *yaffs.ScanResult) (map[uint32][]string, error) {
+	tree := result.Tree
+	tags := make(map[uint32][]string)
+	skipped := make(map[uint32]bool)
+	renames := make(map[uint32]string)
+
+	for _, id := range tree.SortedIDs() {
+		object := tree.Objects[id]
+		entry := yaffs.ListEntry{ObjectID: id, Header: object.Header, Path: tree.Path(id), SeqNumber: object.SeqNumber}
+		decision, err := decideObject(hook, entry.Metadata(result.ByteOrder))
+		if err != nil {
+			return nil, err
+		}
+		switch decision.Action {
+		case "skip":
+			skipped[id] = true
+		case "rename":
+			renames[id] = decision.Value
+		case "tag":
+			tags[id] = append(tags[id], decision.Value)
+		}
+	}
+
+	// Entries without an object, as in data-only dumps, are decided on
+	// their own
+	var entries []yaffs.ListEntry
+	for _, entry := range result.Entries {
+		if entry.ObjectID == 0 {
+			decision, err := decideObject(hook, entry.Metadata(result.ByteOrder))
+			if err != nil {
+				return nil, err
+			}
+			switch decision.Action {
+			case "skip":
+				continue
+			case "rename":
+				entry.Path = decision.Value
+			case "tag":
+				entry.Tags = append(entry.Tags, decision.Value)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	result.Entries = entries
+
+	for _, id := range tree.SortedIDs() {
+		if newPath, ok := renames[id]; ok && !skipped[id] {
+			err := renameObject(result, id, newPath)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(skipped) > 0 {
+		dropObjects(result, skipped)
+	}
+
+	for k := range result.Entries {
+		if entry := &result.Entries[k]; entry.ObjectID != 0 {
+			entry.Path = tree.HeaderPath(entry.Header)
+		}
+	}
+	return tags, nil
+}
+
+// Give an object a new name, and a live one a new parent, replacing its
+// header by a copy in the tree and the entries
+func renameObject(result *yaffs.ScanResult, id uint32, newPath string) error {
+	tree := result.Tree
+	object := tree.Objects[id]
+	header := *object.Header
+
+	name := path.Base(path.Clean("/" + newPath))
+	if name == "/" {
+		return fmt.Errorf("rename of object %d to %q: no name", id, newPath)
+	}
+	header.Name = [yaffs.YAFFS_MAX_NAME_LENGTH + 1]byte{}
+	copy(header.Name[:yaffs.YAFFS_MAX_NAME_LENGTH], name)
+
+	if !tree.Deleted(id) {
+		parentID, err := hookDirectory(result, path.Dir(path.Clean("/"+newPath)))
+		if err != nil {
+			return fmt.Errorf("rename of object %d to %q: %w", id, newPath, err)
+		}
+		// A directory cannot move below itself
+		for ancestor, seen := parentID, map[uint32]bool{}; !seen[ancestor]; {
+			if ancestor == id {
+				return fmt.Errorf("rename of object %d to %q: below itself", id, newPath)
+			}
+			seen[ancestor] = true
+			parent, ok := tree.Objects[ancestor]
+			if !ok {
+				break
+			}
+			ancestor = parent.Header.ParentObjectID
+		}
+		header.ParentObjectID = parentID
+	}
+
+	for k := range result.Entries {
+		if result.Entries[k].Header == object.Header {
+			result.Entries[k].Header = &header
+		}
+	}
+	object.Header = &header
+	return nil
+}
+
+// Object ID of the live directory at dirPath, synthesizing the missing
+// ones with IDs above every object of the image
+func hookDirectory(result *yaffs.ScanResult, dirPath string) (uint32, error) {
+	tree := result.Tree
+	children := tree.Children()
+	dirID := uint32(yaffs.YAFFS_OBJECTID_ROOT)
+
+	for _, name := range strings.Split(strings.TrimPrefix(dirPath, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		found := false
+		for _, child := range children[dirID] {
+			if yaffs.CToGoString(tree.Objects[child].Header.Name[:]) == name {
+				if tree.Objects[child].Header.ObjectType != yaffs.YAFFS_OBJECT_TYPE_DIRECTORY {
+					return 0, fmt.Errorf("%s is no directory", name)
+				}
+				dirID, found = child, true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+
+		ids := tree.SortedIDs()
+		id := ids[len(ids)-1] + 1
+		header := yaffs.SynthesizeHeader(yaffs.YAFFS_OBJECT_TYPE_DIRECTORY, dirID, name)
+		tree.Objects[id] = &yaffs.Object{ID: id, Header: header, Synthesized: true}
+		result.Entries = append(result.Entries, yaffs.ListEntry{ObjectID: id, Header: header})
+		children[dirID] = append(children[dirID], id)
+		dirID = id
+	}
+	return dirID, nil
+}
+
+// Remove objects, everything below them, their header versions and their
+// chunks from the scan result
+func dropObjects(result *yaffs.ScanResult, skipped map[uint32]bool) {
+	tree := result.Tree
+	dropped := make(map[uint32]bool)
+	for _, id := range tree.SortedIDs() {
+		seen := make(map[uint32]bool)
+		for ancestor := id; !seen[ancestor]; {
+			seen[ancestor] = true
+			if skipped[ancestor] {
+				dropped[id] = true
+				break
+			}
+			object, ok := tree.Objects[ancestor]
+			if !ok {
+				break
+			}
+			ancestor = object.Header.ParentObjectID
+		}
+	}
+
+	for id := range dropped {
+		delete(tree.Objects, id)
+	}
+	var entries []yaffs.ListEntry
+	for _, entry := range result.Entries {
+		if !dropped[entry.ObjectID] {
+			entries = append(entries, entry)
+		}
+	}
+	result.Entries = entries
+	var chunks []yaffs.ScanChunk
+	for _, chunk := range result.Chunks {
+		if !dropped[chunk.Spare.ObjectID] {
+			chunks = append(chunks, chunk)
+		}
+	}
+	result.Chunks = chunks
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Exit status of a run stopped by SIGINT or SIGTERM, like shells report it
+const EXIT_INTERRUPTED = 130
+
+// Returned by steps that cannot produce a partial result when stopped
+var errInterrupted = errors.New("interrupted before completion")
+
+// Done once the run is to stop, passed to scans through the image options
+var runContext, stopRun = context.WithCancel(context.Background())
+
+// Whether loops over objects and files should stop early
+func interrupted() bool {
+	return runContext.Err() != nil
+}
+
+// Stop long scans and exports at the next chunk or file on the first
+// SIGINT or SIGTERM, so reports and manifests are flushed for what was
+// completed. A second signal ends the process at once.
+func handleInterrupts() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		received := <-signals
+		signal.Reset(os.Interrupt, syscall.SIGTERM)
+		log.Printf("Received %s, stopping after the current step; repeat to abort at once", received)
+		stopRun()
+	}()
+}
+
+// Called by main once run returned, so every output is closed. Runs
+// stopped by a resource limit fail with status 1.
+func exitIfInterrupted() {
+	if !interrupted() {
+		return
+	}
+	if limitExceeded.Load() {
+		log.Println("Resource limit exceeded, output covers only what was completed")
+		os.Exit(1)
+	}
+	log.Println("Interrupted, output covers only what was completed")
+	os.Exit(EXIT_INTERRUPTED)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+	"runtime/metrics"
+	"sync/atomic"
+	"time"
+)
+
+// Interval of the memory watchdog
+const MEMORY_POLL_INTERVAL = 100 * time.Millisecond
+
+// Time the current step has to stop after a limit is exceeded, before the
+// process ends without flushing
+const LIMIT_GRACE_PERIOD = 10 * time.Second
+
+// Set once a limit stopped the run, making it exit with an error
+var limitExceeded atomic.Bool
+
+// Limits for automated pipelines processing untrusted images, zero for
+// none. Exceeding the memory limit or the timeout stops the run like
+// SIGINT does, flushing what was completed, and ends the process with an
+// error. A crafted image must not hang or exhaust the host, so a step that
+// does not stop within LIMIT_GRACE_PERIOD is cut short.
+type ResourceLimits struct {
+	Memory    int64 // bytes mapped from the OS
+	OpenFiles uint64
+	Timeout   time.Duration
+}
+
+func (l ResourceLimits) Apply() error {
+	if l.OpenFiles > 0 {
+		err := limitOpenFiles(l.OpenFiles)
+		if err != nil {
+			return fmt.Errorf("limiting open files: %w", err)
+		}
+	}
+
+	if l.Memory > 0 {
+		// The garbage collector works harder close to the limit before
+		// the watchdog gives up
+		debug.SetMemoryLimit(l.Memory)
+		go watchMemory(l.Memory)
+	}
+
+	if l.Timeout > 0 {
+		time.AfterFunc(l.Timeout, func() {
+			stopAtLimit(fmt.Sprintf("Timeout of %s exceeded", l.Timeout))
+		})
+	}
+	return nil
+}
+
+func watchMemory(limit int64) {
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	for range time.Tick(MEMORY_POLL_INTERVAL) {
+		metrics.Read(samples)
+		used := int64(samples[0].Value.Uint64() - samples[1].Value.Uint64())
+		if used > limit {
+			stopAtLimit(fmt.Sprintf("Memory limit of %d bytes exceeded, %d bytes in use", limit, used))
+			return
+		}
+	}
+}
+
+func stopAtLimit(message string) {
+	if limitExceeded.Swap(true) {
+		return
+	}
+	log.Printf("%s, stopping after the current step", message)
+	stopRun()
+	time.AfterFunc(LIMIT_GRACE_PERIOD, func() {
+		log.Fatalf("%s, current step did not stop within %s", message, LIMIT_GRACE_PERIOD)
+	})
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+func limitOpenFiles(n uint64) error {
+	return errors.New("not supported on this platform")
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// Lower the soft limit, the hard limit stays for child processes
+func limitOpenFiles(n uint64) error {
+	var limit syscall.Rlimit
+	err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit)
+	if err != nil {
+		return err
+	}
+	if n < limit.Max {
+		limit.Cur = n
+	}
+	return syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit)
+}
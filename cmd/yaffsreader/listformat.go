@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+// Fields of one listed object for -format templates
+type ListTemplateData struct {
+	yaffs.ObjectMetadata
+	SeqNumber   uint32   `json:"seq_number"`
+	Permissions string   `json:"permissions"` // like ls -l, e.g. -rw-r--r--
+	Tags        []string `json:"tags,omitempty"`
+}
+
+// Timestamp format of every listing and report, set by -time-format and
+// -time-zone
+var timeFormat yaffs.TimeFormat
+
+var listTemplateFuncs = template.FuncMap{
+	// The time format is only known after the flags are parsed
+	"time": func(timestamp uint32) string {
+		return timeFormat.Format(timestamp)
+	},
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"join": strings.Join,
+}
+
+// Full metadata of one object for -format json
+type objectRecord struct {
+	ListTemplateData
+	RDev          uint32        `json:"rdev"`
+	EquivID       int32         `json:"equiv_id,omitempty"`
+	ShadowsObject uint32        `json:"shadows_object,omitempty"`
+	IsShrink      bool          `json:"is_shrink"`
+	Obsolete      bool          `json:"obsolete"`
+	Chunks        []chunkRecord `json:"chunks,omitempty"`
+}
+
+// Where one chunk of an object is stored
+type chunkRecord struct {
+	ChunkID     uint32 `json:"chunk_id"` // zero for the header
+	Index       int    `json:"index"`
+	Offset      int64  `json:"offset"`
+	SeqNumber   uint32 `json:"seq_number"`
+	NumberBytes uint32 `json:"bytes"`
+}
+
+func newChunkRecord(chunk *yaffs.ScanChunk) chunkRecord {
+	return chunkRecord{
+		ChunkID:     chunk.Spare.ChunkID,
+		Index:       chunk.Index,
+		Offset:      chunk.Offset,
+		SeqNumber:   chunk.Spare.SeqNumber,
+		NumberBytes: chunk.Spare.NumberBytes,
+	}
+}
+
+// Header and current data chunks of the current version of an object
+func objectChunks(result *yaffs.ScanResult, id uint32) []chunkRecord {
+	var records []chunkRecord
+	if header := result.HeaderChunk(id); header != nil {
+		records = append(records, newChunkRecord(header))
+	}
+	chunks := result.DataChunks(id)
+	chunkIDs := make([]uint32, 0, len(chunks))
+	for chunkID := range chunks {
+		chunkIDs = append(chunkIDs, chunkID)
+	}
+	sort.Slice(chunkIDs, func(i, j int) bool { return chunkIDs[i] < chunkIDs[j] })
+	for _, chunkID := range chunkIDs {
+		records = append(records, newChunkRecord(chunks[chunkID]))
+	}
+	return records
+}
+
+// Write the listed objects as one JSON array. Names are not escaped, JSON
+// quotes control characters itself. Chunks are listed for current
+// versions only.
+func writeJSONListing(w io.Writer, entries []yaffs.ListEntry, byteOrder binary.ByteOrder, result *yaffs.ScanResult) error {
+	records := make([]objectRecord, 0, len(entries))
+	for k := range entries {
+		entry := &entries[k]
+		header := entry.Header
+		record := objectRecord{
+			ListTemplateData: ListTemplateData{
+				ObjectMetadata: entry.Metadata(byteOrder),
+				SeqNumber:      entry.SeqNumber,
+				Permissions:    modeString(header),
+				Tags:           entry.Tags,
+			},
+			RDev:          header.RDev,
+			ShadowsObject: header.InbandShadowedObjectID,
+			IsShrink:      header.IsShrink != 0 || header.InbandIsShrink != 0,
+			Obsolete:      entry.Obsolete,
+		}
+		if header.ObjectType == yaffs.YAFFS_OBJECT_TYPE_HARDLINK {
+			record.EquivID = header.EquivID
+		}
+		if header.ShadowsObject > 0 {
+			record.ShadowsObject = uint32(header.ShadowsObject)
+		}
+		if result != nil && entry.ObjectID != 0 && !entry.Obsolete {
+			record.Chunks = objectChunks(result, entry.ObjectID)
+		}
+		records = append(records, record)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(records)
+}
+
+// Parse a -format template, executed once per listed object like docker
+// ps --format, e.g. '{{.ObjectID}} {{.Path}} {{time .ModTime}}'. Tabs are
+// written as {{"\t"}}.
+func parseListTemplate(text string) (*template.Template, error) {
+	return template.New("format").Funcs(listTemplateFuncs).Parse(text)
+}
+
+func writeTemplateListing(w io.Writer, entries []yaffs.ListEntry, byteOrder binary.ByteOrder, options ListOptions) error {
+	escape := func(s string) string {
+		if options.Escape {
+			return escapeName(s)
+		}
+		return s
+	}
+
+	for _, entry := range entries {
+		data := ListTemplateData{
+			ObjectMetadata: entry.Metadata(byteOrder),
+			SeqNumber:      entry.SeqNumber,
+			Permissions:    modeString(entry.Header),
+		}
+		data.Path = escape(data.Path)
+		data.Name = escape(data.Name)
+		data.Alias = escape(data.Alias)
+		for _, tag := range entry.Tags {
+			data.Tags = append(data.Tags, escape(tag))
+		}
+
+		err := options.Template.Execute(w, data)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, "\n")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+const (
+	colorReset     = "\x1b[0m"
+	colorDirectory = "\x1b[01;34m"
+	colorSymlink   = "\x1b[01;36m"
+	colorHardlink  = "\x1b[36m"
+	colorSpecial   = "\x1b[33m"
+	colorDeleted   = "\x1b[31m"
+)
+
+type ListOptions struct {
+	Color  bool
+	Escape bool // escape control characters and invalid UTF-8 in names
+
+	Sort    string // key of sortKeys, empty for scan order
+	Reverse bool
+
+	AllVersions bool // also list header versions replaced by newer ones
+
+	MaxDepth int                       // of listed paths below the root, negative for no limit
+	Types    map[yaffs.ObjectType]bool // listed object types, nil for all
+
+	ObjectFilter
+
+	Template *template.Template // -format, nil for the ls -l layout
+	JSON     bool               // -format json
+
+	// Chunk lists for -format json, nil for data-only dumps
+	Result *yaffs.ScanResult
+}
+
+// Bounds on file size and modification time, inclusive, of listings and
+// tree exports
+type ObjectFilter struct {
+	MinSize, MaxSize     int64   // negative for unbounded
+	NewerThan, OlderThan *uint32 // nil for unbounded
+}
+
+func (f ObjectFilter) Bounded() bool {
+	return f.MinSize >= 0 || f.MaxSize >= 0 || f.NewerThan != nil || f.OlderThan != nil
+}
+
+// Sizes bound files only, so the directories leading to them stay listed
+func (f ObjectFilter) Matches(header *yaffs.ObjectHeader, byteOrder binary.ByteOrder) bool {
+	if header.ObjectType == yaffs.YAFFS_OBJECT_TYPE_FILE {
+		size := int64(header.FileSize(byteOrder))
+		if f.MinSize >= 0 && size < f.MinSize || f.MaxSize >= 0 && size > f.MaxSize {
+			return false
+		}
+	}
+	modTime := header.ModTime
+	return (f.NewerThan == nil || modTime >= *f.NewerThan) && (f.OlderThan == nil || modTime <= *f.OlderThan)
+}
+
+// Object types selectable with -type, named like find -type
+var typeLetters = map[byte]yaffs.ObjectType{
+	'f': yaffs.YAFFS_OBJECT_TYPE_FILE,
+	'd': yaffs.YAFFS_OBJECT_TYPE_DIRECTORY,
+	'l': yaffs.YAFFS_OBJECT_TYPE_SYMLINK,
+	'h': yaffs.YAFFS_OBJECT_TYPE_HARDLINK,
+	's': yaffs.YAFFS_OBJECT_TYPE_SPECIAL,
+}
+
+func typeLetterNames() []string {
+	var names []string
+	for letter := range typeLetters {
+		names = append(names, string(letter))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Parse comma separated type letters, e.g. "d" or "f,l"
+func parseTypeFilter(s string) (map[yaffs.ObjectType]bool, error) {
+	if s == "" {
+		return nil, nil
+	}
+	types := make(map[yaffs.ObjectType]bool)
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		var objectType yaffs.ObjectType
+		ok := len(field) == 1
+		if ok {
+			objectType, ok = typeLetters[field[0]]
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown type %q, expected f, d, l, h or s", field)
+		}
+		types[objectType] = true
+	}
+	return types, nil
+}
+
+func filterEntries(entries []yaffs.ListEntry, byteOrder binary.ByteOrder, options ListOptions) []yaffs.ListEntry {
+	if options.AllVersions && options.MaxDepth < 0 && options.Types == nil && !options.Bounded() {
+		return entries
+	}
+	var filtered []yaffs.ListEntry
+	for _, entry := range entries {
+		if !options.AllVersions && entry.Obsolete {
+			continue
+		}
+		if options.MaxDepth >= 0 && entry.Depth() > options.MaxDepth {
+			continue
+		}
+		if options.Types != nil && !options.Types[entry.Header.ObjectType] {
+			continue
+		}
+		if !options.Matches(entry.Header, byteOrder) {
+			continue
+		}
+		filtered = append(filtered, entry)
+	}
+	return filtered
+}
+
+// Orderings selectable with -sort, each comparing two entries
+var sortKeys = map[string]func(a, b *yaffs.ListEntry, byteOrder binary.ByteOrder) bool{
+	"name": func(a, b *yaffs.ListEntry, byteOrder binary.ByteOrder) bool {
+		return a.Name() < b.Name()
+	},
+	"size": func(a, b *yaffs.ListEntry, byteOrder binary.ByteOrder) bool {
+		return a.Header.FileSize(byteOrder) < b.Header.FileSize(byteOrder)
+	},
+	"mtime": func(a, b *yaffs.ListEntry, byteOrder binary.ByteOrder) bool {
+		return a.Header.ModTime < b.Header.ModTime
+	},
+	"id": func(a, b *yaffs.ListEntry, byteOrder binary.ByteOrder) bool {
+		return a.ObjectID < b.ObjectID
+	},
+	"seq": func(a, b *yaffs.ListEntry, byteOrder binary.ByteOrder) bool {
+		return a.SeqNumber < b.SeqNumber
+	},
+}
+
+func sortKeyNames() []string {
+	var names []string
+	for key := range sortKeys {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func parseSortKey(key string) (string, error) {
+	if _, ok := sortKeys[key]; key != "" && !ok {
+		return "", fmt.Errorf("unknown sort key %q, expected name, size, mtime, id or seq", key)
+	}
+	return key, nil
+}
+
+// Order entries by the sort options, keeping scan order among equal ones
+func sortEntries(entries []yaffs.ListEntry, byteOrder binary.ByteOrder, options ListOptions) []yaffs.ListEntry {
+	less, ok := sortKeys[options.Sort]
+	if !ok {
+		return entries
+	}
+	sorted := append([]yaffs.ListEntry{}, entries...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if options.Reverse {
+			return less(&sorted[j], &sorted[i], byteOrder)
+		}
+		return less(&sorted[i], &sorted[j], byteOrder)
+	})
+	return sorted
+}
+
+// Decide on a terminal feature for a flag value of auto, always or never
+func terminalFeature(mode string, out *os.File) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		info, err := out.Stat()
+		if err != nil {
+			return false, nil
+		}
+		return info.Mode()&os.ModeCharDevice != 0, nil
+	}
+	return false, fmt.Errorf("unknown mode %q, expected auto, always or never", mode)
+}
+
+// Make a name from the image safe to print on a terminal. Control
+// characters could otherwise move the cursor or change terminal settings.
+func escapeName(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); {
+		r, size := utf8.DecodeRuneInString(name[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			fmt.Fprintf(&b, "\\x%02x", name[i])
+		case r == '\\':
+			b.WriteString("\\\\")
+		case r == '\n':
+			b.WriteString("\\n")
+		case r == '\t':
+			b.WriteString("\\t")
+		case r < 0x20 || r == 0x7F:
+			fmt.Fprintf(&b, "\\x%02x", r)
+		case !unicode.IsPrint(r) && r != ' ':
+			fmt.Fprintf(&b, "\\u{%x}", r)
+		default:
+			b.WriteRune(r)
+		}
+		i += size
+	}
+	return b.String()
+}
+
+// Print entries like ls -l, with the object ID in front like ls -i
+func writeListing(w io.Writer, entries []yaffs.ListEntry, byteOrder binary.ByteOrder, options ListOptions) error {
+	escape := func(s string) string {
+		if options.Escape {
+			return escapeName(s)
+		}
+		return s
+	}
+
+	entries = sortEntries(filterEntries(entries, byteOrder, options), byteOrder, options)
+	if options.JSON {
+		return writeJSONListing(w, entries, byteOrder, options.Result)
+	}
+	if options.Template != nil {
+		return writeTemplateListing(w, entries, byteOrder, options)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', tabwriter.AlignRight)
+
+	for _, entry := range entries {
+		header := entry.Header
+
+		objectID := "?"
+		if entry.ObjectID != 0 {
+			objectID = fmt.Sprint(entry.ObjectID)
+		}
+
+		name := escape(entry.Name())
+		if header.ObjectType == yaffs.YAFFS_OBJECT_TYPE_SYMLINK {
+			name += " -> " + escape(yaffs.CToGoString(header.Alias[:]))
+		}
+		if header.ObjectType == yaffs.YAFFS_OBJECT_TYPE_HARDLINK {
+			name += fmt.Sprintf(" => %d", header.EquivID)
+		}
+		if code := entryColor(&entry); options.Color && code != "" {
+			name = code + name + colorReset
+		}
+		if entry.Deleted() {
+			name += " (deleted)"
+		}
+		for _, tag := range entry.Tags {
+			name += " [" + escape(tag) + "]"
+		}
+
+		// Trailing tab terminates the last aligned cell, the name is left aligned
+		_, err := fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%s\t %s\n",
+			objectID,
+			modeString(header),
+			header.UID,
+			header.GID,
+			header.FileSize(byteOrder),
+			timeFormat.Format(header.ModTime),
+			name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tw.Flush()
+}
+
+func entryColor(entry *yaffs.ListEntry) string {
+	if entry.Deleted() {
+		return colorDeleted
+	}
+	switch entry.Header.ObjectType {
+	case yaffs.YAFFS_OBJECT_TYPE_DIRECTORY:
+		return colorDirectory
+	case yaffs.YAFFS_OBJECT_TYPE_SYMLINK:
+		return colorSymlink
+	case yaffs.YAFFS_OBJECT_TYPE_HARDLINK:
+		return colorHardlink
+	case yaffs.YAFFS_OBJECT_TYPE_SPECIAL:
+		return colorSpecial
+	}
+	return ""
+}
+
+// Unix permission string, with the type character taken from the object
+// type and, for special files, from the S_IFMT bits of the mode
+func modeString(header *yaffs.ObjectHeader) string {
+	var typeChar byte
+	switch header.ObjectType {
+	case yaffs.YAFFS_OBJECT_TYPE_FILE:
+		typeChar = '-'
+	case yaffs.YAFFS_OBJECT_TYPE_DIRECTORY:
+		typeChar = 'd'
+	case yaffs.YAFFS_OBJECT_TYPE_SYMLINK:
+		typeChar = 'l'
+	case yaffs.YAFFS_OBJECT_TYPE_HARDLINK:
+		typeChar = 'h'
+	case yaffs.YAFFS_OBJECT_TYPE_SPECIAL:
+		switch header.Mode & 0170000 {
+		case 0020000:
+			typeChar = 'c'
+		case 0060000:
+			typeChar = 'b'
+		case 0010000:
+			typeChar = 'p'
+		case 0140000:
+			typeChar = 's'
+		default:
+			typeChar = '?'
+		}
+	default:
+		typeChar = '?'
+	}
+
+	var b strings.Builder
+	b.WriteByte(typeChar)
+	const rwx = "rwxrwxrwx"
+	for i := 0; i < 9; i++ {
+		if header.Mode&(1<<uint(8-i)) != 0 {
+			b.WriteByte(rwx[i])
+		} else {
+			b.WriteByte('-')
+		}
+	}
+	return b.String()
+}
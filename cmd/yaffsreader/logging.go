@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+// Turns each line of the standard logger into a JSON record. Expects the
+// logger to only have log.Lshortfile set.
+type jsonLogWriter struct {
+	w  io.Writer
+	mu sync.Mutex
+}
+
+type logRecord struct {
+	Time    string `json:"time"`
+	Level   string `json:"level"`
+	Source  string `json:"source,omitempty"`
+	Message string `json:"message"`
+	Chunk   *int   `json:"chunk,omitempty"`
+	Offset  *int64 `json:"offset,omitempty"`
+}
+
+// Set for -log-format json, diagnostics with chunk details use it directly
+var structuredLog *jsonLogWriter
+
+func setLogFormat(format string, w io.Writer) error {
+	switch format {
+	case "text":
+		log.SetFlags(log.LstdFlags | log.Lshortfile)
+		log.SetOutput(w)
+	case "json":
+		structuredLog = &jsonLogWriter{w: w}
+		log.SetFlags(log.Lshortfile)
+		log.SetOutput(structuredLog)
+	default:
+		return fmt.Errorf("unknown log format %q, expected text or json", format)
+	}
+	return nil
+}
+
+func (j *jsonLogWriter) Write(p []byte) (int, error) {
+	line := string(bytes.TrimRight(p, "\n"))
+	record := logRecord{Level: "info", Message: line}
+
+	// Split "file.go:123: message"
+	if i := bytes.Index(p, []byte(": ")); i > 0 && bytes.Contains(p[:i], []byte(".go:")) {
+		record.Source, record.Message = line[:i], line[i+2:]
+	}
+
+	err := j.write(record)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Write a warning carrying the position of the affected chunk
+func (j *jsonLogWriter) Anomaly(message string, chunk int, offset int64) error {
+	return j.write(logRecord{Level: "warning", Message: message, Chunk: &chunk, Offset: &offset})
+}
+
+func (j *jsonLogWriter) write(record logRecord) error {
+	record.Time = time.Now().UTC().Format(time.RFC3339Nano)
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, err = j.w.Write(append(line, '\n'))
+	return err
+}
+
+// Default handler keeping the previous log output for anomalies
+func logAnomalies(e yaffs.Event) {
+	if e.Type != yaffs.EVENT_ANOMALY {
+		return
+	}
+	if structuredLog != nil {
+		err := structuredLog.Anomaly(e.Message, e.Chunk, e.Offset)
+		if err == nil {
+			return
+		}
+	}
+	log.Println(e.Message)
+}
@@ -0,0 +1,991 @@
+// Command yaffsreader analyzes and reads YAFFS images for forensic
+// purposes. It is a thin command line interface to the yaffs package.
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+// Workers per stage, set by -jobs and the flags of each stage
+var jobLimits yaffs.JobLimits
+
+func main() {
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	err := run()
+	if err != nil {
+		log.Print(err)
+	}
+	exitIfInterrupted()
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// Everything of main but the exit, so deferred closes run before it
+func run() error {
+	carveDir := flag.String("carve", "", "find all YAFFS regions and write per-partition reports to this directory")
+	specialIDs := flag.String("special-ids", yaffs.FormatIDList(yaffs.ReferenceObjectIDRules.SpecialIDs), "comma separated object IDs always accepted")
+	idRanges := flag.String("object-ids", "", "comma separated inclusive ranges of accepted object IDs (default derived from -object-space)")
+	objectSpace := flag.Uint("object-space", yaffs.YAFFS_OBJECT_SPACE, "object ID space of the YAFFS build that wrote the image")
+	timeFormatName := choiceFlag("time-format", "rfc3339", []string{"rfc3339", "iso8601", "epoch"}, "timestamp format: rfc3339, iso8601 or epoch")
+	timeZone := choiceFlag("time-zone", "utc", []string{"utc", "local"}, "time zone for timestamps: utc or local")
+	colorMode := choiceFlag("color", "auto", []string{"auto", "always", "never"}, "color listings by type and deleted status: auto, always or never")
+	headerAnchor := flag.Int64("assume-header-at", -1, "byte offset of a known object header page to anchor geometry detection")
+	layoutPluginCommand := flag.String("layout-plugin", "", "command of a plugin decoding spares of a proprietary layout")
+	scriptCommand := flag.String("script", "", "command of a script deciding per object whether to extract, skip, rename or tag it")
+	eventsPath := flag.String("events", "", "write scan events as JSON lines to this file, e.g. a pipe read by a frontend")
+	extractDir := flag.String("extract", "", "write the live tree to `DIR`, created if missing")
+	resumeExtract := flag.Bool("resume", false, "continue an interrupted -extract, skipping the objects it recorded as written")
+	rewriteSymlinks := flag.Bool("rewrite-symlinks", false, "with -extract, point absolute symlink targets and relative ones leaving the image root into the extracted tree")
+	symlinkPrefix := flag.String("symlink-prefix", "", "with -extract, rewrite symlink targets like -rewrite-symlinks, but below this `PREFIX` instead of relative to the link")
+	zipPath := flag.String("zip", "", "write the live tree into a ZIP archive at this path and attributes ZIP cannot hold to NAME.manifest.csv next to it")
+	tarPath := flag.String("tar", "", "stream the live tree into a tar archive at this path instead of writing files, - for stdout")
+	mountpoint := flag.String("mount", "", "mount the live tree read-only at `DIR` through FUSE until unmounted, needs root")
+	mountDeleted := flag.Bool("mount-deleted", false, "with -mount or -serve, also serve recovered deleted files at their original paths below /.deleted")
+	mountVersions := flag.Bool("mount-versions", false, "with -mount or -serve, also serve the on-flash versions of every file as /.versions/PATH/SEQUENCE")
+	serveAddr := flag.String("serve", "", "serve the live tree read-only over HTTP at `ADDR`, e.g. localhost:8080, until interrupted")
+	verifyDir := flag.String("verify", "", "verify files extracted to this directory against the image and report PASS / FAIL per object")
+	trackObject := flag.String("track", "", "path or object ID to follow across all given dumps of one device")
+	spaceReport := flag.Bool("space", false, "report used, obsolete, erased and free chunks")
+	catObject := flag.String("cat", "", "write the content of the file with this path or object ID to stdout")
+	headerDumpPath := flag.String("dump-headers", "", "write every header version with all raw fields as JSON lines to this file, - for stdout")
+	var transforms yaffs.TransformPipeline
+	flag.Var(&transforms, "transform", "add an input transform stage, applied in order: offset=N, length=N, byteswap=WORD, deinterleave=WAYS:STRIDE, descramble=POLYNOMIAL:UNIT:SEED[,SEED...], strip=UNIT:KEEP or plugin=UNIT:COMMAND")
+	sortKey := choiceFlag("sort", "", sortKeyNames(), "sort listings by name, size, mtime, id or seq instead of scan order")
+	reverseSort := flag.Bool("reverse", false, "sort listings in descending order")
+	allVersions := flag.Bool("all-versions", false, "list every header version found in physical order, not only the current state of each object")
+	maxDepth := flag.Int("max-depth", -1, "list only paths up to this many levels below the root, negative for no limit")
+	typeFilter := choiceFlag("type", "", typeLetterNames(), "list only objects of these comma separated types: f, d, l, h (hardlink) or s (special)")
+	maxMemory := flag.Int64("max-memory", 0, "abort when the process uses more than this many bytes of memory, zero for no limit")
+	maxOpenFiles := flag.Uint64("max-open-files", 0, "limit of open file descriptors, zero to keep the system limit")
+	timeout := flag.Duration("timeout", 0, "abort when processing takes longer, e.g. 10m, zero for no limit")
+	minSize := flag.Int64("min-size", -1, "list or export only files of at least this many bytes")
+	maxSize := flag.Int64("max-size", -1, "list or export only files of at most this many bytes")
+	newerThan := flag.String("newer-than", "", "list or export only objects modified at or after this time: epoch seconds, RFC 3339 or YYYY-MM-DD[ HH:MM:SS] in -time-zone")
+	olderThan := flag.String("older-than", "", "list or export only objects modified at or before this time, in the formats of -newer-than")
+	listFormat := flag.String("format", "", "Go template printed per listed object instead of the ls -l layout, e.g. '{{.ObjectID}} {{.Path}} {{time .ModTime}}', or json for the full metadata and chunk list of every object")
+	escapeMode := choiceFlag("escape", "auto", []string{"auto", "always", "never"}, "escape control characters in listed names: auto, always or never")
+	completionShell := choiceFlag("completion", "", completionShells, "print a completion script for bash, zsh or fish and exit")
+	logFormat := choiceFlag("log-format", "text", []string{"text", "json"}, "format of diagnostics on stderr: text or json")
+	quiet := flag.Bool("quiet", false, "leave out detection and scan diagnostics and anomalies on stderr, printing only errors")
+	scanSummary := flag.Bool("summary", false, "print counts of chunks, objects by state and type, anomalies by kind and pages corrected by the ECC")
+	anomalyPath := flag.String("anomalies", "", "write every anomaly found while scanning to this file, - for stdout")
+	anomalyFormat := choiceFlag("anomaly-format", "json", []string{"json", "csv"}, "format of the anomaly report: json or csv")
+	sparePolicyName := choiceFlag("invalid-spares", "skip", []string{"skip", "abort", "header", "alternate"}, "action for pages with an invalid spare: skip, abort, header (signature fallback) or alternate (other spare offsets)")
+	spareOffsets := flag.String("spare-offsets", "", "byte offsets of the tag fields within the spare as SEQ,OBJ,CHUNK,NBYTES, replacing the detected spare skip")
+	tskConfigPath := flag.String("tsk-config", "", "read page size, spare size and tag offsets from this TSK yaffs2 config instead of detecting them")
+	byteOrderName := choiceFlag("byte-order", "auto", []string{"auto", "little", "big"}, "byte order of object headers and, unless -tag-byte-order is given, tags: auto, little or big")
+	tagByteOrderName := choiceFlag("tag-byte-order", "auto", []string{"auto", "little", "big"}, "byte order of the tags in the spare: auto, little or big")
+	spareMapRanges := flag.String("spare-map", "", "comma separated byte ranges of the spare holding the tags, gathered in order before decoding, e.g. 2-5,8-19")
+	chunkMapPath := flag.String("chunk-map", "", "write the class of every chunk as CSV to this file, - for stdout")
+	chunkMapPNG := flag.String("chunk-map-png", "", "render the class of every chunk as a PNG heatmap to this file")
+	manifestPath := flag.String("manifest", "", "write path, type, size, mode, owner, timestamps, object ID and deleted flag of every object as CSV to this file, - for stdout")
+	bodyfilePath := flag.String("bodyfile", "", "write a TSK bodyfile of every object for mactime and Plaso timelines to this file, - for stdout")
+	dfxmlPath := flag.String("dfxml", "", "write a DFXML fileobject with byte runs in the dump for every object to this file, - for stdout")
+	casePath := flag.String("case", "", "write every object as a CASE/UCO File observable with metadata and SHA-256 in JSON-LD to this file, - for stdout")
+	databasePath := flag.String("sqlite-db", "", "write all header versions, chunks and anomalies to a new SQLite database at this path for SQL queries")
+	sqliteReport := flag.Bool("sqlite", false, "report every SQLite database version with its -wal, -journal and -shm companions")
+	ignoreEncryption := flag.Bool("ignore-encryption", false, "list objects even if the image looks encrypted")
+	cryptoDir := flag.String("extract-crypto", "", "write encryption footers and key blob files such as keystore and vold keys to this directory")
+	grepPattern := flag.String("grep", "", "search the content of live files for this regular expression and print path, offset and line of each match")
+	grepGlob := flag.String("grep-path", "", "only search files whose path matches this glob, e.g. /data/*/*.xml")
+	grepBinary := flag.Bool("grep-binary", false, "also search files that look binary")
+	diffObject := flag.String("diff", "", "compare two on-flash versions of the file with this path or object ID")
+	diffVersions := flag.String("diff-versions", "", "versions to compare as FROM,TO, numbered from 1 for the oldest (default the two newest)")
+	versionsObject := flag.String("versions", "", "list every on-flash version of the file with this path or object ID")
+	damagedFiles := flag.Bool("damaged-files", false, "list the byte ranges of live files read from bad or uncorrectable blocks, or missing")
+	dedup := flag.Bool("dedup", false, "hardlink files of directory exports with identical content and attributes to one stored copy")
+	historyDir := flag.String("export-versions", "", "write every on-flash version of every file as PATH.v<sequence> and a manifest below `DIR`")
+	versionsDir := flag.String("versions-dir", "", "with -versions, write each version as NAME.v<sequence> and a manifest to this directory")
+	recoverability := flag.Bool("recoverability", false, "estimate how much of every deleted file is still recoverable")
+	layoutConfigPath := flag.String("layout-config", "", "read page size, spare size, tag, ECC and bad block marker positions from this JSON `FILE` instead of detecting them")
+	oobProfileName := choiceFlag("oob-profile", "", yaffs.OOBProfileNames(), "spare layout of a controller family, giving tag, ECC and bad block marker positions: "+strings.Join(yaffs.OOBProfileNames(), ", "))
+	spareDecoderName := choiceFlag("spare-decoder", "packed-tags2", yaffs.SpareDecoderNames(), "registered decoder for the tags in the spare")
+	headerDecoderName := choiceFlag("header-decoder", "yaffs2", yaffs.HeaderDecoderNames(), "registered decoder for object headers")
+	oobPath := flag.String("oob", "", "read the spares from this separate OOB `FILE`, paired page by page with the image holding only page data")
+	eccSchemeName := flag.String("ecc", "", "ECC scheme and layout of the page data as `KIND[/STEP]@OFFSET` instead of detecting it: none, hamming, hamming-sm or bchN correcting N bits per step, e.g. bch8@32")
+	inband := flag.Bool("inband", false, "read packed tags from the last 16 bytes of each chunk instead of a spare area, detecting the chunk size if the image starts with a header")
+	yaffs1 := flag.Bool("yaffs1", false, "read the image as YAFFS1 with 512 byte pages and 16 byte spares instead of detecting the geometry")
+	pagesPerBlock := flag.Int("pages-per-block", 0, "pages per erase block, enables the per-block sequence number check")
+	prefetch := flag.Int64("prefetch", 0, "bytes of the image to read ahead in the background, for slow media such as network shares")
+	indexOnly := flag.Bool("index-only", false, "read only spare areas and headers while scanning, reading file data when needed")
+	mtreePath := flag.String("mtree", "", "write an mtree(8) specification of the live tree with sha256 digests to `FILE`")
+	s3CacheDir := flag.String("s3-cache", "", "cache ranges of s3:// images in `DIR`, instead of the user cache directory")
+	series := flag.Bool("series", false, "report files added, changed and removed between each of the given dumps of one device, in order")
+	jobs := flag.Int("jobs", 0, "limit CPUs and workers of every stage, one per CPU if zero")
+	flag.IntVar(&jobLimits.Detect, "detect-jobs", 0, "workers evaluating geometries during detection, overriding -jobs")
+	flag.IntVar(&jobLimits.Hash, "hash-jobs", 0, "workers hashing file content, overriding -jobs")
+	flag.IntVar(&jobLimits.Extract, "extract-jobs", 0, "workers writing files during extraction, overriding -jobs")
+	deletedDir := flag.String("recover-deleted", "", "reconstruct deleted and unlinked files from their newest chunks, superseded ones included, below `DIR` at their original paths")
+	orphansDir := flag.String("recover-orphans", "", "reassemble data chunks of objects without any header into nameless files in `DIR`")
+	squashfsPath := flag.String("squashfs", "", "write the live tree as a SquashFS image to `FILE`")
+	ext4Path := flag.String("ext4", "", "write the live tree as an ext4 image to `FILE`, using mkfs.ext4 and debugfs")
+	ext4Size := flag.Int64("ext4-size", 0, "size of the ext4 image in bytes, by default fitted to the content")
+	avdDir := flag.String("avd", "", "write an Android emulator device booting the live tree as its data partition to the AVD home `DIR`, using mkfs.ext4 and debugfs")
+	avdAPI := flag.Int("avd-api", 0, "API level of the system image the -avd device boots, by default that of /system/packages.xml in the dump")
+	avdABI := choiceFlag("avd-abi", "armeabi-v7a", avdABINames(), "ABI of the system image the -avd device boots: armeabi-v7a, arm64-v8a, x86 or x86_64")
+	flag.StringVar(&outputDir, "output-dir", "", "write the TSK config, reports, manifests and images given by relative paths to this directory instead of next to the image")
+	detect := flag.Bool("detect", false, "print the dump format, geometry and ECC scheme, and exit unless another report is requested")
+	statObject := flag.String("stat", "", "print the metadata of the object with this path or object ID")
+
+	flag.Usage = func() {
+		writeCommandList(flag.CommandLine.Output())
+		fmt.Fprintln(flag.CommandLine.Output(), "\nFlags:")
+		flag.PrintDefaults()
+	}
+	args, err := parseSubcommand(os.Args[1:])
+	if err != nil {
+		return err
+	}
+	flag.CommandLine.Parse(args)
+	err = checkOutputFlags(flag.CommandLine)
+	if err != nil {
+		return err
+	}
+
+	err = setLogFormat(*logFormat, os.Stderr)
+	if err != nil {
+		return err
+	}
+	var logger *log.Logger
+	if !*quiet {
+		logger = log.Default()
+	}
+
+	if *completionShell != "" {
+		err = writeCompletion(os.Stdout, *completionShell, filepath.Base(os.Args[0]))
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if flag.NArg() < 1 {
+		return fmt.Errorf("Usage: %s [flags] IMAGE...", os.Args[0])
+	}
+
+	if *dedup {
+		outputStore = newDedupStore()
+		defer func() {
+			if outputStore.saved > 0 {
+				log.Printf("Deduplication saved %d bytes", outputStore.saved)
+			}
+		}()
+	}
+
+	if outputDir != "" {
+		err = os.MkdirAll(outputDir, 0777)
+		if err != nil {
+			return err
+		}
+		for _, output := range []*string{carveDir, eventsPath, headerDumpPath, anomalyPath, chunkMapPath, chunkMapPNG, manifestPath, bodyfilePath, dfxmlPath, casePath, databasePath,
+			cryptoDir, versionsDir, historyDir, mtreePath, orphansDir, deletedDir, squashfsPath, ext4Path, avdDir, extractDir, tarPath, zipPath} {
+			*output = outputPath(*output)
+		}
+	}
+
+	handleInterrupts()
+
+	limits := ResourceLimits{Memory: *maxMemory, OpenFiles: *maxOpenFiles, Timeout: *timeout}
+	err = limits.Apply()
+	if err != nil {
+		return err
+	}
+
+	if *ext4Path != "" || *avdDir != "" {
+		err = checkExt4Tools()
+		if err != nil {
+			return err
+		}
+	}
+
+	if *jobs > 0 {
+		runtime.GOMAXPROCS(*jobs)
+		if jobLimits.Detect <= 0 {
+			jobLimits.Detect = *jobs
+		}
+		if jobLimits.Hash <= 0 {
+			jobLimits.Hash = *jobs
+		}
+		if jobLimits.Extract <= 0 {
+			jobLimits.Extract = *jobs
+		}
+	}
+
+	var decoding yaffs.Decoding
+	decoding.SparePolicy, err = yaffs.ParseSparePolicy(*sparePolicyName)
+	if err != nil {
+		return err
+	}
+
+	var listOptions ListOptions
+	listOptions.Color, err = terminalFeature(*colorMode, os.Stdout)
+	if err != nil {
+		return err
+	}
+	listOptions.Escape, err = terminalFeature(*escapeMode, os.Stdout)
+	if err != nil {
+		return err
+	}
+	listOptions.Sort, err = parseSortKey(*sortKey)
+	if err != nil {
+		return err
+	}
+	listOptions.Reverse = *reverseSort
+	listOptions.AllVersions = *allVersions
+	if *listFormat == "json" {
+		listOptions.JSON = true
+	} else if *listFormat != "" {
+		listOptions.Template, err = parseListTemplate(*listFormat)
+		if err != nil {
+			return err
+		}
+	}
+	listOptions.MaxDepth = *maxDepth
+	listOptions.Types, err = parseTypeFilter(*typeFilter)
+	if err != nil {
+		return err
+	}
+
+	timeFormat, err = yaffs.ParseTimeFormat(*timeFormatName, *timeZone)
+	if err != nil {
+		return err
+	}
+
+	listOptions.MinSize, listOptions.MaxSize = *minSize, *maxSize
+	if *newerThan != "" {
+		bound, err := timeFormat.ParseArgument(*newerThan)
+		if err != nil {
+			return err
+		}
+		listOptions.NewerThan = &bound
+	}
+	if *olderThan != "" {
+		bound, err := timeFormat.ParseArgument(*olderThan)
+		if err != nil {
+			return err
+		}
+		listOptions.OlderThan = &bound
+	}
+
+	objectIDs := yaffs.ReferenceObjectIDRules
+	objectIDs.SpecialIDs, err = yaffs.ParseIDList(*specialIDs)
+	if err != nil {
+		return err
+	}
+	if *idRanges != "" {
+		objectIDs.Ranges, err = yaffs.ParseIDRanges(*idRanges)
+		if err != nil {
+			return err
+		}
+	} else {
+		objectIDs.Ranges, err = yaffs.ObjectSpaceRanges(*objectSpace)
+		if err != nil {
+			return err
+		}
+	}
+	decoding.ObjectIDs = &objectIDs
+
+	imageOptions := &yaffs.ImageOptions{Transforms: &transforms, Anchored: *headerAnchor >= 0, Anchor: *headerAnchor, Inband: *inband, OOBPath: *oobPath, Prefetch: *prefetch,
+		PagesPerBlock: *pagesPerBlock, IndexOnly: *indexOnly, Workers: jobLimits.Detect, S3CacheDir: *s3CacheDir,
+		Context: runContext, Logger: logger}
+	imageOptions.ByteOrder, err = yaffs.ParseByteOrder(*byteOrderName)
+	if err != nil {
+		return err
+	}
+	imageOptions.TagByteOrder, err = yaffs.ParseByteOrder(*tagByteOrderName)
+	if err != nil {
+		return err
+	}
+	if *eccSchemeName != "" {
+		imageOptions.ECC, err = yaffs.ParseECCScheme(*eccSchemeName)
+		if err != nil {
+			return err
+		}
+	}
+	var spareLayout *yaffs.SpareLayout
+	if *tskConfigPath != "" {
+		imageOptions.Settings, spareLayout, err = yaffs.ReadTSKConfig(*tskConfigPath)
+		if err != nil {
+			return err
+		}
+	}
+	if *yaffs1 {
+		if imageOptions.Settings != nil {
+			return errors.New("-yaffs1 and -tsk-config both set the geometry, use one")
+		}
+		imageOptions.Settings = yaffs.Yaffs1Settings()
+	}
+
+	decoding.SpareDecoder, err = yaffs.LookupSpareDecoder(*spareDecoderName)
+	if err != nil {
+		return err
+	}
+	decoding.HeaderDecoder, err = yaffs.LookupHeaderDecoder(*headerDecoderName)
+	if err != nil {
+		return err
+	}
+
+	if *spareOffsets != "" {
+		spareLayout, err = yaffs.ParseSpareLayout(*spareOffsets)
+		if err != nil {
+			return err
+		}
+	}
+	if spareLayout != nil {
+		decoding.SpareDecoder = spareLayout
+	}
+
+	var profile *yaffs.OOBProfile
+	if *oobProfileName != "" {
+		profile, err = yaffs.LookupOOBProfile(*oobProfileName)
+		if err != nil {
+			return err
+		}
+	}
+	if *layoutConfigPath != "" {
+		if profile != nil || imageOptions.Settings != nil {
+			return errors.New("-layout-config gives geometry and spare layout, drop -oob-profile, -tsk-config and -yaffs1")
+		}
+		imageOptions.Settings, profile, err = yaffs.ReadLayoutConfig(*layoutConfigPath)
+		if err != nil {
+			return err
+		}
+	}
+	if profile != nil {
+		if *spareOffsets != "" || *spareMapRanges != "" || spareLayout != nil {
+			return errors.New("-oob-profile and -layout-config give the tag positions, drop -spare-offsets, -spare-map and -tsk-config")
+		}
+		ecc, err := profile.Apply(&decoding)
+		if err != nil {
+			return err
+		}
+		if imageOptions.ECC == nil {
+			imageOptions.ECC = ecc
+		}
+		log.Println("OOB profile", profile)
+	}
+
+	if *spareMapRanges != "" {
+		spareMap, err := yaffs.ParseSpareMap(*spareMapRanges)
+		if err != nil {
+			return err
+		}
+		decoding.SpareDecoder = &yaffs.MappedSpareDecoder{Map: spareMap, Decoder: decoding.SpareDecoder}
+	}
+
+	// Plugins receive the complete spare
+	if *layoutPluginCommand != "" {
+		plugin, err := yaffs.StartPlugin(*layoutPluginCommand)
+		if err != nil {
+			return err
+		}
+		defer plugin.Close()
+		decoding.SpareDecoder = plugin
+	}
+	imageOptions.Decoding = decoding
+
+	if *scriptCommand != "" {
+		objectHook, err = yaffs.StartPlugin(*scriptCommand)
+		if err != nil {
+			return err
+		}
+		defer objectHook.Close()
+	}
+
+	if *oobPath != "" && flag.NArg() > 1 {
+		return errors.New("-oob pairs one OOB file with a single image")
+	}
+
+	if *trackObject != "" {
+		err = trackAcrossDumps(os.Stdout, *trackObject, flag.Args(), imageOptions)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if *series {
+		err = writeSeriesHistory(os.Stdout, flag.Args(), imageOptions)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if flag.NArg() != 1 {
+		return fmt.Errorf("Usage: %s [flags] IMAGE", os.Args[0])
+	}
+	imagePath := flag.Arg(0)
+
+	img, err := yaffs.OpenImage(imagePath, imageOptions)
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+
+	image, imageSize, settings := img.Reader, img.Size, img.Settings
+
+	if *detect {
+		err = img.WriteInfo(os.Stdout)
+		if err != nil {
+			return err
+		}
+		if !*spaceReport {
+			return nil
+		}
+	}
+
+	if img.Classification.Format == yaffs.DUMP_FORMAT_DATA_ONLY {
+		entries, err := yaffs.ScanDataOnly(image, settings)
+		if err != nil {
+			return err
+		}
+		err = writeListing(os.Stdout, entries, settings.ByteOrder, listOptions)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	// Write TSK config, which can only describe little endian YAFFS2. An
+	// imported config is kept as it is, with its comments and byte count
+	// offset.
+	if sameFile(tskConfigOutput(imagePath), *tskConfigPath) {
+		log.Println("Not writing a TSK config over the one read with -tsk-config")
+	} else if settings.ByteOrder == binary.LittleEndian && !settings.Yaffs1 {
+		err = ioutil.WriteFile(tskConfigOutput(imagePath), []byte(yaffs.TSKConfig(settings)), 0666)
+		if err != nil {
+			log.Println(err)
+		}
+	} else {
+		log.Println("Not writing a TSK config, TSK reads only little endian YAFFS2")
+	}
+
+	if *spaceReport {
+		report, err := yaffs.AccountSpace(image, settings)
+		if err != nil {
+			return err
+		}
+		err = report.Write(os.Stdout)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if *chunkMapPath != "" || *chunkMapPNG != "" {
+		chunkMap, err := yaffs.MapChunks(image, settings)
+		if err != nil {
+			return err
+		}
+		if *chunkMapPath != "" {
+			out := os.Stdout
+			if *chunkMapPath != "-" {
+				out, err = os.Create(*chunkMapPath)
+				if err != nil {
+					return err
+				}
+				defer out.Close()
+			}
+			err = chunkMap.WriteCSV(out)
+			if err != nil {
+				return err
+			}
+		}
+		if *chunkMapPNG != "" {
+			out, err := os.Create(*chunkMapPNG)
+			if err != nil {
+				return err
+			}
+			defer out.Close()
+			err = chunkMap.WritePNG(out)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if *carveDir != "" {
+		regions, err := findRegions(image, settings)
+		if err != nil {
+			return err
+		}
+		log.Printf("Found %d YAFFS regions", len(regions))
+
+		err = writePartitionReports(*carveDir, regions, settings)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	emit := logAnomalies
+	if *quiet {
+		emit = func(yaffs.Event) {}
+	}
+	if *eventsPath != "" {
+		eventsFile, err := os.Create(*eventsPath)
+		if err != nil {
+			return err
+		}
+		defer eventsFile.Close()
+		emit = yaffs.MultiHandler(emit, yaffs.JSONEventWriter(eventsFile, logger))
+	}
+	var anomalies anomalyCollector
+	if *anomalyPath != "" || *scanSummary || *databasePath != "" {
+		emit = yaffs.MultiHandler(emit, anomalies.Handle)
+	}
+
+	result, err := yaffs.ScanImage(image, settings, imageSize, emit)
+	if err != nil {
+		return err
+	}
+	var objectTags map[uint32][]string
+	if objectHook != nil {
+		objectTags, err = applyObjectHook(objectHook, result)
+		if err != nil {
+			return err
+		}
+	}
+	entries := result.Entries
+
+	if *anomalyPath != "" {
+		out := os.Stdout
+		if *anomalyPath != "-" {
+			out, err = os.Create(*anomalyPath)
+			if err != nil {
+				return err
+			}
+			defer out.Close()
+		}
+		err = anomalies.Write(out, *anomalyFormat)
+		if err != nil {
+			return err
+		}
+		// On stdout the report is the output, as of report anomalies
+		if *anomalyPath == "-" {
+			return nil
+		}
+	}
+
+	if *scanSummary {
+		err = writeScanSummary(os.Stdout, result, &anomalies)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	encryption, err := detectEncryption(image, result)
+	if err != nil {
+		return err
+	}
+	for _, indicator := range encryption.Indicators() {
+		log.Println("Encryption indicator:", indicator)
+	}
+	if *cryptoDir != "" {
+		err = extractCryptoMaterial(*cryptoDir, image, imageSize, encryption, result)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+	if encryption.Encrypted() {
+		log.Println("Image looks encrypted, file content will not be recoverable in plaintext")
+		if !*ignoreEncryption {
+			return errors.New("Not listing encrypted image, use -ignore-encryption to list anyway")
+		}
+	}
+
+	if *headerDumpPath != "" {
+		out := os.Stdout
+		if *headerDumpPath != "-" {
+			out, err = os.Create(*headerDumpPath)
+			if err != nil {
+				return err
+			}
+			defer out.Close()
+		}
+		err = dumpRawHeaders(out, result)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if *catObject != "" {
+		id, ok := findObject(result.Tree, *catObject)
+		if !ok {
+			return fmt.Errorf("No object %s in image", *catObject)
+		}
+		_, err = result.WriteObject(os.Stdout, id)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if *statObject != "" {
+		id, ok := findObject(result.Tree, *statObject)
+		if !ok {
+			return fmt.Errorf("No object %s in image", *statObject)
+		}
+		err = writeObjectStat(os.Stdout, result, id)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if *diffObject != "" {
+		id, ok := findObject(result.Tree, *diffObject)
+		if !ok {
+			return fmt.Errorf("No object %s in image", *diffObject)
+		}
+		versions, err := result.FileVersions(id)
+		if err != nil {
+			return err
+		}
+		from, to, err := selectVersions(versions, *diffVersions)
+		if err != nil {
+			return err
+		}
+		err = writeVersionDiff(result, os.Stdout, from, to)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if *historyDir != "" {
+		err = os.MkdirAll(*historyDir, 0777)
+		if err != nil {
+			return err
+		}
+		manifest, err := os.Create(filepath.Join(*historyDir, "manifest.txt"))
+		if err != nil {
+			return err
+		}
+		defer manifest.Close()
+		err = exportAllVersions(result, io.MultiWriter(os.Stdout, manifest), *historyDir)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if *versionsObject != "" {
+		id, ok := findObject(result.Tree, *versionsObject)
+		if !ok {
+			return fmt.Errorf("No object %s in image", *versionsObject)
+		}
+		versions, err := result.FileVersions(id)
+		if err != nil {
+			return err
+		}
+		out := io.Writer(os.Stdout)
+		if *versionsDir != "" {
+			err = os.MkdirAll(*versionsDir, 0777)
+			if err != nil {
+				return err
+			}
+			manifest, err := os.Create(filepath.Join(*versionsDir, "manifest.txt"))
+			if err != nil {
+				return err
+			}
+			defer manifest.Close()
+			out = io.MultiWriter(os.Stdout, manifest)
+		}
+		err = exportVersions(result, out, versions, *versionsDir)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if *tarPath != "" {
+		out := os.Stdout
+		if *tarPath != "-" {
+			out, err = os.Create(*tarPath)
+			if err != nil {
+				return err
+			}
+			defer out.Close()
+		}
+		err = writeTar(out, result, listOptions.ObjectFilter)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if *zipPath != "" {
+		out, err := os.Create(*zipPath)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		manifest, err := os.Create(zipManifestPath(*zipPath))
+		if err != nil {
+			return err
+		}
+		defer manifest.Close()
+		err = writeZip(out, manifest, result, listOptions.ObjectFilter)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if *extractDir != "" {
+		err = os.MkdirAll(*extractDir, 0777)
+		if err != nil {
+			return err
+		}
+		err = extractTree(*extractDir, result, os.Stdout, ExtractOptions{
+			Resume:          *resumeExtract,
+			RewriteSymlinks: *rewriteSymlinks,
+			SymlinkPrefix:   *symlinkPrefix,
+			Jobs:            jobLimits.Extract,
+			Filter:          listOptions.ObjectFilter,
+		})
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	fsOptions := yaffs.FileSystemOptions{Deleted: *mountDeleted, Versions: *mountVersions}
+	if *mountpoint != "" {
+		err = mountTree(*mountpoint, result, fsOptions)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if *serveAddr != "" {
+		err = serveTree(*serveAddr, result, fsOptions)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if *verifyDir != "" {
+		failures, err := verifyExtraction(*verifyDir, result, os.Stdout)
+		if err != nil {
+			return err
+		}
+		if failures > 0 {
+			return fmt.Errorf("Verification failed for %d objects", failures)
+		}
+		log.Println("Verification passed")
+		return nil
+	}
+
+	if *grepPattern != "" {
+		pattern, err := regexp.Compile(*grepPattern)
+		if err != nil {
+			return err
+		}
+		err = grepFiles(os.Stdout, result, pattern, GrepOptions{Glob: *grepGlob, Binary: *grepBinary, Escape: listOptions.Escape})
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if *deletedDir != "" {
+		err = os.MkdirAll(*deletedDir, 0777)
+		if err != nil {
+			return err
+		}
+		manifest, err := os.Create(filepath.Join(*deletedDir, "manifest.txt"))
+		if err != nil {
+			return err
+		}
+		defer manifest.Close()
+		err = recoverDeleted(result, io.MultiWriter(os.Stdout, manifest), result.DeletedFiles(), *deletedDir)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if *orphansDir != "" {
+		err = os.MkdirAll(*orphansDir, 0777)
+		if err != nil {
+			return err
+		}
+		manifest, err := os.Create(filepath.Join(*orphansDir, "manifest.txt"))
+		if err != nil {
+			return err
+		}
+		defer manifest.Close()
+		err = recoverOrphans(result, io.MultiWriter(os.Stdout, manifest), findOrphanGroups(result), *orphansDir)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if *damagedFiles {
+		chunkMap, err := yaffs.MapChunks(image, settings)
+		if err != nil {
+			return err
+		}
+		err = writeAffectedFiles(os.Stdout, chunkMap, findAffectedFiles(result, chunkMap))
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if *recoverability {
+		err = writeRecoverability(os.Stdout, estimateRecoverability(result))
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if *ext4Path != "" {
+		err = writeExt4(*ext4Path, result, *ext4Size)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if *avdDir != "" {
+		err = writeAVD(*avdDir, result, *avdAPI, *avdABI, *ext4Size)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if *squashfsPath != "" {
+		err = writeSquashfs(*squashfsPath, result)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if *mtreePath != "" {
+		mtreeFile, err := os.Create(*mtreePath)
+		if err != nil {
+			return err
+		}
+		defer mtreeFile.Close()
+		err = writeMtree(mtreeFile, result)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if *databasePath != "" {
+		err = writeDatabase(*databasePath, result, &anomalies)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if *manifestPath != "" {
+		out := os.Stdout
+		if *manifestPath != "-" {
+			out, err = os.Create(*manifestPath)
+			if err != nil {
+				return err
+			}
+			defer out.Close()
+		}
+		err = writeManifest(out, entries, settings.ByteOrder)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if *bodyfilePath != "" {
+		out := os.Stdout
+		if *bodyfilePath != "-" {
+			out, err = os.Create(*bodyfilePath)
+			if err != nil {
+				return err
+			}
+			defer out.Close()
+		}
+		err = writeBodyfile(out, entries, settings.ByteOrder)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if *dfxmlPath != "" {
+		out := os.Stdout
+		if *dfxmlPath != "-" {
+			out, err = os.Create(*dfxmlPath)
+			if err != nil {
+				return err
+			}
+			defer out.Close()
+		}
+		err = writeDFXML(out, imagePath, entries, result, settings.ByteOrder)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if *casePath != "" {
+		out := os.Stdout
+		if *casePath != "-" {
+			out, err = os.Create(*casePath)
+			if err != nil {
+				return err
+			}
+			defer out.Close()
+		}
+		err = writeCASE(out, imagePath, entries, result, settings.ByteOrder)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if *sqliteReport {
+		err = writeSQLiteReport(os.Stdout, findSQLiteDatabases(result))
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	for k := range entries {
+		entries[k].Tags = append(entries[k].Tags, objectTags[entries[k].ObjectID]...)
+	}
+
+	listOptions.Result = result
+	return writeListing(os.Stdout, entries, settings.ByteOrder, listOptions)
+}
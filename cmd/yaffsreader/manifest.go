@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/csv"
+	"io"
+	"strconv"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+var manifestColumns = []string{"path", "type", "size", "mode", "uid", "gid", "mtime", "atime", "ctime", "object_id", "deleted"}
+
+// Write one CSV row per object in its current state, deleted objects
+// included, for triage in a spreadsheet. Modes are octal, timestamps in
+// the -time-format.
+func writeManifest(w io.Writer, entries []yaffs.ListEntry, byteOrder binary.ByteOrder) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write(manifestColumns)
+	if err != nil {
+		return err
+	}
+
+	for k := range entries {
+		if entries[k].Obsolete {
+			continue
+		}
+		m := entries[k].Metadata(byteOrder)
+		err = cw.Write([]string{
+			m.Path,
+			m.Type,
+			strconv.FormatUint(m.Size, 10),
+			strconv.FormatUint(uint64(m.Mode), 8),
+			strconv.FormatUint(uint64(m.UID), 10),
+			strconv.FormatUint(uint64(m.GID), 10),
+			timeFormat.Format(m.ModTime),
+			timeFormat.Format(m.AccessTime),
+			timeFormat.Format(m.CreateTime),
+			strconv.FormatUint(uint64(m.ObjectID), 10),
+			strconv.FormatBool(m.Deleted),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
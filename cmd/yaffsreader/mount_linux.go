@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"syscall"
+	"unsafe"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+// FUSE kernel protocol, see include/uapi/linux/fuse.h. Only the requests
+// of a read-only filesystem are answered, everything else gets ENOSYS.
+const (
+	FUSE_LOOKUP       = 1
+	FUSE_FORGET       = 2
+	FUSE_GETATTR      = 3
+	FUSE_READLINK     = 5
+	FUSE_OPEN         = 14
+	FUSE_READ         = 15
+	FUSE_STATFS       = 17
+	FUSE_RELEASE      = 18
+	FUSE_FLUSH        = 25
+	FUSE_INIT         = 26
+	FUSE_OPENDIR      = 27
+	FUSE_READDIR      = 28
+	FUSE_RELEASEDIR   = 29
+	FUSE_INTERRUPT    = 36
+	FUSE_DESTROY      = 38
+	FUSE_BATCH_FORGET = 42
+
+	FUSE_KERNEL_VERSION       = 7
+	FUSE_KERNEL_MINOR_VERSION = 31
+	FUSE_MAX_WRITE            = 128 << 10
+	FUSE_READ_BUFFER          = FUSE_MAX_WRITE + 4096
+
+	// Seconds the kernel may cache entries and attributes, nothing changes
+	FUSE_CACHE_TIMEOUT = 3600
+)
+
+type fuseInHeader struct {
+	Len     uint32
+	Opcode  uint32
+	Unique  uint64
+	NodeID  uint64
+	UID     uint32
+	GID     uint32
+	PID     uint32
+	Padding uint32
+}
+
+type fuseOutHeader struct {
+	Len    uint32
+	Error  int32
+	Unique uint64
+}
+
+type fuseInitOut struct {
+	Major               uint32
+	Minor               uint32
+	MaxReadahead        uint32
+	Flags               uint32
+	MaxBackground       uint16
+	CongestionThreshold uint16
+	MaxWrite            uint32
+	TimeGran            uint32
+	MaxPages            uint16
+	MapAlignment        uint16
+	Flags2              uint32
+	Unused              [7]uint32
+}
+
+type fuseAttr struct {
+	Ino       uint64
+	Size      uint64
+	Blocks    uint64
+	Atime     uint64
+	Mtime     uint64
+	Ctime     uint64
+	AtimeNsec uint32
+	MtimeNsec uint32
+	CtimeNsec uint32
+	Mode      uint32
+	Nlink     uint32
+	UID       uint32
+	GID       uint32
+	RDev      uint32
+	BlkSize   uint32
+	Flags     uint32
+}
+
+type fuseEntryOut struct {
+	NodeID         uint64
+	Generation     uint64
+	EntryValid     uint64
+	AttrValid      uint64
+	EntryValidNsec uint32
+	AttrValidNsec  uint32
+	Attr           fuseAttr
+}
+
+type fuseAttrOut struct {
+	AttrValid     uint64
+	AttrValidNsec uint32
+	Dummy         uint32
+	Attr          fuseAttr
+}
+
+type fuseOpenOut struct {
+	FH        uint64
+	OpenFlags uint32
+	Padding   uint32
+}
+
+type fuseReadIn struct {
+	FH        uint64
+	Offset    uint64
+	Size      uint32
+	ReadFlags uint32
+	LockOwner uint64
+	Flags     uint32
+	Padding   uint32
+}
+
+type fuseStatfsOut struct {
+	Blocks  uint64
+	BFree   uint64
+	BAvail  uint64
+	Files   uint64
+	FFree   uint64
+	BSize   uint32
+	NameLen uint32
+	FrSize  uint32
+	Padding uint32
+	Spare   [6]uint32
+}
+
+type fuseDirent struct {
+	Ino     uint64
+	Off     uint64
+	NameLen uint32
+	Type    uint32
+}
+
+// The kernel uses the byte order of the host
+var hostByteOrder = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// Serves a yaffs.FileSystem with its inode numbers; the root object ID is
+// also the FUSE root node ID
+type fuseServer struct {
+	dev   *os.File
+	fsys  *yaffs.FileSystem
+	paths map[uint64]string
+	files map[uint64]fs.File
+	next  uint64
+}
+
+// Mount the live tree of the scan read-only at mountpoint and serve it
+// until it is unmounted or the process is interrupted. Mounting directly
+// needs root, there is no fusermount fallback.
+func mountTree(mountpoint string, result *yaffs.ScanResult, options yaffs.FileSystemOptions) error {
+	dev, err := os.OpenFile("/dev/fuse", os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer dev.Close()
+
+	mountOptions := fmt.Sprintf("fd=%d,rootmode=40000,user_id=%d,group_id=%d,default_permissions,allow_other", dev.Fd(), os.Getuid(), os.Getgid())
+	err = syscall.Mount("yaffsreader", mountpoint, "fuse.yaffsreader", syscall.MS_RDONLY|syscall.MS_NOSUID|syscall.MS_NODEV, mountOptions)
+	if errors.Is(err, syscall.EPERM) {
+		return fmt.Errorf("mounting %s: %w; mounting needs root, convert to squashfs or extract instead", mountpoint, err)
+	}
+	if err != nil {
+		return fmt.Errorf("mounting %s: %w", mountpoint, err)
+	}
+	log.Printf("Mounted at %s, unmount or interrupt to stop", mountpoint)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-done:
+		case <-runContext.Done():
+			syscall.Unmount(mountpoint, syscall.MNT_DETACH)
+		}
+	}()
+
+	server := &fuseServer{
+		dev:   dev,
+		fsys:  yaffs.NewFileSystem(result, options),
+		paths: map[uint64]string{yaffs.YAFFS_OBJECTID_ROOT: "."},
+		files: make(map[uint64]fs.File),
+	}
+	return server.serve()
+}
+
+func (s *fuseServer) serve() error {
+	buf := make([]byte, FUSE_READ_BUFFER)
+	for {
+		n, err := s.dev.Read(buf)
+		if errors.Is(err, syscall.ENODEV) {
+			return nil // unmounted
+		}
+		if errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.ENOENT) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		var header fuseInHeader
+		headerSize := binary.Size(header)
+		if n < headerSize {
+			return fmt.Errorf("short FUSE request of %d bytes", n)
+		}
+		binary.Read(bytes.NewReader(buf[:headerSize]), hostByteOrder, &header)
+
+		reply, errno := s.handle(&header, buf[headerSize:n])
+		switch header.Opcode {
+		case FUSE_FORGET, FUSE_BATCH_FORGET, FUSE_INTERRUPT:
+			continue
+		}
+		err = s.reply(header.Unique, reply, errno)
+		if err != nil && !errors.Is(err, syscall.ENOENT) {
+			return err
+		}
+		if header.Opcode == FUSE_DESTROY {
+			return nil
+		}
+	}
+}
+
+func (s *fuseServer) reply(unique uint64, reply interface{}, errno syscall.Errno) error {
+	var body bytes.Buffer
+	if errno == 0 {
+		switch reply := reply.(type) {
+		case nil:
+		case []byte:
+			body.Write(reply)
+		default:
+			binary.Write(&body, hostByteOrder, reply)
+		}
+	}
+
+	var out bytes.Buffer
+	header := fuseOutHeader{Error: -int32(errno), Unique: unique}
+	header.Len = uint32(binary.Size(header) + body.Len())
+	binary.Write(&out, hostByteOrder, header)
+	out.Write(body.Bytes())
+	_, err := s.dev.Write(out.Bytes())
+	return err
+}
+
+func (s *fuseServer) handle(header *fuseInHeader, payload []byte) (interface{}, syscall.Errno) {
+	switch header.Opcode {
+	case FUSE_INIT:
+		minor := hostByteOrder.Uint32(payload[4:])
+		if minor > FUSE_KERNEL_MINOR_VERSION {
+			minor = FUSE_KERNEL_MINOR_VERSION
+		}
+		return &fuseInitOut{
+			Major:        FUSE_KERNEL_VERSION,
+			Minor:        minor,
+			MaxReadahead: hostByteOrder.Uint32(payload[8:]),
+			MaxWrite:     FUSE_MAX_WRITE,
+			TimeGran:     1,
+		}, 0
+
+	case FUSE_LOOKUP:
+		parent, ok := s.paths[header.NodeID]
+		if !ok {
+			return nil, syscall.ENOENT
+		}
+		name := string(bytes.TrimRight(payload, "\x00"))
+		objectPath := path.Join(parent, name)
+		info, err := s.fsys.Stat(objectPath)
+		if err != nil {
+			return nil, syscall.ENOENT
+		}
+		attr := fuseAttributes(info)
+		s.paths[attr.Ino] = objectPath
+		return &fuseEntryOut{NodeID: attr.Ino, EntryValid: FUSE_CACHE_TIMEOUT, AttrValid: FUSE_CACHE_TIMEOUT, Attr: attr}, 0
+
+	case FUSE_GETATTR:
+		info, errno := s.stat(header.NodeID)
+		if errno != 0 {
+			return nil, errno
+		}
+		return &fuseAttrOut{AttrValid: FUSE_CACHE_TIMEOUT, Attr: fuseAttributes(info)}, 0
+
+	case FUSE_READLINK:
+		info, errno := s.stat(header.NodeID)
+		if errno != 0 {
+			return nil, errno
+		}
+		object := info.Sys().(*yaffs.ObjectInfo)
+		if info.Mode()&fs.ModeSymlink == 0 || object.Header == nil {
+			return nil, syscall.EINVAL
+		}
+		return []byte(yaffs.CToGoString(object.Header.Alias[:])), 0
+
+	case FUSE_OPEN, FUSE_OPENDIR:
+		objectPath, ok := s.paths[header.NodeID]
+		if !ok {
+			return nil, syscall.ENOENT
+		}
+		file, err := s.fsys.Open(objectPath)
+		if err != nil {
+			return nil, syscall.ENOENT
+		}
+		s.next++
+		s.files[s.next] = file
+		return &fuseOpenOut{FH: s.next}, 0
+
+	case FUSE_READ:
+		var in fuseReadIn
+		binary.Read(bytes.NewReader(payload), hostByteOrder, &in)
+		file, ok := s.files[in.FH].(io.ReaderAt)
+		if !ok {
+			return nil, syscall.EBADF
+		}
+		data := make([]byte, in.Size)
+		n, err := file.ReadAt(data, int64(in.Offset))
+		if err != nil && err != io.EOF {
+			log.Printf("Reading %s failed: %v", s.paths[header.NodeID], err)
+			return nil, syscall.EIO
+		}
+		return data[:n], 0
+
+	case FUSE_READDIR:
+		var in fuseReadIn
+		binary.Read(bytes.NewReader(payload), hostByteOrder, &in)
+		return s.readDir(header.NodeID, in.Offset, int(in.Size))
+
+	case FUSE_RELEASE, FUSE_RELEASEDIR:
+		fh := hostByteOrder.Uint64(payload)
+		if file, ok := s.files[fh]; ok {
+			file.Close()
+			delete(s.files, fh)
+		}
+		return nil, 0
+
+	case FUSE_STATFS:
+		return &fuseStatfsOut{BSize: 4096, FrSize: 4096, NameLen: yaffs.YAFFS_MAX_NAME_LENGTH}, 0
+
+	case FUSE_FLUSH, FUSE_DESTROY, FUSE_FORGET, FUSE_BATCH_FORGET, FUSE_INTERRUPT:
+		return nil, 0
+	}
+	return nil, syscall.ENOSYS
+}
+
+func (s *fuseServer) stat(nodeID uint64) (fs.FileInfo, syscall.Errno) {
+	objectPath, ok := s.paths[nodeID]
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	info, err := s.fsys.Stat(objectPath)
+	if err != nil {
+		return nil, syscall.ENOENT
+	}
+	return info, 0
+}
+
+// Entries from offset on, as many as fit into size bytes. The offset of
+// an entry is its index plus one, counting "." and "..".
+func (s *fuseServer) readDir(nodeID uint64, offset uint64, size int) (interface{}, syscall.Errno) {
+	objectPath, ok := s.paths[nodeID]
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	entries, err := s.fsys.ReadDir(objectPath)
+	if err != nil {
+		return nil, syscall.ENOTDIR
+	}
+
+	type dirent struct {
+		ino  uint64
+		mode uint32
+		name string
+	}
+	list := []dirent{{nodeID, syscall.S_IFDIR, "."}, {nodeID, syscall.S_IFDIR, ".."}}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		attr := fuseAttributes(info)
+		list = append(list, dirent{attr.Ino, attr.Mode, entry.Name()})
+	}
+
+	var out bytes.Buffer
+	for k := offset; k < uint64(len(list)); k++ {
+		entry := list[k]
+		record := fuseDirent{Ino: entry.ino, Off: k + 1, NameLen: uint32(len(entry.name)), Type: entry.mode & syscall.S_IFMT >> 12}
+		length := binary.Size(record) + len(entry.name)
+		padded := (length + 7) &^ 7
+		if out.Len()+padded > size {
+			break
+		}
+		binary.Write(&out, hostByteOrder, record)
+		out.WriteString(entry.name)
+		out.Write(make([]byte, padded-length))
+	}
+	return out.Bytes(), 0
+}
+
+func fuseAttributes(info fs.FileInfo) fuseAttr {
+	object := info.Sys().(*yaffs.ObjectInfo)
+	mode := info.Mode()
+	attr := fuseAttr{
+		Ino:     object.Inode,
+		Size:    uint64(info.Size()),
+		Blocks:  uint64(info.Size()+511) / 512,
+		Mtime:   uint64(info.ModTime().Unix()),
+		Atime:   uint64(info.ModTime().Unix()),
+		Ctime:   uint64(info.ModTime().Unix()),
+		Mode:    uint32(mode.Perm()),
+		Nlink:   1,
+		BlkSize: 4096,
+	}
+	if header := object.Header; header != nil {
+		attr.UID, attr.GID = header.UID, header.GID
+		attr.Atime, attr.Ctime = uint64(header.AccessTime), uint64(header.CreateTime)
+		attr.RDev = header.RDev
+	}
+
+	if mode&fs.ModeSetuid != 0 {
+		attr.Mode |= syscall.S_ISUID
+	}
+	if mode&fs.ModeSetgid != 0 {
+		attr.Mode |= syscall.S_ISGID
+	}
+	if mode&fs.ModeSticky != 0 {
+		attr.Mode |= syscall.S_ISVTX
+	}
+	switch {
+	case mode.IsDir():
+		attr.Mode |= syscall.S_IFDIR
+		attr.Nlink = 2
+	case mode&fs.ModeSymlink != 0:
+		attr.Mode |= syscall.S_IFLNK
+	case mode&fs.ModeCharDevice != 0:
+		attr.Mode |= syscall.S_IFCHR
+	case mode&fs.ModeDevice != 0:
+		attr.Mode |= syscall.S_IFBLK
+	case mode&fs.ModeNamedPipe != 0:
+		attr.Mode |= syscall.S_IFIFO
+	case mode&fs.ModeSocket != 0:
+		attr.Mode |= syscall.S_IFSOCK
+	default:
+		attr.Mode |= syscall.S_IFREG
+	}
+	return attr
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+func mountTree(mountpoint string, result *yaffs.ScanResult, options yaffs.FileSystemOptions) error {
+	return errors.New("mounting is only supported on Linux, convert to squashfs or extract instead")
+}
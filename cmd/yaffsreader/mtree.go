@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+// Write an mtree(8) specification of the live tree, so an extraction can be
+// checked with mtree -f spec -p dir or bsdtar
+func writeMtree(w io.Writer, result *yaffs.ScanResult) error {
+	tree := result.Tree
+
+	_, err := fmt.Fprintln(w, "#mtree")
+	if err != nil {
+		return err
+	}
+
+	var ids []uint32
+	for _, id := range tree.SortedIDs() {
+		if !tree.Deleted(id) {
+			ids = append(ids, id)
+		}
+	}
+	hashes, err := hashObjects(result, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		header := tree.Objects[id].Header
+
+		keywords := []string{
+			"type=" + mtreeType(header),
+			fmt.Sprintf("mode=%04o", header.Mode&07777),
+			fmt.Sprintf("uid=%d", header.UID),
+			fmt.Sprintf("gid=%d", header.GID),
+			fmt.Sprintf("time=%d.000000000", header.ModTime),
+		}
+
+		switch header.ObjectType {
+		case yaffs.YAFFS_OBJECT_TYPE_FILE, yaffs.YAFFS_OBJECT_TYPE_HARDLINK:
+			targetID, target, err := result.FileObject(id)
+			if err != nil {
+				return fmt.Errorf("%s: %w", tree.Path(id), err)
+			}
+			size := result.ContentSize(target.FileSize(result.ByteOrder), result.DataChunks(targetID))
+			keywords = append(keywords, fmt.Sprintf("size=%d", size), "sha256digest="+hashes[id])
+		case yaffs.YAFFS_OBJECT_TYPE_SYMLINK:
+			keywords = append(keywords, "link="+mtreeEscape(yaffs.CToGoString(header.Alias[:])))
+		}
+
+		_, err = fmt.Fprintf(w, "%s %s\n", mtreeEscape("."+tree.Path(id)), strings.Join(keywords, " "))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func mtreeType(header *yaffs.ObjectHeader) string {
+	switch header.ObjectType {
+	case yaffs.YAFFS_OBJECT_TYPE_DIRECTORY:
+		return "dir"
+	case yaffs.YAFFS_OBJECT_TYPE_SYMLINK:
+		return "link"
+	case yaffs.YAFFS_OBJECT_TYPE_SPECIAL:
+		switch header.Mode & 0170000 {
+		case 0020000:
+			return "char"
+		case 0060000:
+			return "block"
+		case 0010000:
+			return "fifo"
+		case 0140000:
+			return "socket"
+		}
+	}
+	return "file"
+}
+
+// Octal escape whitespace, glob characters, backslashes and non-printable
+// bytes as mtree expects in file names
+func mtreeEscape(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c <= ' ' || c >= 0x7F || strings.IndexByte(`\#*?[`, c) >= 0 {
+			fmt.Fprintf(&b, "\\%03o", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+// Data chunks of an object without any surviving header
+type OrphanGroup struct {
+	ObjectID uint32
+	Chunks   map[uint32]*yaffs.ScanChunk // newest chunk per chunk ID
+	Size     uint64                      // up to the valid bytes of the highest chunk
+	Missing  int                         // chunk IDs below the highest one not found
+}
+
+// Group data chunks of unknown objects by object ID. The size is inferred
+// from the position and byte count of the highest chunk ID, bytes of lost
+// chunks below it are zero filled.
+func findOrphanGroups(result *yaffs.ScanResult) []*OrphanGroup {
+	ids := make(map[uint32]bool)
+	for _, chunk := range result.Chunks {
+		id := chunk.Spare.ObjectID
+		if _, ok := result.Tree.Objects[id]; !ok && chunk.Spare.ChunkID != 0 {
+			ids[id] = true
+		}
+	}
+
+	var groups []*OrphanGroup
+	for id := range ids {
+		group := &OrphanGroup{ObjectID: id, Chunks: result.DataChunks(id)}
+
+		var last uint32
+		for chunkID := range group.Chunks {
+			if chunkID > last {
+				last = chunkID
+			}
+		}
+		group.Size = uint64(last-1)*uint64(result.ChunkDataSize) + uint64(group.Chunks[last].Spare.NumberBytes)
+		group.Missing = int(last) - len(group.Chunks)
+
+		groups = append(groups, group)
+	}
+
+	sort.Slice(groups, func(i, j int) bool { return groups[i].ObjectID < groups[j].ObjectID })
+	return groups
+}
+
+// Write every orphan group as orphan-ID into dir, with a manifest of
+// chunk counts, sizes and hashes written to w
+func recoverOrphans(r *yaffs.ScanResult, w io.Writer, groups []*OrphanGroup, dir string) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "OBJECT\tCHUNKS\tMISSING\tSIZE\tSHA256\tFILE")
+
+	for k, group := range groups {
+		if interrupted() {
+			log.Printf("Interrupted after %d of %d orphan groups", k, len(groups))
+			break
+		}
+		name := fmt.Sprintf("orphan-%d", group.ObjectID)
+		file, err := createOutput(filepath.Join(dir, name), nil)
+		if err != nil {
+			return err
+		}
+
+		hash := sha256.New()
+		_, err = r.WriteChunks(io.MultiWriter(file, hash), group.Size, group.Chunks)
+		closeErr := file.Close()
+		if err != nil {
+			return err
+		}
+		if closeErr != nil {
+			return closeErr
+		}
+
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%x\t%s\n", group.ObjectID, len(group.Chunks), group.Missing, group.Size, hash.Sum(nil), name)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Directory for generated artifacts, set by -output-dir. Empty to write them
+// where given and the TSK config next to the image, which is often on
+// read-only or network media.
+var outputDir string
+
+// Resolve a relative output path inside the output directory. Absolute
+// paths, empty paths and - for stdout are kept.
+func outputPath(name string) string {
+	if outputDir == "" || name == "" || name == "-" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(outputDir, name)
+}
+
+// Where the TSK config for an image is written
+func tskConfigOutput(imagePath string) string {
+	if outputDir == "" {
+		return imagePath + "-yaffs2.config"
+	}
+	// Image paths may be s3:// or archive:// locations
+	return filepath.Join(outputDir, path.Base(filepath.ToSlash(imagePath))+"-yaffs2.config")
+}
+
+// Whether two paths name one existing file
+func sameFile(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(infoA, infoB)
+}
@@ -0,0 +1,6 @@
+//go:build !unix
+
+package main
+
+// O_EXCL alone keeps outputs from being written through symlinks here
+const openNoFollow = 0
@@ -0,0 +1,8 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// Refuses to open a symlink in place of an output file
+const openNoFollow = syscall.O_NOFOLLOW
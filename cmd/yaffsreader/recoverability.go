@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+type RecoverabilityEstimate struct {
+	ObjectID       uint32
+	Path           string
+	Size           uint64 // largest size of any header version
+	Chunks         int    // needed for that size
+	SurvivingBytes uint64
+	SurvivingCount int
+}
+
+// Estimate for every deleted file how much of its data is still on flash.
+// Deletion may write a header with a truncated size, so the largest size
+// of any header version of the object is assumed.
+func estimateRecoverability(result *yaffs.ScanResult) []*RecoverabilityEstimate {
+	sizes := make(map[uint32]uint64)
+	for _, entry := range result.Entries {
+		if size := entry.Header.FileSize(result.ByteOrder); size > sizes[entry.ObjectID] {
+			sizes[entry.ObjectID] = size
+		}
+	}
+
+	var estimates []*RecoverabilityEstimate
+	tree := result.Tree
+	for _, id := range tree.SortedIDs() {
+		object := tree.Objects[id]
+		if object.Header.ObjectType != yaffs.YAFFS_OBJECT_TYPE_FILE || !tree.Deleted(id) {
+			continue
+		}
+
+		chunkSize := uint64(result.ChunkDataSize)
+		estimate := &RecoverabilityEstimate{
+			ObjectID: id,
+			Path:     tree.Path(id),
+			Size:     sizes[id],
+			Chunks:   int((sizes[id] + chunkSize - 1) / chunkSize),
+		}
+
+		for chunkID, chunk := range result.DataChunks(id) {
+			if int(chunkID) > estimate.Chunks {
+				continue
+			}
+			n := uint64(chunk.Spare.NumberBytes)
+			if remaining := estimate.Size - uint64(chunkID-1)*chunkSize; n > remaining {
+				n = remaining
+			}
+			estimate.SurvivingCount++
+			estimate.SurvivingBytes += n
+		}
+
+		estimates = append(estimates, estimate)
+	}
+
+	return estimates
+}
+
+func writeRecoverability(w io.Writer, estimates []*RecoverabilityEstimate) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "OBJECT\tSIZE\tCHUNKS\tSURVIVING\tBYTES\tPATH")
+
+	var totalSize, totalBytes uint64
+	var complete int
+	for _, e := range estimates {
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%d\t%s\n", e.ObjectID, e.Size, e.Chunks, e.SurvivingCount, e.SurvivingBytes, e.Path)
+		totalSize += e.Size
+		totalBytes += e.SurvivingBytes
+		if e.SurvivingCount == e.Chunks {
+			complete++
+		}
+	}
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+
+	percent := 0.0
+	if totalSize > 0 {
+		percent = float64(totalBytes) * 100 / float64(totalSize)
+	}
+	_, err = fmt.Fprintf(w, "\n%d deleted files, %d fully recoverable, %d of %d bytes recoverable (%.1f%%)\n",
+		len(estimates), complete, totalBytes, totalSize, percent)
+	return err
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+// State of one live path in one dump
+type pathState struct {
+	ObjectID uint32
+	Type     yaffs.ObjectType
+	Mode     uint32
+	UID, GID uint32
+	Size     uint64
+	ModTime  uint32
+	Hash     string
+}
+
+func snapshotPaths(result *yaffs.ScanResult) (map[string]pathState, error) {
+	tree := result.Tree
+	var ids []uint32
+	for _, id := range tree.SortedIDs() {
+		if !tree.Deleted(id) {
+			ids = append(ids, id)
+		}
+	}
+	hashes, err := hashObjects(result, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make(map[string]pathState)
+	for _, id := range ids {
+		header := tree.Objects[id].Header
+		paths[tree.Path(id)] = pathState{
+			ObjectID: id,
+			Type:     header.ObjectType,
+			Mode:     header.Mode,
+			UID:      header.UID,
+			GID:      header.GID,
+			Size:     header.FileSize(result.ByteOrder),
+			ModTime:  header.ModTime,
+			Hash:     hashes[id],
+		}
+	}
+	return paths, nil
+}
+
+// Describe how a path changed between two dumps, empty if it did not
+func compareStates(before, after pathState) (string, string) {
+	if before.Type != after.Type {
+		return "modified", fmt.Sprintf("type %s -> %s", before.Type, after.Type)
+	}
+	if before.Hash != after.Hash || before.Size != after.Size {
+		return "modified", fmt.Sprintf("size %d -> %d, sha256 %s", before.Size, after.Size, after.Hash)
+	}
+
+	var changes []string
+	if before.Mode != after.Mode {
+		changes = append(changes, fmt.Sprintf("mode %o -> %o", before.Mode, after.Mode))
+	}
+	if before.UID != after.UID || before.GID != after.GID {
+		changes = append(changes, fmt.Sprintf("owner %d:%d -> %d:%d", before.UID, before.GID, after.UID, after.GID))
+	}
+	if before.ModTime != after.ModTime {
+		changes = append(changes, fmt.Sprintf("mtime %s -> %s", timeFormat.Format(before.ModTime), timeFormat.Format(after.ModTime)))
+	}
+	if len(changes) > 0 {
+		return "metadata", strings.Join(changes, ", ")
+	}
+	return "", ""
+}
+
+// Consolidated change history of an ordered series of dumps of one device.
+// Every dump is compared with the previous one by live path; the first dump
+// is the baseline.
+func writeSeriesHistory(w io.Writer, imagePaths []string, options *yaffs.ImageOptions) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "DUMP\tCHANGE\tPATH\tDETAIL")
+
+	var previous map[string]pathState
+	for _, imagePath := range imagePaths {
+		if interrupted() {
+			log.Printf("Interrupted before %s", imagePath)
+			break
+		}
+		result, err := scanDump(imagePath, options)
+		if err != nil {
+			return err
+		}
+		if result == nil {
+			fmt.Fprintf(tw, "%s\tunsupported\t-\t-\n", imagePath)
+			continue
+		}
+
+		current, err := snapshotPaths(result)
+		if err != nil {
+			return err
+		}
+		if previous == nil {
+			log.Printf("Baseline %s with %d live objects", imagePath, len(current))
+			previous = current
+			continue
+		}
+
+		var paths []string
+		for p := range previous {
+			paths = append(paths, p)
+		}
+		for p := range current {
+			if _, ok := previous[p]; !ok {
+				paths = append(paths, p)
+			}
+		}
+		sort.Strings(paths)
+
+		for _, p := range paths {
+			before, existed := previous[p]
+			after, exists := current[p]
+
+			switch {
+			case !existed:
+				fmt.Fprintf(tw, "%s\tadded\t%s\t%s, size %d, sha256 %s\n", imagePath, p, after.Type, after.Size, after.Hash)
+			case !exists:
+				fmt.Fprintf(tw, "%s\tremoved\t%s\t%s\n", imagePath, p, before.Type)
+			default:
+				change, detail := compareStates(before, after)
+				if change != "" {
+					fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", imagePath, change, p, detail)
+				}
+			}
+		}
+
+		previous = current
+	}
+
+	return tw.Flush()
+}
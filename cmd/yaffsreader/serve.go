@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+// Serve the live tree of the scan read-only over HTTP at addr, with
+// directory listings, until the process is interrupted
+func serveTree(addr string, result *yaffs.ScanResult, options yaffs.FileSystemOptions) error {
+	server := &http.Server{
+		Addr:    addr,
+		Handler: http.FileServer(http.FS(yaffs.NewFileSystem(result, options))),
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-done:
+		case <-runContext.Done():
+			server.Close()
+		}
+	}()
+
+	log.Printf("Serving at http://%s/, interrupt to stop", addr)
+	err := server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
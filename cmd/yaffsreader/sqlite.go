@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+const SQLITE_MAGIC = "SQLite format 3\x00"
+
+// Files SQLite keeps next to a database, needed to analyze it correctly
+var sqliteCompanionSuffixes = []string{"-wal", "-journal", "-shm"}
+
+var sqliteNameSuffixes = []string{".db", ".sqlite", ".sqlite3"}
+
+type SQLiteDatabase struct {
+	Entry      yaffs.ListEntry
+	Status     string
+	Companions []SQLiteCompanion
+}
+
+type SQLiteCompanion struct {
+	Suffix string
+	Entry  yaffs.ListEntry
+	Status string
+}
+
+// Whether a header version is the current state of its object, deleted or
+// superseded by a newer header
+func entryStatus(entry *yaffs.ListEntry) string {
+	switch {
+	case entry.Obsolete:
+		return "obsolete"
+	case entry.Deleted():
+		return "deleted"
+	}
+	return "live"
+}
+
+// Recognize databases by the magic in their first chunk, or by name if that
+// chunk is lost
+func isSQLite(r *yaffs.ScanResult, entry *yaffs.ListEntry) bool {
+	if entry.Header.ObjectType != yaffs.YAFFS_OBJECT_TYPE_FILE {
+		return false
+	}
+	if chunk, ok := r.DataChunks(entry.ObjectID)[1]; ok {
+		if page, err := r.ChunkPage(chunk); err == nil {
+			return bytes.HasPrefix(page, []byte(SQLITE_MAGIC))
+		}
+	}
+	name := yaffs.CToGoString(entry.Header.Name[:])
+	for _, suffix := range sqliteNameSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Find every version of every database and pair it with the companion
+// files of the same name, including deleted and obsolete versions. Deleted
+// companions lost their directory, so they pair by name alone.
+func findSQLiteDatabases(result *yaffs.ScanResult) []*SQLiteDatabase {
+	var databases []*SQLiteDatabase
+
+	for k := range result.Entries {
+		entry := &result.Entries[k]
+		if entry.ObjectID == 0 || !isSQLite(result, entry) {
+			continue
+		}
+
+		db := &SQLiteDatabase{Entry: *entry, Status: entryStatus(entry)}
+		name := yaffs.CToGoString(entry.Header.Name[:])
+
+		for j := range result.Entries {
+			candidate := &result.Entries[j]
+			if candidate.ObjectID == 0 || candidate.Header.ObjectType != yaffs.YAFFS_OBJECT_TYPE_FILE {
+				continue
+			}
+			for _, suffix := range sqliteCompanionSuffixes {
+				if yaffs.CToGoString(candidate.Header.Name[:]) != name+suffix {
+					continue
+				}
+				if path.Dir(candidate.Path) != path.Dir(entry.Path) && !candidate.Deleted() && !entry.Deleted() {
+					continue
+				}
+				db.Companions = append(db.Companions, SQLiteCompanion{Suffix: suffix, Entry: *candidate, Status: entryStatus(candidate)})
+			}
+		}
+
+		databases = append(databases, db)
+	}
+
+	return databases
+}
+
+func writeSQLiteReport(w io.Writer, databases []*SQLiteDatabase) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "DATABASE\tOBJECT\tSTATUS\tCOMPANION\tOBJECT\tSTATUS")
+
+	for _, db := range databases {
+		if len(db.Companions) == 0 {
+			fmt.Fprintf(tw, "%s\t%d\t%s\t-\t-\t-\n", db.Entry.Path, db.Entry.ObjectID, db.Status)
+			continue
+		}
+		for _, companion := range db.Companions {
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%d\t%s\n",
+				db.Entry.Path, db.Entry.ObjectID, db.Status,
+				companion.Entry.Path, companion.Entry.ObjectID, companion.Status)
+		}
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Minimal writer of SQLite 3 database files, so metadata can be exported
+// without cgo or a driver. Tables are written once, rows in ascending
+// rowid order, into B-trees built bottom up; there are no indexes, free
+// pages or updates.
+
+const (
+	sqlitePageSize = 4096
+
+	sqliteLeafTable     = 0x0d
+	sqliteInteriorTable = 0x05
+	sqliteMaxChildren   = 200
+)
+
+type sqliteDB struct {
+	w      io.WriterAt
+	pages  uint32 // pages allocated, page 1 holds the schema
+	schema *sqliteTable
+	err    error
+}
+
+// Table being filled with rows
+type sqliteTable struct {
+	db    *sqliteDB
+	name  string
+	sql   string
+	rowid int64
+
+	leaf  [][]byte      // cells of the leaf page being filled
+	used  int           // bytes of the leaf page taken
+	level []sqliteChild // finished leaves
+}
+
+type sqliteChild struct {
+	page   uint32
+	maxKey int64
+}
+
+func newSQLiteDB(w io.WriterAt) *sqliteDB {
+	db := &sqliteDB{w: w, pages: 1}
+	db.schema = &sqliteTable{db: db, name: "sqlite_schema"}
+	db.schema.reset()
+	return db
+}
+
+func (db *sqliteDB) allocate() uint32 {
+	db.pages++
+	return db.pages
+}
+
+func (db *sqliteDB) writePage(number uint32, page []byte) {
+	if db.err != nil {
+		return
+	}
+	_, db.err = db.w.WriteAt(page, int64(number-1)*sqlitePageSize)
+}
+
+// Start a table given its CREATE TABLE statement
+func (db *sqliteDB) CreateTable(name, sql string) *sqliteTable {
+	table := &sqliteTable{db: db, name: name, sql: sql}
+	table.reset()
+	return table
+}
+
+func (t *sqliteTable) reset() {
+	t.leaf = nil
+	t.used = 8
+}
+
+// Append a row of int64, int, uint32, uint64, bool, string, []byte or nil
+// values
+func (t *sqliteTable) Insert(values ...interface{}) error {
+	if t.db.err != nil {
+		return t.db.err
+	}
+	record, err := sqliteRecord(values)
+	if err != nil {
+		return fmt.Errorf("%s: %w", t.name, err)
+	}
+	t.rowid++
+	cell := t.db.leafCell(t.rowid, record)
+	if t.used+len(cell)+2 > sqlitePageSize && len(t.leaf) > 0 {
+		t.flushLeaf()
+	}
+	t.leaf = append(t.leaf, cell)
+	t.used += len(cell) + 2
+	return t.db.err
+}
+
+func (t *sqliteTable) flushLeaf() {
+	number := t.db.allocate()
+	t.db.writePage(number, sqliteBTreePage(sqliteLeafTable, 0, t.leaf, 0))
+	t.level = append(t.level, sqliteChild{number, t.rowid - 1})
+	t.reset()
+}
+
+// Write the remaining pages of the table and record it in the schema
+func (t *sqliteTable) Close() error {
+	if t.db.err != nil {
+		return t.db.err
+	}
+	// The last leaf ends at the current rowid
+	number := t.db.allocate()
+	t.db.writePage(number, sqliteBTreePage(sqliteLeafTable, 0, t.leaf, 0))
+	children := append(t.level, sqliteChild{number, t.rowid})
+
+	// Interior cells take at most 15 bytes with their pointer, so 200
+	// children always fit a page. Spreading them evenly keeps every page
+	// at two children or more and all leaves at the same depth.
+	for len(children) > 1 {
+		var parents []sqliteChild
+		groups := (len(children) + sqliteMaxChildren - 1) / sqliteMaxChildren
+		size := (len(children) + groups - 1) / groups
+		for start := 0; start < len(children); start += size {
+			end := start + size
+			if end > len(children) {
+				end = len(children)
+			}
+			group := children[start:end]
+			var cells [][]byte
+			for _, child := range group[:len(group)-1] {
+				cell := binary.BigEndian.AppendUint32(nil, child.page)
+				cells = append(cells, appendSQLiteVarint(cell, uint64(child.maxKey)))
+			}
+			last := group[len(group)-1]
+			number := t.db.allocate()
+			t.db.writePage(number, sqliteBTreePage(sqliteInteriorTable, 0, cells, last.page))
+			parents = append(parents, sqliteChild{number, last.maxKey})
+		}
+		children = parents
+	}
+
+	return t.db.schema.Insert("table", t.name, t.name, int64(children[0].page), t.sql)
+}
+
+// Write the schema to page 1 along with the database header
+func (db *sqliteDB) Close() error {
+	if db.err != nil {
+		return db.err
+	}
+	schema := db.schema
+	if len(schema.level) > 0 || schema.used > sqlitePageSize-100 {
+		return fmt.Errorf("schema exceeds the first page")
+	}
+	page := sqliteBTreePage(sqliteLeafTable, 100, schema.leaf, 0)
+
+	header := page[:100]
+	copy(header, SQLITE_MAGIC)
+	binary.BigEndian.PutUint16(header[16:], sqlitePageSize)
+	header[18], header[19] = 1, 1 // legacy journal
+	header[21], header[22], header[23] = 64, 32, 32
+	binary.BigEndian.PutUint32(header[24:], 1) // change counter
+	binary.BigEndian.PutUint32(header[28:], db.pages)
+	binary.BigEndian.PutUint32(header[40:], 1) // schema cookie
+	binary.BigEndian.PutUint32(header[44:], 4) // schema format
+	binary.BigEndian.PutUint32(header[56:], 1) // UTF-8
+	binary.BigEndian.PutUint32(header[92:], 1) // version valid for
+	binary.BigEndian.PutUint32(header[96:], 3031001)
+
+	db.writePage(1, page)
+	return db.err
+}
+
+// Table B-tree page with its header at offset, 100 on page 1, and cells
+// packed from the end of the page
+func sqliteBTreePage(pageType byte, offset int, cells [][]byte, rightMost uint32) []byte {
+	page := make([]byte, sqlitePageSize)
+	header := page[offset:]
+	header[0] = pageType
+	binary.BigEndian.PutUint16(header[3:], uint16(len(cells)))
+	pointers := header[8:]
+	if pageType == sqliteInteriorTable {
+		binary.BigEndian.PutUint32(header[8:], rightMost)
+		pointers = header[12:]
+	}
+	content := sqlitePageSize
+	for k, cell := range cells {
+		content -= len(cell)
+		copy(page[content:], cell)
+		binary.BigEndian.PutUint16(pointers[2*k:], uint16(content))
+	}
+	binary.BigEndian.PutUint16(header[5:], uint16(content))
+	return page
+}
+
+// Leaf cell of a row, spilling payload that does not fit the page to a
+// chain of overflow pages
+func (db *sqliteDB) leafCell(rowid int64, payload []byte) []byte {
+	cell := appendSQLiteVarint(nil, uint64(len(payload)))
+	cell = appendSQLiteVarint(cell, uint64(rowid))
+
+	const usable = sqlitePageSize
+	maxLocal := usable - 35
+	if len(payload) <= maxLocal {
+		return append(cell, payload...)
+	}
+	minLocal := (usable-12)*32/255 - 23
+	local := minLocal + (len(payload)-minLocal)%(usable-4)
+	if local > maxLocal {
+		local = minLocal
+	}
+	cell = append(cell, payload[:local]...)
+
+	rest := payload[local:]
+	first := db.allocate()
+	cell = binary.BigEndian.AppendUint32(cell, first)
+	for number := first; len(rest) > 0; {
+		page := make([]byte, sqlitePageSize)
+		n := copy(page[4:], rest)
+		rest = rest[n:]
+		next := uint32(0)
+		if len(rest) > 0 {
+			next = db.allocate()
+		}
+		binary.BigEndian.PutUint32(page, next)
+		db.writePage(number, page)
+		number = next
+	}
+	return cell
+}
+
+// Record of a row: the serial types of the values, then their content
+func sqliteRecord(values []interface{}) ([]byte, error) {
+	var types, body []byte
+	for _, value := range values {
+		switch v := value.(type) {
+		case nil:
+			types = appendSQLiteVarint(types, 0)
+		case bool:
+			types = appendSQLiteVarint(types, 8)
+			if v {
+				types[len(types)-1] = 9
+			}
+		case int:
+			types, body = appendSQLiteInt(types, body, int64(v))
+		case int64:
+			types, body = appendSQLiteInt(types, body, v)
+		case uint32:
+			types, body = appendSQLiteInt(types, body, int64(v))
+		case uint64:
+			types, body = appendSQLiteInt(types, body, int64(v))
+		case string:
+			types = appendSQLiteVarint(types, uint64(2*len(v)+13))
+			body = append(body, v...)
+		case []byte:
+			types = appendSQLiteVarint(types, uint64(2*len(v)+12))
+			body = append(body, v...)
+		default:
+			return nil, fmt.Errorf("unsupported column value %T", value)
+		}
+	}
+
+	// The header size counts its own varint
+	size := len(types) + 1
+	for len(appendSQLiteVarint(nil, uint64(size)))+len(types) != size {
+		size++
+	}
+	record := appendSQLiteVarint(nil, uint64(size))
+	record = append(record, types...)
+	return append(record, body...), nil
+}
+
+// Integers take the smallest serial type holding them
+func appendSQLiteInt(types, body []byte, v int64) ([]byte, []byte) {
+	switch {
+	case v == 0:
+		return append(types, 8), body
+	case v == 1:
+		return append(types, 9), body
+	}
+	for _, size := range []struct {
+		serial byte
+		bytes  int
+	}{{1, 1}, {2, 2}, {3, 3}, {4, 4}, {5, 6}} {
+		bits := uint(8 * size.bytes)
+		if v >= -1<<(bits-1) && v < 1<<(bits-1) {
+			for i := size.bytes - 1; i >= 0; i-- {
+				body = append(body, byte(v>>uint(8*i)))
+			}
+			return append(types, size.serial), body
+		}
+	}
+	return append(types, 6), binary.BigEndian.AppendUint64(body, uint64(v))
+}
+
+// SQLite varint: big endian groups of seven bits, the ninth byte holding
+// eight
+func appendSQLiteVarint(buf []byte, v uint64) []byte {
+	if v > 1<<56-1 {
+		var b [9]byte
+		b[8] = byte(v)
+		v >>= 8
+		for i := 7; i >= 0; i-- {
+			b[i] = byte(v&0x7f) | 0x80
+			v >>= 7
+		}
+		return append(buf, b[:]...)
+	}
+	var b [8]byte
+	n := 0
+	for {
+		b[7-n] = byte(v & 0x7f)
+		if n > 0 {
+			b[7-n] |= 0x80
+		}
+		n++
+		v >>= 7
+		if v == 0 {
+			break
+		}
+	}
+	return append(buf, b[8-n:]...)
+}
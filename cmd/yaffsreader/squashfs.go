@@ -0,0 +1,709 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+const (
+	SQUASHFS_MAGIC         = 0x73717368
+	SQUASHFS_BLOCK_LOG     = 17
+	SQUASHFS_BLOCK_SIZE    = 1 << SQUASHFS_BLOCK_LOG
+	SQUASHFS_METADATA_SIZE = 8192
+	SQUASHFS_SUPERBLOCK    = 96
+	SQUASHFS_INVALID       = 0xFFFFFFFFFFFFFFFF
+
+	SQUASHFS_COMPRESSION_ZLIB = 1
+
+	SQUASHFS_NO_FRAGMENTS = 0x0010
+	SQUASHFS_NO_XATTRS    = 0x0200
+
+	SQUASHFS_METADATA_UNCOMPRESSED = 0x8000
+	SQUASHFS_DATA_UNCOMPRESSED     = 1 << 24
+	SQUASHFS_NO_FRAGMENT           = 0xFFFFFFFF
+	SQUASHFS_INVALID_XATTR         = 0xFFFFFFFF
+
+	// Basic inode types, extended types are 7 higher
+	SQUASHFS_DIR      = 1
+	SQUASHFS_FILE     = 2
+	SQUASHFS_SYMLINK  = 3
+	SQUASHFS_BLKDEV   = 4
+	SQUASHFS_CHRDEV   = 5
+	SQUASHFS_FIFO     = 6
+	SQUASHFS_SOCKET   = 7
+	SQUASHFS_EXTENDED = 7
+)
+
+// Attribute name prefixes SquashFS can store, by type
+var squashfsXattrPrefixes = []string{"user.", "trusted.", "security."}
+
+// Sequence of metadata blocks of at most 8K each, compressed if that helps
+type metadataWriter struct {
+	out   bytes.Buffer
+	block bytes.Buffer
+	size  int // uncompressed bytes written
+}
+
+// Position of the next byte as block start << 16 | offset in the block
+func (m *metadataWriter) Ref() uint64 {
+	return uint64(m.out.Len())<<16 | uint64(m.block.Len())
+}
+
+func (m *metadataWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	m.size += n
+	for len(p) > 0 {
+		k := SQUASHFS_METADATA_SIZE - m.block.Len()
+		if k > len(p) {
+			k = len(p)
+		}
+		m.block.Write(p[:k])
+		p = p[k:]
+		if m.block.Len() == SQUASHFS_METADATA_SIZE {
+			m.flush()
+		}
+	}
+	return n, nil
+}
+
+func (m *metadataWriter) flush() {
+	if m.block.Len() == 0 {
+		return
+	}
+	data, compressed := compressSquashfs(m.block.Bytes())
+	header := uint16(len(data))
+	if !compressed {
+		header |= SQUASHFS_METADATA_UNCOMPRESSED
+	}
+	binary.Write(&m.out, binary.LittleEndian, header)
+	m.out.Write(data)
+	m.block.Reset()
+}
+
+// All blocks, flushing the last partial one
+func (m *metadataWriter) Bytes() []byte {
+	m.flush()
+	return m.out.Bytes()
+}
+
+// zlib compress a block, keeping it as is if that does not save space
+func compressSquashfs(data []byte) ([]byte, bool) {
+	var buf bytes.Buffer
+	zw, _ := zlib.NewWriterLevel(&buf, zlib.BestCompression)
+	zw.Write(data)
+	zw.Close()
+	if buf.Len() >= len(data) {
+		return data, false
+	}
+	return buf.Bytes(), true
+}
+
+type squashfsDirEntry struct {
+	Name   string
+	Ref    uint64
+	Number uint32
+	Type   uint16 // basic type
+}
+
+// Writes the live tree of a scan as a SquashFS 4.0 image with zlib
+// compression, without fragments. Modes, owners, modification times and
+// attributes in the user, trusted and security namespaces are kept.
+type squashfsWriter struct {
+	result   *yaffs.ScanResult
+	out      *os.File
+	offset   int64
+	children map[uint32][]uint32
+
+	numbers map[uint32]uint32 // inode number per object
+	links   map[uint32]uint32 // link count per hardlink target
+	written map[uint32]squashfsDirEntry
+
+	inodes      metadataWriter
+	directories metadataWriter
+
+	ids     []uint32
+	idIndex map[uint32]uint16
+
+	xattrs     metadataWriter
+	xattrIDs   bytes.Buffer
+	xattrCount uint32
+	xattrIndex map[string]uint32
+}
+
+func writeSquashfs(path string, result *yaffs.ScanResult) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	w := &squashfsWriter{
+		result:     result,
+		out:        out,
+		offset:     SQUASHFS_SUPERBLOCK,
+		children:   result.Tree.Children(),
+		numbers:    make(map[uint32]uint32),
+		links:      make(map[uint32]uint32),
+		written:    make(map[uint32]squashfsDirEntry),
+		idIndex:    make(map[uint32]uint16),
+		xattrIndex: make(map[string]uint32),
+	}
+
+	err = w.write()
+	closeErr := out.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// Children of a directory, including lost+found below the root when it
+// holds objects but has no header of its own
+func (w *squashfsWriter) entries(id uint32) []uint32 {
+	children := w.children[id]
+	_, known := w.result.Tree.Objects[yaffs.YAFFS_OBJECTID_LOSTNFOUND]
+	if id != yaffs.YAFFS_OBJECTID_ROOT || known || len(w.children[yaffs.YAFFS_OBJECTID_LOSTNFOUND]) == 0 {
+		return children
+	}
+	children = append([]uint32{yaffs.YAFFS_OBJECTID_LOSTNFOUND}, children...)
+	sort.SliceStable(children, func(i, j int) bool { return w.name(children[i]) < w.name(children[j]) })
+	return children
+}
+
+func (w *squashfsWriter) name(id uint32) string {
+	if object, ok := w.result.Tree.Objects[id]; ok {
+		return yaffs.CToGoString(object.Header.Name[:])
+	}
+	return yaffs.PseudoDirectoryNames[id]
+}
+
+func (w *squashfsWriter) header(id uint32) *yaffs.ObjectHeader {
+	if object, ok := w.result.Tree.Objects[id]; ok {
+		return object.Header
+	}
+	return yaffs.SynthesizeHeader(yaffs.YAFFS_OBJECT_TYPE_DIRECTORY, yaffs.YAFFS_OBJECTID_ROOT, yaffs.PseudoDirectoryNames[id])
+}
+
+func (w *squashfsWriter) isDir(id uint32) bool {
+	return w.header(id).ObjectType == yaffs.YAFFS_OBJECT_TYPE_DIRECTORY
+}
+
+// Assign inode numbers breadth first and count hardlinks. Hardlinks share
+// the inode of the file they point to.
+func (w *squashfsWriter) number() uint32 {
+	next := uint32(1)
+	queue := []uint32{yaffs.YAFFS_OBJECTID_ROOT}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+
+		target := id
+		if w.header(id).ObjectType == yaffs.YAFFS_OBJECT_TYPE_HARDLINK {
+			resolved, _, err := w.result.FileObject(id)
+			if err != nil {
+				continue
+			}
+			target = resolved
+		}
+		w.links[target]++
+		if _, ok := w.numbers[target]; !ok {
+			w.numbers[target] = next
+			next++
+		}
+		if target != id {
+			w.numbers[id] = w.numbers[target]
+		}
+
+		if w.isDir(id) {
+			queue = append(queue, w.entries(id)...)
+		}
+	}
+	return next - 1
+}
+
+func (w *squashfsWriter) write() error {
+	inodeCount := w.number()
+
+	root, err := w.writeDir(yaffs.YAFFS_OBJECTID_ROOT, inodeCount+1)
+	if err != nil {
+		return err
+	}
+
+	inodeTableStart := w.offset
+	err = w.append(w.inodes.Bytes())
+	if err != nil {
+		return err
+	}
+	directoryTableStart := w.offset
+	err = w.append(w.directories.Bytes())
+	if err != nil {
+		return err
+	}
+
+	idTableStart, err := w.writeIDTable()
+	if err != nil {
+		return err
+	}
+
+	flags := uint16(SQUASHFS_NO_FRAGMENTS)
+	xattrTableStart := uint64(SQUASHFS_INVALID)
+	if w.xattrCount > 0 {
+		xattrTableStart, err = w.writeXattrTables()
+		if err != nil {
+			return err
+		}
+	} else {
+		flags |= SQUASHFS_NO_XATTRS
+	}
+
+	// The newest modification keeps the image reproducible
+	var modTime uint32
+	for _, object := range w.result.Tree.Objects {
+		if object.Header.ModTime > modTime {
+			modTime = object.Header.ModTime
+		}
+	}
+
+	bytesUsed := w.offset
+	if padding := bytesUsed % 4096; padding != 0 {
+		err = w.append(make([]byte, 4096-padding))
+		if err != nil {
+			return err
+		}
+	}
+
+	superblock := struct {
+		Magic               uint32
+		InodeCount          uint32
+		ModTime             uint32
+		BlockSize           uint32
+		FragmentCount       uint32
+		Compression         uint16
+		BlockLog            uint16
+		Flags               uint16
+		IDCount             uint16
+		VersionMajor        uint16
+		VersionMinor        uint16
+		RootInode           uint64
+		BytesUsed           uint64
+		IDTableStart        uint64
+		XattrIDTableStart   uint64
+		InodeTableStart     uint64
+		DirectoryTableStart uint64
+		FragmentTableStart  uint64
+		ExportTableStart    uint64
+	}{
+		Magic:               SQUASHFS_MAGIC,
+		InodeCount:          inodeCount,
+		ModTime:             modTime,
+		BlockSize:           SQUASHFS_BLOCK_SIZE,
+		Compression:         SQUASHFS_COMPRESSION_ZLIB,
+		BlockLog:            SQUASHFS_BLOCK_LOG,
+		Flags:               flags,
+		IDCount:             uint16(len(w.ids)),
+		VersionMajor:        4,
+		RootInode:           root.Ref,
+		BytesUsed:           uint64(bytesUsed),
+		IDTableStart:        idTableStart,
+		XattrIDTableStart:   xattrTableStart,
+		InodeTableStart:     uint64(inodeTableStart),
+		DirectoryTableStart: uint64(directoryTableStart),
+		FragmentTableStart:  idTableStart,
+		ExportTableStart:    SQUASHFS_INVALID,
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, &superblock)
+	_, err = w.out.WriteAt(buf.Bytes(), 0)
+	return err
+}
+
+func (w *squashfsWriter) append(data []byte) error {
+	_, err := w.out.WriteAt(data, w.offset)
+	w.offset += int64(len(data))
+	return err
+}
+
+func (w *squashfsWriter) id(value uint32) (uint16, error) {
+	if index, ok := w.idIndex[value]; ok {
+		return index, nil
+	}
+	if len(w.ids) == 0xFFFF {
+		return 0, errors.New("too many distinct owners for SquashFS")
+	}
+	index := uint16(len(w.ids))
+	w.ids = append(w.ids, value)
+	w.idIndex[value] = index
+	return index, nil
+}
+
+// Metadata blocks of the values followed by their index, which the
+// superblock points to
+func (w *squashfsWriter) writeIndexedTable(table *metadataWriter) (uint64, error) {
+	start := w.offset
+	blocks := table.Bytes()
+	err := w.append(blocks)
+	if err != nil {
+		return 0, err
+	}
+
+	var index bytes.Buffer
+	for pos := 0; pos < len(blocks); {
+		binary.Write(&index, binary.LittleEndian, uint64(start)+uint64(pos))
+		size := int(binary.LittleEndian.Uint16(blocks[pos:]) &^ SQUASHFS_METADATA_UNCOMPRESSED)
+		pos += 2 + size
+	}
+
+	indexStart := w.offset
+	return uint64(indexStart), w.append(index.Bytes())
+}
+
+func (w *squashfsWriter) writeIDTable() (uint64, error) {
+	var table metadataWriter
+	binary.Write(&table, binary.LittleEndian, w.ids)
+	return w.writeIndexedTable(&table)
+}
+
+func (w *squashfsWriter) writeXattrTables() (uint64, error) {
+	kvStart := w.offset
+	err := w.append(w.xattrs.Bytes())
+	if err != nil {
+		return 0, err
+	}
+
+	var ids metadataWriter
+	ids.Write(w.xattrIDs.Bytes())
+	blocks := ids.Bytes()
+	blocksStart := w.offset
+	err = w.append(blocks)
+	if err != nil {
+		return 0, err
+	}
+
+	var table bytes.Buffer
+	binary.Write(&table, binary.LittleEndian, struct {
+		KVStart uint64
+		Count   uint32
+		Unused  uint32
+	}{uint64(kvStart), w.xattrCount, 0})
+	for pos := 0; pos < len(blocks); {
+		binary.Write(&table, binary.LittleEndian, uint64(blocksStart)+uint64(pos))
+		size := int(binary.LittleEndian.Uint16(blocks[pos:]) &^ SQUASHFS_METADATA_UNCOMPRESSED)
+		pos += 2 + size
+	}
+
+	tableStart := w.offset
+	return uint64(tableStart), w.append(table.Bytes())
+}
+
+// Index of the attribute set of an object, SQUASHFS_INVALID_XATTR if there
+// is none. Identical sets are stored once.
+func (w *squashfsWriter) xattr(id uint32) uint32 {
+	var kv bytes.Buffer
+	count := uint32(0)
+	for _, xattr := range w.result.Xattrs(id) {
+		prefix := -1
+		for k, p := range squashfsXattrPrefixes {
+			if strings.HasPrefix(xattr.Name, p) {
+				prefix = k
+			}
+		}
+		if prefix < 0 {
+			log.Printf("Skipping attribute %s of %s, unsupported by SquashFS", xattr.Name, w.result.Tree.Path(id))
+			continue
+		}
+		name := strings.TrimPrefix(xattr.Name, squashfsXattrPrefixes[prefix])
+		binary.Write(&kv, binary.LittleEndian, uint16(prefix))
+		binary.Write(&kv, binary.LittleEndian, uint16(len(name)))
+		kv.WriteString(name)
+		binary.Write(&kv, binary.LittleEndian, uint32(len(xattr.Value)))
+		kv.Write(xattr.Value)
+		count++
+	}
+	if count == 0 {
+		return SQUASHFS_INVALID_XATTR
+	}
+
+	if index, ok := w.xattrIndex[kv.String()]; ok {
+		return index
+	}
+	ref := w.xattrs.Ref()
+	w.xattrs.Write(kv.Bytes())
+	binary.Write(&w.xattrIDs, binary.LittleEndian, struct {
+		Ref   uint64
+		Count uint32
+		Size  uint32
+	}{ref, count, uint32(kv.Len())})
+
+	index := w.xattrCount
+	w.xattrIndex[kv.String()] = index
+	w.xattrCount++
+	return index
+}
+
+// Common inode header
+func (w *squashfsWriter) inodeHeader(inodeType uint16, header *yaffs.ObjectHeader, number uint32) ([]byte, error) {
+	uid, err := w.id(header.UID)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := w.id(header.GID)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, struct {
+		Type   uint16
+		Mode   uint16
+		UID    uint16
+		GID    uint16
+		MTime  uint32
+		Number uint32
+	}{inodeType, uint16(header.Mode & 07777), uid, gid, header.ModTime, number})
+	return buf.Bytes(), nil
+}
+
+// Write an inode to the inode table and return its directory entry
+func (w *squashfsWriter) writeInode(id uint32, basicType uint16, extended bool, header *yaffs.ObjectHeader, body ...interface{}) (squashfsDirEntry, error) {
+	inodeType := basicType
+	if extended {
+		inodeType += SQUASHFS_EXTENDED
+	}
+	entry := squashfsDirEntry{Name: w.name(id), Ref: w.inodes.Ref(), Number: w.numbers[id], Type: basicType}
+
+	common, err := w.inodeHeader(inodeType, header, entry.Number)
+	if err != nil {
+		return entry, err
+	}
+	w.inodes.Write(common)
+	for _, field := range body {
+		if s, ok := field.(string); ok {
+			w.inodes.Write([]byte(s))
+			continue
+		}
+		binary.Write(&w.inodes, binary.LittleEndian, field)
+	}
+	return entry, nil
+}
+
+func (w *squashfsWriter) writeObject(id uint32, parent uint32) (squashfsDirEntry, error) {
+	header := w.header(id)
+	if header.ObjectType == yaffs.YAFFS_OBJECT_TYPE_HARDLINK {
+		target, _, err := w.result.FileObject(id)
+		if err != nil {
+			return squashfsDirEntry{}, err
+		}
+		entry, err := w.writeObject(target, parent)
+		entry.Name = w.name(id)
+		return entry, err
+	}
+	if entry, ok := w.written[id]; ok {
+		entry.Name = w.name(id)
+		return entry, nil
+	}
+
+	xattr := w.xattr(id)
+	hasXattr := xattr != SQUASHFS_INVALID_XATTR
+	links := w.links[id]
+
+	var entry squashfsDirEntry
+	var err error
+	switch header.ObjectType {
+	case yaffs.YAFFS_OBJECT_TYPE_DIRECTORY:
+		return w.writeDir(id, parent)
+
+	case yaffs.YAFFS_OBJECT_TYPE_FILE:
+		entry, err = w.writeFile(id, header, xattr, links)
+
+	case yaffs.YAFFS_OBJECT_TYPE_SYMLINK:
+		target := yaffs.CToGoString(header.Alias[:])
+		fields := []interface{}{links, uint32(len(target)), target}
+		if hasXattr {
+			fields = append(fields, xattr)
+		}
+		entry, err = w.writeInode(id, SQUASHFS_SYMLINK, hasXattr, header, fields...)
+
+	case yaffs.YAFFS_OBJECT_TYPE_SPECIAL:
+		var basicType uint16
+		switch header.Mode & 0170000 {
+		case 0020000:
+			basicType = SQUASHFS_CHRDEV
+		case 0060000:
+			basicType = SQUASHFS_BLKDEV
+		case 0010000:
+			basicType = SQUASHFS_FIFO
+		case 0140000:
+			basicType = SQUASHFS_SOCKET
+		default:
+			return entry, fmt.Errorf("special file with mode %o", header.Mode)
+		}
+		fields := []interface{}{links}
+		if basicType == SQUASHFS_CHRDEV || basicType == SQUASHFS_BLKDEV {
+			fields = append(fields, header.RDev)
+		}
+		if hasXattr {
+			fields = append(fields, xattr)
+		}
+		entry, err = w.writeInode(id, basicType, hasXattr, header, fields...)
+
+	default:
+		return entry, fmt.Errorf("object of type %s", header.ObjectType)
+	}
+
+	w.written[id] = entry
+	return entry, err
+}
+
+// Write the content in blocks, followed by its inode
+func (w *squashfsWriter) writeFile(id uint32, header *yaffs.ObjectHeader, xattr, links uint32) (squashfsDirEntry, error) {
+	start := w.offset
+	blocks := &squashfsBlockWriter{writer: w}
+	size, err := w.result.WriteObject(blocks, id)
+	if err == nil {
+		err = blocks.Flush()
+	}
+	if err != nil {
+		return squashfsDirEntry{}, err
+	}
+
+	if size <= 0xFFFFFFFF && start <= 0xFFFFFFFF && xattr == SQUASHFS_INVALID_XATTR && links == 1 {
+		return w.writeInode(id, SQUASHFS_FILE, false, header,
+			uint32(start), uint32(SQUASHFS_NO_FRAGMENT), uint32(0), uint32(size), blocks.sizes)
+	}
+	return w.writeInode(id, SQUASHFS_FILE, true, header,
+		uint64(start), uint64(size), uint64(0), links, uint32(SQUASHFS_NO_FRAGMENT), uint32(0), xattr, blocks.sizes)
+}
+
+// Collects file content into compressed data blocks
+type squashfsBlockWriter struct {
+	writer *squashfsWriter
+	block  []byte
+	sizes  []uint32
+}
+
+func (b *squashfsBlockWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		k := SQUASHFS_BLOCK_SIZE - len(b.block)
+		if k > len(p) {
+			k = len(p)
+		}
+		b.block = append(b.block, p[:k]...)
+		p = p[k:]
+		if len(b.block) == SQUASHFS_BLOCK_SIZE {
+			err := b.Flush()
+			if err != nil {
+				return n - len(p), err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (b *squashfsBlockWriter) Flush() error {
+	if len(b.block) == 0 {
+		return nil
+	}
+	data, compressed := compressSquashfs(b.block)
+	size := uint32(len(data))
+	if !compressed {
+		size |= SQUASHFS_DATA_UNCOMPRESSED
+	}
+	b.sizes = append(b.sizes, size)
+	b.block = b.block[:0]
+	return b.writer.append(data)
+}
+
+// Write all children of a directory, then its listing and inode
+func (w *squashfsWriter) writeDir(id uint32, parent uint32) (squashfsDirEntry, error) {
+	var entries []squashfsDirEntry
+	seen := make(map[string]bool)
+	for _, child := range w.entries(id) {
+		name := w.name(child)
+		if name == "" || name == "." || name == ".." || strings.ContainsRune(name, '/') || seen[name] {
+			log.Printf("Skipping %s, name %q is invalid or used twice", w.result.Tree.Path(child), name)
+			continue
+		}
+		if _, ok := w.numbers[child]; !ok {
+			log.Printf("Skipping %s, hardlink target is unknown", w.result.Tree.Path(child))
+			continue
+		}
+		seen[name] = true
+
+		entry, err := w.writeObject(child, w.numbers[id])
+		if err != nil {
+			return entry, fmt.Errorf("%s: %w", w.result.Tree.Path(child), err)
+		}
+		entries = append(entries, entry)
+	}
+
+	listingRef := w.directories.Ref()
+	listingSize := w.writeListing(entries)
+
+	subdirectories := uint32(0)
+	for _, entry := range entries {
+		if entry.Type == SQUASHFS_DIR {
+			subdirectories++
+		}
+	}
+
+	header := w.header(id)
+	xattr := w.xattr(id)
+	startBlock := uint32(listingRef >> 16)
+	blockOffset := uint16(listingRef & 0xFFFF)
+	size := listingSize + 3
+
+	var entry squashfsDirEntry
+	var err error
+	if size <= 0xFFFF && xattr == SQUASHFS_INVALID_XATTR {
+		entry, err = w.writeInode(id, SQUASHFS_DIR, false, header,
+			startBlock, 2+subdirectories, uint16(size), blockOffset, parent)
+	} else {
+		entry, err = w.writeInode(id, SQUASHFS_DIR, true, header,
+			2+subdirectories, size, startBlock, parent, uint16(0), blockOffset, xattr)
+	}
+	w.written[id] = entry
+	return entry, err
+}
+
+// Write directory headers and entries. A header covers at most 256
+// entries whose inodes start in one metadata block.
+func (w *squashfsWriter) writeListing(entries []squashfsDirEntry) uint32 {
+	start := w.directories.size
+	for k := 0; k < len(entries); {
+		first := entries[k]
+		n := 1
+		for k+n < len(entries) && n < 256 {
+			next := entries[k+n]
+			diff := int64(next.Number) - int64(first.Number)
+			if next.Ref>>16 != first.Ref>>16 || diff > 32767 || diff < -32768 {
+				break
+			}
+			n++
+		}
+
+		binary.Write(&w.directories, binary.LittleEndian, struct {
+			Count  uint32
+			Start  uint32
+			Number uint32
+		}{uint32(n - 1), uint32(first.Ref >> 16), first.Number})
+		for _, entry := range entries[k : k+n] {
+			binary.Write(&w.directories, binary.LittleEndian, struct {
+				Offset     uint16
+				NumberDiff int16
+				Type       uint16
+				NameSize   uint16
+			}{uint16(entry.Ref & 0xFFFF), int16(int64(entry.Number) - int64(first.Number)), entry.Type, uint16(len(entry.Name) - 1)})
+			w.directories.Write([]byte(entry.Name))
+		}
+		k += n
+	}
+	return uint32(w.directories.size - start)
+}
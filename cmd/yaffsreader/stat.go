@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+// Metadata of one object like stat(1), with the YAFFS detail of where it
+// came from
+func writeObjectStat(w io.Writer, result *yaffs.ScanResult, id uint32) error {
+	tree := result.Tree
+	object := tree.Objects[id]
+	header := object.Header
+
+	versions := 0
+	for _, entry := range result.Entries {
+		if entry.ObjectID == id {
+			versions++
+		}
+	}
+	var names []string
+	for _, xattr := range result.Xattrs(id) {
+		names = append(names, xattr.Name)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	fmt.Fprintf(tw, "Path:\t%s\n", tree.Path(id))
+	fmt.Fprintf(tw, "Object ID:\t%d\n", id)
+	fmt.Fprintf(tw, "Parent:\t%d\n", header.ParentObjectID)
+	fmt.Fprintf(tw, "Type:\t%s\n", header.ObjectType)
+	fmt.Fprintf(tw, "Mode:\t%s (%04o)\n", modeString(header), header.Mode&07777)
+	fmt.Fprintf(tw, "Owner:\t%d:%d\n", header.UID, header.GID)
+	fmt.Fprintf(tw, "Size:\t%d\n", header.FileSize(result.ByteOrder))
+	fmt.Fprintf(tw, "Access:\t%s\n", timeFormat.Format(header.AccessTime))
+	fmt.Fprintf(tw, "Modify:\t%s\n", timeFormat.Format(header.ModTime))
+	fmt.Fprintf(tw, "Change:\t%s\n", timeFormat.Format(header.CreateTime))
+	switch header.ObjectType {
+	case yaffs.YAFFS_OBJECT_TYPE_SYMLINK:
+		fmt.Fprintf(tw, "Target:\t%s\n", yaffs.CToGoString(header.Alias[:]))
+	case yaffs.YAFFS_OBJECT_TYPE_HARDLINK:
+		fmt.Fprintf(tw, "Target:\t%d\n", header.EquivID)
+	case yaffs.YAFFS_OBJECT_TYPE_FILE:
+		fmt.Fprintf(tw, "Data chunks:\t%d\n", len(result.DataChunks(id)))
+	}
+	fmt.Fprintf(tw, "Deleted:\t%t\n", tree.Deleted(id))
+	fmt.Fprintf(tw, "Synthesized:\t%t\n", object.Synthesized)
+	fmt.Fprintf(tw, "Sequence:\t%d\n", object.SeqNumber)
+	fmt.Fprintf(tw, "Header versions:\t%d\n", versions)
+	if chunk := result.HeaderChunk(id); chunk != nil {
+		fmt.Fprintf(tw, "Header offset:\t%d\n", chunk.Offset)
+	}
+	if len(names) > 0 {
+		fmt.Fprintf(tw, "Xattrs:\t%s\n", strings.Join(names, ", "))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+// Counts of a scan: chunks, objects by state and type, anomalies by kind
+// and pages corrected by the ECC
+func writeScanSummary(w io.Writer, result *yaffs.ScanResult, anomalies *anomalyCollector) error {
+	states := make(map[string]int)
+	types := make(map[string]int)
+	for k := range result.Entries {
+		entry := &result.Entries[k]
+		status := entryStatus(entry)
+		states[status]++
+		if status == "live" {
+			types[entry.Header.ObjectType.String()]++
+		}
+	}
+	kinds := make(map[string]int)
+	for _, e := range anomalies.Anomalies {
+		kinds[string(e.Anomaly)]++
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	fmt.Fprintf(tw, "Chunks:\t%d with valid tags\n", len(result.Chunks))
+	fmt.Fprintf(tw, "Objects:\t%d live, %d deleted, %d obsolete versions\n", states["live"], states["deleted"], states["obsolete"])
+	fmt.Fprintf(tw, "Live types:\t%s\n", formatCounts(types))
+	fmt.Fprintf(tw, "Anomalies:\t%s\n", formatCounts(kinds))
+	fmt.Fprintf(tw, "Page ECC:\t%d corrected, %d uncorrectable\n", result.CorrectedPages, result.UncorrectablePages)
+	return tw.Flush()
+}
+
+// "a 3, b 1" sorted by name, or "none"
+func formatCounts(counts map[string]int) string {
+	var names []string
+	for name := range counts {
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	sort.Strings(names)
+	s := ""
+	for k, name := range names {
+		if k > 0 {
+			s += ", "
+		}
+		s += fmt.Sprintf("%s %d", name, counts[name])
+	}
+	return s
+}
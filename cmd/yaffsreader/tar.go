@@ -0,0 +1,139 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+// Stream the live tree into a tar archive without writing any file to
+// disk: directories, files, symlinks, hardlinks to the first archived path
+// of their target and device nodes, with modes, owners and all three
+// times in PAX records. Sockets cannot be archived and are skipped, as are
+// objects outside filter.
+func writeTar(w io.Writer, result *yaffs.ScanResult, filter ObjectFilter) error {
+	tree := result.Tree
+	children := tree.Children()
+	tw := tar.NewWriter(w)
+
+	// Archived path of each hardlink target
+	archived := make(map[uint32]string)
+
+	// lost+found has no header of its own
+	if len(children[yaffs.YAFFS_OBJECTID_LOSTNFOUND]) > 0 {
+		err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     strings.TrimPrefix(tree.Path(yaffs.YAFFS_OBJECTID_LOSTNFOUND), "/") + "/",
+			Mode:     0700,
+			Format:   tar.FormatPAX,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, id := range liveTreeOrder(tree, children) {
+		if interrupted() {
+			return errInterrupted
+		}
+
+		header := tree.Objects[id].Header
+		objectPath := tree.Path(id)
+		err := checkPath(objectPath)
+		if err != nil {
+			log.Printf("Skipping %q: %v", objectPath, err)
+			continue
+		}
+		if !matchesTreeFilter(result, id, filter) {
+			continue
+		}
+		name := strings.TrimPrefix(objectPath, "/")
+
+		var target uint32
+		var size uint64
+		entry := &tar.Header{Name: name, Format: tar.FormatPAX}
+		switch header.ObjectType {
+		case yaffs.YAFFS_OBJECT_TYPE_DIRECTORY:
+			entry.Typeflag = tar.TypeDir
+			entry.Name += "/"
+
+		case yaffs.YAFFS_OBJECT_TYPE_FILE, yaffs.YAFFS_OBJECT_TYPE_HARDLINK:
+			target, header, err = result.FileObject(id)
+			if err != nil {
+				log.Printf("Skipping %s: %v", objectPath, err)
+				continue
+			}
+			if existing, ok := archived[target]; ok {
+				entry.Typeflag = tar.TypeLink
+				entry.Linkname = existing
+				break
+			}
+			entry.Typeflag = tar.TypeReg
+			size = result.ContentSize(header.FileSize(result.ByteOrder), result.DataChunks(target))
+			entry.Size = int64(size)
+			archived[target] = name
+
+		case yaffs.YAFFS_OBJECT_TYPE_SYMLINK:
+			entry.Typeflag = tar.TypeSymlink
+			entry.Linkname = yaffs.CToGoString(header.Alias[:])
+
+		case yaffs.YAFFS_OBJECT_TYPE_SPECIAL:
+			switch header.Mode & 0170000 {
+			case 0020000:
+				entry.Typeflag = tar.TypeChar
+			case 0060000:
+				entry.Typeflag = tar.TypeBlock
+			case 0010000:
+				entry.Typeflag = tar.TypeFifo
+			default:
+				log.Printf("Skipping %s, tar cannot hold special files of mode %o", objectPath, header.Mode)
+				continue
+			}
+			entry.Devmajor = int64((header.RDev >> 8) & 0xFFF)
+			entry.Devminor = int64((header.RDev & 0xFF) | ((header.RDev >> 12) & 0xFFF00))
+
+		default:
+			log.Printf("Skipping %s of type %s", objectPath, header.ObjectType)
+			continue
+		}
+
+		entry.Mode = int64(header.Mode & 07777)
+		entry.Uid, entry.Gid = int(header.UID), int(header.GID)
+		entry.ModTime = time.Unix(int64(header.ModTime), 0)
+		entry.AccessTime = time.Unix(int64(header.AccessTime), 0)
+		entry.ChangeTime = time.Unix(int64(header.CreateTime), 0)
+
+		err = tw.WriteHeader(entry)
+		if err != nil {
+			return err
+		}
+		if entry.Typeflag == tar.TypeReg {
+			n, err := result.WriteObject(tw, target)
+			if err != nil {
+				return fmt.Errorf("%s: %w", objectPath, err)
+			}
+			if uint64(n) != size {
+				return fmt.Errorf("%s: wrote %d of %d bytes", objectPath, n, size)
+			}
+		}
+	}
+
+	return tw.Close()
+}
+
+// Objects of the live tree breadth first, parents before their children,
+// starting below the root and lost+found
+func liveTreeOrder(tree *yaffs.Tree, children map[uint32][]uint32) []uint32 {
+	order := append(append([]uint32{}, children[yaffs.YAFFS_OBJECTID_ROOT]...), children[yaffs.YAFFS_OBJECTID_LOSTNFOUND]...)
+	for k := 0; k < len(order); k++ {
+		if tree.Objects[order[k]].Header.ObjectType == yaffs.YAFFS_OBJECT_TYPE_DIRECTORY {
+			order = append(order, children[order[k]]...)
+		}
+	}
+	return order
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+// Find the object for a path or numeric object ID, preferring live objects
+// over deleted ones with the same path
+func findObject(tree *yaffs.Tree, pathOrID string) (uint32, bool) {
+	if id, err := strconv.ParseUint(pathOrID, 0, 32); err == nil {
+		_, ok := tree.Objects[uint32(id)]
+		return uint32(id), ok
+	}
+
+	var found uint32
+	for _, id := range tree.SortedIDs() {
+		if tree.Path(id) != pathOrID {
+			continue
+		}
+		if !tree.Deleted(id) {
+			return id, true
+		}
+		found = id
+	}
+	return found, found != 0
+}
+
+// Open and scan one dump of a series, nil for dumps without object IDs
+func scanDump(imagePath string, options *yaffs.ImageOptions) (*yaffs.ScanResult, error) {
+	img, err := yaffs.OpenImage(imagePath, options)
+	if err != nil {
+		return nil, err
+	}
+	defer img.Close()
+
+	if img.Classification.Format == yaffs.DUMP_FORMAT_DATA_ONLY {
+		log.Printf("Skipping %s, data-only dumps carry no object IDs", imagePath)
+		return nil, nil
+	}
+	return yaffs.ScanImage(img.Reader, img.Settings, img.Size, logAnomalies)
+}
+
+// SHA-256 of the current content of files and hardlinks, "-" for others
+func objectHash(result *yaffs.ScanResult, id uint32) (string, error) {
+	header := result.Tree.Objects[id].Header
+	if header.ObjectType != yaffs.YAFFS_OBJECT_TYPE_FILE && header.ObjectType != yaffs.YAFFS_OBJECT_TYPE_HARDLINK {
+		return "-", nil
+	}
+	hash := sha256.New()
+	_, err := result.WriteObject(hash, id)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// Report the state of one path or object in several dumps of the same
+// device, in the order given
+func trackAcrossDumps(w io.Writer, pathOrID string, imagePaths []string, options *yaffs.ImageOptions) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "DUMP\tOBJECT\tSEQUENCE\tTYPE\tSIZE\tMTIME\tCTIME\tATIME\tSHA256\tPATH")
+
+	for _, imagePath := range imagePaths {
+		result, err := scanDump(imagePath, options)
+		if err != nil {
+			return err
+		}
+		if result == nil {
+			fmt.Fprintf(tw, "%s\t-\t-\t-\t-\t-\t-\t-\t-\tunsupported\n", imagePath)
+			continue
+		}
+
+		id, ok := findObject(result.Tree, pathOrID)
+		if !ok {
+			fmt.Fprintf(tw, "%s\t-\t-\t-\t-\t-\t-\t-\t-\tabsent\n", imagePath)
+			continue
+		}
+
+		object := result.Tree.Objects[id]
+		header := object.Header
+
+		hash, err := objectHash(result, id)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%d\t%s\t%s\t%s\t%s\t%s\n",
+			imagePath,
+			id,
+			object.SeqNumber,
+			header.ObjectType,
+			header.FileSize(result.ByteOrder),
+			timeFormat.Format(header.ModTime),
+			timeFormat.Format(header.CreateTime),
+			timeFormat.Format(header.AccessTime),
+			hash,
+			result.Tree.Path(id))
+	}
+
+	return tw.Flush()
+}
+
+// Hash the content of many objects concurrently. Hardlinks are resolved
+// first so every file is read once, its chunks are not safe to load from
+// two workers.
+func hashObjects(result *yaffs.ScanResult, ids []uint32) (map[uint32]string, error) {
+	targets := make(map[uint32][]uint32)
+	hashes := make(map[uint32]string)
+	for _, id := range ids {
+		objectType := result.Tree.Objects[id].Header.ObjectType
+		if objectType != yaffs.YAFFS_OBJECT_TYPE_FILE && objectType != yaffs.YAFFS_OBJECT_TYPE_HARDLINK {
+			hashes[id] = "-"
+			continue
+		}
+		target, _, err := result.FileObject(id)
+		if err != nil {
+			return nil, err
+		}
+		targets[target] = append(targets[target], id)
+	}
+
+	var mu sync.Mutex
+	var firstErr error
+	work := make(chan uint32)
+	var wg sync.WaitGroup
+	for w := 0; w < yaffs.WorkerCount(jobLimits.Hash); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for target := range work {
+				hash, err := objectHash(result, target)
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				for _, id := range targets[target] {
+					hashes[id] = hash
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for target := range targets {
+		if interrupted() {
+			mu.Lock()
+			firstErr = errInterrupted
+			mu.Unlock()
+			break
+		}
+		work <- target
+	}
+	close(work)
+	wg.Wait()
+
+	return hashes, firstErr
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+// Compare an extracted tree, written by this or any other tool, against the
+// reconstruction from the image. One PASS or FAIL line is written per live
+// object; the number of failures is returned.
+func verifyExtraction(dir string, result *yaffs.ScanResult, w io.Writer) (int, error) {
+	failures := 0
+	tree := result.Tree
+
+	for _, id := range tree.SortedIDs() {
+		if interrupted() {
+			log.Println("Verification interrupted, remaining objects not checked")
+			break
+		}
+		if tree.Deleted(id) {
+			continue
+		}
+		header := tree.Objects[id].Header
+		objectPath := tree.Path(id)
+		local := filepath.Join(dir, filepath.FromSlash(objectPath))
+
+		detail, err := verifyObject(result, id, header, local)
+		status := "PASS"
+		if err != nil {
+			status, detail = "FAIL", err.Error()
+			failures++
+		}
+
+		_, err = fmt.Fprintf(w, "%s\t%s\t%s\n", status, objectPath, detail)
+		if err != nil {
+			return failures, err
+		}
+	}
+
+	return failures, nil
+}
+
+func verifyObject(result *yaffs.ScanResult, id uint32, header *yaffs.ObjectHeader, local string) (string, error) {
+	info, err := os.Lstat(local)
+	if err != nil {
+		return "", err
+	}
+
+	switch header.ObjectType {
+	case yaffs.YAFFS_OBJECT_TYPE_DIRECTORY:
+		if !info.IsDir() {
+			return "", fmt.Errorf("expected a directory, found %s", info.Mode().Type())
+		}
+		return "directory", nil
+
+	case yaffs.YAFFS_OBJECT_TYPE_SYMLINK:
+		target, err := os.Readlink(local)
+		if err != nil {
+			return "", err
+		}
+		alias := yaffs.CToGoString(header.Alias[:])
+		if target != alias {
+			return "", fmt.Errorf("symlink target %q, expected %q", target, alias)
+		}
+		return "-> " + alias, nil
+
+	case yaffs.YAFFS_OBJECT_TYPE_FILE, yaffs.YAFFS_OBJECT_TYPE_HARDLINK:
+		if !info.Mode().IsRegular() {
+			return "", fmt.Errorf("expected a regular file, found %s", info.Mode().Type())
+		}
+		expectedHash := sha256.New()
+		expectedSize, err := result.WriteObject(expectedHash, id)
+		if err != nil {
+			return "", err
+		}
+		if info.Size() != expectedSize {
+			return "", fmt.Errorf("size %d, expected %d", info.Size(), expectedSize)
+		}
+
+		f, err := os.Open(local)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+
+		hash := sha256.New()
+		_, err = io.Copy(hash, f)
+		if err != nil {
+			return "", err
+		}
+		if !bytes.Equal(hash.Sum(nil), expectedHash.Sum(nil)) {
+			return "", fmt.Errorf("sha256 %x, expected %x", hash.Sum(nil), expectedHash.Sum(nil))
+		}
+		return "sha256 " + hex.EncodeToString(expectedHash.Sum(nil)), nil
+	}
+
+	return header.ObjectType.String(), nil
+}
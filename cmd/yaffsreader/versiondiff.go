@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+// Byte range [Start, End) differing between two versions
+type ChangedRange struct {
+	Start int64
+	End   int64
+}
+
+// Compare two versions byte by byte and report changed ranges, plus bytes
+// added or removed at the end of the file
+func writeVersionDiff(r *yaffs.ScanResult, w io.Writer, from, to *yaffs.FileVersion) error {
+	a, err := r.VersionContent(from)
+	if err != nil {
+		return err
+	}
+	b, err := r.VersionContent(to)
+	if err != nil {
+		return err
+	}
+
+	var ranges []ChangedRange
+	common := len(a)
+	if len(b) < common {
+		common = len(b)
+	}
+	for i := 0; i < common; i++ {
+		if a[i] == b[i] {
+			continue
+		}
+		if n := len(ranges); n > 0 && ranges[n-1].End == int64(i) {
+			ranges[n-1].End++
+		} else {
+			ranges = append(ranges, ChangedRange{Start: int64(i), End: int64(i) + 1})
+		}
+	}
+
+	fmt.Fprintf(w, "Version %d (sequence %d, modified %s, %d bytes)\n",
+		from.Number, from.Chunk.Spare.SeqNumber, timeFormat.Format(from.Header.ModTime), len(a))
+	fmt.Fprintf(w, "Version %d (sequence %d, modified %s, %d bytes)\n",
+		to.Number, to.Chunk.Spare.SeqNumber, timeFormat.Format(to.Header.ModTime), len(b))
+
+	changed := int64(0)
+	for _, cr := range ranges {
+		changed += cr.End - cr.Start
+		fmt.Fprintf(w, "Changed %d-%d (%d bytes)\n", cr.Start, cr.End-1, cr.End-cr.Start)
+	}
+	switch {
+	case len(b) > len(a):
+		fmt.Fprintf(w, "Added %d-%d (%d bytes)\n", len(a), len(b)-1, len(b)-len(a))
+	case len(a) > len(b):
+		fmt.Fprintf(w, "Removed %d-%d (%d bytes)\n", len(b), len(a)-1, len(a)-len(b))
+	}
+	_, err = fmt.Fprintf(w, "Summary: %d bytes changed in %d ranges, size %+d bytes\n", changed, len(ranges), len(b)-len(a))
+	return err
+}
+
+// Pick the versions given as "FROM,TO", or the two newest for an empty string
+func selectVersions(versions []*yaffs.FileVersion, spec string) (*yaffs.FileVersion, *yaffs.FileVersion, error) {
+	if spec == "" {
+		if len(versions) < 2 {
+			return nil, nil, fmt.Errorf("only %d version on flash, nothing to compare", len(versions))
+		}
+		return versions[len(versions)-2], versions[len(versions)-1], nil
+	}
+
+	var numbers [2]int
+	fields := strings.Split(spec, ",")
+	if len(fields) != 2 {
+		return nil, nil, fmt.Errorf("invalid versions %q, expected FROM,TO", spec)
+	}
+	for i, field := range fields {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n < 1 || n > len(versions) {
+			return nil, nil, fmt.Errorf("invalid version %q, expected 1 to %d", field, len(versions))
+		}
+		numbers[i] = n
+	}
+	return versions[numbers[0]-1], versions[numbers[1]-1], nil
+}
+
+// List every version of a file, and with a non-empty dir also write each
+// one as NAME.v<sequence> into that existing directory. Versions sharing a sequence
+// number, i.e. written to the same block, get a counter appended.
+func exportVersions(r *yaffs.ScanResult, w io.Writer, versions []*yaffs.FileVersion, dir string) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tSEQUENCE\tOFFSET\tSIZE\tMTIME\tSHA256\tFILE")
+
+	used := make(map[string]int)
+	for k, version := range versions {
+		if interrupted() {
+			log.Printf("Interrupted after %d of %d versions", k, len(versions))
+			break
+		}
+		content, err := r.VersionContent(version)
+		if err != nil {
+			return err
+		}
+
+		file := "-"
+		if dir != "" {
+			file = fmt.Sprintf("%s.v%d", yaffs.CToGoString(version.Header.Name[:]), version.Chunk.Spare.SeqNumber)
+			used[file]++
+			if used[file] > 1 {
+				file = fmt.Sprintf("%s.%d", file, used[file])
+			}
+			err = writeFile(filepath.Join(dir, file), bytes.NewReader(content))
+			if err != nil {
+				return err
+			}
+		}
+
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%s\t%x\t%s\n",
+			version.Number,
+			version.Chunk.Spare.SeqNumber,
+			version.Chunk.Offset,
+			len(content),
+			timeFormat.Format(version.Header.ModTime),
+			sha256.Sum256(content),
+			file)
+	}
+
+	return tw.Flush()
+}
+
+// Write every on-flash version of every file, live or deleted, below dir
+// as PATH.v<sequence>, with one manifest line per version written to w
+func exportAllVersions(r *yaffs.ScanResult, w io.Writer, dir string) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "OBJECT\tVERSION\tSEQUENCE\tOFFSET\tSIZE\tMTIME\tSHA256\tFILE")
+
+	tree := r.Tree
+	used := make(map[string]int)
+	for _, id := range tree.SortedIDs() {
+		if interrupted() {
+			log.Printf("Version export interrupted at object %d", id)
+			break
+		}
+		if tree.Objects[id].Header.ObjectType != yaffs.YAFFS_OBJECT_TYPE_FILE {
+			continue
+		}
+		objectPath := tree.Path(id)
+		local, err := extractPath(dir, objectPath)
+		if err != nil {
+			log.Printf("Skipping %q: %v", objectPath, err)
+			continue
+		}
+		versions, err := r.FileVersions(id)
+		if err != nil {
+			return err
+		}
+
+		for _, version := range versions {
+			file := fmt.Sprintf("%s.v%d", objectPath, version.Chunk.Spare.SeqNumber)
+			used[file]++
+			if used[file] > 1 {
+				file = fmt.Sprintf("%s.%d", file, used[file])
+			}
+			name := local + strings.TrimPrefix(file, objectPath)
+
+			hash := sha256.New()
+			size, err := func() (int64, error) {
+				err := os.MkdirAll(filepath.Dir(name), 0777)
+				if err != nil {
+					return 0, err
+				}
+				out, err := createOutput(name, nil)
+				if err != nil {
+					return 0, err
+				}
+				n, err := r.WriteVersion(io.MultiWriter(out, hash), version)
+				closeErr := out.Close()
+				if err != nil {
+					return n, err
+				}
+				return n, closeErr
+			}()
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%d\t%s\t%x\t%s\n",
+				id,
+				version.Number,
+				version.Chunk.Spare.SeqNumber,
+				version.Chunk.Offset,
+				size,
+				timeFormat.Format(version.Header.ModTime),
+				hash.Sum(nil),
+				file)
+		}
+	}
+
+	return tw.Flush()
+}
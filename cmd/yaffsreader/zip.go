@@ -0,0 +1,161 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fabian-z/yaffsreader/yaffs"
+)
+
+// Info-ZIP new Unix extra field holding owner and group
+const zipExtraUnixOwner = 0x7875
+
+// Path of the manifest written next to a ZIP archive
+func zipManifestPath(name string) string {
+	return strings.TrimSuffix(name, ".zip") + ".manifest.csv"
+}
+
+var zipManifestColumns = []string{"path", "type", "atime", "ctime", "link_target", "rdev_major", "rdev_minor"}
+
+// Write the live tree into a ZIP archive. Modes, modification times and
+// symlinks map to the Unix attributes and extended timestamps of Info-ZIP,
+// owners to its Unix extra field. What ZIP cannot hold goes to a CSV
+// manifest, one row per object: access and change times, the target of
+// hardlinks, which are stored as copies, and the device numbers of special
+// files, which are stored empty. Objects outside filter are left out.
+func writeZip(w io.Writer, manifest io.Writer, result *yaffs.ScanResult, filter ObjectFilter) error {
+	tree := result.Tree
+	children := tree.Children()
+	zw := zip.NewWriter(w)
+	cw := csv.NewWriter(manifest)
+	err := cw.Write(zipManifestColumns)
+	if err != nil {
+		return err
+	}
+
+	// Archived path of each hardlink target
+	archived := make(map[uint32]string)
+
+	// lost+found has no header of its own
+	if len(children[yaffs.YAFFS_OBJECTID_LOSTNFOUND]) > 0 {
+		entry := &zip.FileHeader{Name: strings.TrimPrefix(tree.Path(yaffs.YAFFS_OBJECTID_LOSTNFOUND), "/") + "/"}
+		entry.SetMode(os.ModeDir | 0700)
+		_, err = zw.CreateHeader(entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, id := range liveTreeOrder(tree, children) {
+		if interrupted() {
+			return errInterrupted
+		}
+
+		header := tree.Objects[id].Header
+		objectPath := tree.Path(id)
+		err := checkPath(objectPath)
+		if err != nil {
+			log.Printf("Skipping %q: %v", objectPath, err)
+			continue
+		}
+		if !matchesTreeFilter(result, id, filter) {
+			continue
+		}
+
+		entry := &zip.FileHeader{Name: strings.TrimPrefix(objectPath, "/"), Method: zip.Deflate}
+		row := []string{objectPath, header.ObjectType.String(), "", "", "", "", ""}
+		mode := os.FileMode(header.Mode&0777) | unixModeBits(header.Mode)
+		var target uint32
+		var content io.Reader
+
+		switch header.ObjectType {
+		case yaffs.YAFFS_OBJECT_TYPE_DIRECTORY:
+			entry.Name += "/"
+			entry.Method = zip.Store
+			mode |= os.ModeDir
+
+		case yaffs.YAFFS_OBJECT_TYPE_FILE, yaffs.YAFFS_OBJECT_TYPE_HARDLINK:
+			target, header, err = result.FileObject(id)
+			if err != nil {
+				log.Printf("Skipping %s: %v", objectPath, err)
+				continue
+			}
+			if existing, ok := archived[target]; ok {
+				row[4] = "/" + existing
+			} else {
+				archived[target] = entry.Name
+			}
+
+		case yaffs.YAFFS_OBJECT_TYPE_SYMLINK:
+			mode |= os.ModeSymlink
+			content = strings.NewReader(yaffs.CToGoString(header.Alias[:]))
+			row[4] = yaffs.CToGoString(header.Alias[:])
+
+		case yaffs.YAFFS_OBJECT_TYPE_SPECIAL:
+			switch header.Mode & 0170000 {
+			case 0020000:
+				mode |= os.ModeDevice | os.ModeCharDevice
+			case 0060000:
+				mode |= os.ModeDevice
+			case 0010000:
+				mode |= os.ModeNamedPipe
+			case 0140000:
+				mode |= os.ModeSocket
+			}
+			row[5] = strconv.FormatUint(uint64((header.RDev>>8)&0xFFF), 10)
+			row[6] = strconv.FormatUint(uint64((header.RDev&0xFF)|((header.RDev>>12)&0xFFF00)), 10)
+
+		default:
+			log.Printf("Skipping %s of type %s", objectPath, header.ObjectType)
+			continue
+		}
+
+		entry.SetMode(mode)
+		entry.Modified = time.Unix(int64(header.ModTime), 0)
+		entry.Extra = zipOwnerExtra(header.UID, header.GID)
+		row[2] = timeFormat.Format(header.AccessTime)
+		row[3] = timeFormat.Format(header.CreateTime)
+
+		out, err := zw.CreateHeader(entry)
+		if err != nil {
+			return err
+		}
+		if target != 0 {
+			_, err = result.WriteObject(out, target)
+		} else if content != nil {
+			_, err = io.Copy(out, content)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", objectPath, err)
+		}
+		err = cw.Write(row)
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// Info-ZIP Unix extra field version 1 with 32 bit IDs
+func zipOwnerExtra(uid, gid uint32) []byte {
+	extra := make([]byte, 4, 15)
+	binary.LittleEndian.PutUint16(extra, zipExtraUnixOwner)
+	binary.LittleEndian.PutUint16(extra[2:], 11)
+	extra = append(extra, 1, 4)
+	extra = binary.LittleEndian.AppendUint32(extra, uid)
+	extra = append(extra, 4)
+	return binary.LittleEndian.AppendUint32(extra, gid)
+}
@@ -0,0 +1,170 @@
+package yaffs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// An image inside a zip or tar archive. Stored zip members and tar members
+// are read in place, compressed members are unpacked once to a temporary
+// file since scanning needs random access.
+type archiveMember struct {
+	io.ReaderAt
+	Size int64
+
+	file *os.File
+	temp string
+}
+
+func (m *archiveMember) Close() error {
+	err := m.file.Close()
+	if m.temp != "" {
+		os.Remove(m.temp)
+	}
+	return err
+}
+
+// Open archive://ARCHIVE/MEMBER, where ARCHIVE is the longest leading part
+// naming an existing file
+func openArchiveMember(location string) (*archiveMember, error) {
+	location = strings.TrimPrefix(location, "archive://")
+
+	archivePath, member := "", ""
+	for k := len(location); k > 0; k = strings.LastIndexByte(location[:k], '/') {
+		info, err := os.Stat(location[:k])
+		if err == nil && info.Mode().IsRegular() {
+			archivePath, member = location[:k], strings.TrimPrefix(location[k:], "/")
+			break
+		}
+	}
+	if archivePath == "" || member == "" {
+		return nil, fmt.Errorf("invalid archive location %q, expected archive://ARCHIVE/MEMBER", location)
+	}
+
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var m *archiveMember
+	switch strings.ToLower(filepath.Ext(archivePath)) {
+	case ".zip":
+		m, err = openZipMember(file, member)
+	case ".tar":
+		m, err = openTarMember(file, member)
+	case ".gz", ".tgz":
+		m, err = unpackTarMember(file, member)
+	case ".7z":
+		err = errors.New("7z archives are not supported, unpack the image first")
+	default:
+		err = fmt.Errorf("unknown archive type of %s, expected .zip, .tar, .tar.gz or .tgz", archivePath)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", archivePath, err)
+	}
+	return m, nil
+}
+
+func openZipMember(file *os.File, member string) (*archiveMember, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	archive, err := zip.NewReader(file, info.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range archive.File {
+		if entry.Name != member {
+			continue
+		}
+		size := int64(entry.UncompressedSize64)
+
+		if entry.Method == zip.Store {
+			offset, err := entry.DataOffset()
+			if err != nil {
+				return nil, err
+			}
+			return &archiveMember{ReaderAt: io.NewSectionReader(file, offset, size), Size: size, file: file}, nil
+		}
+
+		content, err := entry.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer content.Close()
+		return unpackMember(file, content, size)
+	}
+
+	return nil, fmt.Errorf("no member %s", member)
+}
+
+func openTarMember(file *os.File, member string) (*archiveMember, error) {
+	archive := tar.NewReader(file)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no member %s", member)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimPrefix(header.Name, "./") != member || header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		// The reader stops right before the member content
+		offset, err := file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		return &archiveMember{ReaderAt: io.NewSectionReader(file, offset, header.Size), Size: header.Size, file: file}, nil
+	}
+}
+
+func unpackTarMember(file *os.File, member string) (*archiveMember, error) {
+	decompressed, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer decompressed.Close()
+
+	archive := tar.NewReader(decompressed)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no member %s", member)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimPrefix(header.Name, "./") == member && header.Typeflag == tar.TypeReg {
+			return unpackMember(file, archive, header.Size)
+		}
+	}
+}
+
+func unpackMember(file *os.File, content io.Reader, size int64) (*archiveMember, error) {
+	temp, err := os.CreateTemp("", "yaffsreader-member-")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = io.CopyN(temp, content, size)
+	if err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return nil, err
+	}
+	file.Close()
+	return &archiveMember{ReaderAt: temp, Size: size, file: temp, temp: temp.Name()}, nil
+}
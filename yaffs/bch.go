@@ -0,0 +1,275 @@
+package yaffs
+
+import (
+	"fmt"
+	"math/bits"
+	"sync"
+)
+
+// Primitive polynomials of GF(2^m) used by the Linux BCH library, for the
+// field sizes of 512, 1024 and 2048 byte steps
+var bchPrimitivePolynomials = map[int]int{13: 0x201b, 14: 0x402b, 15: 0x8003}
+
+// Binary BCH code as computed by the Linux software BCH ECC (lib/bch.c
+// with nand_bch): data bits most significant first, the remainder stored
+// left aligned in the ECC bytes, xored with a mask that makes the code of
+// an erased step read as erased
+type bchCode struct {
+	m, t, n  int // field bits, correctable bits, field elements - 1
+	stepSize int
+	eccBits  int
+	eccBytes int
+
+	exp []int // powers of alpha, twice over to skip the modulo
+	log []int
+
+	table [256][]byte // remainder of every byte value shifted past the data
+	mask  []byte
+}
+
+// Field bits of the code over a step, as chosen by nand_bch
+func bchFieldBits(stepSize int) int {
+	return bits.Len(uint(1 + 8*stepSize))
+}
+
+// ECC bytes per step of a code correcting strength bits
+func bchECCBytes(stepSize, strength int) int {
+	return (bchFieldBits(stepSize)*strength + 7) / 8
+}
+
+var bchCodes = struct {
+	sync.Mutex
+	codes map[[2]int]*bchCode
+}{codes: make(map[[2]int]*bchCode)}
+
+// Codes are built once per step size and strength
+func bchCodeFor(stepSize, strength int) (*bchCode, error) {
+	bchCodes.Lock()
+	defer bchCodes.Unlock()
+	key := [2]int{stepSize, strength}
+	if code, ok := bchCodes.codes[key]; ok {
+		return code, nil
+	}
+	code, err := newBCHCode(stepSize, strength)
+	if err != nil {
+		return nil, err
+	}
+	bchCodes.codes[key] = code
+	return code, nil
+}
+
+func newBCHCode(stepSize, strength int) (*bchCode, error) {
+	m := bchFieldBits(stepSize)
+	poly, ok := bchPrimitivePolynomials[m]
+	if !ok {
+		return nil, fmt.Errorf("no BCH code over %d byte steps, expected 512, 1024 or 2048", stepSize)
+	}
+	n := 1<<uint(m) - 1
+	if strength < 1 || m*strength >= 8*stepSize {
+		return nil, fmt.Errorf("no BCH code correcting %d bits of %d byte steps", strength, stepSize)
+	}
+
+	code := &bchCode{m: m, t: strength, n: n, stepSize: stepSize, exp: make([]int, 2*n), log: make([]int, n+1)}
+	x := 1
+	for i := 0; i < n; i++ {
+		code.exp[i], code.exp[i+n] = x, x
+		code.log[x] = i
+		x <<= 1
+		if x&(1<<uint(m)) != 0 {
+			x ^= poly
+		}
+	}
+
+	// Generator polynomial: the product of x - alpha^r over the roots of
+	// the minimal polynomials of alpha, alpha^3, ..., alpha^(2t-1)
+	roots := make(map[int]bool)
+	for i := 0; i < strength; i++ {
+		r := 2*i + 1
+		for j := 0; j < m; j++ {
+			roots[r] = true
+			r = 2 * r % n
+		}
+	}
+	generator := []int{1} // coefficients from degree 0 up
+	for r := range roots {
+		next := make([]int, len(generator)+1)
+		for i, c := range generator {
+			next[i+1] ^= c
+			next[i] ^= code.mul(c, code.exp[r])
+		}
+		generator = next
+	}
+	code.eccBits = len(generator) - 1
+	code.eccBytes = (code.eccBits + 7) / 8
+
+	// Scaled past the padding bits, the remainder comes out left aligned
+	pad := 8*code.eccBytes - code.eccBits
+	low := make([]byte, code.eccBytes)
+	for degree := 0; degree < code.eccBits; degree++ {
+		if generator[degree] != 0 {
+			index := 8*code.eccBytes - 1 - (degree + pad)
+			low[index/8] |= 0x80 >> uint(index%8)
+		}
+	}
+	for v := 0; v < 256; v++ {
+		rem := make([]byte, code.eccBytes)
+		for bit := 7; bit >= 0; bit-- {
+			top := rem[0]>>7 ^ byte(v>>uint(bit))&1
+			shiftLeft(rem)
+			if top != 0 {
+				xorBytes(rem, low)
+			}
+		}
+		code.table[v] = rem
+	}
+
+	erased := make([]byte, stepSize)
+	for i := range erased {
+		erased[i] = 0xFF
+	}
+	code.mask = code.remainder(erased)
+	for i := range code.mask {
+		code.mask[i] ^= 0xFF
+	}
+	return code, nil
+}
+
+func shiftLeft(buf []byte) {
+	for i := range buf {
+		buf[i] <<= 1
+		if i+1 < len(buf) {
+			buf[i] |= buf[i+1] >> 7
+		}
+	}
+}
+
+func xorBytes(dst, src []byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
+
+func (c *bchCode) mul(a, b int) int {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return c.exp[c.log[a]+c.log[b]]
+}
+
+// data(x) * x^eccBits mod g(x), left aligned in the ECC bytes
+func (c *bchCode) remainder(data []byte) []byte {
+	rem := make([]byte, c.eccBytes)
+	for _, b := range data {
+		entry := c.table[rem[0]^b]
+		copy(rem, rem[1:])
+		rem[len(rem)-1] = 0
+		xorBytes(rem, entry)
+	}
+	return rem
+}
+
+// ECC bytes of a step as nand_bch stores them
+func (c *bchCode) encode(data []byte) []byte {
+	ecc := c.remainder(data)
+	xorBytes(ecc, c.mask)
+	return ecc
+}
+
+// Verify one step against its stored ECC bytes and flip wrong data bits in
+// place, as long as there are no more than the code corrects
+func (c *bchCode) correct(data, stored []byte) TagsECCResult {
+	diff := c.encode(data)
+	xorBytes(diff, stored[:c.eccBytes])
+	pad := 8*c.eccBytes - c.eccBits
+	diff[len(diff)-1] &^= byte(1<<uint(pad) - 1)
+
+	// The received word differs from the codeword of the data in the ECC
+	// bits only, so its syndromes are those of the difference
+	syndromes := make([]int, 2*c.t)
+	errors := false
+	for index := 0; index < c.eccBits; index++ {
+		if diff[index/8]&(0x80>>uint(index%8)) == 0 {
+			continue
+		}
+		errors = true
+		degree := c.eccBits - 1 - index
+		for j := range syndromes {
+			syndromes[j] ^= c.exp[(j+1)*degree%c.n]
+		}
+	}
+	if !errors {
+		return TAGS_ECC_OK
+	}
+
+	locator := c.berlekampMassey(syndromes)
+	count := len(locator) - 1
+	if count > c.t {
+		return TAGS_ECC_UNFIXED
+	}
+
+	// Chien search over every bit of the codeword
+	length := 8*len(data) + c.eccBits
+	var found []int
+	for degree := 0; degree < length && len(found) < count; degree++ {
+		sum := 0
+		for i, coefficient := range locator {
+			if coefficient != 0 {
+				sum ^= c.exp[((c.log[coefficient]-degree*i)%c.n+c.n)%c.n]
+			}
+		}
+		if sum == 0 {
+			found = append(found, degree)
+		}
+	}
+	if len(found) != count {
+		return TAGS_ECC_UNFIXED
+	}
+	for _, degree := range found {
+		// Errors in the ECC bits leave the data intact
+		if degree < c.eccBits {
+			continue
+		}
+		position := length - 1 - degree
+		data[position/8] ^= 0x80 >> uint(position%8)
+	}
+	return TAGS_ECC_FIXED
+}
+
+// Error locator polynomial of the syndromes, coefficients from degree 0 up
+func (c *bchCode) berlekampMassey(syndromes []int) []int {
+	locator, previous := []int{1}, []int{1}
+	length, shift, previousDiscrepancy := 0, 1, 1
+	for r := range syndromes {
+		discrepancy := syndromes[r]
+		for i := 1; i <= length && i < len(locator); i++ {
+			discrepancy ^= c.mul(locator[i], syndromes[r-i])
+		}
+		if discrepancy == 0 {
+			shift++
+			continue
+		}
+
+		factor := c.exp[(c.log[discrepancy]-c.log[previousDiscrepancy]+c.n)%c.n]
+		saved := append([]int(nil), locator...)
+		for len(locator) < len(previous)+shift {
+			locator = append(locator, 0)
+		}
+		for i, coefficient := range previous {
+			locator[i+shift] ^= c.mul(factor, coefficient)
+		}
+		if 2*length <= r {
+			length = r + 1 - length
+			previous, previousDiscrepancy, shift = saved, discrepancy, 1
+		} else {
+			shift++
+		}
+	}
+	for len(locator) > 1 && locator[len(locator)-1] == 0 {
+		locator = locator[:len(locator)-1]
+	}
+	if len(locator)-1 != length {
+		// Inconsistent syndromes, more errors than the code can locate
+		return make([]int, c.t+2)
+	}
+	return locator
+}
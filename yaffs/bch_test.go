@@ -0,0 +1,237 @@
+package yaffs
+
+import (
+	"bytes"
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+// Codes of the ECC schemes NAND controllers commonly configure nand_bch
+// with, and the ECC bytes per step Linux computes for them as
+// DIV_ROUND_UP(m * t, 8)
+var bchTestCodes = []struct {
+	stepSize, strength, eccBytes int
+}{
+	{512, 1, 2},
+	{512, 4, 7},
+	{512, 8, 13},
+	{1024, 8, 14},
+	{1024, 24, 42},
+	{1024, 40, 70},
+	{2048, 4, 8},
+}
+
+func testBCHCode(t *testing.T, stepSize, strength int) *bchCode {
+	t.Helper()
+	code, err := bchCodeFor(stepSize, strength)
+	if err != nil {
+		t.Fatalf("bch%d over %d bytes: %v", strength, stepSize, err)
+	}
+	return code
+}
+
+func TestBCHParameters(t *testing.T) {
+	for _, test := range bchTestCodes {
+		code := testBCHCode(t, test.stepSize, test.strength)
+		if code.eccBytes != test.eccBytes || bchECCBytes(test.stepSize, test.strength) != test.eccBytes {
+			t.Errorf("bch%d over %d bytes: %d ECC bytes, want %d", test.strength, test.stepSize, code.eccBytes, test.eccBytes)
+		}
+		// lib/bch.c rejects generators of another degree than m * t
+		if code.eccBits != code.m*code.t {
+			t.Errorf("bch%d over %d bytes: generator of degree %d, want %d", test.strength, test.stepSize, code.eccBits, code.m*code.t)
+		}
+	}
+
+	// The polynomials of lib/bch.c must be primitive, alpha generating
+	// every nonzero element once
+	for m, poly := range bchPrimitivePolynomials {
+		seen := make(map[int]bool)
+		x := 1
+		for i := 0; i < 1<<uint(m)-1; i++ {
+			if seen[x] {
+				t.Errorf("polynomial %#x of GF(2^%d) is not primitive, alpha^%d repeats", poly, m, i)
+				break
+			}
+			seen[x] = true
+			x <<= 1
+			if x&(1<<uint(m)) != 0 {
+				x ^= poly
+			}
+		}
+	}
+
+	for _, test := range []struct{ stepSize, strength int }{{256, 4}, {512, 0}, {512, 316}} {
+		if _, err := newBCHCode(test.stepSize, test.strength); err == nil {
+			t.Errorf("bch%d over %d bytes: no error", test.strength, test.stepSize)
+		}
+	}
+}
+
+// Bit serial encoder following lib/bch.c and nand_bch independently of the
+// table driven one: the remainder of data(x) * x^(m*t) by the product of
+// the minimal polynomials of alpha, alpha^3, ..., alpha^(2t-1), data bits
+// most significant first, stored left aligned and xored with the inverted
+// ECC of an erased step
+func referenceBCHEncode(stepSize, strength int, data []byte) []byte {
+	m := bchFieldBits(stepSize)
+	poly := bchPrimitivePolynomials[m]
+	n := 1<<uint(m) - 1
+	exp := make([]int, n)
+	logs := make([]int, n+1)
+	for i, x := 0, 1; i < n; i++ {
+		exp[i], logs[x] = x, i
+		x <<= 1
+		if x&(1<<uint(m)) != 0 {
+			x ^= poly
+		}
+	}
+	mul := func(a, b int) int {
+		if a == 0 || b == 0 {
+			return 0
+		}
+		return exp[(logs[a]+logs[b])%n]
+	}
+
+	// Minimal polynomials over the cyclotomic cosets of the odd powers,
+	// each coset once
+	generator := new(big.Int).SetInt64(1)
+	used := make(map[int]bool)
+	for i := 0; i < strength; i++ {
+		if used[2*i+1] {
+			continue
+		}
+		minimal := []int{1}
+		for r := 2*i + 1; !used[r]; r = 2 * r % n {
+			used[r] = true
+			next := make([]int, len(minimal)+1)
+			for k, c := range minimal {
+				next[k+1] ^= c
+				next[k] ^= mul(c, exp[r])
+			}
+			minimal = next
+		}
+		factor := new(big.Int)
+		for k, c := range minimal {
+			factor.SetBit(factor, k, uint(c))
+		}
+		generator = carrylessMultiply(generator, factor)
+	}
+	degree := generator.BitLen() - 1
+
+	encode := func(data []byte) []byte {
+		register := new(big.Int)
+		for _, b := range data {
+			for bit := 7; bit >= 0; bit-- {
+				feedback := register.Bit(degree-1) ^ uint(b>>uint(bit))&1
+				register.Lsh(register, 1)
+				register.SetBit(register, degree, 0)
+				if feedback != 0 {
+					register.Xor(register, generator)
+					register.SetBit(register, degree, 0)
+				}
+			}
+		}
+		ecc := make([]byte, (degree+7)/8)
+		for k := 0; k < degree; k++ {
+			if register.Bit(degree-1-k) != 0 {
+				ecc[k/8] |= 0x80 >> uint(k%8)
+			}
+		}
+		return ecc
+	}
+
+	ecc := encode(data)
+	mask := encode(bytes.Repeat([]byte{0xFF}, stepSize))
+	for k := range ecc {
+		ecc[k] ^= ^mask[k]
+	}
+	return ecc
+}
+
+// Product of two polynomials over GF(2), one bit per coefficient
+func carrylessMultiply(a, b *big.Int) *big.Int {
+	product := new(big.Int)
+	for k := 0; k < b.BitLen(); k++ {
+		if b.Bit(k) != 0 {
+			product.Xor(product, new(big.Int).Lsh(a, uint(k)))
+		}
+	}
+	return product
+}
+
+func TestBCHEncodeReference(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for _, test := range bchTestCodes {
+		code := testBCHCode(t, test.stepSize, test.strength)
+		data := make([]byte, test.stepSize)
+		for k := 0; k < 3; k++ {
+			if k > 0 {
+				random.Read(data)
+			}
+			got, want := code.encode(data), referenceBCHEncode(test.stepSize, test.strength, data)
+			if !bytes.Equal(got, want) {
+				t.Errorf("bch%d over %d bytes, step %d: ECC %x, want %x", test.strength, test.stepSize, k, got, want)
+			}
+		}
+	}
+}
+
+// nand_bch masks the ECC so that erased pages need no special case: the
+// ECC of an all 0xFF step is all 0xFF, and bit flips in an erased step are
+// corrected back to 0xFF like in any other
+func TestBCHErasedStep(t *testing.T) {
+	random := rand.New(rand.NewSource(2))
+	for _, test := range bchTestCodes {
+		code := testBCHCode(t, test.stepSize, test.strength)
+		erased := bytes.Repeat([]byte{0xFF}, test.stepSize)
+		ecc := code.encode(erased)
+		if !bytes.Equal(ecc, bytes.Repeat([]byte{0xFF}, test.eccBytes)) {
+			t.Errorf("bch%d over %d bytes: ECC of an erased step %x", test.strength, test.stepSize, ecc)
+		}
+
+		data := append([]byte(nil), erased...)
+		if result := code.correct(data, ecc); result != TAGS_ECC_OK {
+			t.Errorf("bch%d over %d bytes: erased step %v", test.strength, test.stepSize, result)
+		}
+		for _, position := range random.Perm(8 * test.stepSize)[:test.strength] {
+			data[position/8] ^= 0x80 >> uint(position%8)
+		}
+		if result := code.correct(data, ecc); result != TAGS_ECC_FIXED || !bytes.Equal(data, erased) {
+			t.Errorf("bch%d over %d bytes: erased step with %d bit flips %v", test.strength, test.stepSize, test.strength, result)
+		}
+	}
+}
+
+func TestBCHCorrection(t *testing.T) {
+	random := rand.New(rand.NewSource(3))
+	for _, test := range bchTestCodes {
+		code := testBCHCode(t, test.stepSize, test.strength)
+		original := make([]byte, test.stepSize)
+		random.Read(original)
+		ecc := code.encode(original)
+
+		for errors := 0; errors <= test.strength; errors++ {
+			data := append([]byte(nil), original...)
+			stored := append([]byte(nil), ecc...)
+			// Flips anywhere in the codeword, data and ECC bits alike
+			for _, position := range random.Perm(8*test.stepSize + code.eccBits)[:errors] {
+				if position < 8*test.stepSize {
+					data[position/8] ^= 0x80 >> uint(position%8)
+				} else {
+					position -= 8 * test.stepSize
+					stored[position/8] ^= 0x80 >> uint(position%8)
+				}
+			}
+
+			want := TAGS_ECC_FIXED
+			if errors == 0 {
+				want = TAGS_ECC_OK
+			}
+			result := code.correct(data, stored)
+			if result != want || !bytes.Equal(data, original) {
+				t.Errorf("bch%d over %d bytes with %d bit errors: %v, data restored %t", test.strength, test.stepSize, errors, result, bytes.Equal(data, original))
+			}
+		}
+	}
+}
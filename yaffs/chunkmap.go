@@ -0,0 +1,83 @@
+package yaffs
+
+import (
+	"encoding/csv"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strconv"
+)
+
+type ChunkClass string
+
+const (
+	CHUNK_HEADER   ChunkClass = "header"
+	CHUNK_DATA     ChunkClass = "data"
+	CHUNK_ORPHAN   ChunkClass = "orphan" // data of an object without any header
+	CHUNK_OBSOLETE ChunkClass = "obsolete"
+	CHUNK_ERASED   ChunkClass = "erased"
+	CHUNK_INVALID  ChunkClass = "invalid"
+	CHUNK_BAD      ChunkClass = "bad"
+)
+
+// Class of every chunk of an image in physical order, with the decoded
+// tags where they are valid
+type ChunkMap struct {
+	Settings *Settings
+	Classes  []ChunkClass
+	Spares   []*Yaffs2Spare
+}
+
+// Chunks per row of the heatmap
+const CHUNK_MAP_WIDTH = 256
+
+var chunkClassColors = map[ChunkClass]color.RGBA{
+	CHUNK_HEADER:   {0x1f, 0x77, 0xb4, 0xff},
+	CHUNK_DATA:     {0x2c, 0xa0, 0x2c, 0xff},
+	CHUNK_ORPHAN:   {0xbc, 0xbd, 0x22, 0xff},
+	CHUNK_OBSOLETE: {0x7f, 0x7f, 0x7f, 0xff},
+	CHUNK_ERASED:   {0xff, 0xff, 0xff, 0xff},
+	CHUNK_INVALID:  {0xff, 0x7f, 0x0e, 0xff},
+	CHUNK_BAD:      {0xd6, 0x27, 0x28, 0xff},
+}
+
+// Write one CSV row per chunk with its image offset, class and tags
+func (m *ChunkMap) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write([]string{"chunk", "offset", "class", "sequence", "object_id", "chunk_id"})
+	if err != nil {
+		return err
+	}
+
+	chunkSize := int64(m.Settings.PageSize + m.Settings.SpareSize)
+	for index, class := range m.Classes {
+		record := []string{strconv.Itoa(index), strconv.FormatInt(int64(index)*chunkSize, 10), string(class), "", "", ""}
+		if spare := m.Spares[index]; spare != nil {
+			record[3] = strconv.FormatUint(uint64(spare.SeqNumber), 10)
+			record[4] = strconv.FormatUint(uint64(spare.ObjectID), 10)
+			record[5] = strconv.FormatUint(uint64(spare.ChunkID), 10)
+		}
+		err = cw.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// Render one pixel per chunk, rows of CHUNK_MAP_WIDTH chunks from the start
+// of the image
+func (m *ChunkMap) WritePNG(w io.Writer) error {
+	rows := (len(m.Classes) + CHUNK_MAP_WIDTH - 1) / CHUNK_MAP_WIDTH
+	if rows == 0 {
+		rows = 1
+	}
+	img := image.NewRGBA(image.Rect(0, 0, CHUNK_MAP_WIDTH, rows))
+	for index, class := range m.Classes {
+		img.SetRGBA(index%CHUNK_MAP_WIDTH, index/CHUNK_MAP_WIDTH, chunkClassColors[class])
+	}
+	return png.Encode(w, img)
+}
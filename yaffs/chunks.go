@@ -0,0 +1,95 @@
+package yaffs
+
+import (
+	"io"
+)
+
+// Pages per erase block assumed when the geometry does not say
+const DEFAULT_PAGES_PER_BLOCK = 64
+
+// One page / spare pair as read from the image, for consumers implementing
+// their own recovery logic on top of the parser
+type RawChunk struct {
+	Index       int   // physical chunk number from the start of the image
+	Offset      int64 // of the page data
+	SpareOffset int64
+	Block       int
+	Page        int // within the block
+
+	Data      []byte
+	SpareData []byte
+	Erased    bool         // page and spare all ones
+	Spare     *Yaffs2Spare // nil if erased or the tags are invalid
+}
+
+// Iterates over every chunk of an image from the current position of the
+// reader. Each chunk gets its own buffers, so chunks may be retained.
+//
+//	it := NewChunkIterator(image, settings)
+//	for it.Next() {
+//		chunk := it.Chunk()
+//	}
+//	if err := it.Err(); err != nil {
+type ChunkIterator struct {
+	r        io.Reader
+	settings *Settings
+	chunk    *RawChunk
+	index    int
+	err      error
+}
+
+func NewChunkIterator(r io.Reader, settings *Settings) *ChunkIterator {
+	return &ChunkIterator{r: r, settings: settings}
+}
+
+// Read the next chunk. Returns false at the end of the image or on error.
+func (it *ChunkIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	settings := it.settings
+	chunk := &RawChunk{
+		Index:     it.index,
+		Data:      getEmptyBuf(settings.PageSize),
+		SpareData: getEmptyBuf(settings.SpareSize),
+	}
+
+	// A trailing partial chunk is no chunk
+	_, err := io.ReadFull(it.r, chunk.Data)
+	if err != nil {
+		return false
+	}
+	_, err = io.ReadFull(it.r, chunk.SpareData)
+	if err != nil {
+		return false
+	}
+
+	chunkSize := int64(settings.PageSize + settings.SpareSize)
+	pagesPerBlock := settings.BlockPages()
+	chunk.Offset = int64(it.index) * chunkSize
+	chunk.SpareOffset = chunk.Offset + int64(settings.PageSize)
+	chunk.Block = it.index / pagesPerBlock
+	chunk.Page = it.index % pagesPerBlock
+	it.index++
+
+	chunk.Erased = checkBlockEmpty(chunk.Data) && checkBlockEmpty(chunk.SpareData)
+	if !chunk.Erased {
+		chunk.Spare, err = settings.parseSpare(chunk.SpareData, settings.SpareSkip)
+		if err != nil {
+			it.err = err
+			return false
+		}
+	}
+
+	it.chunk = chunk
+	return true
+}
+
+func (it *ChunkIterator) Chunk() *RawChunk {
+	return it.chunk
+}
+
+func (it *ChunkIterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,291 @@
+package yaffs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+type DumpFormat int
+
+const (
+	DUMP_FORMAT_UNKNOWN DumpFormat = iota
+	DUMP_FORMAT_RAW_OOB
+	DUMP_FORMAT_INBAND
+	DUMP_FORMAT_DATA_ONLY
+)
+
+func (d DumpFormat) String() string {
+	return []string{"unknown", "raw+OOB", "inband tags", "data-only"}[d]
+}
+
+// Size of yaffs_packed_tags2_tags_only, which is what inband tags store
+// at the end of each chunk
+const INBAND_TAGS_SIZE = 16
+
+type Classification struct {
+	Format      DumpFormat
+	Explanation string
+	Settings    *Settings
+}
+
+// Chunk size assumed when inband tags are given but the image does not
+// start with a header chunk to detect it from
+const DEFAULT_INBAND_CHUNK_SIZE = 2048
+
+// Analyze the start of an image and decide whether it carries tags in an
+// OOB area, inband at the end of each chunk, or not at all. An anchor at a
+// known header restricts the search to raw+OOB geometries matching it,
+// inband skips the search for raw+OOB and data-only dumps.
+func classifyDump(image io.ReadSeeker, options *ImageOptions) (*Classification, error) {
+	anchor := options.Anchor
+	if options.Inband {
+		if options.Anchored {
+			return nil, fmt.Errorf("a header anchor only applies to raw+OOB dumps, not to inband tags")
+		}
+		return classifyInband(image, options)
+	}
+
+	settings, err := detectSettings(image, options)
+	if options.Anchored {
+		if err != nil {
+			return nil, err
+		}
+		return &Classification{
+			Format:      DUMP_FORMAT_RAW_OOB,
+			Explanation: fmt.Sprintf("geometry derived from header at offset %d", anchor),
+			Settings:    settings,
+		}, nil
+	}
+	if err == nil && settings.Yaffs1 {
+		return &Classification{
+			Format:      DUMP_FORMAT_RAW_OOB,
+			Explanation: "valid YAFFS1 tags found in the 16 byte spare after each 512 byte page",
+			Settings:    settings,
+		}, nil
+	}
+	if err == nil {
+		return &Classification{
+			Format:      DUMP_FORMAT_RAW_OOB,
+			Explanation: "valid packed tags found in spare area after each page",
+			Settings:    settings,
+		}, nil
+	}
+
+	settings, err = detectInbandSettings(image, options)
+	if err == nil {
+		return inbandClassification(settings), nil
+	}
+
+	pageSize, byteOrder, err := detectDataOnlyPageSize(image, options)
+	if err == nil {
+		return &Classification{
+			Format:      DUMP_FORMAT_DATA_ONLY,
+			Explanation: fmt.Sprintf("no tags found, but object headers found at %d byte page boundaries; only headers can be recovered", pageSize),
+			Settings: &Settings{
+				PageSize:  pageSize,
+				SpareSize: 0,
+				SpareSkip: 0,
+				ByteOrder: byteOrder,
+			},
+		}, nil
+	}
+
+	return &Classification{
+		Format:      DUMP_FORMAT_UNKNOWN,
+		Explanation: "neither tags nor object headers found at the start of the image",
+	}, nil
+}
+
+func inbandClassification(settings *Settings) *Classification {
+	return &Classification{
+		Format:      DUMP_FORMAT_INBAND,
+		Explanation: fmt.Sprintf("valid packed tags found in the last %d bytes of each %d byte chunk", INBAND_TAGS_SIZE, settings.PageSize+INBAND_TAGS_SIZE),
+		Settings:    settings,
+	}
+}
+
+// Inband tags given by the caller. The chunk size is still detected if the
+// image starts with a header chunk, otherwise the default is assumed.
+func classifyInband(image io.ReadSeeker, options *ImageOptions) (*Classification, error) {
+	settings, err := detectInbandSettings(image, options)
+	if err == nil {
+		return inbandClassification(settings), nil
+	}
+	return &Classification{
+		Format:      DUMP_FORMAT_INBAND,
+		Explanation: fmt.Sprintf("inband tags given, but no header chunk at the start of the image; assuming %d byte chunks without OOB", DEFAULT_INBAND_CHUNK_SIZE),
+		Settings: &Settings{
+			PageSize:     DEFAULT_INBAND_CHUNK_SIZE - INBAND_TAGS_SIZE,
+			SpareSize:    INBAND_TAGS_SIZE,
+			SpareSkip:    0,
+			ByteOrder:    options.defaultByteOrder(),
+			TagByteOrder: options.TagByteOrder,
+		},
+	}, nil
+}
+
+// Inband chunks are laid out as data followed by tags, which is the same
+// byte layout as a page followed by a 16 byte spare. Any real OOB data
+// following the chunk is treated as part of the spare.
+func detectInbandSettings(image io.ReadSeeker, options *ImageOptions) (*Settings, error) {
+
+	var chunkSizes = []int{1024, 2048, 4096, 8192, 16384}
+	var oobSizes = []int{0, 32, 64, 128, 256, 512, 640, 744, 1024, 1280}
+
+	for _, byteOrder := range detectByteOrders(options) {
+		for _, chunkSize := range chunkSizes {
+			for _, oobSize := range oobSizes {
+				settings := &Settings{
+					PageSize:  chunkSize - INBAND_TAGS_SIZE,
+					SpareSize: INBAND_TAGS_SIZE + oobSize,
+					SpareSkip: 0,
+					ByteOrder: byteOrder,
+					Decoding:  options.Decoding,
+				}
+
+				_, err := image.Seek(0, 0)
+				if err != nil {
+					return nil, err
+				}
+
+				var pages [][]byte
+				var spares []*Yaffs2Spare
+				for x := 0; x <= 1; x++ {
+					pageBuf := getEmptyBuf(settings.PageSize)
+					_, err := io.ReadFull(image, pageBuf)
+					if err != nil {
+						break
+					}
+
+					spareBuf := getEmptyBuf(settings.SpareSize)
+					_, err = io.ReadFull(image, spareBuf)
+					if err != nil {
+						break
+					}
+
+					spare, err := settings.parseSpare(spareBuf, 0)
+					if err != nil {
+						return nil, err
+					}
+
+					pages = append(pages, pageBuf)
+					spares = append(spares, spare)
+				}
+
+				// The header decides the byte order, tags of either order
+				// may pass their range checks
+				if len(spares) < 2 || spares[0] == nil || spares[0].ChunkID != 0 || spares[1] == nil ||
+					!LooksLikeHeader(pages[0], byteOrder) {
+					continue
+				}
+
+				return settings, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("no inband tags detected")
+}
+
+// Byte orders tried by detection, the one given if any
+func detectByteOrders(options *ImageOptions) []binary.ByteOrder {
+	return options.detectOptions().withDefaults().ByteOrders
+}
+
+// Without any tags, headers can only be found by their signature. Smaller
+// page sizes also match headers aligned to larger ones, so the largest page
+// size that still finds every header is used.
+func detectDataOnlyPageSize(image io.ReadSeeker, options *ImageOptions) (int, binary.ByteOrder, error) {
+
+	var pageSizes = []int{1024, 2048, 4096, 8192, 16384}
+	const samplePages = 64
+
+	_, err := image.Seek(0, 0)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	sample := make([]byte, pageSizes[0]*samplePages)
+	n, err := io.ReadFull(image, sample)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return 0, nil, err
+	}
+	sample = sample[:n]
+
+	// The byte order the first header parses in
+	var byteOrder binary.ByteOrder
+	for _, order := range detectByteOrders(options) {
+		if len(sample) >= pageSizes[0] && LooksLikeHeader(sample, order) {
+			byteOrder = order
+			break
+		}
+	}
+	if byteOrder == nil {
+		return 0, nil, fmt.Errorf("no object header at start of image")
+	}
+
+	var bestSize, bestCount int
+	for _, pageSize := range pageSizes {
+		count := 0
+		for off := 0; off+pageSize <= len(sample); off += pageSize {
+			if LooksLikeHeader(sample[off:], byteOrder) {
+				count++
+			}
+		}
+		if count >= bestCount {
+			bestSize, bestCount = pageSize, count
+		}
+	}
+
+	return bestSize, byteOrder, nil
+}
+
+// Check the fixed parts of an object header: known type, unused checksum
+// bytes still erased and a printable, terminated name
+func LooksLikeHeader(page []byte, byteOrder binary.ByteOrder) bool {
+	return headerMismatch(page, byteOrder) == ""
+}
+
+// Why a page is no object header, empty if it looks like one
+func headerMismatch(page []byte, byteOrder binary.ByteOrder) string {
+	if mismatch := headerLayoutMismatch(page, byteOrder); mismatch != "" {
+		return mismatch
+	}
+
+	name := page[10 : 10+YAFFS_MAX_NAME_LENGTH+1]
+	end := bytes.IndexByte(name, 0)
+	if end <= 0 || !utf8.Valid(name[:end]) {
+		return "name empty, unterminated or invalid UTF-8"
+	}
+	for _, r := range string(name[:end]) {
+		if r < 0x20 || r == 0x7F {
+			return "name with control characters"
+		}
+	}
+
+	return ""
+}
+
+// Why a page cannot be parsed as an object header. Unlike headerMismatch
+// the name is not judged, so this is what pages with valid header tags
+// are checked against: names in other encodings or with control
+// characters are still real names.
+func headerLayoutMismatch(page []byte, byteOrder binary.ByteOrder) string {
+	if len(page) < 10+YAFFS_MAX_NAME_LENGTH+1 {
+		return "page too short"
+	}
+
+	objectType := ObjectType(byteOrder.Uint32(page[0:4]))
+	if objectType < YAFFS_OBJECT_TYPE_FILE || objectType > YAFFS_OBJECT_TYPE_SPECIAL {
+		return fmt.Sprintf("object type %d out of range", uint32(objectType))
+	}
+
+	if !bytes.Equal(page[8:10], []byte{0xFF, 0xFF}) {
+		return fmt.Sprintf("checksum field %x instead of ffff", page[8:10])
+	}
+
+	return ""
+}
@@ -0,0 +1,135 @@
+package yaffs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Current data chunk per chunk ID of an object, leaving out chunks the
+// scan found obsolete. The map is shared and must not be modified.
+func (r *ScanResult) DataChunks(objectID uint32) map[uint32]*ScanChunk {
+	r.indexOnce.Do(func() {
+		r.dataChunks = make(map[uint32]map[uint32]*ScanChunk)
+		for k := range r.Chunks {
+			chunk := &r.Chunks[k]
+			if chunk.Spare.ChunkID == 0 || chunk.Obsolete {
+				continue
+			}
+			if r.dataChunks[chunk.Spare.ObjectID] == nil {
+				r.dataChunks[chunk.Spare.ObjectID] = make(map[uint32]*ScanChunk)
+			}
+			r.dataChunks[chunk.Spare.ObjectID][chunk.Spare.ChunkID] = chunk
+		}
+	})
+	return r.dataChunks[objectID]
+}
+
+// Largest file YAFFS can address with its chunk IDs
+func (r *ScanResult) maxFileSize() uint64 {
+	return YAFFS_MAX_CHUNK_ID * uint64(r.ChunkDataSize)
+}
+
+// Content size of a file whose header gives size. A size beyond what YAFFS
+// can address comes from a damaged header, reported by the scan, and ends
+// at the highest chunk present instead of zero filling up to 16 EiB.
+func (r *ScanResult) ContentSize(size uint64, chunks map[uint32]*ScanChunk) uint64 {
+	if size <= r.maxFileSize() {
+		return size
+	}
+	var highest uint32
+	for chunkID := range chunks {
+		if chunkID > highest {
+			highest = chunkID
+		}
+	}
+	return uint64(highest) * uint64(r.ChunkDataSize)
+}
+
+// Resolve hardlinks and check the object is a file
+func (r *ScanResult) FileObject(objectID uint32) (uint32, *ObjectHeader, error) {
+	seen := make(map[uint32]bool)
+	for {
+		object, ok := r.Tree.Objects[objectID]
+		if !ok {
+			return 0, nil, fmt.Errorf("unknown object %d", objectID)
+		}
+		header := object.Header
+		switch header.ObjectType {
+		case YAFFS_OBJECT_TYPE_FILE:
+			return objectID, header, nil
+		case YAFFS_OBJECT_TYPE_HARDLINK:
+			if seen[objectID] {
+				return 0, nil, fmt.Errorf("hardlink loop at object %d", objectID)
+			}
+			seen[objectID] = true
+			objectID = uint32(header.EquivID)
+		default:
+			return 0, nil, fmt.Errorf("object %d is a %s, not a file", objectID, header.ObjectType)
+		}
+	}
+}
+
+// Stream the current content of a file in ascending chunk ID order, so
+// large files never have to be held in memory. Missing chunks are written
+// as zeros, up to the size from ContentSize.
+func (r *ScanResult) WriteObject(w io.Writer, objectID uint32) (int64, error) {
+	objectID, header, err := r.FileObject(objectID)
+	if err != nil {
+		return 0, err
+	}
+	return r.WriteChunks(w, header.FileSize(r.ByteOrder), r.DataChunks(objectID))
+}
+
+func (r *ScanResult) WriteChunks(w io.Writer, size uint64, chunks map[uint32]*ScanChunk) (int64, error) {
+	size = r.ContentSize(size, chunks)
+	zeros := make([]byte, r.ChunkDataSize)
+
+	var written int64
+	for chunkID := uint32(1); uint64(written) < size; chunkID++ {
+		n := uint64(r.ChunkDataSize)
+		if remaining := size - uint64(written); n > remaining {
+			n = remaining
+		}
+
+		data, err := r.chunkData(chunks[chunkID], zeros[:n])
+		if err != nil {
+			return written, err
+		}
+
+		m, err := w.Write(data)
+		written += int64(m)
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// The first len(zeros) bytes of a chunk, zeros where it is missing
+func (r *ScanResult) chunkData(chunk *ScanChunk, zeros []byte) ([]byte, error) {
+	if chunk == nil {
+		return zeros, nil
+	}
+	page, err := r.ChunkPage(chunk)
+	if err != nil {
+		return nil, err
+	}
+	n := uint64(len(zeros))
+	// Bytes beyond NumberBytes were never written
+	if valid := uint64(chunk.Spare.NumberBytes); valid < n {
+		return append(append([]byte(nil), page[:valid]...), zeros[valid:]...), nil
+	}
+	return page[:n], nil
+}
+
+// Assemble the current content of a file in memory
+func (r *ScanResult) ObjectContent(objectID uint32) ([]byte, error) {
+	var buf bytes.Buffer
+	_, err := r.WriteObject(&buf, objectID)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,76 @@
+package yaffs
+
+// Deleted or unlinked file with the chunks left of its content
+type DeletedFile struct {
+	ObjectID uint32
+	Path     string // below the deleted or unlinked pseudo directory
+	Original string // path before deletion, best effort
+	Size     uint64 // largest size of any header version
+	Chunks   map[uint32]*ScanChunk
+	Missing  int // chunks within the size not found
+	Stale    int // chunks taken from superseded versions
+}
+
+// Collect the content of every deleted file. Deletion may write a header
+// with a truncated size and leaves chunks obsolete, so the largest size of
+// any header version is assumed and each chunk ID is filled from its newest
+// chunk, current or not. The original path is that of the newest header
+// version still outside the pseudo directories.
+func (r *ScanResult) DeletedFiles() []*DeletedFile {
+	tree := r.Tree
+	sizes := make(map[uint32]uint64)
+	originals := make(map[uint32]*ListEntry)
+	for k := range r.Entries {
+		entry := &r.Entries[k]
+		if size := entry.Header.FileSize(r.ByteOrder); size > sizes[entry.ObjectID] {
+			sizes[entry.ObjectID] = size
+		}
+		if entry.Deleted() {
+			continue
+		}
+		if previous, ok := originals[entry.ObjectID]; !ok || entry.SeqNumber >= previous.SeqNumber {
+			originals[entry.ObjectID] = entry
+		}
+	}
+
+	files := make(map[uint32]*DeletedFile)
+	var order []*DeletedFile
+	for _, id := range tree.SortedIDs() {
+		object := tree.Objects[id]
+		if object.Header.ObjectType != YAFFS_OBJECT_TYPE_FILE || !tree.Deleted(id) {
+			continue
+		}
+		file := &DeletedFile{ObjectID: id, Path: tree.Path(id), Original: tree.Path(id), Size: sizes[id],
+			Chunks: make(map[uint32]*ScanChunk)}
+		if entry, ok := originals[id]; ok {
+			file.Original = tree.HeaderPath(entry.Header)
+		}
+		files[id] = file
+		order = append(order, file)
+	}
+
+	chunkSize := uint64(r.ChunkDataSize)
+	for k := range r.Chunks {
+		chunk := &r.Chunks[k]
+		file, ok := files[chunk.Spare.ObjectID]
+		chunkID := chunk.Spare.ChunkID
+		if !ok || chunkID == 0 || uint64(chunkID-1)*chunkSize >= file.Size {
+			continue
+		}
+		if newest, ok := file.Chunks[chunkID]; ok && newest.Spare.SeqNumber > chunk.Spare.SeqNumber {
+			continue
+		}
+		file.Chunks[chunkID] = chunk
+	}
+
+	for _, file := range order {
+		file.Size = r.ContentSize(file.Size, file.Chunks)
+		file.Missing = int((file.Size+chunkSize-1)/chunkSize) - len(file.Chunks)
+		for _, chunk := range file.Chunks {
+			if chunk.Obsolete {
+				file.Stale++
+			}
+		}
+	}
+	return order
+}
@@ -0,0 +1,33 @@
+// Package yaffs reads YAFFS2 file system images, such as mkyaffs2image
+// files and raw NAND dumps with or without spare areas.
+//
+// OpenImage opens a local file, an s3:// object or an archive:// member,
+// applies input transforms and detects the page and spare geometry.
+// ScanImage reads the chunks of an opened image and returns a ScanResult
+// with every object header found, the reconstructed Tree and the chunks
+// holding file content:
+//
+//	img, err := yaffs.OpenImage("userdata.img", &yaffs.ImageOptions{})
+//	if err != nil {
+//		return err
+//	}
+//	defer img.Close()
+//
+//	result, err := yaffs.ScanImage(img.Reader, img.Settings, img.Size, nil)
+//	if err != nil {
+//		return err
+//	}
+//	for _, id := range result.Tree.SortedIDs() {
+//		fmt.Println(result.Tree.Path(id))
+//	}
+//
+// WriteObject copies the content of a file, FileVersions lists the older
+// versions still on flash. Scans report progress, object headers and
+// anomalies through an EventHandler.
+//
+// What vendor builds change, such as the spare decoder, the spare policy
+// and the accepted object IDs, is given as the Decoding of ImageOptions,
+// with the reference behavior as zero value. Diagnostics go to the Logger
+// of ImageOptions and are discarded if it is nil, cancelling its Context
+// stops a running scan.
+package yaffs
@@ -0,0 +1,436 @@
+package yaffs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+type ECCKind string
+
+const (
+	ECC_NONE    ECCKind = "none"
+	ECC_HAMMING ECCKind = "hamming"
+	ECC_BCH     ECCKind = "bch"
+)
+
+// Bytes of the Hamming code over each 256 byte step of page data
+const HAMMING_STEP_SIZE = 256
+
+// Written chunks compared when detecting the ECC scheme
+const ECC_SAMPLE_CHUNKS = 256
+
+// Bytes of yaffs_packed_tags2 following the tags, holding the ECC over them
+const PACKED_TAGS2_ECC_SIZE = 12
+
+// ECC scheme of the controller, as far as it can be told from the spares
+type ECCScheme struct {
+	Kind         ECCKind
+	StepSize     int  // page data bytes per step
+	BytesPerStep int  // ECC bytes per step
+	Offset       int  // of the first ECC byte in the spare
+	Swapped      bool // Hamming line parity bytes in SmartMedia order
+	Strength     int  // correctable bits per step
+	Estimated    bool // BCH guessed from varying spare bytes, not verified
+	Explanation  string
+}
+
+func (s *ECCScheme) String() string {
+	switch s.Kind {
+	case ECC_HAMMING:
+		return fmt.Sprintf("hamming, %d bytes per %d byte step at spare offset %d: %s", s.BytesPerStep, s.StepSize, s.Offset, s.Explanation)
+	case ECC_BCH:
+		kind := "bch"
+		if s.Estimated {
+			kind = "bch (estimated)"
+		}
+		return fmt.Sprintf("%s, %d bytes per %d byte step correcting %d bits, at spare offset %d: %s", kind, s.BytesPerStep, s.StepSize, s.Strength, s.Offset, s.Explanation)
+	}
+	return fmt.Sprintf("%s: %s", s.Kind, s.Explanation)
+}
+
+// Parity bits of every byte value in the layout of the Hamming column
+// parity: bit 0 is the parity of the whole byte, bits 2 to 7 the column
+// parities CP0 to CP5
+var columnParityTable = func() [256]byte {
+	var table [256]byte
+	masks := []byte{0x55, 0xAA, 0x33, 0xCC, 0x0F, 0xF0}
+	for b := 0; b < 256; b++ {
+		v := byte(bits.OnesCount8(uint8(b)) & 1)
+		for k, mask := range masks {
+			v |= byte(bits.OnesCount8(uint8(b)&mask)&1) << uint(k+2)
+		}
+		table[b] = v
+	}
+	return table
+}()
+
+// Hamming code of one 256 byte step as computed by yaffs_ecc_calc and the
+// Linux software ECC
+func hammingECC(data []byte) [3]byte {
+	var colParity, lineParity, lineParityPrime byte
+	for i := 0; i < HAMMING_STEP_SIZE; i++ {
+		b := columnParityTable[data[i]]
+		colParity ^= b
+		if b&0x01 != 0 {
+			lineParity ^= byte(i)
+			lineParityPrime ^= ^byte(i)
+		}
+	}
+
+	var ecc [3]byte
+	ecc[2] = ^colParity | 0x03
+	var high, low byte
+	for bit := 7; bit >= 0; bit-- {
+		mask := byte(1) << uint(bit)
+		pair := byte(0)
+		if lineParity&mask != 0 {
+			pair |= 2
+		}
+		if lineParityPrime&mask != 0 {
+			pair |= 1
+		}
+		if bit >= 4 {
+			high |= pair << uint(2*(bit-4))
+		} else {
+			low |= pair << uint(2*bit)
+		}
+	}
+	ecc[1], ecc[0] = ^high, ^low
+	return ecc
+}
+
+// Spare bytes holding the tags of the configured decoder, which must not
+// be mistaken for ECC
+func tagBytes(settings *Settings) map[int]bool {
+	tags := map[int]bool{0: true, 1: true} // bad block marker
+	for _, b := range settings.BadBlockMarker {
+		tags[b] = true
+	}
+	switch decoder := settings.spareDecoder().(type) {
+	case PackedTags2Decoder:
+		for k := 0; k < binary.Size(Yaffs2SpareRaw{})+PACKED_TAGS2_ECC_SIZE; k++ {
+			tags[settings.SpareSkip+k] = true
+		}
+	case *SpareLayout:
+		for _, offset := range []int{decoder.SeqNumberOffset, decoder.ObjectIDOffset, decoder.ChunkIDOffset, decoder.NumberBytesOffset} {
+			for k := 0; k < 4; k++ {
+				tags[offset+k] = true
+			}
+		}
+	case *MappedSpareDecoder:
+		for _, offset := range decoder.Map {
+			tags[offset] = true
+		}
+	}
+	return tags
+}
+
+// Infer the ECC scheme from up to sampleChunks written chunks. Hamming
+// codes and the BCH codes of the Linux software ECC are verified against
+// the page data; other codes are recognized as spare bytes outside the
+// tags that change from page to page, and estimated as BCH over 512 byte
+// steps.
+func detectECC(r io.Reader, settings *Settings, sampleChunks int) (*ECCScheme, error) {
+	var pages, spares [][]byte
+	it := NewChunkIterator(r, settings)
+	for len(pages) < sampleChunks && it.Next() {
+		chunk := it.Chunk()
+		if chunk.Erased || chunk.Spare == nil {
+			continue
+		}
+		pages = append(pages, chunk.Data)
+		spares = append(spares, chunk.SpareData)
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	if len(pages) == 0 {
+		return &ECCScheme{Kind: ECC_NONE, Explanation: "no written chunks to sample"}, nil
+	}
+
+	// Codes of every sampled page, compared at every spare offset
+	steps := settings.PageSize / HAMMING_STEP_SIZE
+	codes := make([][]byte, len(pages))
+	for k, page := range pages {
+		for step := 0; step < steps; step++ {
+			ecc := hammingECC(page[step*HAMMING_STEP_SIZE:])
+			codes[k] = append(codes[k], ecc[:]...)
+		}
+	}
+
+	for _, swapped := range []bool{false, true} {
+		for offset := 0; offset+3*steps <= settings.SpareSize; offset++ {
+			matches := 0
+			for k := range pages {
+				if hammingMatches(codes[k], spares[k][offset:], swapped) {
+					matches++
+				}
+			}
+			// Allow for pages with bit errors
+			if matches*10 >= len(pages)*9 {
+				return &ECCScheme{
+					Kind:         ECC_HAMMING,
+					StepSize:     HAMMING_STEP_SIZE,
+					BytesPerStep: 3,
+					Offset:       offset,
+					Swapped:      swapped,
+					Strength:     1,
+					Explanation:  fmt.Sprintf("codes match the page data in %d of %d sampled chunks", matches, len(pages)),
+				}, nil
+			}
+		}
+	}
+
+	if scheme := detectBCH(pages, spares, settings); scheme != nil {
+		return scheme, nil
+	}
+
+	tags := tagBytes(settings)
+	first, varying := -1, 0
+	for offset := 0; offset < settings.SpareSize; offset++ {
+		if tags[offset] {
+			continue
+		}
+		for k := 1; k < len(spares); k++ {
+			if spares[k][offset] != spares[0][offset] {
+				if first < 0 {
+					first = offset
+				}
+				varying++
+				break
+			}
+		}
+	}
+
+	if varying == 0 {
+		return &ECCScheme{Kind: ECC_NONE, Explanation: fmt.Sprintf("no spare bytes outside the tags vary across %d sampled chunks", len(pages))}, nil
+	}
+
+	// BCH over GF(2^13) needs 13 bits per correctable bit of a 512 byte step
+	const stepSize = 512
+	stepCount := settings.PageSize / stepSize
+	if stepCount == 0 {
+		stepCount = 1
+	}
+	perStep := varying / stepCount
+	return &ECCScheme{
+		Kind:         ECC_BCH,
+		StepSize:     stepSize,
+		BytesPerStep: perStep,
+		Offset:       first,
+		Strength:     perStep * 8 / 13,
+		Estimated:    true,
+		Explanation:  fmt.Sprintf("%d spare bytes outside the tags vary across %d sampled chunks and match no Hamming code", varying, len(pages)),
+	}, nil
+}
+
+// Strengths of the Linux software BCH ECC tried against the page data
+var bchStrengths = []int{4, 8, 16}
+
+// Offset and strength at which the BCH codes of the sampled pages match
+// their spares, nil if none does
+func detectBCH(pages, spares [][]byte, settings *Settings) *ECCScheme {
+	const stepSize = 512
+	steps := settings.PageSize / stepSize
+	if steps == 0 {
+		return nil
+	}
+	for _, strength := range bchStrengths {
+		bytesPerStep := bchECCBytes(stepSize, strength)
+		if steps*bytesPerStep > settings.SpareSize {
+			continue
+		}
+		code, err := bchCodeFor(stepSize, strength)
+		if err != nil {
+			continue
+		}
+		codes := make([][]byte, len(pages))
+		for k, page := range pages {
+			for step := 0; step < steps; step++ {
+				codes[k] = append(codes[k], code.encode(page[step*stepSize:(step+1)*stepSize])...)
+			}
+		}
+		for offset := 0; offset+len(codes[0]) <= settings.SpareSize; offset++ {
+			matches := 0
+			for k := range pages {
+				if bytes.Equal(codes[k], spares[k][offset:offset+len(codes[k])]) {
+					matches++
+				}
+			}
+			// Allow for pages with bit errors
+			if matches*10 >= len(pages)*9 {
+				return &ECCScheme{
+					Kind:         ECC_BCH,
+					StepSize:     stepSize,
+					BytesPerStep: bytesPerStep,
+					Offset:       offset,
+					Strength:     strength,
+					Explanation:  fmt.Sprintf("codes match the page data in %d of %d sampled chunks", matches, len(pages)),
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Parse an ECC scheme given as KIND[/STEP]@OFFSET, with KIND none,
+// hamming, hamming-sm (SmartMedia byte order) or bchN correcting N bits
+// per step like the Linux software BCH ECC, e.g. bch8@32 or
+// bch16/1024@2. Steps default to 256 bytes for Hamming and 512 for BCH.
+func ParseECCScheme(s string) (*ECCScheme, error) {
+	if s == string(ECC_NONE) {
+		return &ECCScheme{Kind: ECC_NONE, Explanation: "given"}, nil
+	}
+	at := strings.LastIndex(s, "@")
+	if at < 0 {
+		return nil, fmt.Errorf("invalid ECC scheme %q, expected KIND[/STEP]@OFFSET", s)
+	}
+	offset, err := strconv.Atoi(s[at+1:])
+	if err != nil || offset < 0 {
+		return nil, fmt.Errorf("invalid ECC offset in %q", s)
+	}
+	kind, step := s[:at], 0
+	if slash := strings.Index(kind, "/"); slash >= 0 {
+		step, err = strconv.Atoi(kind[slash+1:])
+		if err != nil || step <= 0 {
+			return nil, fmt.Errorf("invalid ECC step size in %q", s)
+		}
+		kind = kind[:slash]
+	}
+
+	scheme := &ECCScheme{Offset: offset, Explanation: "given"}
+	switch {
+	case kind == "hamming" || kind == "hamming-sm":
+		if step != 0 && step != HAMMING_STEP_SIZE {
+			return nil, fmt.Errorf("Hamming codes cover %d byte steps, not %d", HAMMING_STEP_SIZE, step)
+		}
+		scheme.Kind, scheme.StepSize, scheme.BytesPerStep, scheme.Strength = ECC_HAMMING, HAMMING_STEP_SIZE, 3, 1
+		scheme.Swapped = kind == "hamming-sm"
+
+	case strings.HasPrefix(kind, "bch"):
+		strength, err := strconv.Atoi(strings.TrimPrefix(kind, "bch"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid BCH strength in %q, expected e.g. bch8", s)
+		}
+		if step == 0 {
+			step = 512
+		}
+		if _, err := bchCodeFor(step, strength); err != nil {
+			return nil, err
+		}
+		scheme.Kind, scheme.StepSize, scheme.Strength = ECC_BCH, step, strength
+		scheme.BytesPerStep = bchECCBytes(step, strength)
+
+	default:
+		return nil, fmt.Errorf("unknown ECC scheme %q, expected none, hamming, hamming-sm or bchN", kind)
+	}
+	return scheme, nil
+}
+
+// SmartMedia controllers store the two line parity bytes swapped
+func hammingMatches(codes, spare []byte, swapped bool) bool {
+	for k := 0; k < len(codes); k += 3 {
+		first, second := codes[k], codes[k+1]
+		if swapped {
+			first, second = second, first
+		}
+		if spare[k] != first || spare[k+1] != second || spare[k+2] != codes[k+2] {
+			return false
+		}
+	}
+	return true
+}
+
+// Whether pages can be checked against the scheme: Hamming codes and BCH
+// codes verified or given, not estimated ones
+func (s *ECCScheme) correctable() bool {
+	return s != nil && (s.Kind == ECC_HAMMING || s.Kind == ECC_BCH && !s.Estimated)
+}
+
+// Verify every step of a page against the codes in its spare and correct
+// bit errors in place, up to the strength of the code. Pages of schemes
+// that cannot be checked are returned as read.
+func (s *ECCScheme) correctPage(page, spare []byte) TagsECCResult {
+	if !s.correctable() || len(page) < s.StepSize {
+		return TAGS_ECC_NONE
+	}
+	var code *bchCode
+	if s.Kind == ECC_BCH {
+		var err error
+		code, err = bchCodeFor(s.StepSize, s.Strength)
+		if err != nil {
+			return TAGS_ECC_NONE
+		}
+	}
+
+	result := TAGS_ECC_OK
+	for step := 0; (step+1)*s.StepSize <= len(page); step++ {
+		offset := s.Offset + step*s.BytesPerStep
+		if offset+s.BytesPerStep > len(spare) {
+			break
+		}
+		data := page[step*s.StepSize : (step+1)*s.StepSize]
+
+		var stepResult TagsECCResult
+		if code != nil {
+			stepResult = code.correct(data, spare[offset:offset+s.BytesPerStep])
+		} else {
+			stored := [3]byte{spare[offset], spare[offset+1], spare[offset+2]}
+			if s.Swapped {
+				stored[0], stored[1] = stored[1], stored[0]
+			}
+			stepResult = correctHamming(data, stored)
+		}
+		switch stepResult {
+		case TAGS_ECC_FIXED:
+			if result == TAGS_ECC_OK {
+				result = TAGS_ECC_FIXED
+			}
+		case TAGS_ECC_UNFIXED:
+			result = TAGS_ECC_UNFIXED
+		}
+	}
+	return result
+}
+
+// yaffs_ecc_correct: verify one 256 byte step against its stored code and
+// flip a single wrong data bit in place
+func correctHamming(data []byte, stored [3]byte) TagsECCResult {
+	computed := hammingECC(data)
+	d0, d1, d2 := stored[0]^computed[0], stored[1]^computed[1], stored[2]^computed[2]
+
+	if d0|d1|d2 == 0 {
+		return TAGS_ECC_OK
+	}
+
+	// A data bit error flips one parity of every pair
+	if (d0^d0>>1)&0x55 == 0x55 && (d1^d1>>1)&0x55 == 0x55 && (d2^d2>>1)&0x54 == 0x54 {
+		var index, bit byte
+		for k, mask := range []byte{0x80, 0x20, 0x08, 0x02} {
+			if d1&mask != 0 {
+				index |= 0x80 >> uint(k)
+			}
+			if d0&mask != 0 {
+				index |= 0x08 >> uint(k)
+			}
+		}
+		for k, mask := range []byte{0x80, 0x20, 0x08} {
+			if d2&mask != 0 {
+				bit |= 0x04 >> uint(k)
+			}
+		}
+		data[index] ^= 1 << bit
+		return TAGS_ECC_FIXED
+	}
+
+	// A single bit error in the code itself leaves the data intact
+	if bits.OnesCount8(d0)+bits.OnesCount8(d1)+bits.OnesCount8(d2) == 1 {
+		return TAGS_ECC_FIXED
+	}
+	return TAGS_ECC_UNFIXED
+}
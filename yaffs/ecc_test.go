@@ -0,0 +1,148 @@
+package yaffs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// Spare of a data chunk with packed tags and their ECC
+func testSpare(t *testing.T) []byte {
+	t.Helper()
+	img := newTestImage(testSettings())
+	img.chunk(300, 5, 2048, nil)
+	return img.bytes()[2048:2112]
+}
+
+var tagsECCTests = []struct {
+	name   string
+	damage func(spare []byte)
+	result TagsECCResult
+	valid  bool
+}{
+	{"intact", func(spare []byte) {}, TAGS_ECC_OK, true},
+	{"object ID bit", func(spare []byte) { spare[5] ^= 0x10 }, TAGS_ECC_FIXED, true},
+	{"chunk ID bit", func(spare []byte) { spare[8] ^= 0x01 }, TAGS_ECC_FIXED, true},
+	{"ECC bit", func(spare []byte) { spare[16] ^= 0x04 }, TAGS_ECC_FIXED, true},
+	{"two tag bits", func(spare []byte) { spare[5] ^= 0x10; spare[9] ^= 0x02 }, TAGS_ECC_UNFIXED, false},
+	{"erased ECC", func(spare []byte) { copy(spare[16:28], bytes.Repeat([]byte{0xFF}, 12)) }, TAGS_ECC_NONE, true},
+}
+
+func TestPackedTagsECC(t *testing.T) {
+	settings := testSettings()
+	for _, test := range tagsECCTests {
+		spare := testSpare(t)
+		test.damage(spare)
+
+		tags, err := PackedTags2Decoder{}.DecodeSpare(spare, 0, binary.LittleEndian)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if tags.TagsECC != test.result {
+			t.Errorf("%s: tags ECC %s, want %s", test.name, tags.TagsECC, test.result)
+		}
+		if test.result != TAGS_ECC_UNFIXED && (tags.ObjectID != 300 || tags.ChunkID != 5 || tags.NumberBytes != 2048) {
+			t.Errorf("%s: object %d chunk %d with %d bytes, want object 300 chunk 5 with 2048 bytes", test.name, tags.ObjectID, tags.ChunkID, tags.NumberBytes)
+		}
+
+		trusted, err := settings.parseSpare(spare, 0)
+		if err != nil || (trusted != nil) != test.valid {
+			t.Errorf("%s: trusted tags %v, %v, want valid %v", test.name, trusted, err, test.valid)
+		}
+	}
+}
+
+func TestCorrectHamming(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), HAMMING_STEP_SIZE/16)
+	stored := hammingECC(data)
+
+	for _, test := range []struct {
+		name   string
+		flips  [][2]int // byte and bit
+		result TagsECCResult
+	}{
+		{"intact", nil, TAGS_ECC_OK},
+		{"first bit", [][2]int{{0, 0}}, TAGS_ECC_FIXED},
+		{"last bit", [][2]int{{HAMMING_STEP_SIZE - 1, 7}}, TAGS_ECC_FIXED},
+		{"middle bit", [][2]int{{100, 3}}, TAGS_ECC_FIXED},
+		{"two bits", [][2]int{{100, 3}, {7, 1}}, TAGS_ECC_UNFIXED},
+	} {
+		damaged := append([]byte(nil), data...)
+		for _, flip := range test.flips {
+			damaged[flip[0]] ^= 1 << uint(flip[1])
+		}
+		result := correctHamming(damaged, stored)
+		if result != test.result {
+			t.Errorf("%s: %s, want %s", test.name, result, test.result)
+		}
+		if result != TAGS_ECC_UNFIXED && !bytes.Equal(damaged, data) {
+			t.Errorf("%s: data not restored", test.name)
+		}
+	}
+}
+
+// Hamming codes over 256 byte steps after the tags ECC, as written by
+// controllers using the Linux software ECC
+var testHammingScheme = &ECCScheme{Kind: ECC_HAMMING, StepSize: HAMMING_STEP_SIZE, BytesPerStep: 3, Offset: 40}
+
+var pageECCTests = []struct {
+	name          string
+	flips         []int // bit offsets into the page of the data chunk
+	corrected     int
+	uncorrectable int
+}{
+	{"intact", nil, 0, 0},
+	{"one bit", []int{1000}, 1, 0},
+	{"one bit in two steps", []int{8, 8 * 300}, 1, 0},
+	{"two bits in one step", []int{8, 9}, 0, 1},
+}
+
+func TestPageECC(t *testing.T) {
+	content := bytes.Repeat([]byte("page data "), 204)
+	for _, test := range pageECCTests {
+		settings := testSettings()
+		img := newTestImage(settings)
+		img.ecc = testHammingScheme
+		first := img.file(300, YAFFS_OBJECTID_ROOT, "a", content)
+		data := img.bytes()
+
+		// Detection verifies the codes against intact pages
+		detected, err := detectECC(bytes.NewReader(data), settings, ECC_SAMPLE_CHUNKS)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if detected.Kind != ECC_HAMMING || detected.Offset != testHammingScheme.Offset || detected.StepSize != HAMMING_STEP_SIZE {
+			t.Errorf("%s: detected %s, want %s", test.name, detected, testHammingScheme)
+		}
+
+		offset := first * (settings.PageSize + settings.SpareSize)
+		for _, bit := range test.flips {
+			data[offset+bit/8] ^= 1 << uint(bit%8)
+		}
+
+		settings.ECC = testHammingScheme
+		result, _ := scanTestImage(t, data, settings)
+		if result.CorrectedPages != test.corrected || result.UncorrectablePages != test.uncorrectable {
+			t.Errorf("%s: %d pages corrected, %d uncorrectable, want %d and %d",
+				test.name, result.CorrectedPages, result.UncorrectablePages, test.corrected, test.uncorrectable)
+		}
+		read, err := result.ObjectContent(300)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if restored := bytes.Equal(read, content); restored != (test.uncorrectable == 0) {
+			t.Errorf("%s: content restored %v, want %v", test.name, restored, test.uncorrectable == 0)
+		}
+
+		// Index-only scans correct the data pages when reading them
+		settings.IndexOnly = true
+		result, _ = scanTestImage(t, data, settings)
+		read, err = result.ObjectContent(300)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if restored := bytes.Equal(read, content); restored != (test.uncorrectable == 0) {
+			t.Errorf("%s: index-only content restored %v, want %v", test.name, restored, test.uncorrectable == 0)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package yaffs
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+// Header found by a scan, with the path of its object if the tree could be
+// reconstructed
+type ListEntry struct {
+	ObjectID uint32 // zero if unknown, e.g. in data-only dumps
+	Header   *ObjectHeader
+	Path     string // empty if the tree could not be reconstructed
+	Tags     []string
+
+	SeqNumber uint32 // of the block holding the header, zero if unknown
+	Obsolete  bool   // replaced by a newer header of the object
+}
+
+// Deleted and unlinked objects are reparented to pseudo directories
+func (e *ListEntry) Deleted() bool {
+	return e.Header.ParentObjectID == YAFFS_OBJECTID_DELETED ||
+		e.Header.ParentObjectID == YAFFS_OBJECTID_UNLINKED
+}
+
+// Depth of the entry below the root, one for top level objects. Entries
+// without a reconstructed path count as top level.
+func (e *ListEntry) Depth() int {
+	if e.Path == "" {
+		return 1
+	}
+	return strings.Count(e.Path, "/")
+}
+
+// Path of the entry, or its name if the tree could not be reconstructed
+func (e *ListEntry) Name() string {
+	if e.Path != "" {
+		return e.Path
+	}
+	return CToGoString(e.Header.Name[:])
+}
+
+// Object metadata reported in object events and handed to decision scripts
+type ObjectMetadata struct {
+	ObjectID   uint32 `json:"object_id"`
+	ParentID   uint32 `json:"parent_id"`
+	Path       string `json:"path,omitempty"`
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Mode       uint32 `json:"mode"`
+	UID        uint32 `json:"uid"`
+	GID        uint32 `json:"gid"`
+	Size       uint64 `json:"size"`
+	AccessTime uint32 `json:"atime"`
+	ModTime    uint32 `json:"mtime"`
+	CreateTime uint32 `json:"ctime"`
+	Alias      string `json:"alias,omitempty"`
+	Deleted    bool   `json:"deleted"`
+}
+
+// Metadata of the entry as reported in object events and to decision
+// scripts
+func (e *ListEntry) Metadata(byteOrder binary.ByteOrder) ObjectMetadata {
+	header := e.Header
+	return ObjectMetadata{
+		ObjectID:   e.ObjectID,
+		ParentID:   header.ParentObjectID,
+		Path:       e.Path,
+		Name:       CToGoString(header.Name[:]),
+		Type:       header.ObjectType.String(),
+		Mode:       header.Mode,
+		UID:        header.UID,
+		GID:        header.GID,
+		Size:       header.FileSize(byteOrder),
+		AccessTime: header.AccessTime,
+		ModTime:    header.ModTime,
+		CreateTime: header.CreateTime,
+		Alias:      CToGoString(header.Alias[:]),
+		Deleted:    e.Deleted(),
+	}
+}
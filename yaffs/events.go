@@ -0,0 +1,84 @@
+package yaffs
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+)
+
+var discardLogger = log.New(io.Discard, "", 0)
+
+// The logger given, or one discarding everything if nil
+func orDiscard(logger *log.Logger) *log.Logger {
+	if logger == nil {
+		return discardLogger
+	}
+	return logger
+}
+
+type EventType string
+
+const (
+	EVENT_SCAN_PROGRESS EventType = "scan_progress"
+	EVENT_OBJECT        EventType = "object"
+	EVENT_ANOMALY       EventType = "anomaly"
+	EVENT_SCAN_DONE     EventType = "scan_done"
+)
+
+type AnomalyKind string
+
+const (
+	ANOMALY_INVALID_SPARE     AnomalyKind = "invalid_spare"
+	ANOMALY_CHECKSUM_MISMATCH AnomalyKind = "checksum_mismatch"
+	ANOMALY_ECC_FAILURE       AnomalyKind = "ecc_failure"
+	ANOMALY_ECC_CORRECTED     AnomalyKind = "ecc_corrected"
+	ANOMALY_ORPHAN            AnomalyKind = "orphan"
+	ANOMALY_CONFLICT          AnomalyKind = "conflict"
+	ANOMALY_BLOCK_SEQUENCE    AnomalyKind = "block_sequence"
+	ANOMALY_AMBIGUOUS_TAGS    AnomalyKind = "ambiguous_tags"
+	ANOMALY_FILE_SIZE         AnomalyKind = "file_size"
+)
+
+// Emitted while scanning so frontends can show live status. Offsets are
+// relative to the transformed image, anomalies not tied to a chunk have
+// chunk and offset -1.
+type Event struct {
+	Type     EventType       `json:"type"`
+	Chunk    int             `json:"chunk"`
+	Offset   int64           `json:"offset"`
+	Total    int64           `json:"total,omitempty"`
+	Object   *ObjectMetadata `json:"object,omitempty"`
+	Anomaly  AnomalyKind     `json:"anomaly,omitempty"`
+	ObjectID uint32          `json:"object_id,omitempty"`
+	Message  string          `json:"message,omitempty"`
+}
+
+type EventHandler func(Event)
+
+// Chunks between two progress events
+const PROGRESS_INTERVAL = 1024
+
+// Fan an event out to several handlers
+func MultiHandler(handlers ...EventHandler) EventHandler {
+	return func(e Event) {
+		for _, handler := range handlers {
+			handler(e)
+		}
+	}
+}
+
+// Handler writing one JSON object per line, e.g. to a pipe read by a GUI.
+// Write failures are logged to logger unless nil.
+func JSONEventWriter(w io.Writer, logger *log.Logger) EventHandler {
+	var mu sync.Mutex
+	encoder := json.NewEncoder(w)
+	return func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		err := encoder.Encode(e)
+		if err != nil {
+			orDiscard(logger).Println("Writing event failed:", err)
+		}
+	}
+}
@@ -0,0 +1,595 @@
+package yaffs
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Read-only view of the live tree of a scan, for fs.WalkDir, http.FS,
+// testing/fstest and other users of io/fs. Names are the tree paths without
+// the leading slash, lost+found is listed once it holds objects. Hardlinks
+// read as their target, symlinks and special files read as empty.
+type FileSystem struct {
+	result   *ScanResult
+	options  FileSystemOptions
+	paths    map[string]uint32
+	children map[uint32][]uint32
+	versions map[uint32]int
+
+	mutex   sync.Mutex
+	history map[uint32][]namedVersion // by file object, replayed on first use
+
+	deleted     map[string]*DeletedFile // by path below .deleted
+	deletedDirs map[string][]string     // names in each directory below .deleted
+	dirInodes   map[string]uint64
+}
+
+type FileSystemOptions struct {
+	// Serve the on-flash versions of every file as .versions/PATH/SEQUENCE,
+	// with a directory for each file below a copy of the live directories,
+	// a counter appended to versions sharing a sequence number
+	Versions bool
+	// Serve the files DeletedFiles recovers below .deleted at their
+	// original paths, a ~ID suffix telling apart files of one path
+	Deleted bool
+}
+
+// Names of the virtual directories holding the versions of every file and
+// the recovered deleted files
+const (
+	VERSIONS_DIRECTORY = ".versions"
+	DELETED_DIRECTORY  = ".deleted"
+)
+
+type namedVersion struct {
+	name    string
+	version *FileVersion
+}
+
+// Object of the live tree, its directory below .versions or one of the
+// versions of a file there, or a path below .deleted
+type node struct {
+	id          uint32
+	versions    bool // .versions for the root
+	version     *FileVersion
+	deletedPath string
+}
+
+// YAFFS metadata of an object, returned by Sys of its fs.FileInfo
+type ObjectInfo struct {
+	ObjectID uint32
+	// Unique within the file system: the object ID, or above 2^32 for the
+	// virtual directories and versions
+	Inode       uint64
+	SeqNumber   uint32 // of the block holding the current header
+	Header      *ObjectHeader
+	Versions    int  // header versions found by the scan
+	Version     int  // of a file below .versions, 1 for the oldest, 0 for the current state
+	Synthesized bool // header lost, known from tags or as a parent only
+	Deleted     bool // in the deleted or unlinked pseudo directory
+
+	// Where the content of a file is read from, by chunk ID
+	Chunks []ChunkLocation
+}
+
+type ChunkLocation struct {
+	ChunkID   uint32
+	Block     int // erase block, assuming the default block size unless given
+	Page      int // within the block
+	Offset    int64
+	SeqNumber uint32
+	Obsolete  bool // superseded, as read for versions and deleted files
+}
+
+func NewFileSystem(result *ScanResult, options FileSystemOptions) *FileSystem {
+	fsys := &FileSystem{
+		result:   result,
+		options:  options,
+		paths:    map[string]uint32{".": YAFFS_OBJECTID_ROOT},
+		children: make(map[uint32][]uint32),
+		versions: make(map[uint32]int),
+		history:  make(map[uint32][]namedVersion),
+	}
+	for _, entry := range result.Entries {
+		fsys.versions[entry.ObjectID]++
+	}
+
+	children := result.Tree.Children()
+	if len(children[YAFFS_OBJECTID_LOSTNFOUND]) > 0 {
+		children[YAFFS_OBJECTID_ROOT] = append(children[YAFFS_OBJECTID_ROOT], YAFFS_OBJECTID_LOSTNFOUND)
+	}
+
+	// Breadth first from the root, so every directory is indexed before
+	// its children. Of several objects with one name the first is kept.
+	queue := []uint32{YAFFS_OBJECTID_ROOT}
+	for len(queue) > 0 {
+		dir := queue[0]
+		queue = queue[1:]
+		dirPath := fsys.pathOf(dir)
+
+		for _, id := range children[dir] {
+			name := fsys.name(id)
+			if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+				continue
+			}
+			objectPath := path.Join(dirPath, name)
+			if _, ok := fsys.paths[objectPath]; ok {
+				continue
+			}
+			fsys.paths[objectPath] = id
+			fsys.children[dir] = append(fsys.children[dir], id)
+			if fsys.header(id).ObjectType == YAFFS_OBJECT_TYPE_DIRECTORY {
+				queue = append(queue, id)
+			}
+		}
+		sort.Slice(fsys.children[dir], func(i, j int) bool {
+			return fsys.name(fsys.children[dir][i]) < fsys.name(fsys.children[dir][j])
+		})
+	}
+
+	if options.Deleted {
+		fsys.indexDeleted()
+	}
+	return fsys
+}
+
+// Place every recovered deleted file below .deleted, creating the
+// directories of its original path
+func (fsys *FileSystem) indexDeleted() {
+	fsys.deleted = make(map[string]*DeletedFile)
+	fsys.deletedDirs = map[string][]string{DELETED_DIRECTORY: nil}
+
+	for _, file := range fsys.result.DeletedFiles() {
+		name := path.Join(DELETED_DIRECTORY, strings.TrimPrefix(file.Original, "/"))
+		conflict := !fs.ValidPath(name)
+		for dir := path.Dir(name); !conflict && dir != "."; dir = path.Dir(dir) {
+			_, conflict = fsys.deleted[dir]
+		}
+		if conflict {
+			name = path.Join(DELETED_DIRECTORY, fmt.Sprintf("object-%d", file.ObjectID))
+		}
+		_, isFile := fsys.deleted[name]
+		_, isDir := fsys.deletedDirs[name]
+		if isFile || isDir {
+			name = fmt.Sprintf("%s~%d", name, file.ObjectID)
+		}
+		fsys.deleted[name] = file
+
+		for dir, child := path.Dir(name), path.Base(name); dir != "."; dir, child = path.Dir(dir), path.Base(dir) {
+			_, known := fsys.deletedDirs[dir]
+			fsys.deletedDirs[dir] = append(fsys.deletedDirs[dir], child)
+			if known {
+				break
+			}
+		}
+	}
+
+	fsys.dirInodes = make(map[string]uint64)
+	for dir, names := range fsys.deletedDirs {
+		sort.Strings(names)
+		fsys.dirInodes[dir] = 1<<63 | uint64(len(fsys.dirInodes))
+	}
+}
+
+// Root and lost+found have no header on flash
+func (fsys *FileSystem) header(id uint32) *ObjectHeader {
+	if object, ok := fsys.result.Tree.Objects[id]; ok {
+		return object.Header
+	}
+	return SynthesizeHeader(YAFFS_OBJECT_TYPE_DIRECTORY, YAFFS_OBJECTID_ROOT, PseudoDirectoryNames[id])
+}
+
+func (fsys *FileSystem) name(id uint32) string {
+	if name, ok := PseudoDirectoryNames[id]; ok {
+		return name
+	}
+	return CToGoString(fsys.header(id).Name[:])
+}
+
+func (fsys *FileSystem) pathOf(id uint32) string {
+	if id == YAFFS_OBJECTID_ROOT {
+		return "."
+	}
+	return strings.TrimPrefix(fsys.result.Tree.Path(id), "/")
+}
+
+func (fsys *FileSystem) lookup(op, name string) (node, error) {
+	if !fs.ValidPath(name) {
+		return node{}, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if id, ok := fsys.paths[name]; ok {
+		return node{id: id}, nil
+	}
+	_, isFile := fsys.deleted[name]
+	_, isDir := fsys.deletedDirs[name]
+	if isFile || isDir {
+		return node{deletedPath: name}, nil
+	}
+	if fsys.options.Versions {
+		if n, ok := fsys.lookupVersion(name); ok {
+			return n, nil
+		}
+	}
+	return node{}, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
+
+// .versions, .versions/PATH or .versions/PATH/SEQUENCE
+func (fsys *FileSystem) lookupVersion(name string) (node, bool) {
+	if name == VERSIONS_DIRECTORY {
+		return node{id: YAFFS_OBJECTID_ROOT, versions: true}, true
+	}
+	objectPath := strings.TrimPrefix(name, VERSIONS_DIRECTORY+"/")
+	if objectPath == name {
+		return node{}, false
+	}
+	if id, ok := fsys.paths[objectPath]; ok && fsys.hasVersions(id) {
+		return node{id: id, versions: true}, true
+	}
+	id, ok := fsys.paths[path.Dir(objectPath)]
+	if !ok || !fsys.isFile(id) {
+		return node{}, false
+	}
+	for _, named := range fsys.fileVersions(id) {
+		if named.name == path.Base(objectPath) {
+			return node{id: id, version: named.version}, true
+		}
+	}
+	return node{}, false
+}
+
+// Directories and files, hardlinks included, are directories below .versions
+func (fsys *FileSystem) hasVersions(id uint32) bool {
+	return fsys.header(id).ObjectType == YAFFS_OBJECT_TYPE_DIRECTORY || fsys.isFile(id)
+}
+
+func (fsys *FileSystem) isFile(id uint32) bool {
+	_, _, err := fsys.result.FileObject(id)
+	return err == nil
+}
+
+// Versions of a file named by sequence number, replaying the log once
+func (fsys *FileSystem) fileVersions(id uint32) []namedVersion {
+	fsys.mutex.Lock()
+	defer fsys.mutex.Unlock()
+	if named, ok := fsys.history[id]; ok {
+		return named
+	}
+
+	versions, _ := fsys.result.FileVersions(id)
+	named := make([]namedVersion, 0, len(versions))
+	used := make(map[string]int)
+	for _, version := range versions {
+		name := fmt.Sprintf("%07d", version.Chunk.Spare.SeqNumber)
+		used[name]++
+		if used[name] > 1 {
+			name = fmt.Sprintf("%s.%d", name, used[name])
+		}
+		named = append(named, namedVersion{name, version})
+	}
+	fsys.history[id] = named
+	return named
+}
+
+func (fsys *FileSystem) Open(name string) (fs.File, error) {
+	n, err := fsys.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return &file{fsys: fsys, node: n, info: fsys.stat(n, path.Base(name))}, nil
+}
+
+func (fsys *FileSystem) Stat(name string) (fs.FileInfo, error) {
+	n, err := fsys.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fsys.stat(n, path.Base(name)), nil
+}
+
+func (fsys *FileSystem) ReadDir(name string) ([]fs.DirEntry, error) {
+	n, err := fsys.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !fsys.stat(n, name).IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	return fsys.entries(n), nil
+}
+
+func (fsys *FileSystem) entries(dir node) []fs.DirEntry {
+	var entries []fs.DirEntry
+	if dir.versions && fsys.isFile(dir.id) {
+		for _, named := range fsys.fileVersions(dir.id) {
+			entries = append(entries, fs.FileInfoToDirEntry(fsys.stat(node{id: dir.id, version: named.version}, named.name)))
+		}
+		return entries
+	}
+	if dir.versions {
+		for _, id := range fsys.children[dir.id] {
+			if fsys.hasVersions(id) {
+				entries = append(entries, fs.FileInfoToDirEntry(fsys.stat(node{id: id, versions: true}, fsys.name(id))))
+			}
+		}
+		return entries
+	}
+	if dir.deletedPath != "" {
+		for _, name := range fsys.deletedDirs[dir.deletedPath] {
+			entries = append(entries, fs.FileInfoToDirEntry(fsys.stat(node{deletedPath: path.Join(dir.deletedPath, name)}, name)))
+		}
+		return entries
+	}
+
+	for _, id := range fsys.children[dir.id] {
+		entries = append(entries, fs.FileInfoToDirEntry(fsys.stat(node{id: id}, fsys.name(id))))
+	}
+	if dir.id != YAFFS_OBJECTID_ROOT {
+		return entries
+	}
+	// Live objects of the same name hide the virtual directories
+	if _, shadowed := fsys.paths[DELETED_DIRECTORY]; fsys.options.Deleted && !shadowed {
+		entries = append(entries, fs.FileInfoToDirEntry(fsys.stat(node{deletedPath: DELETED_DIRECTORY}, DELETED_DIRECTORY)))
+	}
+	if _, shadowed := fsys.paths[VERSIONS_DIRECTORY]; fsys.options.Versions && !shadowed {
+		entries = append(entries, fs.FileInfoToDirEntry(fsys.stat(node{id: YAFFS_OBJECTID_ROOT, versions: true}, VERSIONS_DIRECTORY)))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries
+}
+
+func (fsys *FileSystem) stat(n node, name string) *fileInfo {
+	id := n.id
+	switch {
+	case n.versions:
+		// Read-only directory with the times of the file or directory
+		header := *fsys.header(id)
+		header.ObjectType, header.Mode = YAFFS_OBJECT_TYPE_DIRECTORY, 040555
+		return &fileInfo{name: name, header: &header,
+			sys: &ObjectInfo{ObjectID: id, Inode: 1<<32 | uint64(id), Versions: fsys.versions[id], Synthesized: true}}
+	case n.version != nil:
+		header := n.version.Header
+		return &fileInfo{name: name, header: header, size: int64(fsys.result.ContentSize(header.FileSize(fsys.result.ByteOrder), n.version.Chunks)),
+			sys: &ObjectInfo{ObjectID: id, Inode: uint64(n.version.Number+1)<<32 | uint64(id), SeqNumber: n.version.Chunk.Spare.SeqNumber,
+				Header: header, Versions: fsys.versions[id], Version: n.version.Number, Chunks: fsys.locations(n.version.Chunks)}}
+	case n.deletedPath != "":
+		file, ok := fsys.deleted[n.deletedPath]
+		if !ok {
+			header := SynthesizeHeader(YAFFS_OBJECT_TYPE_DIRECTORY, YAFFS_OBJECTID_DELETED, name)
+			header.Mode = 040555
+			return &fileInfo{name: name, header: header, sys: &ObjectInfo{Inode: fsys.dirInodes[n.deletedPath], Synthesized: true}}
+		}
+		id = file.ObjectID
+		object := fsys.result.Tree.Objects[id]
+		return &fileInfo{name: name, header: object.Header, size: int64(file.Size),
+			sys: &ObjectInfo{ObjectID: id, Inode: uint64(id), SeqNumber: object.SeqNumber, Header: object.Header,
+				Versions: fsys.versions[id], Synthesized: object.Synthesized, Deleted: true, Chunks: fsys.locations(file.Chunks)}}
+	}
+
+	info := &fileInfo{name: name, header: fsys.header(id), sys: &ObjectInfo{ObjectID: id, Inode: uint64(id), Versions: fsys.versions[id]}}
+	if object, ok := fsys.result.Tree.Objects[id]; ok {
+		info.sys.SeqNumber = object.SeqNumber
+		info.sys.Header = object.Header
+		info.sys.Synthesized = object.Synthesized
+	} else {
+		info.sys.Synthesized = true
+	}
+
+	// Hardlinks take type, mode, times and size from their target
+	switch info.header.ObjectType {
+	case YAFFS_OBJECT_TYPE_FILE, YAFFS_OBJECT_TYPE_HARDLINK:
+		if target, header, err := fsys.result.FileObject(id); err == nil {
+			info.header = header
+			chunks := fsys.result.DataChunks(target)
+			info.size = int64(fsys.result.ContentSize(header.FileSize(fsys.result.ByteOrder), chunks))
+			info.sys.Chunks = fsys.locations(chunks)
+		}
+	}
+	return info
+}
+
+type fileInfo struct {
+	name   string
+	header *ObjectHeader
+	size   int64
+	sys    *ObjectInfo
+}
+
+func (i *fileInfo) Name() string       { return i.name }
+func (i *fileInfo) Size() int64        { return i.size }
+func (i *fileInfo) ModTime() time.Time { return time.Unix(int64(i.header.ModTime), 0) }
+func (i *fileInfo) IsDir() bool        { return i.Mode().IsDir() }
+func (i *fileInfo) Sys() interface{}   { return i.sys }
+
+func (i *fileInfo) Mode() fs.FileMode {
+	mode := fs.FileMode(i.header.Mode & 0777)
+	if i.header.Mode&04000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if i.header.Mode&02000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if i.header.Mode&01000 != 0 {
+		mode |= fs.ModeSticky
+	}
+
+	switch i.header.ObjectType {
+	case YAFFS_OBJECT_TYPE_DIRECTORY:
+		return mode | fs.ModeDir
+	case YAFFS_OBJECT_TYPE_SYMLINK:
+		return mode | fs.ModeSymlink
+	case YAFFS_OBJECT_TYPE_SPECIAL:
+		switch i.header.Mode & 0170000 {
+		case 0020000:
+			return mode | fs.ModeDevice | fs.ModeCharDevice
+		case 0060000:
+			return mode | fs.ModeDevice
+		case 0010000:
+			return mode | fs.ModeNamedPipe
+		case 0140000:
+			return mode | fs.ModeSocket
+		}
+		return mode | fs.ModeIrregular
+	case YAFFS_OBJECT_TYPE_FILE, YAFFS_OBJECT_TYPE_HARDLINK:
+		return mode
+	}
+	return mode | fs.ModeIrregular
+}
+
+// Size and data chunks of a file
+func (fsys *FileSystem) content(n node) (uint64, map[uint32]*ScanChunk, error) {
+	if n.version != nil {
+		return fsys.result.ContentSize(n.version.Header.FileSize(fsys.result.ByteOrder), n.version.Chunks), n.version.Chunks, nil
+	}
+	if file, ok := fsys.deleted[n.deletedPath]; ok {
+		return file.Size, file.Chunks, nil
+	}
+	id, header, err := fsys.result.FileObject(n.id)
+	if err != nil {
+		return 0, nil, err
+	}
+	chunks := fsys.result.DataChunks(id)
+	return fsys.result.ContentSize(header.FileSize(fsys.result.ByteOrder), chunks), chunks, nil
+}
+
+func (fsys *FileSystem) locations(chunks map[uint32]*ScanChunk) []ChunkLocation {
+	locations := make([]ChunkLocation, 0, len(chunks))
+	for chunkID, chunk := range chunks {
+		locations = append(locations, ChunkLocation{
+			ChunkID:   chunkID,
+			Block:     chunk.Index / fsys.result.PagesPerBlock,
+			Page:      chunk.Index % fsys.result.PagesPerBlock,
+			Offset:    chunk.Offset,
+			SeqNumber: chunk.Spare.SeqNumber,
+			Obsolete:  chunk.Obsolete,
+		})
+	}
+	sort.Slice(locations, func(i, j int) bool { return locations[i].ChunkID < locations[j].ChunkID })
+	return locations
+}
+
+// Content is read chunk by chunk as needed, entries on the first ReadDir
+type file struct {
+	fsys    *FileSystem
+	node    node
+	info    *fileInfo
+	loaded  bool
+	size    int64
+	chunks  map[uint32]*ScanChunk
+	offset  int64
+	entries []fs.DirEntry
+	listed  bool
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// Look up size and data chunks, leaving symlinks and special files empty
+func (f *file) load() error {
+	if f.loaded || !f.info.Mode().IsRegular() {
+		return nil
+	}
+	size, chunks, err := f.fsys.content(f.node)
+	if err != nil {
+		return &fs.PathError{Op: "read", Path: f.info.name, Err: err}
+	}
+	f.size, f.chunks, f.loaded = int64(size), chunks, true
+	return nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.info.IsDir() {
+		return 0, &fs.PathError{Op: "read", Path: f.info.name, Err: fs.ErrInvalid}
+	}
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if err := f.load(); err != nil {
+		return 0, err
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: f.info.name, Err: fs.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.info.name, Err: fs.ErrInvalid}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// Read the chunks covering p, by chunk index from the offset
+func (f *file) ReadAt(p []byte, offset int64) (int, error) {
+	if err := f.load(); err != nil {
+		return 0, err
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.info.name, Err: fs.ErrInvalid}
+	}
+	chunkSize := int64(f.fsys.result.ChunkDataSize)
+	zeros := make([]byte, chunkSize)
+
+	read := 0
+	for read < len(p) && offset < f.size {
+		n := chunkSize
+		if remaining := f.size - offset/chunkSize*chunkSize; n > remaining {
+			n = remaining
+		}
+		data, err := f.fsys.result.chunkData(f.chunks[uint32(offset/chunkSize)+1], zeros[:n])
+		if err != nil {
+			return read, &fs.PathError{Op: "read", Path: f.info.name, Err: err}
+		}
+		m := copy(p[read:], data[offset%chunkSize:])
+		read += m
+		offset += int64(m)
+	}
+	if read < len(p) {
+		return read, io.EOF
+	}
+	return read, nil
+}
+
+func (f *file) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !f.info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: f.info.name, Err: fs.ErrInvalid}
+	}
+	if !f.listed {
+		f.entries = f.fsys.entries(f.node)
+		f.listed = true
+	}
+	if n <= 0 {
+		entries := f.entries
+		f.entries = nil
+		return entries, nil
+	}
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(f.entries) {
+		n = len(f.entries)
+	}
+	entries := f.entries[:n]
+	f.entries = f.entries[n:]
+	return entries, nil
+}
+
+func (f *file) Close() error {
+	return nil
+}
@@ -0,0 +1,136 @@
+package yaffs
+
+import (
+	"bytes"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+// A directory with a file written twice and a hardlink to it, a two chunk
+// file, a symlink and a deleted file
+func fsTestResult(t *testing.T) *ScanResult {
+	t.Helper()
+	img := newTestImage(testSettings())
+	img.header(299, YAFFS_OBJECTID_ROOT, YAFFS_OBJECT_TYPE_DIRECTORY, "dir", 0, "", 0)
+	img.file(300, 299, "hello.txt", []byte("hello world\n"))
+	img.file(301, YAFFS_OBJECTID_ROOT, "big.bin", bytes.Repeat([]byte("0123456789abcdef"), 200))
+	img.header(302, YAFFS_OBJECTID_ROOT, YAFFS_OBJECT_TYPE_SYMLINK, "link", 0, "/dir/hello.txt", 0)
+	img.header(303, 299, YAFFS_OBJECT_TYPE_HARDLINK, "hard", 0, "", 300)
+	img.file(300, 299, "hello.txt", []byte("hello world, again\n"))
+	img.file(304, YAFFS_OBJECTID_ROOT, "gone.txt", []byte("deleted content\n"))
+	img.header(304, YAFFS_OBJECTID_DELETED, YAFFS_OBJECT_TYPE_FILE, "gone.txt", 0, "", 0)
+
+	result, _ := scanTestImage(t, img.bytes(), img.settings)
+	return result
+}
+
+var fileSystemTests = []struct {
+	name    string
+	options FileSystemOptions
+	files   map[string]string
+	missing []string
+}{
+	{
+		name: "live tree",
+		files: map[string]string{
+			"dir/hello.txt": "hello world, again\n",
+			"dir/hard":      "hello world, again\n",
+			"big.bin":       string(bytes.Repeat([]byte("0123456789abcdef"), 200)),
+			"link":          "",
+		},
+		missing: []string{VERSIONS_DIRECTORY, DELETED_DIRECTORY, "gone.txt", "dir/hello.txt/" + VERSIONS_DIRECTORY},
+	},
+	{
+		name:    "versions",
+		options: FileSystemOptions{Versions: true},
+		files: map[string]string{
+			"dir/hello.txt":                     "hello world, again\n",
+			".versions/dir/hello.txt/0004096":   "hello world\n",
+			".versions/dir/hello.txt/0004096.2": "hello world, again\n",
+			".versions/dir/hard/0004096.2":      "hello world, again\n",
+		},
+		missing: []string{DELETED_DIRECTORY, ".versions/link", ".versions/dir/hello.txt/0004097"},
+	},
+	{
+		name:    "deleted files",
+		options: FileSystemOptions{Deleted: true},
+		files: map[string]string{
+			"big.bin":           string(bytes.Repeat([]byte("0123456789abcdef"), 200)),
+			".deleted/gone.txt": "deleted content\n",
+		},
+		missing: []string{VERSIONS_DIRECTORY, "gone.txt"},
+	},
+}
+
+func TestFileSystem(t *testing.T) {
+	result := fsTestResult(t)
+	for _, test := range fileSystemTests {
+		fsys := NewFileSystem(result, test.options)
+
+		var expected []string
+		for name, want := range test.files {
+			expected = append(expected, name)
+			content, err := fs.ReadFile(fsys, name)
+			if err != nil {
+				t.Errorf("%s: %v", test.name, err)
+				continue
+			}
+			if string(content) != want {
+				t.Errorf("%s: %s holds %q, want %q", test.name, name, content, want)
+			}
+		}
+		if err := fstest.TestFS(fsys, expected...); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+
+		for _, name := range test.missing {
+			if _, err := fs.Stat(fsys, name); err == nil {
+				t.Errorf("%s: %s exists", test.name, name)
+			}
+		}
+	}
+}
+
+func TestFileSystemInfo(t *testing.T) {
+	fsys := NewFileSystem(fsTestResult(t), FileSystemOptions{})
+
+	for _, test := range []struct {
+		name     string
+		mode     fs.FileMode
+		objectID uint32
+		versions int
+	}{
+		{".", fs.ModeDir | 0755, YAFFS_OBJECTID_ROOT, 0},
+		{"dir", fs.ModeDir | 0755, 299, 1},
+		{"dir/hello.txt", 0644, 300, 2},
+		{"dir/hard", 0644, 303, 1},
+		{"link", fs.ModeSymlink | 0777, 302, 1},
+	} {
+		info, err := fs.Stat(fsys, test.name)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		object := info.Sys().(*ObjectInfo)
+		if info.Mode() != test.mode || object.ObjectID != test.objectID || object.Versions != test.versions {
+			t.Errorf("%s: mode %s, object %d with %d versions, want mode %s, object %d with %d versions",
+				test.name, info.Mode(), object.ObjectID, object.Versions, test.mode, test.objectID, test.versions)
+		}
+	}
+
+	info, err := fs.Stat(fsys, "big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := info.Sys().(*ObjectInfo).Chunks
+	if len(chunks) != 2 || chunks[0].ChunkID != 1 || chunks[1].ChunkID != 2 || chunks[1].Offset <= chunks[0].Offset {
+		t.Errorf("big.bin chunks %+v, want chunks 1 and 2 in order", chunks)
+	}
+
+	for _, name := range []string{"/dir", "dir/", "../dir", "dir/../big.bin"} {
+		if _, err := fsys.Open(name); err == nil {
+			t.Errorf("%s opened", name)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package yaffs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// Turns the data of a header chunk into an object header, for vendor forks
+// with a different header layout
+type HeaderDecoder interface {
+	DecodeHeader(page []byte, byteOrder binary.ByteOrder) (*ObjectHeader, error)
+}
+
+// Default decoder for struct yaffs_obj_hdr as laid out by the reference
+// implementation
+type StandardHeaderDecoder struct{}
+
+func (StandardHeaderDecoder) DecodeHeader(page []byte, byteOrder binary.ByteOrder) (*ObjectHeader, error) {
+	header := &ObjectHeader{}
+	err := binary.Read(bytes.NewReader(page), byteOrder, header)
+	if err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// Decoders selectable with -header-decoder
+var headerDecoders = map[string]HeaderDecoder{
+	"yaffs2": StandardHeaderDecoder{},
+}
+
+// Make a vendor specific decoder selectable by name
+func RegisterHeaderDecoder(name string, decoder HeaderDecoder) {
+	headerDecoders[name] = decoder
+}
+
+func HeaderDecoderNames() []string {
+	var names []string
+	for name := range headerDecoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func LookupHeaderDecoder(name string) (HeaderDecoder, error) {
+	decoder, ok := headerDecoders[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown header decoder %q, expected one of %v", name, HeaderDecoderNames())
+	}
+	return decoder, nil
+}
+
+// Decode an object header in the yaffs_obj_hdr layout, see
+// Settings.ParseHeader for the decoder of an image
+func ParseHeader(page []byte, byteOrder binary.ByteOrder) (*ObjectHeader, error) {
+	return StandardHeaderDecoder{}.DecodeHeader(page, byteOrder)
+}
@@ -0,0 +1,256 @@
+package yaffs
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// An opened, transformed and classified image
+type Image struct {
+	Path           string
+	Source         io.Closer
+	Reader         io.ReadSeeker
+	Size           int64
+	Classification *Classification
+	Settings       *Settings
+	ECC            *ECCScheme // nil for dumps without spares
+}
+
+// Options of OpenImage, the zero value detects everything
+type ImageOptions struct {
+	Transforms *TransformPipeline
+	Settings   *Settings  // fixed geometry skipping detection, e.g. from a TSK config
+	Inband     bool       // tags at the end of each chunk, skipping raw+OOB detection
+	OOBPath    string     // spares in a separate file, paired page by page with the data
+	ECC        *ECCScheme // ECC scheme and layout skipping detection
+	Prefetch   int64      // bytes to read ahead in the background, zero to disable
+
+	// Only consider geometries placing a header chunk at Anchor
+	Anchored bool
+	Anchor   int64
+
+	ByteOrder     binary.ByteOrder // of headers and tags, nil to detect it
+	TagByteOrder  binary.ByteOrder // of tags differing from the data, nil to detect it
+	PagesPerBlock int              // pages per erase block, enabling the block sequence check
+	IndexOnly     bool             // see Settings.IndexOnly
+	Decoding      Decoding         // of detected and given geometries alike
+	Workers       int              // geometries evaluated concurrently, one per CPU if zero
+	S3CacheDir    string           // cache of s3:// ranges, the user cache directory if empty
+
+	Context context.Context // copied to the settings, stopping the scan once done
+	Logger  *log.Logger     // diagnostics of opening and scanning, discarded if nil
+}
+
+func (o *ImageOptions) logger() *log.Logger {
+	return orDiscard(o.Logger)
+}
+
+// Detection restricted to the byte orders given
+func (o *ImageOptions) detectOptions() DetectOptions {
+	options := DetectOptions{PagesPerBlock: o.PagesPerBlock, Workers: o.Workers, Decoding: o.Decoding}
+	if o.ByteOrder != nil {
+		options.ByteOrders = []binary.ByteOrder{o.ByteOrder}
+	}
+	if o.TagByteOrder != nil {
+		options.TagByteOrders = []binary.ByteOrder{o.TagByteOrder}
+	}
+	return options
+}
+
+// Byte order of geometries not detected, little endian unless given
+func (o *ImageOptions) defaultByteOrder() binary.ByteOrder {
+	if o.ByteOrder != nil {
+		return o.ByteOrder
+	}
+	return binary.LittleEndian
+}
+
+// Open a local file, an object given as s3://bucket/key or an archive
+// member given as archive://ARCHIVE/MEMBER
+func openSource(path string, options *ImageOptions) (io.ReaderAt, int64, io.Closer, error) {
+	if strings.HasPrefix(path, "s3://") {
+		object, err := openS3Object(path, options.S3CacheDir)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		return object, object.Size, object, nil
+	}
+	if strings.HasPrefix(path, "archive://") {
+		member, err := openArchiveMember(path)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		return member, member.Size, member, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, nil, err
+	}
+	return file, info.Size(), file, nil
+}
+
+// Closes the sources of an image read from several files
+type multiCloser []io.Closer
+
+func (m multiCloser) Close() error {
+	var first error
+	for _, c := range m {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func OpenImage(path string, options *ImageOptions) (*Image, error) {
+	reader, size, source, err := openSource(path, options)
+	if err != nil {
+		return nil, err
+	}
+
+	// Transforms and detection see the data and OOB files as one raw+OOB
+	// dump. The geometry is decided while pairing them.
+	settings, explanation := options.Settings, "geometry given by configuration"
+	if options.OOBPath != "" {
+		oob, oobSize, oobSource, err := openSource(options.OOBPath, options)
+		if err != nil {
+			source.Close()
+			return nil, err
+		}
+		source = multiCloser{source, oobSource}
+
+		var paired *interleavedReader
+		paired, settings, err = pairOOB(reader, size, oob, oobSize, options)
+		if err != nil {
+			source.Close()
+			return nil, err
+		}
+		reader, size = paired, size/paired.pageSize*(paired.pageSize+paired.spareSize)
+		if options.Settings == nil {
+			explanation = fmt.Sprintf("page data and spares read from separate files, %d byte pages with %d byte spares", settings.PageSize, settings.SpareSize)
+		}
+	}
+
+	if options.Prefetch > 0 {
+		reader = newPrefetchReader(reader, size, options.Prefetch)
+	}
+
+	reader, size, plugins, err := options.Transforms.Apply(reader, size, options.Logger)
+	if err != nil {
+		source.Close()
+		return nil, err
+	}
+	source = multiCloser{plugins, source}
+
+	image := &Image{
+		Path:   path,
+		Source: source,
+		Reader: io.NewSectionReader(reader, 0, size),
+		Size:   size,
+	}
+
+	if settings != nil {
+		image.Classification = &Classification{
+			Format:      DUMP_FORMAT_RAW_OOB,
+			Explanation: explanation,
+			Settings:    settings,
+		}
+	} else {
+		image.Classification, err = classifyDump(image.Reader, options)
+		if err != nil {
+			source.Close()
+			return nil, err
+		}
+	}
+	options.logger().Printf("Dump format %s: %s", image.Classification.Format, image.Classification.Explanation)
+
+	image.Settings = image.Classification.Settings
+	if image.Settings == nil {
+		options.logger().Println("Using default settings, auto-detect failed")
+		image.Settings = &Settings{
+			PageSize:     2048,
+			SpareSize:    64,
+			SpareSkip:    0,
+			ByteOrder:    options.defaultByteOrder(),
+			TagByteOrder: options.TagByteOrder,
+		}
+	} else {
+		// Configurations leave the byte orders to the options
+		if image.Settings.ByteOrder == nil {
+			image.Settings.ByteOrder = options.defaultByteOrder()
+		}
+		if settings != nil && options.TagByteOrder != nil {
+			image.Settings.TagByteOrder = options.TagByteOrder
+		}
+		options.logger().Println("Using settings:", image.Settings)
+	}
+	if options.PagesPerBlock > 0 {
+		image.Settings.PagesPerBlock = options.PagesPerBlock
+	}
+	image.Settings.IndexOnly = options.IndexOnly
+	image.Settings.Decoding = options.Decoding
+	image.Settings.Context, image.Settings.Logger = options.Context, options.Logger
+
+	if options.ECC != nil {
+		ecc := options.ECC
+		if ecc.Kind != ECC_NONE {
+			steps := image.Settings.PageSize / ecc.StepSize
+			if ecc.Offset+steps*ecc.BytesPerStep > image.Settings.SpareSize {
+				source.Close()
+				return nil, fmt.Errorf("ECC of %d steps with %d bytes each at offset %d does not fit the %d byte spare",
+					steps, ecc.BytesPerStep, ecc.Offset, image.Settings.SpareSize)
+			}
+		}
+		image.ECC = options.ECC
+		options.logger().Println("ECC:", image.ECC)
+		image.Settings.ECC = image.ECC
+	} else if image.Settings.SpareSize > 0 {
+		_, err = image.Reader.Seek(0, 0)
+		if err == nil {
+			image.ECC, err = detectECC(image.Reader, image.Settings, ECC_SAMPLE_CHUNKS)
+		}
+		if err != nil {
+			source.Close()
+			return nil, err
+		}
+		options.logger().Println("ECC:", image.ECC)
+		image.Settings.ECC = image.ECC
+	}
+
+	_, err = image.Reader.Seek(0, 0)
+	if err != nil {
+		source.Close()
+		return nil, err
+	}
+
+	return image, nil
+}
+
+func (i *Image) Close() error {
+	return i.Source.Close()
+}
+
+// Summary of what detection found, without scanning
+func (i *Image) WriteInfo(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	fmt.Fprintf(tw, "Image:\t%s\n", i.Path)
+	fmt.Fprintf(tw, "Size:\t%d\n", i.Size)
+	fmt.Fprintf(tw, "Format:\t%s, %s\n", i.Classification.Format, i.Classification.Explanation)
+	fmt.Fprintf(tw, "Geometry:\t%s\n", i.Settings)
+	if i.ECC != nil {
+		fmt.Fprintf(tw, "ECC:\t%s\n", i.ECC)
+	}
+	return tw.Flush()
+}
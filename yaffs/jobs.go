@@ -0,0 +1,17 @@
+package yaffs
+
+import "runtime"
+
+// Worker counts per stage, zero meaning one per CPU
+type JobLimits struct {
+	Detect  int // geometry evaluation during detection
+	Hash    int // content hashing for mtree output and dump series
+	Extract int // files written by extraction
+}
+
+func WorkerCount(n int) int {
+	if n <= 0 {
+		return runtime.NumCPU()
+	}
+	return n
+}
@@ -0,0 +1,100 @@
+package yaffs
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// JSON description of an exotic controller's page geometry and spare
+// layout. Byte positions use the range syntax of -spare-map, e.g.
+//
+//	{
+//	  "page_size": 2048,
+//	  "spare_size": 64,
+//	  "pages_per_block": 64,
+//	  "byte_order": "little",
+//	  "tags": "2-4,14-15,18-20,30-31,34-36,46-47,50-52",
+//	  "ecc": "8-13,24-29,40-45,56-61",
+//	  "ecc_scheme": "",
+//	  "bad_block_marker": "0-1"
+//	}
+type layoutConfig struct {
+	PageSize       int    `json:"page_size"`
+	SpareSize      int    `json:"spare_size"`
+	PagesPerBlock  int    `json:"pages_per_block"`
+	ByteOrder      string `json:"byte_order"`
+	Tags           string `json:"tags"`
+	ECC            string `json:"ecc"`
+	ECCScheme      string `json:"ecc_scheme"`
+	BadBlockMarker string `json:"bad_block_marker"`
+}
+
+// Read a layout config into fixed settings and a profile to apply
+func ReadLayoutConfig(path string) (*Settings, *OOBProfile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	var config layoutConfig
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if config.PageSize <= 0 || config.SpareSize <= 0 {
+		return nil, nil, fmt.Errorf("%s: page_size and spare_size are required", path)
+	}
+	if config.Tags == "" {
+		return nil, nil, fmt.Errorf("%s: tags are required", path)
+	}
+
+	profile := &OOBProfile{Name: path, Description: "layout config", SpareSize: config.SpareSize, ECCScheme: config.ECCScheme}
+	for _, field := range []struct {
+		name  string
+		value string
+		m     *SpareMap
+	}{
+		{"tags", config.Tags, &profile.Tags},
+		{"ecc", config.ECC, &profile.ECC},
+		{"bad_block_marker", config.BadBlockMarker, &profile.BadBlockMarker},
+	} {
+		if field.value == "" {
+			continue
+		}
+		*field.m, err = ParseSpareMap(field.value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %s: %v", path, field.name, err)
+		}
+		if field.m.Size() > config.SpareSize {
+			return nil, nil, fmt.Errorf("%s: %s exceed spare size %d", path, field.name, config.SpareSize)
+		}
+	}
+	if tagsSize := binary.Size(Yaffs2SpareRaw{}); len(profile.Tags) < tagsSize {
+		return nil, nil, fmt.Errorf("%s: %d tag bytes, the packed tags need %d", path, len(profile.Tags), tagsSize)
+	}
+	if config.ECCScheme != "" {
+		if _, err := ParseECCScheme(config.ECCScheme); err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+
+	settings := &Settings{
+		PageSize:      config.PageSize,
+		SpareSize:     config.SpareSize,
+		PagesPerBlock: config.PagesPerBlock,
+	}
+	if config.ByteOrder != "" {
+		byteOrder, err := ParseByteOrder(config.ByteOrder)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if byteOrder != nil {
+			settings.ByteOrder = byteOrder
+		}
+	}
+	return settings, profile, nil
+}
@@ -0,0 +1,99 @@
+package yaffs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type IDRange struct {
+	Min uint32
+	Max uint32
+}
+
+// Object IDs accepted when parsing spares. Vendor builds and hand-crafted
+// images may use IDs the reference implementation never allocates.
+type ObjectIDRules struct {
+	SpecialIDs []uint32
+	Ranges     []IDRange
+}
+
+var ReferenceObjectIDRules = ObjectIDRules{
+	SpecialIDs: []uint32{
+		YAFFS_OBJECTID_ROOT,
+		YAFFS_OBJECTID_LOSTNFOUND,
+		YAFFS_OBJECTID_UNLINKED,
+		YAFFS_OBJECTID_DELETED,
+		YAFFS_OBJECTID_SUMMARY,
+	},
+	Ranges: []IDRange{{Min: YAFFS_NOBJECT_BUCKETS, Max: YAFFS_MAX_OBJECT_ID}},
+}
+
+func (r *ObjectIDRules) Valid(objectID uint32) bool {
+	for _, id := range r.SpecialIDs {
+		if objectID == id {
+			return true
+		}
+	}
+	for _, idRange := range r.Ranges {
+		if objectID >= idRange.Min && objectID <= idRange.Max {
+			return true
+		}
+	}
+	return false
+}
+
+// Default ranges for a build compiled with the given YAFFS_OBJECT_SPACE
+func ObjectSpaceRanges(objectSpace uint) ([]IDRange, error) {
+	if objectSpace <= YAFFS_NOBJECT_BUCKETS || objectSpace > 1<<28 {
+		return nil, fmt.Errorf("invalid object space %#x", objectSpace)
+	}
+	return []IDRange{{Min: YAFFS_NOBJECT_BUCKETS, Max: uint32(objectSpace - 1)}}, nil
+}
+
+// Parse a comma separated list of IDs, e.g. "1,2,3,4,0x10"
+func ParseIDList(s string) ([]uint32, error) {
+	var ids []uint32
+	if s == "" {
+		return ids, nil
+	}
+	for _, field := range strings.Split(s, ",") {
+		id, err := strconv.ParseUint(strings.TrimSpace(field), 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid object ID %q: %w", field, err)
+		}
+		ids = append(ids, uint32(id))
+	}
+	return ids, nil
+}
+
+// Parse a comma separated list of inclusive ranges, e.g. "5-255,0x100-0x3ffff"
+func ParseIDRanges(s string) ([]IDRange, error) {
+	var ranges []IDRange
+	if s == "" {
+		return ranges, nil
+	}
+	for _, field := range strings.Split(s, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(field), "-", 2)
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("invalid object ID range %q", field)
+		}
+		ids, err := ParseIDList(bounds[0] + "," + bounds[1])
+		if err != nil {
+			return nil, err
+		}
+		if ids[0] > ids[1] {
+			return nil, fmt.Errorf("invalid object ID range %q: minimum above maximum", field)
+		}
+		ranges = append(ranges, IDRange{Min: ids[0], Max: ids[1]})
+	}
+	return ranges, nil
+}
+
+func FormatIDList(ids []uint32) string {
+	var fields []string
+	for _, id := range ids {
+		fields = append(fields, fmt.Sprintf("%#x", id))
+	}
+	return strings.Join(fields, ",")
+}
@@ -0,0 +1,106 @@
+package yaffs
+
+import (
+	"fmt"
+	"io"
+)
+
+// Page data and spares dumped to separate files, e.g. by nanddump writing
+// the OOB to its own file or by programmers saving main and spare areas
+// apart, read as page / spare pairs like a raw+OOB dump
+type interleavedReader struct {
+	data, oob           io.ReaderAt
+	pageSize, spareSize int64
+}
+
+func (r *interleavedReader) ReadAt(p []byte, offset int64) (int, error) {
+	chunkSize := r.pageSize + r.spareSize
+	n := 0
+	for n < len(p) {
+		chunk, within := offset/chunkSize, offset%chunkSize
+
+		source, sourceOffset, available := r.data, chunk*r.pageSize+within, r.pageSize-within
+		if within >= r.pageSize {
+			within -= r.pageSize
+			source, sourceOffset, available = r.oob, chunk*r.spareSize+within, r.spareSize-within
+		}
+		if available > int64(len(p)-n) {
+			available = int64(len(p) - n)
+		}
+
+		m, err := source.ReadAt(p[n:n+int(available)], sourceOffset)
+		n += m
+		offset += int64(m)
+		if err == io.EOF && int64(m) == available {
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Page and spare sizes holding the same number of pages in both files.
+// Sizes of one ratio, like 2048 / 64 and 4096 / 128, all qualify.
+func oobGeometries(dataSize, oobSize int64) [][2]int {
+	pageSizes := append([]int{YAFFS1_PAGE_SIZE}, defaultDetectOptions.PageSizes...)
+	spareSizes := append([]int{YAFFS1_SPARE_SIZE}, defaultDetectOptions.SpareSizes...)
+
+	var geometries [][2]int
+	for _, pageSize := range pageSizes {
+		if dataSize%int64(pageSize) != 0 {
+			continue
+		}
+		pages := dataSize / int64(pageSize)
+		for _, spareSize := range spareSizes {
+			if pages > 0 && int64(spareSize)*pages == oobSize {
+				geometries = append(geometries, [2]int{pageSize, spareSize})
+			}
+		}
+	}
+	return geometries
+}
+
+// Interleave a data file with its OOB file. The geometry is taken from the
+// settings of the options if given, otherwise the page and spare size
+// pairing both files whose interleaved pages detect best is used.
+func pairOOB(data io.ReaderAt, dataSize int64, oob io.ReaderAt, oobSize int64, options *ImageOptions) (*interleavedReader, *Settings, error) {
+	if settings := options.Settings; settings != nil {
+		pages := dataSize / int64(settings.PageSize)
+		if pages*int64(settings.SpareSize) != oobSize {
+			options.logger().Printf("OOB file of %d bytes does not hold %d spares of %d bytes for %d pages", oobSize, pages, settings.SpareSize, pages)
+		}
+		return &interleavedReader{data: data, oob: oob, pageSize: int64(settings.PageSize), spareSize: int64(settings.SpareSize)}, settings, nil
+	}
+
+	geometries := oobGeometries(dataSize, oobSize)
+	if len(geometries) == 0 {
+		return nil, nil, fmt.Errorf("no page and spare size pairs a data file of %d bytes with an OOB file of %d bytes", dataSize, oobSize)
+	}
+
+	var best *Candidate
+	var bestReader *interleavedReader
+	for _, geometry := range geometries {
+		reader := &interleavedReader{data: data, oob: oob, pageSize: int64(geometry[0]), spareSize: int64(geometry[1])}
+		size := dataSize / int64(geometry[0]) * int64(geometry[0]+geometry[1])
+		detectOptions := options.detectOptions()
+		detectOptions.PageSizes = []int{geometry[0]}
+		detectOptions.SpareSizes = []int{geometry[1]}
+		detectOptions.SkipYaffs1 = geometry[0] != YAFFS1_PAGE_SIZE || geometry[1] != YAFFS1_SPARE_SIZE
+		candidates, err := rankGeometries(io.NewSectionReader(reader, 0, size), detectOptions.withDefaults())
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, candidate := range candidates {
+			if best == nil || candidate.Score > best.Score {
+				best, bestReader = candidate, reader
+			}
+		}
+	}
+	if best == nil || best.Score == 0 {
+		return nil, nil, fmt.Errorf("no valid tags in any of %d page and spare size pairs of the data and OOB files", len(geometries))
+	}
+	options.logger().Println("OOB pairing:", best)
+	return bestReader, best.Settings, nil
+}
@@ -0,0 +1,164 @@
+package yaffs
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Spare layout of a controller family: where the tags, the ECC and the
+// factory bad block marker sit within the spare
+type OOBProfile struct {
+	Name        string
+	Description string
+	SpareSize   int
+
+	Tags           SpareMap // bytes YAFFS wrote the packed tags to, in order
+	ECC            SpareMap // bytes of the controller ECC
+	BadBlockMarker SpareMap // bytes of the factory bad block marker
+
+	// ECC scheme in the syntax of ParseECCScheme, empty for hardware codes
+	// pages cannot be checked against
+	ECCScheme string
+}
+
+// Profile as listed in the table, byte positions in the syntax of
+// ParseSpareMap
+type oobProfileSpec struct {
+	Description    string
+	SpareSize      int
+	Tags           string
+	ECC            string
+	BadBlockMarker string
+	ECCScheme      string
+}
+
+// Profiles selectable with -oob-profile
+var oobProfiles = map[string]oobProfileSpec{
+	"linux-hamming": {
+		Description:    "Linux MTD software Hamming ECC, large page layout",
+		SpareSize:      64,
+		Tags:           "2-29",
+		ECC:            "40-63",
+		BadBlockMarker: "0-1",
+		ECCScheme:      "hamming@40",
+	},
+	"linux-bch4": {
+		Description:    "Linux MTD software BCH-4 ECC, large page layout",
+		SpareSize:      64,
+		Tags:           "2-29",
+		ECC:            "36-63",
+		BadBlockMarker: "0-1",
+		ECCScheme:      "bch4@36",
+	},
+	"linux-bch8": {
+		Description:    "Linux MTD software BCH-8 ECC on 4K pages, tags without their ECC",
+		SpareSize:      128,
+		Tags:           "2-17",
+		ECC:            "24-127",
+		BadBlockMarker: "0-1",
+		ECCScheme:      "bch8@24",
+	},
+	"samsung-onenand": {
+		Description:    "Samsung OneNAND, tags gathered from the free bytes between the ECC of each sector",
+		SpareSize:      64,
+		Tags:           "2-4,14-15,18-20,30-31,34-36,46-47,50-52",
+		ECC:            "8-13,24-29,40-45,56-61",
+		BadBlockMarker: "0-1",
+	},
+	"ti-omap-ham1": {
+		Description:    "TI OMAP GPMC 1 bit Hamming ECC per 512 bytes",
+		SpareSize:      64,
+		Tags:           "14-41",
+		ECC:            "2-13",
+		BadBlockMarker: "0-1",
+	},
+	"broadcom-bch4": {
+		Description:    "Broadcom BRCMNAND BCH-4, 16 spare bytes per 512 byte sector with the ECC at their end",
+		SpareSize:      64,
+		Tags:           "1-8,16-24,32-40,48-49",
+		ECC:            "9-15,25-31,41-47,57-63",
+		BadBlockMarker: "0",
+	},
+	"qualcomm-msm": {
+		Description: "Qualcomm MSM NAND controller, 16 free bytes behind the ECC, tags without their ECC",
+		SpareSize:   64,
+		Tags:        "30-45",
+		ECC:         "0-29",
+	},
+}
+
+func OOBProfileNames() []string {
+	var names []string
+	for name := range oobProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func LookupOOBProfile(name string) (*OOBProfile, error) {
+	spec, ok := oobProfiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown OOB profile %q, expected one of %v", name, OOBProfileNames())
+	}
+	profile := &OOBProfile{Name: name, Description: spec.Description, SpareSize: spec.SpareSize, ECCScheme: spec.ECCScheme}
+	for _, field := range []struct {
+		value string
+		m     *SpareMap
+	}{
+		{spec.Tags, &profile.Tags},
+		{spec.ECC, &profile.ECC},
+		{spec.BadBlockMarker, &profile.BadBlockMarker},
+	} {
+		if field.value == "" {
+			continue
+		}
+		m, err := ParseSpareMap(field.value)
+		if err != nil {
+			return nil, fmt.Errorf("OOB profile %s: %v", name, err)
+		}
+		*field.m = m
+	}
+	return profile, nil
+}
+
+func (p *OOBProfile) String() string {
+	s := fmt.Sprintf("%s: %s, %d byte spares, tags at %s, ECC at %s", p.Name, p.Description, p.SpareSize, p.Tags, p.ECC)
+	if len(p.BadBlockMarker) > 0 {
+		s += fmt.Sprintf(", bad block marker at %s", p.BadBlockMarker)
+	}
+	return s
+}
+
+// Ranges of a spare map, e.g. 2-4,14-15
+func (m SpareMap) String() string {
+	s := ""
+	for start := 0; start < len(m); {
+		end := start
+		for end+1 < len(m) && m[end+1] == m[end]+1 {
+			end++
+		}
+		if s != "" {
+			s += ","
+		}
+		if end > start {
+			s += fmt.Sprintf("%d-%d", m[start], m[end])
+		} else {
+			s += fmt.Sprint(m[start])
+		}
+		start = end + 1
+	}
+	return s
+}
+
+// Set decoding to read tags with the profile's layout and mark bad blocks
+// at its marker, and return the ECC scheme to check pages against, nil if
+// it cannot be checked
+func (p *OOBProfile) Apply(decoding *Decoding) (*ECCScheme, error) {
+	decoding.SpareDecoder = &MappedSpareDecoder{Map: p.Tags, Decoder: PackedTags2Decoder{}}
+	decoding.BadBlockMarker = p.BadBlockMarker
+	if p.ECCScheme == "" {
+		return nil, nil
+	}
+	return ParseECCScheme(p.ECCScheme)
+}
@@ -0,0 +1,249 @@
+package yaffs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Plugins are long running subprocesses exchanging frames over stdin and
+// stdout, see README.md for the protocol
+const (
+	PLUGIN_OP_TRANSFORM = 'T'
+	PLUGIN_OP_SPARE     = 'S'
+
+	PLUGIN_STATUS_OK     = 0
+	PLUGIN_STATUS_REJECT = 1
+)
+
+// Time a plugin gets to exit once its input is closed before it is killed
+const PLUGIN_EXIT_TIMEOUT = 5 * time.Second
+
+type Plugin struct {
+	Command string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	in      *bufio.Writer
+	out     *bufio.Reader
+	mu      sync.Mutex
+}
+
+func StartPlugin(command string) (*Plugin, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, errors.New("empty plugin command")
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Plugin{
+		Command: command,
+		cmd:     cmd,
+		stdin:   stdin,
+		in:      bufio.NewWriter(stdin),
+		out:     bufio.NewReader(stdout),
+	}, nil
+}
+
+// Send one request frame and wait for the response. A rejected request
+// returns ok == false.
+func (p *Plugin) Call(op byte, index uint64, payload []byte) (response []byte, ok bool, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var header [13]byte
+	header[0] = op
+	binary.BigEndian.PutUint64(header[1:9], index)
+	binary.BigEndian.PutUint32(header[9:13], uint32(len(payload)))
+
+	_, err = p.in.Write(header[:])
+	if err == nil {
+		_, err = p.in.Write(payload)
+	}
+	if err == nil {
+		err = p.in.Flush()
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("plugin %q: %w", p.Command, err)
+	}
+
+	var responseHeader [5]byte
+	_, err = io.ReadFull(p.out, responseHeader[:])
+	if err != nil {
+		return nil, false, fmt.Errorf("plugin %q: %w", p.Command, err)
+	}
+	response = make([]byte, binary.BigEndian.Uint32(responseHeader[1:5]))
+	_, err = io.ReadFull(p.out, response)
+	if err != nil {
+		return nil, false, fmt.Errorf("plugin %q: %w", p.Command, err)
+	}
+
+	switch responseHeader[0] {
+	case PLUGIN_STATUS_OK:
+		return response, true, nil
+	case PLUGIN_STATUS_REJECT:
+		return nil, false, nil
+	}
+	return nil, false, fmt.Errorf("plugin %q: unknown status %d", p.Command, responseHeader[0])
+}
+
+// Close the input of the plugin and wait for it to exit, killing it if it
+// does not within PLUGIN_EXIT_TIMEOUT
+func (p *Plugin) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.stdin.Close()
+	exited := make(chan error, 1)
+	go func() {
+		exited <- p.cmd.Wait()
+	}()
+	select {
+	case err := <-exited:
+		if err != nil {
+			return fmt.Errorf("plugin %q: %w", p.Command, err)
+		}
+		return nil
+	case <-time.After(PLUGIN_EXIT_TIMEOUT):
+		p.cmd.Process.Kill()
+		<-exited
+		return fmt.Errorf("plugin %q did not exit within %s of its input closing", p.Command, PLUGIN_EXIT_TIMEOUT)
+	}
+}
+
+// Decode a spare of a proprietary layout, passing the whole spare to the
+// plugin for the four packed tags fields. Flags are decoded and validated
+// like those of built-in layouts.
+func (p *Plugin) DecodeSpare(spareBuf []byte, spareSkip int, byteOrder binary.ByteOrder) (*Yaffs2Spare, error) {
+	response, ok, err := p.Call(PLUGIN_OP_SPARE, 0, spareBuf)
+	if err != nil || !ok {
+		return nil, err
+	}
+	if len(response) != 16 {
+		return nil, fmt.Errorf("plugin %q: spare response of %d bytes, expected 16", p.Command, len(response))
+	}
+
+	spareRaw := &Yaffs2SpareRaw{
+		SeqNumber:   binary.BigEndian.Uint32(response[0:4]),
+		ObjectID:    binary.BigEndian.Uint32(response[4:8]),
+		ChunkID:     binary.BigEndian.Uint32(response[8:12]),
+		NumberBytes: binary.BigEndian.Uint32(response[12:16]),
+	}
+	return spareRaw.Parse(), nil
+}
+
+// Transform stage passing each unit of the image through a plugin. Every
+// unit must transform to the same output size.
+type pluginReader struct {
+	r       io.ReaderAt
+	plugin  *Plugin
+	unit    int64
+	outUnit int64
+
+	mu        sync.Mutex
+	lastIndex int64
+	last      []byte
+}
+
+func newPluginReader(r io.ReaderAt, size int64, args string) (*pluginReader, int64, error) {
+	fields := strings.SplitN(args, ":", 2)
+	if len(fields) != 2 {
+		return nil, 0, fmt.Errorf("invalid plugin transform %q, expected UNIT:COMMAND", args)
+	}
+	unit, err := strconv.ParseInt(fields[0], 0, 64)
+	if err != nil || unit < 1 {
+		return nil, 0, fmt.Errorf("invalid plugin unit size %q", fields[0])
+	}
+
+	p, err := StartPlugin(fields[1])
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pr := &pluginReader{r: r, plugin: p, unit: unit, lastIndex: -1}
+	units := size / unit
+	if units == 0 {
+		return pr, 0, nil
+	}
+
+	// The first unit tells the output unit size
+	first, err := pr.transformUnit(0)
+	if err != nil {
+		p.Close()
+		return nil, 0, err
+	}
+	pr.outUnit = int64(len(first))
+	if pr.outUnit == 0 {
+		p.Close()
+		return nil, 0, fmt.Errorf("plugin %q returned an empty unit", p.Command)
+	}
+
+	return pr, units * pr.outUnit, nil
+}
+
+func (pr *pluginReader) transformUnit(index int64) ([]byte, error) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	// Page and spare of one unit are usually read separately
+	if index == pr.lastIndex {
+		return pr.last, nil
+	}
+
+	buf := make([]byte, pr.unit)
+	_, err := pr.r.ReadAt(buf, index*pr.unit)
+	if err != nil {
+		return nil, err
+	}
+
+	out, ok, err := pr.plugin.Call(PLUGIN_OP_TRANSFORM, uint64(index), buf)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("plugin %q rejected unit %d", pr.plugin.Command, index)
+	}
+	if pr.outUnit != 0 && int64(len(out)) != pr.outUnit {
+		return nil, fmt.Errorf("plugin %q returned %d bytes for unit %d, expected %d", pr.plugin.Command, len(out), index, pr.outUnit)
+	}
+
+	pr.lastIndex, pr.last = index, out
+	return out, nil
+}
+
+func (pr *pluginReader) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		logical := off + int64(n)
+		out, err := pr.transformUnit(logical / pr.outUnit)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return n, io.EOF
+		}
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], out[logical%pr.outUnit:])
+	}
+	return n, nil
+}
@@ -0,0 +1,98 @@
+package yaffs
+
+import (
+	"io"
+	"sync"
+)
+
+// Size of the regions read ahead
+const PREFETCH_BLOCK_SIZE = 1 << 20
+
+// Reads the regions following each read in the background, keeping at most
+// budget bytes, so sequential reads from slow media are not latency bound
+type prefetchReader struct {
+	r     io.ReaderAt
+	size  int64
+	ahead int64 // blocks read ahead of the current one
+
+	mu     sync.Mutex
+	blocks map[int64]*prefetchBlock
+	order  []int64 // block numbers in load order, oldest evicted first
+}
+
+type prefetchBlock struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+func newPrefetchReader(r io.ReaderAt, size, budget int64) *prefetchReader {
+	ahead := budget/PREFETCH_BLOCK_SIZE - 1
+	if ahead < 1 {
+		ahead = 1
+	}
+	return &prefetchReader{r: r, size: size, ahead: ahead, blocks: make(map[int64]*prefetchBlock)}
+}
+
+func (p *prefetchReader) ReadAt(buf []byte, off int64) (int, error) {
+	n := 0
+	for n < len(buf) {
+		pos := off + int64(n)
+		if pos >= p.size {
+			return n, io.EOF
+		}
+
+		number := pos / PREFETCH_BLOCK_SIZE
+		block := p.load(number)
+		for i := int64(1); i <= p.ahead; i++ {
+			p.load(number + i)
+		}
+
+		<-block.done
+		start := pos - number*PREFETCH_BLOCK_SIZE
+		if start >= int64(len(block.data)) {
+			if block.err != nil {
+				return n, block.err
+			}
+			return n, io.ErrUnexpectedEOF
+		}
+		n += copy(buf[n:], block.data[start:])
+	}
+	return n, nil
+}
+
+// Start loading a block unless it is loaded, loading or beyond the end
+func (p *prefetchReader) load(number int64) *prefetchBlock {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if block, ok := p.blocks[number]; ok {
+		return block
+	}
+
+	block := &prefetchBlock{done: make(chan struct{})}
+	offset := number * PREFETCH_BLOCK_SIZE
+	if offset >= p.size {
+		close(block.done)
+		return block
+	}
+
+	p.blocks[number] = block
+	p.order = append(p.order, number)
+	for int64(len(p.order)) > p.ahead+1 {
+		delete(p.blocks, p.order[0])
+		p.order = p.order[1:]
+	}
+
+	go func() {
+		data := make([]byte, minInt64(PREFETCH_BLOCK_SIZE, p.size-offset))
+		n, err := p.r.ReadAt(data, offset)
+		if err == io.EOF && n == len(data) {
+			err = nil
+		}
+		block.data, block.err = data[:n], err
+		close(block.done)
+	}()
+
+	return block
+}
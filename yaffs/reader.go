@@ -0,0 +1,782 @@
+package yaffs
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+)
+
+/* https://elinux.org/images/e/e3/Yaffs.pdf
+https://yaffs.net/documents/how-yaffs-works
+https://yaffs.net/documents/yaffs-direct-interface
+*/
+
+const (
+	YAFFS_MAX_NAME_LENGTH  = 255
+	YAFFS_MAX_ALIAS_LENGTH = 159 // TODO CHECK
+
+	/* Some special object ids for pseudo objects */
+	YAFFS_OBJECTID_ROOT       = 1
+	YAFFS_OBJECTID_LOSTNFOUND = 2
+	YAFFS_OBJECTID_UNLINKED   = 3
+	YAFFS_OBJECTID_DELETED    = 4
+	YAFFS_OBJECTID_SUMMARY    = 0x10
+
+	YAFFS_LOWEST_SEQUENCE_NUMBER  = 0x00001000
+	YAFFS_HIGHEST_SEQUENCE_NUMBER = 0xefffff00
+	/* Special sequence number for bad block that failed to be marked bad */
+	YAFFS_SEQUENCE_BAD_BLOCK = 0xffff0000
+
+	/* YAFFS2 Additions */
+	EXTRA_HEADER_INFO_FLAG = 0x80000000
+	EXTRA_SHRINK_FLAG      = 0x40000000
+	EXTRA_SHADOWS_FLAG     = 0x20000000
+	EXTRA_SPARE_FLAGS      = 0x10000000
+
+	ALL_EXTRA_FLAGS     = 0xf0000000
+	NOT_ALL_EXTRA_FLAGS = 0xfffffff
+
+	/* Also, the top 4 bits of the object Id are set to the object type. */
+	EXTRA_OBJECT_TYPE_SHIFT    = (28)
+	EXTRA_OBJECT_TYPE_MASK     = ((0x0f) << EXTRA_OBJECT_TYPE_SHIFT)
+	NOT_EXTRA_OBJECT_TYPE_MASK = 0xfffffff
+
+	YAFFS_OBJECT_SPACE  = 0x40000
+	YAFFS_MAX_OBJECT_ID = (YAFFS_OBJECT_SPACE - 1)
+
+	YAFFS_TNODES_LEVEL0_BITS   = 4
+	YAFFS_TNODES_INTERNAL_BITS = (YAFFS_TNODES_LEVEL0_BITS - 1)
+	YAFFS_TNODES_MAX_LEVEL     = 8
+	YAFFS_TNODES_MAX_BITS      = (YAFFS_TNODES_LEVEL0_BITS + YAFFS_TNODES_INTERNAL_BITS*YAFFS_TNODES_MAX_LEVEL)
+	YAFFS_MAX_CHUNK_ID         = ((1 << YAFFS_TNODES_MAX_BITS) - 1)
+
+	YAFFS_NOBJECT_BUCKETS = 256
+)
+
+type ObjectHeader struct {
+	ObjectType     ObjectType
+	ParentObjectID uint32
+	Checksum       [2]byte //unused
+	Name           [YAFFS_MAX_NAME_LENGTH + 1]byte
+	_              [2]byte // C struct padding
+
+	Mode       uint32
+	UID        uint32
+	GID        uint32
+	AccessTime uint32
+	ModTime    uint32
+	CreateTime uint32
+
+	FileSizeLow [4]byte
+	EquivID     int32 // originally int
+
+	Alias [YAFFS_MAX_ALIAS_LENGTH + 1]byte
+
+	RDev uint32
+
+	WinCreateTime uint64
+	WinAccessTime uint64
+	WinModTime    uint64
+
+	InbandShadowedObjectID uint32
+	InbandIsShrink         uint32
+	FileSizeHigh           [4]byte
+
+	Reserved uint32
+
+	ShadowsObject int32 // originally ints
+
+	IsShrink uint32
+}
+
+// yaffs_packed_tags2_tags_only, followed in the spare by the tags ECC
+// checked by PackedTags2Decoder
+type Yaffs2SpareRaw struct {
+	SeqNumber   uint32
+	ObjectID    uint32
+	ChunkID     uint32
+	NumberBytes uint32
+}
+
+func (s *Yaffs2SpareRaw) Parse() *Yaffs2Spare {
+
+	// Sanity check sequence number
+	if s.SeqNumber == YAFFS_SEQUENCE_BAD_BLOCK ||
+		s.SeqNumber < YAFFS_LOWEST_SEQUENCE_NUMBER ||
+		s.SeqNumber > YAFFS_HIGHEST_SEQUENCE_NUMBER {
+		return nil
+	}
+
+	var spare = &Yaffs2Spare{
+		SeqNumber:   s.SeqNumber,
+		ObjectID:    s.ObjectID,
+		ChunkID:     s.ChunkID,
+		NumberBytes: s.NumberBytes,
+	}
+
+	// Match C logic (everything not zero is true)
+	if (s.ChunkID & EXTRA_HEADER_INFO_FLAG) != 0 {
+		spare.ChunkID = 0
+		spare.NumberBytes = 0
+		spare.ExtraValid = true
+		spare.ParentID = s.ChunkID & NOT_ALL_EXTRA_FLAGS
+		spare.IsShrink = s.ChunkID&EXTRA_SHRINK_FLAG != 0
+		spare.Shadows = s.ChunkID&EXTRA_SHADOWS_FLAG != 0
+		spare.ObjType = s.ObjectID >> EXTRA_OBJECT_TYPE_SHIFT
+		spare.ObjectID = s.ObjectID & NOT_EXTRA_OBJECT_TYPE_MASK
+	}
+
+	// Checks after parsing extra header information, object IDs are
+	// checked against the rules of the settings
+	if spare.ChunkID > YAFFS_MAX_CHUNK_ID {
+		return nil
+	}
+
+	return spare
+
+}
+
+type Yaffs2Spare struct {
+	SeqNumber   uint32
+	ObjectID    uint32
+	ChunkID     uint32
+	NumberBytes uint32
+
+	// YAFFS2 Extended Tags parsed with Flags from ChunkID
+	ExtraValid bool
+	ParentID   uint32
+	IsShrink   bool
+	Shadows    bool
+	ObjType    uint32
+
+	// YAFFS1 tags have no sequence number, but a 2 bit serial number and
+	// a page status marking deleted chunks
+	Serial  uint8
+	Deleted bool
+
+	TagsECC TagsECCResult
+}
+
+func (oh *ObjectHeader) String() string {
+
+	return fmt.Sprintf("Type: %s, Name: %s, UID: %v, GID: %v, SizeLow: %v, SizeHigh: %v, ModTime: %s", oh.ObjectType, CToGoString(oh.Name[:]), oh.UID, oh.GID, oh.FileSizeLow, oh.FileSizeHigh, defaultTimeFormat.Format(oh.ModTime))
+}
+
+// A high word of all ones means the header was written by a YAFFS version
+// without 64 bit file size support
+func (oh *ObjectHeader) FileSize(byteOrder binary.ByteOrder) uint64 {
+	size := uint64(byteOrder.Uint32(oh.FileSizeLow[:]))
+	high := byteOrder.Uint32(oh.FileSizeHigh[:])
+	if high != 0xFFFFFFFF {
+		size |= uint64(high) << 32
+	}
+	return size
+}
+
+// One line summary used in all header listings
+func (oh *ObjectHeader) Line(format TimeFormat) string {
+	return fmt.Sprintf("%s: %s (modified %s)", oh.ObjectType, CToGoString(oh.Name[:]), format.Format(oh.ModTime))
+}
+
+type ObjectType uint32
+
+const (
+	YAFFS_OBJECT_TYPE_UNKNOWN ObjectType = iota
+	YAFFS_OBJECT_TYPE_FILE
+	YAFFS_OBJECT_TYPE_SYMLINK
+	YAFFS_OBJECT_TYPE_DIRECTORY
+	YAFFS_OBJECT_TYPE_HARDLINK
+	YAFFS_OBJECT_TYPE_SPECIAL
+)
+
+func (o ObjectType) String() string {
+	if o > YAFFS_OBJECT_TYPE_SPECIAL {
+		return fmt.Sprintf("unknown(%d)", uint32(o))
+	}
+	return []string{"unknown", "file", "symlink", "directory", "hardlink", "special"}[o]
+}
+
+// Without tags only object headers can be identified, by their signature
+func ScanDataOnly(image io.Reader, settings *Settings) ([]ListEntry, error) {
+	var entries []ListEntry
+	pageBuf := getEmptyBuf(settings.PageSize)
+	for {
+		_, err := io.ReadFull(image, pageBuf)
+		if err != nil {
+			break
+		}
+
+		if !LooksLikeHeader(pageBuf, settings.ByteOrder) {
+			continue
+		}
+
+		header, err := settings.ParseHeader(pageBuf)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, ListEntry{Header: header})
+	}
+	return entries, nil
+}
+
+// Empty NAND blocks are 0xFF filled / initialized
+func checkBlockEmpty(buf []byte) bool {
+	for _, v := range buf {
+		if v != 0xFF {
+			return false
+		}
+	}
+
+	return true
+}
+
+func getEmptyBuf(size int) []byte {
+	return bytes.Repeat([]byte{byte(0xFF)}, size)
+}
+
+func TSKConfig(settings *Settings) string {
+	layout := SpareLayout{
+		SeqNumberOffset: settings.SpareSkip,
+		ObjectIDOffset:  settings.SpareSkip + 4,
+		ChunkIDOffset:   settings.SpareSkip + 8,
+	}
+	if given, ok := settings.SpareDecoder.(*SpareLayout); ok {
+		layout = *given
+	}
+	return fmt.Sprintf(
+		`#YAFFS2 config file
+flash_page_size = %d
+flash_spare_size = %d
+
+spare_seq_num_offset = %d
+spare_obj_id_offset = %d
+spare_chunk_id_offset = %d`,
+		settings.PageSize,
+		settings.SpareSize,
+		layout.SeqNumberOffset,
+		layout.ObjectIDOffset,
+		layout.ChunkIDOffset)
+}
+
+type Settings struct {
+	PageSize  int
+	SpareSize int
+	SpareSkip int
+	ByteOrder binary.ByteOrder
+
+	// Byte order of the tags in the spare if it differs from the data,
+	// as written by some vendor controllers
+	TagByteOrder binary.ByteOrder
+
+	PagesPerBlock int // zero if unknown
+
+	// YAFFS1 image: 512 byte pages, yaffs_tags in a 16 byte spare and no
+	// sequence numbers
+	Yaffs1 bool
+
+	// ECC scheme of the page data, pages are verified and bit errors
+	// corrected while reading them unless the scheme is only estimated.
+	// Nil if unknown.
+	ECC *ECCScheme
+
+	// Only read spares while scanning and defer data pages until a file is
+	// read, if the image allows random access
+	IndexOnly bool
+
+	// Stops a scan at the next chunk once done, its result then covers only
+	// what was read. Nil never stops.
+	Context context.Context
+
+	// Diagnostics of the scan, discarded if nil
+	Logger *log.Logger
+
+	Decoding
+}
+
+// How tags and headers are decoded, for vendor builds deviating from the
+// reference implementation. The zero value decodes like mkyaffs2image.
+type Decoding struct {
+	SpareDecoder   SpareDecoder   // nil for packed tags at the spare skip
+	HeaderDecoder  HeaderDecoder  // nil for the yaffs_obj_hdr layout
+	SparePolicy    SparePolicy    // for spares without valid tags, empty to skip them
+	ObjectIDs      *ObjectIDRules // accepted in tags, nil for the reference rules
+	BadBlockMarker SpareMap       // nil for the first byte in front of tags at a spare skip
+}
+
+func (d *Decoding) spareDecoder() SpareDecoder {
+	if d.SpareDecoder != nil {
+		return d.SpareDecoder
+	}
+	return PackedTags2Decoder{}
+}
+
+func (d *Decoding) headerDecoder() HeaderDecoder {
+	if d.HeaderDecoder != nil {
+		return d.HeaderDecoder
+	}
+	return StandardHeaderDecoder{}
+}
+
+func (d *Decoding) objectIDs() *ObjectIDRules {
+	if d.ObjectIDs != nil {
+		return d.ObjectIDs
+	}
+	return &ReferenceObjectIDRules
+}
+
+// Whether the context of the settings is done
+func (s *Settings) stopped() bool {
+	return s.Context != nil && s.Context.Err() != nil
+}
+
+func (s *Settings) logger() *log.Logger {
+	return orDiscard(s.Logger)
+}
+
+func (s *Settings) BlockPages() int {
+	if s.PagesPerBlock > 0 {
+		return s.PagesPerBlock
+	}
+	return DEFAULT_PAGES_PER_BLOCK
+}
+
+func (s *Settings) TagOrder() binary.ByteOrder {
+	if s.TagByteOrder != nil {
+		return s.TagByteOrder
+	}
+	return s.ByteOrder
+}
+
+// Decode tags with the YAFFS1 decoder for YAFFS1 images, otherwise with the
+// configured decoder. Tags failing their ECC are returned flagged, tags of
+// object IDs outside the rules are invalid.
+func (s *Settings) decodeSpare(spareBuf []byte, spareSkip int) (*Yaffs2Spare, error) {
+	var spare *Yaffs2Spare
+	var err error
+	if s.Yaffs1 {
+		spare, err = Yaffs1TagsDecoder{}.DecodeSpare(spareBuf, spareSkip, s.TagOrder())
+		// The object ID of YAFFS1 tags beyond correction is unreliable
+		if spare != nil && spare.TagsECC == TAGS_ECC_UNFIXED {
+			return spare, err
+		}
+	} else {
+		spare, err = s.spareDecoder().DecodeSpare(spareBuf, spareSkip, s.TagOrder())
+	}
+	if spare != nil && !s.objectIDs().Valid(spare.ObjectID) {
+		return nil, err
+	}
+	return spare, err
+}
+
+// Like decodeSpare, but tags failing their ECC are invalid
+func (s *Settings) parseSpare(spareBuf []byte, spareSkip int) (*Yaffs2Spare, error) {
+	return trustedTags(s.decodeSpare(spareBuf, spareSkip))
+}
+
+// Decode an object header with the configured decoder
+func (s *Settings) ParseHeader(page []byte) (*ObjectHeader, error) {
+	return s.headerDecoder().DecodeHeader(page, s.ByteOrder)
+}
+
+func (s *Settings) String() string {
+	if s.Yaffs1 {
+		return fmt.Sprintf("YAFFS1, page size %d, spare size %d", s.PageSize, s.SpareSize)
+	}
+	return fmt.Sprintf("page size %d, spare size %d, spare skip %d, %s, tags %s",
+		s.PageSize, s.SpareSize, s.SpareSkip, s.ByteOrder, s.TagOrder())
+}
+
+func ParseByteOrder(name string) (binary.ByteOrder, error) {
+	switch name {
+	case "auto":
+		return nil, nil
+	case "little":
+		return binary.LittleEndian, nil
+	case "big":
+		return binary.BigEndian, nil
+	}
+	return nil, fmt.Errorf("unknown byte order %q, expected auto, little or big", name)
+}
+
+// Number of chunks read from the start of the image to score a geometry
+const DETECT_SAMPLE_CHUNKS = 256
+
+type Candidate struct {
+	Settings *Settings
+	Score    float64
+
+	Chunks      int // non-empty chunks sampled
+	ValidSpares int
+	HeaderTags  int // spares claiming a header chunk
+	Headers     int // of those, pages that look like a header
+
+	Blocks           int // with at least two valid chunks
+	ConsistentBlocks int // of those, blocks with one sequence number
+}
+
+// Fraction of sampled chunks with valid tags, scaled by the fraction of
+// header tags whose page actually holds a header. A sample without any
+// header tags is only half as trustworthy.
+func (c *Candidate) score() float64 {
+	if c.Chunks == 0 {
+		return 0
+	}
+	score := float64(c.ValidSpares) / float64(c.Chunks)
+	if c.HeaderTags > 0 {
+		score *= float64(c.Headers) / float64(c.HeaderTags)
+	} else {
+		score *= 0.5
+	}
+	// All chunks of an erase block share its sequence number
+	if c.Blocks > 0 {
+		score *= float64(c.ConsistentBlocks) / float64(c.Blocks)
+	}
+	return score
+}
+
+func (c *Candidate) String() string {
+	geometry := fmt.Sprintf("page size %5d, spare size %4d, spare skip %d, data %s, tags %s",
+		c.Settings.PageSize, c.Settings.SpareSize, c.Settings.SpareSkip, c.Settings.ByteOrder, c.Settings.TagOrder())
+	if c.Settings.Yaffs1 {
+		geometry = fmt.Sprintf("YAFFS1, page size %5d, spare size %4d", c.Settings.PageSize, c.Settings.SpareSize)
+	}
+	return fmt.Sprintf("%s: score %.3f (%d/%d valid spares, %d/%d headers, %d/%d consistent blocks)",
+		geometry, c.Score, c.ValidSpares, c.Chunks, c.Headers, c.HeaderTags, c.ConsistentBlocks, c.Blocks)
+}
+
+// Geometries and sample size considered by DetectSettings. Empty lists
+// and a zero sample size select the defaults.
+type DetectOptions struct {
+	PageSizes     []int
+	SpareSizes    []int
+	SpareSkips    []int
+	ByteOrders    []binary.ByteOrder // of headers and, unless given separately, tags
+	TagByteOrders []binary.ByteOrder
+	SampleChunks  int
+
+	// Block size for the sequence number check. The default is small enough
+	// that blocks never span two physical blocks of common parts.
+	PagesPerBlock int
+
+	// Leave out the fixed YAFFS1 geometry
+	SkipYaffs1 bool
+
+	// Only consider geometries placing a header chunk at Anchor
+	Anchored bool
+	Anchor   int64
+
+	// Geometries evaluated concurrently, one per CPU if zero
+	Workers int
+
+	// Tag decoding of every candidate
+	Decoding Decoding
+}
+
+// YAFFS2 requires minimum 1024/32. Spares of 640 bytes and more are used by
+// recent MLC / TLC parts.
+var defaultDetectOptions = DetectOptions{
+	PageSizes:     []int{1024, 2048, 4096, 8192, 16384},
+	SpareSizes:    []int{32, 64, 128, 256, 512, 640, 744, 1024, 1280},
+	SpareSkips:    []int{0, 2},
+	ByteOrders:    []binary.ByteOrder{binary.LittleEndian, binary.BigEndian},
+	TagByteOrders: []binary.ByteOrder{binary.LittleEndian, binary.BigEndian},
+	SampleChunks:  DETECT_SAMPLE_CHUNKS,
+	PagesPerBlock: 32,
+}
+
+func (o DetectOptions) withDefaults() DetectOptions {
+	if len(o.PageSizes) == 0 {
+		o.PageSizes = defaultDetectOptions.PageSizes
+	}
+	if len(o.SpareSizes) == 0 {
+		o.SpareSizes = defaultDetectOptions.SpareSizes
+	}
+	if len(o.SpareSkips) == 0 {
+		o.SpareSkips = defaultDetectOptions.SpareSkips
+	}
+	if len(o.ByteOrders) == 0 {
+		o.ByteOrders = defaultDetectOptions.ByteOrders
+	}
+	if len(o.TagByteOrders) == 0 {
+		o.TagByteOrders = defaultDetectOptions.TagByteOrders
+	}
+	if o.SampleChunks <= 0 {
+		o.SampleChunks = defaultDetectOptions.SampleChunks
+	}
+	if o.PagesPerBlock <= 0 {
+		o.PagesPerBlock = defaultDetectOptions.PagesPerBlock
+	}
+	o.Workers = WorkerCount(o.Workers)
+	return o
+}
+
+// Outcome of a detection: every evaluated candidate best first, the
+// accepted geometry if any and human readable notes on the decision
+type Detection struct {
+	Candidates  []*Candidate
+	Best        *Settings
+	Diagnostics []string
+}
+
+// Evaluate every candidate geometry over a sample of the image and accept
+// the best one if there is some evidence for it. Failing to find a
+// geometry is not an error, Best is nil then.
+func DetectSettings(image io.ReadSeeker, options DetectOptions) (*Detection, error) {
+	options = options.withDefaults()
+
+	candidates, err := rankGeometries(image, options)
+	if err != nil {
+		return nil, err
+	}
+
+	detection := &Detection{Candidates: candidates}
+	if len(candidates) == 0 {
+		detection.Diagnostics = append(detection.Diagnostics, fmt.Sprintf("no geometry places a valid header at offset %d", options.Anchor))
+		return detection, nil
+	}
+
+	for _, candidate := range candidates {
+		if candidate.Score > 0 {
+			detection.Diagnostics = append(detection.Diagnostics, fmt.Sprint("Possible settings: ", candidate))
+		}
+	}
+
+	// Trailing data lowers the score of the right geometry as well, so
+	// only require some evidence for the best one
+	best := candidates[0]
+	if best.ValidSpares < 2 || best.Score == 0 {
+		detection.Diagnostics = append(detection.Diagnostics, "no suitable settings detected")
+		return detection, nil
+	}
+
+	detection.Best = best.Settings
+	return detection, nil
+}
+
+// Candidates in descending score. The sample is read once and candidates
+// are scored concurrently on it.
+func rankGeometries(image io.ReadSeeker, options DetectOptions) ([]*Candidate, error) {
+	start := int64(0)
+	if options.Anchored {
+		start = options.Anchor
+	}
+	_, err := image.Seek(start, 0)
+	if err != nil {
+		return nil, err
+	}
+	sample := make([]byte, options.SampleChunks*(maxInt(options.PageSizes)+maxInt(options.SpareSizes)))
+	n, err := io.ReadFull(image, sample)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	sample = sample[:n]
+
+	var geometries []*Settings
+	for _, pageSize := range options.PageSizes {
+		for _, spareSize := range options.SpareSizes {
+			if options.Anchored && options.Anchor%int64(pageSize+spareSize) != 0 {
+				continue
+			}
+			for _, spareSkip := range options.SpareSkips {
+				for _, byteOrder := range options.ByteOrders {
+					for _, tagOrder := range options.TagByteOrders {
+						settings := &Settings{
+							PageSize:  pageSize,
+							SpareSize: spareSize,
+							SpareSkip: spareSkip,
+							ByteOrder: byteOrder,
+							Decoding:  options.Decoding,
+						}
+						if tagOrder != byteOrder {
+							settings.TagByteOrder = tagOrder
+						}
+						geometries = append(geometries, settings)
+					}
+				}
+			}
+		}
+	}
+	if !options.SkipYaffs1 && (!options.Anchored || options.Anchor%(YAFFS1_PAGE_SIZE+YAFFS1_SPARE_SIZE) == 0) {
+		// Only the tags of little endian hosts are read
+		for _, byteOrder := range options.ByteOrders {
+			if byteOrder == binary.LittleEndian {
+				settings := Yaffs1Settings()
+				settings.Decoding = options.Decoding
+				geometries = append(geometries, settings)
+			}
+		}
+	}
+
+	results := make([]*Candidate, len(geometries))
+	errs := make([]error, len(geometries))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < options.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i], errs[i] = evaluateGeometry(sample, geometries[i], options)
+			}
+		}()
+	}
+	for i := range geometries {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var candidates []*Candidate
+	for i, candidate := range results {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		if candidate != nil {
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Score != candidates[j].Score {
+			return candidates[i].Score > candidates[j].Score
+		}
+		return candidates[i].ValidSpares > candidates[j].ValidSpares
+	})
+
+	return candidates, nil
+}
+
+func maxInt(values []int) int {
+	max := 0
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+	return max
+}
+
+// Score one geometry on the sample. Returns nil if the sample has to start
+// with a header chunk and does not under this geometry.
+func evaluateGeometry(sample []byte, settings *Settings, options DetectOptions) (*Candidate, error) {
+	candidate := &Candidate{Settings: settings}
+	chunkSize := settings.PageSize + settings.SpareSize
+	anchored := options.Anchored
+
+	// Layouts at fixed spare offsets rule out smaller spares
+	if sized, ok := settings.spareDecoder().(interface{ Size() int }); ok && !settings.Yaffs1 && sized.Size() > settings.SpareSize {
+		return nil, nil
+	}
+
+	// Sequence numbers of the valid chunks in the current block. An anchored
+	// sample does not start at a block boundary, so blocks are not checked.
+	var blockSeqs []uint32
+	endBlock := func() {
+		if len(blockSeqs) >= 2 {
+			candidate.Blocks++
+			if sequenceConsistent(blockSeqs) {
+				candidate.ConsistentBlocks++
+			}
+		}
+		blockSeqs = blockSeqs[:0]
+	}
+
+	for x := 0; x < options.SampleChunks && (x+1)*chunkSize <= len(sample); x++ {
+		if !anchored && x > 0 && x%options.PagesPerBlock == 0 {
+			endBlock()
+		}
+
+		pageBuf := sample[x*chunkSize : x*chunkSize+settings.PageSize]
+		spareBuf := sample[x*chunkSize+settings.PageSize : (x+1)*chunkSize]
+
+		if checkBlockEmpty(pageBuf) && checkBlockEmpty(spareBuf) {
+			continue
+		}
+		candidate.Chunks++
+
+		spare, err := settings.parseSpare(spareBuf, settings.SpareSkip)
+		if err != nil {
+			return nil, err
+		}
+
+		// The anchor chunk itself must decode as a header
+		if anchored && x == 0 && (spare == nil || spare.ChunkID != 0 || !LooksLikeHeader(pageBuf, settings.ByteOrder)) {
+			return nil, nil
+		}
+
+		if spare == nil {
+			continue
+		}
+		candidate.ValidSpares++
+		blockSeqs = append(blockSeqs, spare.SeqNumber)
+
+		if spare.ChunkID == 0 {
+			candidate.HeaderTags++
+			if LooksLikeHeader(pageBuf, settings.ByteOrder) {
+				candidate.Headers++
+			}
+		}
+	}
+
+	if !anchored {
+		endBlock()
+	}
+
+	if anchored && candidate.Chunks == 0 {
+		return nil, nil
+	}
+
+	candidate.Score = candidate.score()
+	return candidate, nil
+}
+
+// Detection as done by OpenImage, with the byte orders and decoding of the
+// image options and the diagnostics logged
+func detectSettings(image io.ReadSeeker, options *ImageOptions) (*Settings, error) {
+	detectOptions := options.detectOptions()
+	detectOptions.Anchored, detectOptions.Anchor = options.Anchored, options.Anchor
+
+	detection, err := DetectSettings(image, detectOptions)
+	if err != nil {
+		return nil, err
+	}
+	diagnostics := detection.Diagnostics
+	if detection.Best == nil {
+		// The last diagnostic tells why and becomes the error
+		diagnostics = diagnostics[:len(diagnostics)-1]
+	}
+	for _, diagnostic := range diagnostics {
+		options.logger().Println(diagnostic)
+	}
+	if detection.Best == nil {
+		return nil, errors.New(detection.Diagnostics[len(detection.Diagnostics)-1])
+	}
+	return detection.Best, nil
+}
+
+func CToGoString(c []byte) string {
+	n := -1
+	for i, b := range c {
+		if b == 0 {
+			break
+		}
+		n = i
+	}
+	return string(c[:n+1])
+}
+
+func sequenceConsistent(seqs []uint32) bool {
+	for _, seq := range seqs {
+		if seq != seqs[0] {
+			return false
+		}
+	}
+	return true
+}
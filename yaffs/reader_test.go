@@ -0,0 +1,112 @@
+package yaffs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+// A few directories and files, enough for a sample of valid spares and
+// headers
+func detectTestImage(settings *Settings) *testImage {
+	img := newTestImage(settings)
+	for k := uint32(0); k < 4; k++ {
+		dir := 260 + k
+		img.header(dir, YAFFS_OBJECTID_ROOT, YAFFS_OBJECT_TYPE_DIRECTORY, fmt.Sprintf("dir%d", k), 0, "", 0)
+		img.file(300+k, dir, "data.bin", bytes.Repeat([]byte{byte(k)}, 3*settings.PageSize/2))
+	}
+	return img
+}
+
+var detectTests = []struct {
+	name     string
+	settings Settings
+}{
+	{"2048+64", Settings{PageSize: 2048, SpareSize: 64, ByteOrder: binary.LittleEndian}},
+	{"4096+128 big endian", Settings{PageSize: 4096, SpareSize: 128, ByteOrder: binary.BigEndian}},
+	{"2048+64 skip 2", Settings{PageSize: 2048, SpareSize: 64, SpareSkip: 2, ByteOrder: binary.LittleEndian}},
+	{"2048+64 big endian tags", Settings{PageSize: 2048, SpareSize: 64, ByteOrder: binary.LittleEndian, TagByteOrder: binary.BigEndian}},
+	{"8192+640", Settings{PageSize: 8192, SpareSize: 640, ByteOrder: binary.LittleEndian}},
+}
+
+func TestDetectSettings(t *testing.T) {
+	for _, test := range detectTests {
+		settings := test.settings
+		data := detectTestImage(&settings).bytes()
+
+		detection, err := DetectSettings(bytes.NewReader(data), DetectOptions{})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		best := detection.Best
+		if best == nil {
+			t.Errorf("%s: nothing detected: %q", test.name, detection.Diagnostics)
+			continue
+		}
+		if best.PageSize != settings.PageSize || best.SpareSize != settings.SpareSize || best.SpareSkip != settings.SpareSkip ||
+			best.ByteOrder != settings.ByteOrder || best.TagOrder() != settings.TagOrder() {
+			t.Errorf("%s: detected %s, want %s", test.name, best, &settings)
+		}
+
+		// Ranked best first, with the alternatives scored lower
+		candidates := detection.Candidates
+		if candidates[0].Settings != best {
+			t.Errorf("%s: best %s is not the first candidate %s", test.name, best, candidates[0])
+		}
+		for k := 1; k < len(candidates); k++ {
+			if candidates[k].Score > candidates[k-1].Score {
+				t.Errorf("%s: candidate %d scores %.3f above %.3f", test.name, k, candidates[k].Score, candidates[k-1].Score)
+			}
+		}
+		if len(candidates) > 1 && candidates[1].Score >= candidates[0].Score {
+			t.Errorf("%s: runner-up %s scores as high as %s", test.name, candidates[1], candidates[0])
+		}
+	}
+}
+
+func TestDetectSettingsOptions(t *testing.T) {
+	settings := testSettings()
+	img := detectTestImage(settings)
+	chunkSize := int64(settings.PageSize + settings.SpareSize)
+	data := img.bytes()
+
+	for _, test := range []struct {
+		name     string
+		options  DetectOptions
+		detected bool
+	}{
+		{"defaults", DetectOptions{}, true},
+		{"restricted", DetectOptions{PageSizes: []int{2048}, SpareSizes: []int{64}, ByteOrders: []binary.ByteOrder{binary.LittleEndian}}, true},
+		{"wrong page size", DetectOptions{PageSizes: []int{4096}, SpareSizes: []int{64}, SkipYaffs1: true}, false},
+		{"wrong byte order", DetectOptions{ByteOrders: []binary.ByteOrder{binary.BigEndian}, TagByteOrders: []binary.ByteOrder{binary.BigEndian}}, false},
+		// A directory header and two data chunks precede the first file header
+		{"anchored at a header", DetectOptions{Anchored: true, Anchor: 3 * chunkSize}, true},
+		{"anchored at data", DetectOptions{Anchored: true, Anchor: 2 * chunkSize}, false},
+		{"single worker", DetectOptions{Workers: 1}, true},
+	} {
+		detection, err := DetectSettings(bytes.NewReader(data), test.options)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if (detection.Best != nil) != test.detected {
+			t.Errorf("%s: detected %v, want %v: %q", test.name, detection.Best, test.detected, detection.Diagnostics)
+			continue
+		}
+		if detection.Best != nil && (detection.Best.PageSize != 2048 || detection.Best.SpareSize != 64) {
+			t.Errorf("%s: detected %s", test.name, detection.Best)
+		}
+		if detection.Best == nil && len(detection.Diagnostics) == 0 {
+			t.Errorf("%s: no diagnostic telling why nothing was detected", test.name)
+		}
+	}
+
+	// Nothing but zeros has no valid spare anywhere
+	detection, err := DetectSettings(bytes.NewReader(make([]byte, len(data))), DetectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if detection.Best != nil {
+		t.Errorf("zeros: detected %s", detection.Best)
+	}
+}
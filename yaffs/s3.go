@@ -0,0 +1,316 @@
+package yaffs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Size of the ranges fetched from object storage and cached locally
+const S3_BLOCK_SIZE = 4 << 20
+
+const s3EmptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// An image in S3 compatible object storage, read with ranged GETs. Fetched
+// ranges are cached on disk, keyed by object and ETag, so repeated runs over
+// the same evidence do not download it again.
+//
+// Credentials and region are taken from AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN and AWS_REGION. AWS_ENDPOINT_URL
+// selects another S3 compatible service, addressed path style.
+type s3Object struct {
+	Bucket string
+	Key    string
+	Size   int64
+	ETag   string
+
+	client       *http.Client
+	endpoint     *url.URL
+	pathStyle    bool
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	cacheDir     string
+
+	mu        sync.Mutex
+	lastBlock int64
+	lastData  []byte
+}
+
+// Ranges are cached below cacheDir, the user cache directory if empty
+func openS3Object(path, cacheDir string) (*s3Object, error) {
+	u, err := url.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || key == "" {
+		return nil, fmt.Errorf("invalid S3 location %q, expected s3://bucket/key", path)
+	}
+
+	o := &s3Object{
+		Bucket:       u.Host,
+		Key:          key,
+		client:       &http.Client{Timeout: 5 * time.Minute},
+		region:       os.Getenv("AWS_REGION"),
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		lastBlock:    -1,
+	}
+	if o.region == "" {
+		o.region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if o.region == "" {
+		o.region = "us-east-1"
+	}
+
+	if endpoint := os.Getenv("AWS_ENDPOINT_URL"); endpoint != "" {
+		o.endpoint, err = url.Parse(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AWS_ENDPOINT_URL: %w", err)
+		}
+		o.pathStyle = true
+	} else {
+		o.endpoint = &url.URL{Scheme: "https", Host: fmt.Sprintf("%s.s3.%s.amazonaws.com", o.Bucket, o.region)}
+	}
+
+	response, err := o.do(http.MethodHead, "")
+	if err != nil {
+		return nil, err
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", path, response.Status)
+	}
+	o.Size = response.ContentLength
+	o.ETag = strings.Trim(response.Header.Get("ETag"), `"`)
+	if o.Size < 0 {
+		return nil, fmt.Errorf("%s: unknown object size", path)
+	}
+
+	o.cacheDir = cacheDir
+	if o.cacheDir == "" {
+		dir, err := os.UserCacheDir()
+		if err == nil {
+			o.cacheDir = filepath.Join(dir, "yaffsreader", "s3")
+		}
+	}
+
+	return o, nil
+}
+
+func (o *s3Object) ReadAt(buf []byte, off int64) (int, error) {
+	n := 0
+	for n < len(buf) {
+		pos := off + int64(n)
+		if pos >= o.Size {
+			return n, io.EOF
+		}
+		number := pos / S3_BLOCK_SIZE
+		data, err := o.block(number)
+		if err != nil {
+			return n, err
+		}
+		n += copy(buf[n:], data[pos-number*S3_BLOCK_SIZE:])
+	}
+	return n, nil
+}
+
+func (o *s3Object) Close() error {
+	return nil
+}
+
+// Fetch a block from the last read, the cache or the object storage
+func (o *s3Object) block(number int64) ([]byte, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if number == o.lastBlock {
+		return o.lastData, nil
+	}
+
+	start := number * S3_BLOCK_SIZE
+	length := minInt64(S3_BLOCK_SIZE, o.Size-start)
+
+	cachePath := o.cachePath(number)
+	data, err := os.ReadFile(cachePath)
+	if err != nil || int64(len(data)) != length {
+		data, err = o.fetch(start, length)
+		if err != nil {
+			return nil, err
+		}
+		o.store(cachePath, data)
+	}
+
+	o.lastBlock, o.lastData = number, data
+	return data, nil
+}
+
+func (o *s3Object) fetch(start, length int64) ([]byte, error) {
+	response, err := o.do(http.MethodGet, fmt.Sprintf("bytes=%d-%d", start, start+length-1))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusPartialContent && response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("s3://%s/%s: %s", o.Bucket, o.Key, response.Status)
+	}
+	// Servers ignoring the range send the whole object
+	if response.StatusCode == http.StatusOK && start > 0 {
+		_, err = io.CopyN(io.Discard, response.Body, start)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data := make([]byte, length)
+	_, err = io.ReadFull(response.Body, data)
+	if err != nil {
+		return nil, fmt.Errorf("s3://%s/%s at offset %d: %w", o.Bucket, o.Key, start, err)
+	}
+	return data, nil
+}
+
+func (o *s3Object) cachePath(number int64) string {
+	if o.cacheDir == "" {
+		return ""
+	}
+	id := sha256.Sum256([]byte(o.endpoint.String() + "\x00" + o.Bucket + "\x00" + o.Key + "\x00" + o.ETag))
+	return filepath.Join(o.cacheDir, hex.EncodeToString(id[:16]), fmt.Sprintf("%d", number))
+}
+
+// Caching is best effort, the data was already fetched
+func (o *s3Object) store(cachePath string, data []byte) {
+	if cachePath == "" {
+		return
+	}
+	err := os.MkdirAll(filepath.Dir(cachePath), 0700)
+	if err != nil {
+		return
+	}
+	temp, err := os.CreateTemp(filepath.Dir(cachePath), "partial-")
+	if err != nil {
+		return
+	}
+	_, err = temp.Write(data)
+	closeErr := temp.Close()
+	if err != nil || closeErr != nil {
+		os.Remove(temp.Name())
+		return
+	}
+	err = os.Rename(temp.Name(), cachePath)
+	if err != nil {
+		os.Remove(temp.Name())
+	}
+}
+
+func (o *s3Object) do(method, byteRange string) (*http.Response, error) {
+	u := *o.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + o.Key
+	if o.pathStyle {
+		u.Path = strings.TrimSuffix(o.endpoint.Path, "/") + "/" + o.Bucket + "/" + o.Key
+	}
+	u.RawPath = s3EscapePath(u.Path)
+
+	request, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if byteRange != "" {
+		request.Header.Set("Range", byteRange)
+	}
+	if o.accessKey != "" {
+		if o.secretKey == "" {
+			return nil, errors.New("AWS_ACCESS_KEY_ID given without AWS_SECRET_ACCESS_KEY")
+		}
+		o.sign(request, time.Now().UTC())
+	}
+
+	return o.client.Do(request)
+}
+
+// Sign a request with AWS signature version 4
+func (o *s3Object) sign(request *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", s3EmptyPayloadHash)
+	if o.sessionToken != "" {
+		request.Header.Set("X-Amz-Security-Token", o.sessionToken)
+	}
+
+	headers := map[string]string{"host": request.URL.Host}
+	for name, values := range request.Header {
+		name = strings.ToLower(name)
+		if name == "host" || strings.HasPrefix(name, "x-amz-") || name == "range" {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		s3EscapePath(request.URL.Path),
+		request.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		s3EmptyPayloadHash,
+	}, "\n")
+
+	scope := date + "/" + o.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + o.secretKey)
+	for _, part := range []string{date, o.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		o.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// URI encode every byte except unreserved characters and slashes
+func s3EscapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
@@ -0,0 +1,465 @@
+package yaffs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+type ScanResult struct {
+	Entries []ListEntry
+	Tree    *Tree
+
+	// Every chunk with valid tags, in physical order
+	Chunks        []ScanChunk
+	ChunkDataSize int
+	ByteOrder     binary.ByteOrder
+	PagesPerBlock int // as given, or the default if unknown
+
+	// Pages checked against their ECC while scanning, with bit errors
+	// corrected or beyond correction. Pages an index-only scan
+	// defers are checked when read, without being counted.
+	CorrectedPages     int
+	UncorrectablePages int
+
+	// Current data chunks by object and chunk ID, indexed on first use
+	indexOnce  sync.Once
+	dataChunks map[uint32]map[uint32]*ScanChunk
+
+	// Chunks of every object in log order, sorted on first use
+	logOnce sync.Once
+	logs    map[uint32][]*ScanChunk
+
+	// Image and geometry pages left in the image are read with, a nil
+	// source if the image allows no random access
+	source   io.ReaderAt
+	settings *Settings
+}
+
+type ScanChunk struct {
+	Index  int
+	Offset int64
+	Spare  *Yaffs2Spare
+	Data   []byte // nil for images with random access until loaded
+
+	// Replaced by a newer chunk of the same object and chunk ID, or data
+	// beyond the end of the file once a newer header shrank it
+	Obsolete bool
+}
+
+// Read the page of a chunk the scan left in the image and keep it
+func (r *ScanResult) LoadChunk(c *ScanChunk) error {
+	page, err := r.ChunkPage(c)
+	c.Data = page
+	return err
+}
+
+// Page of a chunk, read from the image without keeping it if the scan
+// left it there. Use LoadChunk to keep it.
+func (r *ScanResult) ChunkPage(c *ScanChunk) ([]byte, error) {
+	if c.Data != nil || r.source == nil {
+		return c.Data, nil
+	}
+	// Read along with the spare to correct the page with its ECC
+	size := r.settings.PageSize
+	if r.settings.ECC.correctable() {
+		size += r.settings.SpareSize
+	}
+	buf := getEmptyBuf(size)
+	_, err := r.source.ReadAt(buf, c.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("reading chunk %d at offset %d: %w", c.Index, c.Offset, err)
+	}
+	page := buf[:r.settings.PageSize]
+	r.settings.ECC.correctPage(page, buf[r.settings.PageSize:])
+	return page, nil
+}
+
+// Decode the object header held by a header chunk
+func (r *ScanResult) ChunkHeader(c *ScanChunk) (*ObjectHeader, error) {
+	page, err := r.ChunkPage(c)
+	if err != nil {
+		return nil, err
+	}
+	return r.settings.ParseHeader(page)
+}
+
+// Read every page / spare pair of the image and collect every object
+// header. Erased pairs are skipped, as blocks written later may follow
+// free pages and blocks. imageSize is only used for progress events, emit may be
+// nil.
+func ScanImage(image io.Reader, settings *Settings, imageSize int64, emit EventHandler) (*ScanResult, error) {
+	if emit == nil {
+		emit = func(Event) {}
+	}
+
+	chunkSize := int64(settings.PageSize + settings.SpareSize)
+
+	// Pages are read again when needed if the image allows random access,
+	// so only the tags and parsed headers stay in memory. Plain streams keep
+	// every page.
+	source, _ := image.(io.ReaderAt)
+	indexOnly := settings.IndexOnly && source != nil
+
+	// Next page / spare pair, erased if both are, false at the end of the
+	// image. Index-only scans read the page only if the spare is erased.
+	next := func(offset int64) (page, spare []byte, erased, ok bool) {
+		if indexOnly {
+			spareBuf := getEmptyBuf(settings.SpareSize)
+			_, err := source.ReadAt(spareBuf, offset+int64(settings.PageSize))
+			if err != nil {
+				return nil, nil, false, false
+			}
+			if !checkBlockEmpty(spareBuf) {
+				return nil, spareBuf, false, true
+			}
+			pageBuf := getEmptyBuf(settings.PageSize)
+			_, err = source.ReadAt(pageBuf, offset)
+			if err != nil {
+				return nil, nil, false, false
+			}
+			return pageBuf, spareBuf, checkBlockEmpty(pageBuf), true
+		}
+
+		pageBuf := getEmptyBuf(settings.PageSize)
+		_, err := io.ReadFull(image, pageBuf)
+		if err != nil {
+			return nil, nil, false, false
+		}
+		spareBuf := getEmptyBuf(settings.SpareSize)
+		_, err = io.ReadFull(image, spareBuf)
+		if err != nil {
+			return nil, nil, false, false
+		}
+		return pageBuf, spareBuf, checkBlockEmpty(pageBuf) && checkBlockEmpty(spareBuf), true
+	}
+
+	result := &ScanResult{
+		Tree:          newTree(),
+		ChunkDataSize: settings.PageSize,
+		ByteOrder:     settings.ByteOrder,
+		PagesPerBlock: settings.BlockPages(),
+		source:        source,
+		settings:      settings,
+	}
+	tree := result.Tree
+
+	// Object type per object and sequence number, to find headers of one
+	// block disagreeing about an object
+	type headerKey struct{ objectID, seqNumber uint32 }
+	headerTypes := make(map[headerKey]ObjectType)
+	// File size per header chunk index, to find truncated data chunks.
+	// Headers of deleted files record size zero and are left out, their
+	// data stays for recovery.
+	fileSizes := make(map[int]uint64)
+	// YAFFS1 objects known only from headers marked deleted
+	deletedOnly := make(map[uint32]bool)
+
+	k := 0
+	for ; !settings.stopped(); k++ {
+		offset := int64(k) * chunkSize
+		page, spareBuf, erased, ok := next(offset)
+		if !ok {
+			break
+		}
+		if k > 0 && k%PROGRESS_INTERVAL == 0 {
+			emit(Event{Type: EVENT_SCAN_PROGRESS, Chunk: k, Offset: offset, Total: imageSize})
+		}
+		if erased {
+			continue
+		}
+
+		spare, err := settings.decodeSpare(spareBuf, settings.SpareSkip)
+		if err != nil {
+			return nil, err
+		}
+		if spare != nil {
+			switch spare.TagsECC {
+			case TAGS_ECC_FIXED:
+				emit(Event{Type: EVENT_ANOMALY, Chunk: k, Offset: offset, Anomaly: ANOMALY_ECC_CORRECTED, ObjectID: spare.ObjectID,
+					Message: "Corrected a bit error in the tags"})
+			case TAGS_ECC_UNFIXED:
+				emit(Event{Type: EVENT_ANOMALY, Chunk: k, Offset: offset, Anomaly: ANOMALY_ECC_FAILURE,
+					Message: "Tags fail their ECC beyond correction, ignoring them"})
+				spare = nil
+			}
+		}
+		if spare != nil && page != nil {
+			switch settings.ECC.correctPage(page, spareBuf) {
+			case TAGS_ECC_FIXED:
+				result.CorrectedPages++
+				emit(Event{Type: EVENT_ANOMALY, Chunk: k, Offset: offset, Anomaly: ANOMALY_ECC_CORRECTED, ObjectID: spare.ObjectID,
+					Message: "Corrected bit errors in the page data"})
+			case TAGS_ECC_UNFIXED:
+				result.UncorrectablePages++
+				emit(Event{Type: EVENT_ANOMALY, Chunk: k, Offset: offset, Anomaly: ANOMALY_ECC_FAILURE, ObjectID: spare.ObjectID,
+					Message: "Page data fails its ECC beyond correction, reading it as is"})
+			}
+		}
+
+		// Headers and pages without valid tags are always read
+		chunk := ScanChunk{Index: k, Offset: offset, Spare: spare, Data: page}
+		if spare == nil || spare.ChunkID == 0 {
+			err = result.LoadChunk(&chunk)
+			if err != nil {
+				return nil, err
+			}
+			page = chunk.Data
+		}
+
+		if spare == nil {
+			switch settings.SparePolicy {
+			case SPARE_POLICY_ABORT:
+				return nil, fmt.Errorf("invalid spare of chunk %d at offset %d", k, offset)
+
+			case SPARE_POLICY_HEADER:
+				if !LooksLikeHeader(page, settings.ByteOrder) {
+					break
+				}
+				header, err := settings.ParseHeader(page)
+				if err != nil {
+					return nil, err
+				}
+				// Without tags the object ID is unknown, so the header is
+				// listed but not part of the tree
+				emit(Event{Type: EVENT_ANOMALY, Chunk: k, Offset: offset, Anomaly: ANOMALY_INVALID_SPARE, Message: "Invalid spare, object header found by signature"})
+				result.Entries = append(result.Entries, ListEntry{Header: header})
+				continue
+
+			case SPARE_POLICY_ALTERNATE:
+				var skip int
+				spare, skip, err = alternateSpare(spareBuf, settings)
+				if err != nil {
+					return nil, err
+				}
+				if spare != nil {
+					chunk.Spare = spare
+					emit(Event{Type: EVENT_ANOMALY, Chunk: k, Offset: offset, Anomaly: ANOMALY_INVALID_SPARE, ObjectID: spare.ObjectID,
+						Message: fmt.Sprintf("Invalid spare, valid tags found at spare offset %d", skip)})
+				}
+			}
+		}
+
+		if spare == nil {
+			emit(Event{Type: EVENT_ANOMALY, Chunk: k, Offset: offset, Anomaly: ANOMALY_INVALID_SPARE, Message: "Invalid spare, skipping page"})
+			continue
+		}
+
+		// Tags of data chunks never carry a zero byte count, so a page that
+		// looks like a header is one whose chunk ID was damaged
+		if spare.ChunkID != 0 && spare.NumberBytes == 0 {
+			err = result.LoadChunk(&chunk)
+			if err != nil {
+				return nil, err
+			}
+			page = chunk.Data
+			if LooksLikeHeader(page, settings.ByteOrder) {
+				emit(Event{Type: EVENT_ANOMALY, Chunk: k, Offset: offset, Anomaly: ANOMALY_AMBIGUOUS_TAGS, ObjectID: spare.ObjectID,
+					Message: fmt.Sprintf("Tags mark an empty data chunk %d, but the page is an object header", spare.ChunkID)})
+				repaired := *spare
+				repaired.ChunkID = 0
+				spare = &repaired
+				chunk.Spare = spare
+			}
+		}
+
+		// Trust header tags only if the page has the layout of a header.
+		// The name is not judged here, it only breaks ties above when the
+		// tags are ambiguous.
+		mismatch := ""
+		if spare.ChunkID == 0 {
+			mismatch = headerLayoutMismatch(page, settings.ByteOrder)
+		}
+		if mismatch != "" && !spare.ExtraValid {
+			emit(Event{Type: EVENT_ANOMALY, Chunk: k, Offset: offset, Anomaly: ANOMALY_AMBIGUOUS_TAGS, ObjectID: spare.ObjectID,
+				Message: fmt.Sprintf("Tags mark an object header, but the page is none (%s), skipping", mismatch)})
+			continue
+		}
+
+		if source != nil {
+			chunk.Data = nil
+		}
+		result.Chunks = append(result.Chunks, chunk)
+
+		if spare.ChunkID == 0 {
+			if mismatch != "" {
+				// Tags still tell type and parent of the lost header
+				emit(Event{Type: EVENT_ANOMALY, Chunk: k, Offset: offset, Anomaly: ANOMALY_CHECKSUM_MISMATCH, ObjectID: spare.ObjectID,
+					Message: fmt.Sprintf("Invalid header (%s), using extra header information from tags", mismatch)})
+				tree.AddTags(spare)
+				continue
+			}
+
+			// This page contains a header to parse
+			header, err := settings.ParseHeader(page)
+			if err != nil {
+				return nil, err
+			}
+
+			key := headerKey{spare.ObjectID, spare.SeqNumber}
+			if objectType, ok := headerTypes[key]; ok && objectType != header.ObjectType {
+				emit(Event{Type: EVENT_ANOMALY, Chunk: k, Offset: offset, Anomaly: ANOMALY_CONFLICT, ObjectID: spare.ObjectID,
+					Message: fmt.Sprintf("Conflicting headers for object %d in sequence %d: %s and %s", spare.ObjectID, spare.SeqNumber, objectType, header.ObjectType)})
+			}
+			headerTypes[key] = header.ObjectType
+			deleted := header.ParentObjectID == YAFFS_OBJECTID_DELETED || header.ParentObjectID == YAFFS_OBJECTID_UNLINKED
+			if header.ObjectType == YAFFS_OBJECT_TYPE_FILE && !deleted {
+				fileSizes[k] = header.FileSize(settings.ByteOrder)
+			}
+			if size := header.FileSize(settings.ByteOrder); header.ObjectType == YAFFS_OBJECT_TYPE_FILE && size > result.maxFileSize() {
+				emit(Event{Type: EVENT_ANOMALY, Chunk: k, Offset: offset, Anomaly: ANOMALY_FILE_SIZE, ObjectID: spare.ObjectID,
+					Message: fmt.Sprintf("File size %d beyond the largest YAFFS file of %d bytes, content ends at the last chunk found", size, result.maxFileSize())})
+			}
+
+			entry := ListEntry{ObjectID: spare.ObjectID, Header: header, SeqNumber: spare.SeqNumber}
+			result.Entries = append(result.Entries, entry)
+			switch {
+			case !settings.Yaffs1:
+				tree.AddHeader(spare.ObjectID, spare.SeqNumber, header)
+			case !spare.Deleted || deletedOnly[spare.ObjectID] || tree.Objects[spare.ObjectID] == nil:
+				// YAFFS1 marks replaced headers deleted instead of
+				// writing newer sequence numbers
+				tree.AddHeader(spare.ObjectID, spare.SeqNumber, header)
+				deletedOnly[spare.ObjectID] = spare.Deleted
+			}
+
+			metadata := entry.Metadata(settings.ByteOrder)
+			emit(Event{Type: EVENT_OBJECT, Chunk: k, Offset: offset, Object: &metadata})
+		}
+	}
+
+	if settings.stopped() {
+		settings.logger().Printf("Scan interrupted at offset %d of %d, results cover only the chunks read", int64(k)*chunkSize, imageSize)
+	}
+	settings.logger().Printf("Read %d chunks", k)
+	if result.CorrectedPages > 0 || result.UncorrectablePages > 0 {
+		settings.logger().Printf("Page ECC: %d pages corrected, %d uncorrectable", result.CorrectedPages, result.UncorrectablePages)
+	}
+
+	// YAFFS1 deletes objects without data by marking their header deleted
+	// rather than writing one under the deleted directory
+	for id, deleted := range deletedOnly {
+		if !deleted {
+			continue
+		}
+		object := tree.Objects[id]
+		header := *object.Header
+		header.ParentObjectID = YAFFS_OBJECTID_DELETED
+		for j := range result.Entries {
+			if result.Entries[j].Header == object.Header {
+				result.Entries[j].Header = &header
+			}
+		}
+		object.Header = &header
+	}
+
+	if settings.PagesPerBlock > 0 {
+		checkBlockSequences(result.Chunks, settings.PagesPerBlock, emit)
+	}
+	markObsolete(result.Chunks, fileSizes, result.ChunkDataSize, settings.Yaffs1)
+
+	// Data chunks of objects without any header, reported once per object
+	orphans := make(map[uint32]bool)
+	for _, chunk := range result.Chunks {
+		id := chunk.Spare.ObjectID
+		if _, ok := tree.Objects[id]; ok || chunk.Spare.ChunkID == 0 || orphans[id] {
+			continue
+		}
+		orphans[id] = true
+		emit(Event{Type: EVENT_ANOMALY, Chunk: chunk.Index, Offset: chunk.Offset, Anomaly: ANOMALY_ORPHAN, ObjectID: id,
+			Message: fmt.Sprintf("Data chunks of object %d without any header", id)})
+	}
+
+	for _, id := range tree.SortedIDs() {
+		if object := tree.Objects[id]; object.Synthesized {
+			result.Entries = append(result.Entries, ListEntry{ObjectID: object.ID, Header: object.Header, SeqNumber: object.SeqNumber})
+		}
+	}
+	for _, placeholder := range tree.SynthesizeParents() {
+		emit(Event{Type: EVENT_ANOMALY, Chunk: -1, Offset: -1, Anomaly: ANOMALY_ORPHAN, ObjectID: placeholder.ID,
+			Message: fmt.Sprintf("Synthesized placeholder directory for missing object %d", placeholder.ID)})
+		result.Entries = append(result.Entries, ListEntry{ObjectID: placeholder.ID, Header: placeholder.Header})
+	}
+	for k := range result.Entries {
+		entry := &result.Entries[k]
+		entry.Path = tree.HeaderPath(entry.Header)
+		if object, ok := tree.Objects[entry.ObjectID]; ok && entry.ObjectID != 0 {
+			entry.Obsolete = object.Header != entry.Header
+		}
+	}
+
+	emit(Event{Type: EVENT_SCAN_DONE, Chunk: k, Offset: int64(k) * chunkSize, Total: imageSize})
+
+	return result, nil
+}
+
+// Mark superseded and truncated chunks obsolete, as the scan and the chunk
+// map both do
+func markObsolete(chunks []ScanChunk, fileSizes map[int]uint64, chunkDataSize int, yaffs1 bool) {
+	if yaffs1 {
+		markObsoleteYaffs1(chunks)
+	} else {
+		markObsoleteYaffs2(chunks, fileSizes, chunkDataSize)
+	}
+}
+
+// Mount semantics of YAFFS2: blocks ordered by sequence number and scanned
+// backwards, so the first chunk seen for an object and chunk ID is the
+// current one. Data chunks at or beyond the size of a newer file header
+// were truncated away.
+func markObsoleteYaffs2(chunks []ScanChunk, fileSizes map[int]uint64, chunkDataSize int) {
+	order := make([]*ScanChunk, len(chunks))
+	for k := range chunks {
+		order[k] = &chunks[k]
+	}
+	sort.Slice(order, func(i, j int) bool {
+		a, b := order[i], order[j]
+		if a.Spare.SeqNumber != b.Spare.SeqNumber {
+			return a.Spare.SeqNumber > b.Spare.SeqNumber
+		}
+		return a.Index > b.Index
+	})
+
+	seen := make(map[chunkKey]bool)
+	// Smallest size of the file headers seen so far per object
+	minSizes := make(map[uint32]uint64)
+
+	for _, chunk := range order {
+		id := chunk.Spare.ObjectID
+		key := chunkKey{ObjectID: id, ChunkID: chunk.Spare.ChunkID}
+		chunk.Obsolete = seen[key]
+		seen[key] = true
+
+		if chunk.Spare.ChunkID == 0 {
+			size, ok := fileSizes[chunk.Index]
+			if min, known := minSizes[id]; ok && (!known || size < min) {
+				minSizes[id] = size
+			}
+			continue
+		}
+		if min, ok := minSizes[id]; ok && uint64(chunk.Spare.ChunkID-1)*uint64(chunkDataSize) >= min {
+			chunk.Obsolete = true
+		}
+	}
+}
+
+// Report blocks whose chunks carry different sequence numbers, which points
+// to a wrong layout, interleaving or a torn dump
+func checkBlockSequences(chunks []ScanChunk, pagesPerBlock int, emit EventHandler) {
+	for k := 1; k < len(chunks); k++ {
+		first, chunk := &chunks[k-1], &chunks[k]
+		if first.Index/pagesPerBlock != chunk.Index/pagesPerBlock || first.Spare.SeqNumber == chunk.Spare.SeqNumber {
+			continue
+		}
+		block := chunk.Index / pagesPerBlock
+		emit(Event{Type: EVENT_ANOMALY, Chunk: chunk.Index, Offset: chunk.Offset, Anomaly: ANOMALY_BLOCK_SEQUENCE,
+			Message: fmt.Sprintf("Block %d mixes sequence numbers %d and %d", block, first.Spare.SeqNumber, chunk.Spare.SeqNumber)})
+
+		// One report per block
+		for k+1 < len(chunks) && chunks[k+1].Index/pagesPerBlock == block {
+			k++
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package yaffs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// Pages per block of the synthesized images, the block size detection
+// checks by default
+const testBlockPages = 32
+
+// Image written chunk by chunk the way mkyaffs2image lays it out: packed
+// tags with their ECC at the spare skip, a new sequence number for every
+// block and data chunks ahead of the header that records them
+type testImage struct {
+	settings *Settings
+	ecc      *ECCScheme // Hamming codes of every page written to the spares if set
+
+	buf     bytes.Buffer
+	chunks  int
+	seq     uint32
+	nextSeq uint32
+}
+
+func newTestImage(settings *Settings) *testImage {
+	return &testImage{settings: settings, nextSeq: YAFFS_LOWEST_SEQUENCE_NUMBER}
+}
+
+func testSettings() *Settings {
+	return &Settings{PageSize: 2048, SpareSize: 64, ByteOrder: binary.LittleEndian, PagesPerBlock: testBlockPages}
+}
+
+// Fill the current block with erased chunks and give the next one seq
+func (t *testImage) block(seq uint32) {
+	for t.chunks%testBlockPages != 0 {
+		t.erased()
+	}
+	t.nextSeq = seq
+}
+
+func (t *testImage) erased() {
+	t.buf.Write(bytes.Repeat([]byte{0xFF}, t.settings.PageSize+t.settings.SpareSize))
+	t.chunks++
+}
+
+// Write a page with tags, returning its chunk index
+func (t *testImage) chunk(objectID, chunkID, numberBytes uint32, data []byte) int {
+	if t.chunks%testBlockPages == 0 {
+		t.seq = t.nextSeq
+		t.nextSeq++
+	}
+
+	page := bytes.Repeat([]byte{0xFF}, t.settings.PageSize)
+	copy(page, data)
+	spare := bytes.Repeat([]byte{0xFF}, t.settings.SpareSize)
+	order, skip := t.settings.TagOrder(), t.settings.SpareSkip
+
+	tags := spare[skip : skip+16]
+	order.PutUint32(tags[0:], t.seq)
+	order.PutUint32(tags[4:], objectID)
+	order.PutUint32(tags[8:], chunkID)
+	order.PutUint32(tags[12:], numberBytes)
+	ecc := calcTagsECC(tags)
+	spare[skip+16] = ecc.colParity
+	copy(spare[skip+17:skip+20], []byte{0, 0, 0})
+	order.PutUint32(spare[skip+20:], ecc.lineParity)
+	order.PutUint32(spare[skip+24:], ecc.lineParityPrime)
+
+	if t.ecc != nil {
+		for step := 0; step*t.ecc.StepSize < len(page); step++ {
+			code := hammingECC(page[step*t.ecc.StepSize : (step+1)*t.ecc.StepSize])
+			copy(spare[t.ecc.Offset+step*t.ecc.BytesPerStep:], code[:])
+		}
+	}
+
+	t.buf.Write(page)
+	t.buf.Write(spare)
+	t.chunks++
+	return t.chunks - 1
+}
+
+func (t *testImage) header(id, parent uint32, objectType ObjectType, name string, size uint32, alias string, equivID int32) int {
+	header := SynthesizeHeader(objectType, parent, name)
+	switch objectType {
+	case YAFFS_OBJECT_TYPE_FILE, YAFFS_OBJECT_TYPE_HARDLINK:
+		header.Mode = 0100644
+	case YAFFS_OBJECT_TYPE_SYMLINK:
+		header.Mode = 0120777
+	}
+	header.ModTime, header.AccessTime = 1600000000, 1600000000
+	t.settings.ByteOrder.PutUint32(header.FileSizeLow[:], size)
+	copy(header.Alias[:], alias)
+	header.EquivID = equivID
+
+	var page bytes.Buffer
+	err := binary.Write(&page, t.settings.ByteOrder, header)
+	if err != nil {
+		panic(err)
+	}
+	return t.chunk(id, 0, 0, page.Bytes())
+}
+
+// Write the data chunks of a file and then its header, returning the
+// chunk index of the first data chunk
+func (t *testImage) file(id, parent uint32, name string, content []byte) int {
+	first := t.chunks
+	for chunkID := 1; (chunkID-1)*t.settings.PageSize < len(content); chunkID++ {
+		data := content[(chunkID-1)*t.settings.PageSize:]
+		if len(data) > t.settings.PageSize {
+			data = data[:t.settings.PageSize]
+		}
+		t.chunk(id, uint32(chunkID), uint32(len(data)), data)
+	}
+	t.header(id, parent, YAFFS_OBJECT_TYPE_FILE, name, uint32(len(content)), "", 0)
+	return first
+}
+
+// The image up to the end of the last block
+func (t *testImage) bytes() []byte {
+	t.block(t.nextSeq)
+	return t.buf.Bytes()
+}
+
+func scanTestImage(t *testing.T, data []byte, settings *Settings) (*ScanResult, []Event) {
+	t.Helper()
+	var events []Event
+	result, err := ScanImage(bytes.NewReader(data), settings, int64(len(data)), func(e Event) {
+		events = append(events, e)
+	})
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	return result, events
+}
+
+// Content of every live file by path
+func liveFiles(t *testing.T, result *ScanResult) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	for id, object := range result.Tree.Objects {
+		if object.Header.ObjectType != YAFFS_OBJECT_TYPE_FILE || result.Tree.Deleted(id) {
+			continue
+		}
+		content, err := result.ObjectContent(id)
+		if err != nil {
+			t.Fatalf("content of object %d: %v", id, err)
+		}
+		files[result.Tree.Path(id)] = string(content)
+	}
+	return files
+}
+
+var scanTests = []struct {
+	name     string
+	build    func(*testImage)
+	files    map[string]string
+	obsolete int
+}{
+	{
+		name: "rewritten file",
+		build: func(img *testImage) {
+			img.file(300, YAFFS_OBJECTID_ROOT, "a", []byte("first"))
+			img.file(300, YAFFS_OBJECTID_ROOT, "a", []byte("second version"))
+		},
+		files:    map[string]string{"/a": "second version"},
+		obsolete: 2,
+	},
+	{
+		name: "newer block first",
+		build: func(img *testImage) {
+			img.block(0x2000)
+			img.file(300, YAFFS_OBJECTID_ROOT, "a", []byte("newer"))
+			img.block(0x1000)
+			img.file(300, YAFFS_OBJECTID_ROOT, "a", []byte("older"))
+		},
+		files:    map[string]string{"/a": "newer"},
+		obsolete: 2,
+	},
+	{
+		name: "renamed file",
+		build: func(img *testImage) {
+			img.file(300, YAFFS_OBJECTID_ROOT, "a", []byte("content"))
+			img.header(300, YAFFS_OBJECTID_ROOT, YAFFS_OBJECT_TYPE_FILE, "b", 7, "", 0)
+		},
+		files:    map[string]string{"/b": "content"},
+		obsolete: 1,
+	},
+	{
+		name: "shrunk file",
+		build: func(img *testImage) {
+			img.file(300, YAFFS_OBJECTID_ROOT, "a", bytes.Repeat([]byte("x"), 5000))
+			img.header(300, YAFFS_OBJECTID_ROOT, YAFFS_OBJECT_TYPE_FILE, "a", 100, "", 0)
+		},
+		files:    map[string]string{"/a": string(bytes.Repeat([]byte("x"), 100))},
+		obsolete: 3,
+	},
+	{
+		name: "deleted file",
+		build: func(img *testImage) {
+			img.file(300, YAFFS_OBJECTID_ROOT, "a", []byte("gone"))
+			img.file(301, YAFFS_OBJECTID_ROOT, "b", []byte("kept"))
+			img.header(300, YAFFS_OBJECTID_DELETED, YAFFS_OBJECT_TYPE_FILE, "a", 0, "", 0)
+		},
+		files:    map[string]string{"/b": "kept"},
+		obsolete: 1,
+	},
+	{
+		name: "file in directory",
+		build: func(img *testImage) {
+			img.header(299, YAFFS_OBJECTID_ROOT, YAFFS_OBJECT_TYPE_DIRECTORY, "dir", 0, "", 0)
+			img.file(300, 299, "a", bytes.Repeat([]byte("0123456789abcdef"), 300))
+		},
+		files: map[string]string{"/dir/a": string(bytes.Repeat([]byte("0123456789abcdef"), 300))},
+	},
+}
+
+func TestScanKeepsNewestChunks(t *testing.T) {
+	for _, test := range scanTests {
+		img := newTestImage(testSettings())
+		test.build(img)
+		result, _ := scanTestImage(t, img.bytes(), img.settings)
+
+		files := liveFiles(t, result)
+		if len(files) != len(test.files) {
+			t.Errorf("%s: files %q, want %q", test.name, files, test.files)
+		}
+		for path, want := range test.files {
+			if files[path] != want {
+				t.Errorf("%s: %s holds %q, want %q", test.name, path, files[path], want)
+			}
+		}
+
+		obsolete := 0
+		for _, chunk := range result.Chunks {
+			if chunk.Obsolete {
+				obsolete++
+			}
+		}
+		if obsolete != test.obsolete {
+			t.Errorf("%s: %d obsolete chunks, want %d", test.name, obsolete, test.obsolete)
+		}
+	}
+}
+
+func TestScanOrphans(t *testing.T) {
+	img := newTestImage(testSettings())
+	img.chunk(300, 1, 2048, bytes.Repeat([]byte("lost"), 512))
+	img.chunk(300, 2, 4, []byte("lost"))
+	result, events := scanTestImage(t, img.bytes(), img.settings)
+
+	if _, ok := result.Tree.Objects[300]; ok {
+		t.Error("object 300 in the tree without a header")
+	}
+	if chunks := result.DataChunks(300); len(chunks) != 2 {
+		t.Errorf("%d data chunks of object 300, want 2", len(chunks))
+	}
+	anomalies := 0
+	for _, e := range events {
+		if e.Type == EVENT_ANOMALY && e.Anomaly == ANOMALY_ORPHAN && e.ObjectID == 300 {
+			anomalies++
+		}
+	}
+	if anomalies != 1 {
+		t.Errorf("%d orphan anomalies for object 300, want 1", anomalies)
+	}
+}
+
+var spaceTests = []struct {
+	name  string
+	build func(*testImage)
+	want  SpaceReport
+}{
+	{
+		name: "rewritten file",
+		build: func(img *testImage) {
+			img.file(300, YAFFS_OBJECTID_ROOT, "a", []byte("first"))
+			img.file(300, YAFFS_OBJECTID_ROOT, "a", []byte("second"))
+		},
+		want: SpaceReport{Total: 32, UsedHeaders: 1, UsedData: 1, Obsolete: 2, Erased: 28},
+	},
+	{
+		name: "deleted file",
+		build: func(img *testImage) {
+			img.file(300, YAFFS_OBJECTID_ROOT, "a", bytes.Repeat([]byte("x"), 3000))
+			img.header(300, YAFFS_OBJECTID_DELETED, YAFFS_OBJECT_TYPE_FILE, "a", 0, "", 0)
+		},
+		want: SpaceReport{Total: 32, Obsolete: 4, Erased: 28},
+	},
+	{
+		name: "orphaned data",
+		build: func(img *testImage) {
+			img.chunk(300, 1, 4, []byte("lost"))
+		},
+		want: SpaceReport{Total: 32, Orphaned: 1, Erased: 31},
+	},
+	{
+		name: "invalid and bad spares",
+		build: func(img *testImage) {
+			img.file(300, YAFFS_OBJECTID_ROOT, "a", []byte("data"))
+			img.block(YAFFS_SEQUENCE_BAD_BLOCK)
+			img.chunk(301, 1, 4, []byte("bad"))
+			img.block(0)
+			img.chunk(302, 1, 4, []byte("zero"))
+		},
+		want: SpaceReport{Total: 96, UsedHeaders: 1, UsedData: 1, Erased: 92, Invalid: 1, Bad: 1},
+	},
+}
+
+func TestAccountSpace(t *testing.T) {
+	for _, test := range spaceTests {
+		img := newTestImage(testSettings())
+		test.build(img)
+		report, err := AccountSpace(bytes.NewReader(img.bytes()), img.settings)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		test.want.ChunkDataSize = img.settings.PageSize
+		if *report != test.want {
+			t.Errorf("%s: %+v, want %+v", test.name, *report, test.want)
+		}
+		if report.Free() != test.want.Erased+test.want.Obsolete {
+			t.Errorf("%s: %d free chunks, want %d", test.name, report.Free(), test.want.Erased+test.want.Obsolete)
+		}
+	}
+}
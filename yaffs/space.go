@@ -0,0 +1,198 @@
+package yaffs
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+type SpaceReport struct {
+	ChunkDataSize int
+
+	Total       int
+	UsedHeaders int
+	UsedData    int
+	Orphaned    int // data chunks of objects without any header
+	Obsolete    int // superseded, truncated away or deleted
+	Erased      int
+	Invalid     int
+	Bad         int
+}
+
+// Free space as YAFFS sees it: erased chunks plus obsolete chunks garbage
+// collection can reclaim
+func (s *SpaceReport) Free() int {
+	return s.Erased + s.Obsolete
+}
+
+type chunkKey struct {
+	ObjectID uint32
+	ChunkID  uint32
+}
+
+// Classify every chunk of the image, counting erased chunks along with
+// the ones in use. Superseded and truncated chunks are found as by the
+// scan, so the space report and the listing agree on the live chunks.
+func MapChunks(image io.ReadSeeker, settings *Settings) (*ChunkMap, error) {
+
+	_, err := image.Seek(0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	chunkMap := &ChunkMap{Settings: settings}
+
+	var chunks []ScanChunk
+	fileSizes := make(map[int]uint64)
+	// Header chunks putting their object under the deleted or unlinked
+	// directory
+	deletedHeaders := make(map[int]bool)
+
+	it := NewChunkIterator(image, settings)
+	for it.Next() {
+		raw := it.Chunk()
+		index, spare := raw.Index, raw.Spare
+
+		if raw.Erased {
+			chunkMap.add(CHUNK_ERASED, nil)
+			continue
+		}
+		if spare == nil {
+			if badBlockMarked(raw.SpareData, settings) {
+				chunkMap.add(CHUNK_BAD, nil)
+			} else {
+				chunkMap.add(CHUNK_INVALID, nil)
+			}
+			continue
+		}
+
+		// Classified once all chunks are known
+		chunkMap.add("", spare)
+		chunks = append(chunks, ScanChunk{Index: index, Spare: spare})
+
+		if spare.ChunkID == 0 {
+			header, err := settings.ParseHeader(raw.Data)
+			if err != nil {
+				return nil, err
+			}
+			deleted := header.ParentObjectID == YAFFS_OBJECTID_DELETED || header.ParentObjectID == YAFFS_OBJECTID_UNLINKED
+			deletedHeaders[index] = deleted
+			if header.ObjectType == YAFFS_OBJECT_TYPE_FILE && !deleted {
+				fileSizes[index] = header.FileSize(settings.ByteOrder)
+			}
+		}
+	}
+	if it.Err() != nil {
+		return nil, it.Err()
+	}
+
+	markObsolete(chunks, fileSizes, settings.PageSize, settings.Yaffs1)
+
+	// Whether the current header of each object is deleted
+	deleted := make(map[uint32]bool)
+	for _, chunk := range chunks {
+		if chunk.Spare.ChunkID == 0 && !chunk.Obsolete {
+			deleted[chunk.Spare.ObjectID] = deletedHeaders[chunk.Index]
+		}
+	}
+
+	for _, chunk := range chunks {
+		isDeleted, ok := deleted[chunk.Spare.ObjectID]
+
+		var class ChunkClass
+		switch {
+		case chunk.Obsolete, isDeleted:
+			class = CHUNK_OBSOLETE
+		case chunk.Spare.ChunkID == 0:
+			class = CHUNK_HEADER
+		case !ok:
+			class = CHUNK_ORPHAN
+		default:
+			class = CHUNK_DATA
+		}
+		chunkMap.Classes[chunk.Index] = class
+	}
+
+	return chunkMap, nil
+}
+
+func (m *ChunkMap) add(class ChunkClass, spare *Yaffs2Spare) {
+	m.Classes = append(m.Classes, class)
+	m.Spares = append(m.Spares, spare)
+}
+
+// A chunk is bad if its tags carry the sequence number YAFFS writes to bad
+// blocks it failed to mark, or if the factory bad block marker in front of
+// the tags is set, or the marker bytes of an OOB profile. YAFFS1 has a
+// block status byte of its own.
+func badBlockMarked(spareBuf []byte, settings *Settings) bool {
+	if settings.Yaffs1 {
+		return yaffs1BlockBad(spareBuf)
+	}
+	if settings.BadBlockMarker != nil {
+		for _, b := range settings.BadBlockMarker {
+			if b < len(spareBuf) && spareBuf[b] != 0xFF {
+				return true
+			}
+		}
+	} else if settings.SpareSkip > 0 && spareBuf[0] != 0xFF {
+		return true
+	}
+	if len(spareBuf) < settings.SpareSkip+4 {
+		return false
+	}
+	return settings.TagOrder().Uint32(spareBuf[settings.SpareSkip:]) == YAFFS_SEQUENCE_BAD_BLOCK
+}
+
+func AccountSpace(image io.ReadSeeker, settings *Settings) (*SpaceReport, error) {
+	chunkMap, err := MapChunks(image, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &SpaceReport{ChunkDataSize: settings.PageSize, Total: len(chunkMap.Classes)}
+	for _, class := range chunkMap.Classes {
+		switch class {
+		case CHUNK_HEADER:
+			report.UsedHeaders++
+		case CHUNK_DATA:
+			report.UsedData++
+		case CHUNK_ORPHAN:
+			report.Orphaned++
+		case CHUNK_OBSOLETE:
+			report.Obsolete++
+		case CHUNK_ERASED:
+			report.Erased++
+		case CHUNK_INVALID:
+			report.Invalid++
+		case CHUNK_BAD:
+			report.Bad++
+		}
+	}
+	return report, nil
+}
+
+func (s *SpaceReport) Write(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+
+	row := func(name string, chunks int) {
+		percent := 0.0
+		if s.Total > 0 {
+			percent = float64(chunks) * 100 / float64(s.Total)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f%%\t\n", name, chunks, int64(chunks)*int64(s.ChunkDataSize), percent)
+	}
+
+	fmt.Fprintln(tw, "\tCHUNKS\tBYTES\tSHARE\t")
+	row("Used (headers)", s.UsedHeaders)
+	row("Used (data)", s.UsedData)
+	row("Used (orphaned data)", s.Orphaned)
+	row("Obsolete", s.Obsolete)
+	row("Erased", s.Erased)
+	row("Invalid", s.Invalid)
+	row("Bad", s.Bad)
+	row("Total", s.Total)
+	row("Free (erased + obsolete)", s.Free())
+
+	return tw.Flush()
+}
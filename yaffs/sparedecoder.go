@@ -0,0 +1,101 @@
+package yaffs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// Turns the raw spare area of a chunk into tags. Decoders return nil tags
+// without error for spares holding no valid tags.
+type SpareDecoder interface {
+	DecodeSpare(spareBuf []byte, spareSkip int, byteOrder binary.ByteOrder) (*Yaffs2Spare, error)
+}
+
+// Default decoder for yaffs_packed_tags2 stored contiguously at the spare
+// skip. The ECC following the tags is checked if one was written.
+type PackedTags2Decoder struct{}
+
+func (PackedTags2Decoder) DecodeSpare(spareBuf []byte, spareSkip int, byteOrder binary.ByteOrder) (*Yaffs2Spare, error) {
+	tagsSize := binary.Size(Yaffs2SpareRaw{})
+	// Too small for the tags, like the 16 byte spares of YAFFS1 with a skip
+	if len(spareBuf) < spareSkip+tagsSize {
+		return nil, nil
+	}
+	tags := spareBuf[spareSkip : spareSkip+tagsSize]
+
+	result := TAGS_ECC_NONE
+	if len(spareBuf) >= spareSkip+tagsSize+PACKED_TAGS2_ECC_SIZE {
+		stored := readTagsECC(spareBuf[spareSkip+tagsSize:], byteOrder)
+		if stored.plausible(tagsSize) {
+			tags = append([]byte(nil), tags...)
+			result = correctTagsECC(tags, stored)
+		}
+	}
+
+	spareRaw := &Yaffs2SpareRaw{}
+	err := binary.Read(bytes.NewReader(tags), byteOrder, spareRaw)
+	if err != nil {
+		return nil, err
+	}
+	spare := spareRaw.Parse()
+	if spare != nil {
+		spare.TagsECC = result
+	}
+	return spare, nil
+}
+
+// Tags fields at independent offsets, ignoring the spare skip
+func (l *SpareLayout) DecodeSpare(spareBuf []byte, spareSkip int, byteOrder binary.ByteOrder) (*Yaffs2Spare, error) {
+	spareRaw, err := l.Read(spareBuf, byteOrder)
+	if err != nil {
+		return nil, err
+	}
+	return spareRaw.Parse(), nil
+}
+
+// Gathers the tag bytes of a spare map before handing them to another decoder
+type MappedSpareDecoder struct {
+	Map     SpareMap
+	Decoder SpareDecoder
+}
+
+func (m *MappedSpareDecoder) Size() int {
+	return m.Map.Size()
+}
+
+func (m *MappedSpareDecoder) DecodeSpare(spareBuf []byte, spareSkip int, byteOrder binary.ByteOrder) (*Yaffs2Spare, error) {
+	tags, err := m.Map.Gather(spareBuf)
+	if err != nil {
+		return nil, err
+	}
+	return m.Decoder.DecodeSpare(tags, 0, byteOrder)
+}
+
+// Decoders selectable with -spare-decoder
+var spareDecoders = map[string]SpareDecoder{
+	"packed-tags2": PackedTags2Decoder{},
+}
+
+// Make a vendor specific decoder selectable by name
+func RegisterSpareDecoder(name string, decoder SpareDecoder) {
+	spareDecoders[name] = decoder
+}
+
+func SpareDecoderNames() []string {
+	var names []string
+	for name := range spareDecoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func LookupSpareDecoder(name string) (SpareDecoder, error) {
+	decoder, ok := spareDecoders[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown spare decoder %q, expected one of %v", name, SpareDecoderNames())
+	}
+	return decoder, nil
+}
@@ -0,0 +1,117 @@
+package yaffs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Independent byte offsets of the packed tags fields within the spare, for
+// controllers scattering them around ECC bytes. Mirrors the spare_*_offset
+// keys of the TSK yaffs2 config. Given as the spare decoder, it replaces
+// the contiguous tags at the spare skip.
+type SpareLayout struct {
+	SeqNumberOffset   int
+	ObjectIDOffset    int
+	ChunkIDOffset     int
+	NumberBytesOffset int
+}
+
+// Parse "SEQ,OBJ,CHUNK,NBYTES" byte offsets
+func ParseSpareLayout(s string) (*SpareLayout, error) {
+	fields := strings.Split(s, ",")
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("invalid spare offsets %q, expected SEQ,OBJ,CHUNK,NBYTES", s)
+	}
+	var offsets [4]int
+	for i, field := range fields {
+		offset, err := strconv.ParseUint(strings.TrimSpace(field), 0, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid spare offset %q: %v", field, err)
+		}
+		offsets[i] = int(offset)
+	}
+	return &SpareLayout{
+		SeqNumberOffset:   offsets[0],
+		ObjectIDOffset:    offsets[1],
+		ChunkIDOffset:     offsets[2],
+		NumberBytesOffset: offsets[3],
+	}, nil
+}
+
+// Offset of the last byte read plus one, spares must be at least this large
+func (l *SpareLayout) Size() int {
+	size := 0
+	for _, offset := range []int{l.SeqNumberOffset, l.ObjectIDOffset, l.ChunkIDOffset, l.NumberBytesOffset} {
+		if offset+4 > size {
+			size = offset + 4
+		}
+	}
+	return size
+}
+
+func (l *SpareLayout) Read(spareBuf []byte, byteOrder binary.ByteOrder) (*Yaffs2SpareRaw, error) {
+	if len(spareBuf) < l.Size() {
+		return nil, fmt.Errorf("spare of %d bytes too small for spare offsets up to %d", len(spareBuf), l.Size())
+	}
+	return &Yaffs2SpareRaw{
+		SeqNumber:   byteOrder.Uint32(spareBuf[l.SeqNumberOffset:]),
+		ObjectID:    byteOrder.Uint32(spareBuf[l.ObjectIDOffset:]),
+		ChunkID:     byteOrder.Uint32(spareBuf[l.ChunkIDOffset:]),
+		NumberBytes: byteOrder.Uint32(spareBuf[l.NumberBytesOffset:]),
+	}, nil
+}
+
+// Byte positions of the tags within the spare, for layouts where ECC or
+// bad block marker bytes interrupt them. Gathering them in order yields the
+// contiguous tags.
+type SpareMap []int
+
+// Parse comma separated inclusive byte ranges or single bytes, e.g. "2-5,8-15,24-27"
+func ParseSpareMap(s string) (SpareMap, error) {
+	var m SpareMap
+	for _, field := range strings.Split(s, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(field), "-", 2)
+		if len(bounds) == 1 {
+			bounds = append(bounds, bounds[0])
+		}
+		var ends [2]int
+		for i, bound := range bounds {
+			n, err := strconv.ParseUint(strings.TrimSpace(bound), 0, 16)
+			if err != nil {
+				return nil, fmt.Errorf("invalid spare map range %q: %v", field, err)
+			}
+			ends[i] = int(n)
+		}
+		if ends[0] > ends[1] {
+			return nil, fmt.Errorf("invalid spare map range %q: start above end", field)
+		}
+		for b := ends[0]; b <= ends[1]; b++ {
+			m = append(m, b)
+		}
+	}
+	return m, nil
+}
+
+// Last byte gathered plus one, spares must be at least this large
+func (m SpareMap) Size() int {
+	size := 0
+	for _, b := range m {
+		if b+1 > size {
+			size = b + 1
+		}
+	}
+	return size
+}
+
+func (m SpareMap) Gather(spareBuf []byte) ([]byte, error) {
+	tags := make([]byte, len(m))
+	for i, b := range m {
+		if b >= len(spareBuf) {
+			return nil, fmt.Errorf("spare map byte %d beyond spare of %d bytes", b, len(spareBuf))
+		}
+		tags[i] = spareBuf[b]
+	}
+	return tags, nil
+}
@@ -0,0 +1,47 @@
+package yaffs
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// What the scan does with a page whose spare holds no valid tags
+type SparePolicy string
+
+const (
+	SPARE_POLICY_SKIP      SparePolicy = "skip"      // ignore the page
+	SPARE_POLICY_ABORT     SparePolicy = "abort"     // stop the scan with an error
+	SPARE_POLICY_HEADER    SparePolicy = "header"    // keep the page if it looks like an object header
+	SPARE_POLICY_ALTERNATE SparePolicy = "alternate" // try every other tag offset within the spare
+)
+
+func ParseSparePolicy(s string) (SparePolicy, error) {
+	switch policy := SparePolicy(s); policy {
+	case SPARE_POLICY_SKIP, SPARE_POLICY_ABORT, SPARE_POLICY_HEADER, SPARE_POLICY_ALTERNATE:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown invalid spare policy %q, expected skip, abort, header or alternate", s)
+}
+
+// Look for valid tags at every offset of the spare except the configured one,
+// for controllers moving the tags around ECC bytes on some pages
+func alternateSpare(spareBuf []byte, settings *Settings) (*Yaffs2Spare, int, error) {
+	if _, ok := settings.spareDecoder().(PackedTags2Decoder); !ok || settings.Yaffs1 {
+		// Offsets are fixed by the layout
+		return nil, 0, nil
+	}
+	tagsSize := binary.Size(Yaffs2SpareRaw{})
+	for skip := 0; skip+tagsSize <= len(spareBuf); skip++ {
+		if skip == settings.SpareSkip {
+			continue
+		}
+		spare, err := settings.parseSpare(spareBuf, skip)
+		if err != nil {
+			return nil, 0, err
+		}
+		if spare != nil {
+			return spare, skip, nil
+		}
+	}
+	return nil, 0, nil
+}
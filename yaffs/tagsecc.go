@@ -0,0 +1,110 @@
+package yaffs
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// Outcome of checking the ECC YAFFS stores over the tags themselves, also
+// used for page data checked against the controller ECC in the spare
+type TagsECCResult int
+
+const (
+	TAGS_ECC_NONE    TagsECCResult = iota // no tags ECC written or known
+	TAGS_ECC_OK                           // tags match their ECC
+	TAGS_ECC_FIXED                        // a single bit error was corrected
+	TAGS_ECC_UNFIXED                      // more bit errors than the ECC can correct
+)
+
+func (r TagsECCResult) String() string {
+	return []string{"none", "ok", "fixed", "unfixed"}[r]
+}
+
+// Tags failing their ECC cannot be trusted and count as invalid
+func trustedTags(spare *Yaffs2Spare, err error) (*Yaffs2Spare, error) {
+	if spare != nil && spare.TagsECC == TAGS_ECC_UNFIXED {
+		return nil, err
+	}
+	return spare, err
+}
+
+// yaffs_ecc_other as stored after yaffs_packed_tags2_tags_only:
+// col_parity, three bytes of padding, line_parity and line_parity_prime
+type tagsECC struct {
+	colParity       byte
+	lineParity      uint32
+	lineParityPrime uint32
+}
+
+func readTagsECC(buf []byte, byteOrder binary.ByteOrder) tagsECC {
+	return tagsECC{
+		colParity:       buf[0],
+		lineParity:      byteOrder.Uint32(buf[4:8]),
+		lineParityPrime: byteOrder.Uint32(buf[8:12]),
+	}
+}
+
+// yaffs_ecc_calc_other
+func calcTagsECC(data []byte) tagsECC {
+	var ecc tagsECC
+	var colParity byte
+	for i, b := range data {
+		parity := columnParityTable[b]
+		colParity ^= parity
+		if parity&0x01 != 0 {
+			ecc.lineParity ^= uint32(i)
+			ecc.lineParityPrime ^= ^uint32(i)
+		}
+	}
+	ecc.colParity = colParity >> 2 & 0x3F
+	return ecc
+}
+
+// Whether stored bytes can be an ECC over n bytes at all. Erased bytes,
+// or vendor data where YAFFS was told to leave the tags ECC out, cannot:
+// line parities only hold byte indices, and the prime parity is the line
+// parity or its complement.
+func (e tagsECC) plausible(n int) bool {
+	limit := uint32(bits.Len(uint(n - 1)))
+	return e.colParity <= 0x3F && e.lineParity>>limit == 0 &&
+		(e.lineParityPrime == e.lineParity || e.lineParityPrime == ^e.lineParity)
+}
+
+// yaffs_ecc_correct_other: verify data against the stored ECC and flip a
+// single wrong data bit in place
+func correctTagsECC(data []byte, stored tagsECC) TagsECCResult {
+	computed := calcTagsECC(data)
+	deltaCol := stored.colParity ^ computed.colParity
+	deltaLine := stored.lineParity ^ computed.lineParity
+	deltaLinePrime := stored.lineParityPrime ^ computed.lineParityPrime
+
+	if deltaCol == 0 && deltaLine == 0 && deltaLinePrime == 0 {
+		return TAGS_ECC_OK
+	}
+
+	// A data bit error flips every line parity pair and one column parity
+	// of each pair
+	if deltaLine == ^deltaLinePrime && (deltaCol^deltaCol>>1)&0x15 == 0x15 {
+		if deltaLine >= uint32(len(data)) {
+			return TAGS_ECC_UNFIXED
+		}
+		bit := 0
+		if deltaCol&0x20 != 0 {
+			bit |= 0x04
+		}
+		if deltaCol&0x08 != 0 {
+			bit |= 0x02
+		}
+		if deltaCol&0x02 != 0 {
+			bit |= 0x01
+		}
+		data[deltaLine] ^= 1 << uint(bit)
+		return TAGS_ECC_FIXED
+	}
+
+	// A single bit error in the ECC itself leaves the data intact
+	if bits.OnesCount32(deltaLine)+bits.OnesCount32(deltaLinePrime)+bits.OnesCount8(deltaCol) == 1 {
+		return TAGS_ECC_FIXED
+	}
+	return TAGS_ECC_UNFIXED
+}
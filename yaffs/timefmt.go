@@ -0,0 +1,84 @@
+package yaffs
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Output format for the Unix timestamps stored in object headers
+type TimeFormat struct {
+	Layout   string         // empty for epoch seconds
+	Location *time.Location // nil for UTC
+}
+
+// Format of the String methods
+var defaultTimeFormat = TimeFormat{Layout: time.RFC3339, Location: time.UTC}
+
+var timeLayouts = map[string]string{
+	"rfc3339": time.RFC3339,
+	"iso8601": "2006-01-02T15:04:05Z0700",
+	"epoch":   "",
+}
+
+func ParseTimeFormat(format, zone string) (TimeFormat, error) {
+	layout, ok := timeLayouts[strings.ToLower(format)]
+	if !ok {
+		return TimeFormat{}, fmt.Errorf("unknown time format %q", format)
+	}
+
+	var location *time.Location
+	switch strings.ToLower(zone) {
+	case "utc":
+		location = time.UTC
+	case "local":
+		location = time.Local
+	default:
+		return TimeFormat{}, fmt.Errorf("unknown time zone %q, expected utc or local", zone)
+	}
+
+	return TimeFormat{Layout: layout, Location: location}, nil
+}
+
+func (f TimeFormat) Format(timestamp uint32) string {
+	if f.Layout == "" {
+		return strconv.FormatUint(uint64(timestamp), 10)
+	}
+	return time.Unix(int64(timestamp), 0).In(f.location()).Format(f.Layout)
+}
+
+func (f TimeFormat) location() *time.Location {
+	if f.Location == nil {
+		return time.UTC
+	}
+	return f.Location
+}
+
+// Layouts accepted for points in time given on the command line, after
+// epoch seconds
+var timeArgumentLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// Parse a point in time given as epoch seconds, RFC 3339 or a date with
+// optional time, the latter in the zone of the time format
+func (f TimeFormat) ParseArgument(s string) (uint32, error) {
+	if seconds, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return uint32(seconds), nil
+	}
+	for _, layout := range timeArgumentLayouts {
+		t, err := time.ParseInLocation(layout, s, f.location())
+		if err == nil {
+			if t.Unix() < 0 || t.Unix() > math.MaxUint32 {
+				return 0, fmt.Errorf("time %q out of range of object headers", s)
+			}
+			return uint32(t.Unix()), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid time %q, expected epoch seconds, RFC 3339 or YYYY-MM-DD[ HH:MM:SS]", s)
+}
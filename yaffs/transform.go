@@ -0,0 +1,385 @@
+package yaffs
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Reorders a dump in which a controller interleaved fixed size units (e.g.
+// page+spare) from several planes or dies. The logical stream holds all
+// units of the first way, followed by all units of the next one.
+type deinterleaver struct {
+	r      io.ReaderAt
+	ways   int64
+	stride int64
+	size   int64 // logical size, trailing partial rounds are dropped
+}
+
+func newDeinterleaver(r io.ReaderAt, size int64, ways, stride int) (*deinterleaver, error) {
+	if ways < 1 || stride < 1 {
+		return nil, fmt.Errorf("invalid interleave of %d ways with stride %d", ways, stride)
+	}
+	round := int64(ways) * int64(stride)
+	return &deinterleaver{
+		r:      r,
+		ways:   int64(ways),
+		stride: int64(stride),
+		size:   size / round * round,
+	}, nil
+}
+
+func (d *deinterleaver) Size() int64 {
+	return d.size
+}
+
+func (d *deinterleaver) ReadAt(p []byte, off int64) (int, error) {
+	wayLen := d.size / d.ways
+	n := 0
+	for n < len(p) {
+		logical := off + int64(n)
+		if logical >= d.size {
+			return n, io.EOF
+		}
+		way := logical / wayLen
+		unit := logical % wayLen / d.stride
+		inUnit := logical % d.stride
+
+		physical := (unit*d.ways+way)*d.stride + inUnit
+		length := d.stride - inUnit
+		if remaining := int64(len(p) - n); length > remaining {
+			length = remaining
+		}
+
+		read, err := d.r.ReadAt(p[n:n+int(length)], physical)
+		n += read
+		if err != nil && !(err == io.EOF && read == int(length)) {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Parse a WAYS:STRIDE interleave specification, e.g. "2:2112"
+func parseInterleave(s string) (int, int, error) {
+	fields := strings.Split(s, ":")
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("invalid interleave %q, expected WAYS:STRIDE", s)
+	}
+	ways, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid interleave ways %q: %w", fields[0], err)
+	}
+	stride, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid interleave stride %q: %w", fields[1], err)
+	}
+	return ways, stride, nil
+}
+
+// Undoes the data randomization of one unit (usually page+spare) in place
+type Descrambler interface {
+	Descramble(buf []byte, unit int64)
+}
+
+// XORs each unit with the output of a Galois LFSR. The seed is picked from
+// the seed table by unit number modulo the table size, which covers both a
+// single fixed seed and controllers seeding by page number within a block.
+type lfsrDescrambler struct {
+	toggle uint32
+	seeds  []uint32
+	cache  map[uint32][]byte
+}
+
+// Polynomial masks include the x^n and 1 terms, e.g. 0xC001 for x^15+x^14+1
+func newLFSRDescrambler(polynomial uint32, seeds []uint32) (*lfsrDescrambler, error) {
+	if polynomial&1 == 0 || polynomial < 3 {
+		return nil, fmt.Errorf("invalid LFSR polynomial %#x", polynomial)
+	}
+	if len(seeds) == 0 {
+		return nil, fmt.Errorf("no LFSR seeds given")
+	}
+	for _, seed := range seeds {
+		if seed == 0 || seed >= polynomial {
+			return nil, fmt.Errorf("invalid LFSR seed %#x for polynomial %#x", seed, polynomial)
+		}
+	}
+	return &lfsrDescrambler{
+		toggle: polynomial >> 1,
+		seeds:  seeds,
+		cache:  make(map[uint32][]byte),
+	}, nil
+}
+
+func (l *lfsrDescrambler) keystream(seed uint32, length int) []byte {
+	if stream, ok := l.cache[seed]; ok && len(stream) >= length {
+		return stream
+	}
+
+	stream := make([]byte, length)
+	state := seed
+	for i := range stream {
+		var b byte
+		for bit := 0; bit < 8; bit++ {
+			lsb := state & 1
+			b |= byte(lsb) << uint(bit)
+			state >>= 1
+			if lsb != 0 {
+				state ^= l.toggle
+			}
+		}
+		stream[i] = b
+	}
+	l.cache[seed] = stream
+	return stream
+}
+
+func (l *lfsrDescrambler) Descramble(buf []byte, unit int64) {
+	stream := l.keystream(l.seeds[unit%int64(len(l.seeds))], len(buf))
+	for i := range buf {
+		buf[i] ^= stream[i]
+	}
+}
+
+// Applies a Descrambler to every unit of the underlying image
+type descrambledReader struct {
+	r          io.ReaderAt
+	unitSize   int64
+	descramble Descrambler
+}
+
+func (d *descrambledReader) ReadAt(p []byte, off int64) (int, error) {
+	unitBuf := make([]byte, d.unitSize)
+	n := 0
+	for n < len(p) {
+		logical := off + int64(n)
+		unit := logical / d.unitSize
+		inUnit := logical % d.unitSize
+
+		read, err := d.r.ReadAt(unitBuf, unit*d.unitSize)
+		if read < int(d.unitSize) {
+			// Partial trailing units are passed through unchanged
+			copied := 0
+			if int64(read) > inUnit {
+				copied = copy(p[n:], unitBuf[inUnit:read])
+			}
+			if err == nil {
+				err = io.EOF
+			}
+			return n + copied, err
+		}
+
+		d.descramble.Descramble(unitBuf, unit)
+		n += copy(p[n:], unitBuf[inUnit:])
+	}
+	return n, nil
+}
+
+// Parse a POLYNOMIAL:UNIT:SEED[,SEED...] LFSR specification,
+// e.g. "0xC001:2112:0x4a80"
+func parseDescrambler(s string) (Descrambler, int, error) {
+	fields := strings.Split(s, ":")
+	if len(fields) != 3 {
+		return nil, 0, fmt.Errorf("invalid descrambler %q, expected POLYNOMIAL:UNIT:SEED[,SEED...]", s)
+	}
+	polynomial, err := strconv.ParseUint(fields[0], 0, 32)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid LFSR polynomial %q: %w", fields[0], err)
+	}
+	unitSize, err := strconv.Atoi(fields[1])
+	if err != nil || unitSize < 1 {
+		return nil, 0, fmt.Errorf("invalid descrambler unit size %q", fields[1])
+	}
+	seeds, err := ParseIDList(fields[2])
+	if err != nil {
+		return nil, 0, err
+	}
+	descrambler, err := newLFSRDescrambler(uint32(polynomial), seeds)
+	if err != nil {
+		return nil, 0, err
+	}
+	return descrambler, unitSize, nil
+}
+
+// Swaps the bytes of every word, for dumps read through a bus of the
+// opposite byte order
+type byteswapReader struct {
+	r    io.ReaderAt
+	word int64
+}
+
+func (b *byteswapReader) ReadAt(p []byte, off int64) (int, error) {
+	// Read whole words around the requested range
+	start := off / b.word * b.word
+	end := (off + int64(len(p)) + b.word - 1) / b.word * b.word
+	buf := make([]byte, end-start)
+
+	read, err := b.r.ReadAt(buf, start)
+	full := int64(read) / b.word * b.word
+	for w := int64(0); w < full; w += b.word {
+		word := buf[w : w+b.word]
+		for i, j := 0, len(word)-1; i < j; i, j = i+1, j-1 {
+			word[i], word[j] = word[j], word[i]
+		}
+	}
+
+	available := full - (off - start)
+	if available < 0 {
+		available = 0
+	}
+	n := copy(p, buf[off-start:off-start+available])
+	if n < len(p) && err == nil {
+		err = io.EOF
+	}
+	if n == len(p) {
+		err = nil
+	}
+	return n, err
+}
+
+// Keeps the first keep bytes of every unit, dropping e.g. ECC bytes a
+// controller stores after each sector
+type stripReader struct {
+	r    io.ReaderAt
+	unit int64
+	keep int64
+}
+
+func (s *stripReader) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		logical := off + int64(n)
+		unit := logical / s.keep
+		inUnit := logical % s.keep
+
+		length := s.keep - inUnit
+		if remaining := int64(len(p) - n); length > remaining {
+			length = remaining
+		}
+
+		read, err := s.r.ReadAt(p[n:n+int(length)], unit*s.unit+inUnit)
+		n += read
+		if err != nil && !(err == io.EOF && read == int(length)) {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+type transformStage struct {
+	Name string
+	Args string
+}
+
+// Ordered preprocessing stages, each wrapping the reader of the previous
+// one. Implements flag.Value so -transform can be given repeatedly.
+type TransformPipeline []transformStage
+
+func (t *TransformPipeline) String() string {
+	var stages []string
+	for _, stage := range *t {
+		stages = append(stages, stage.Name+"="+stage.Args)
+	}
+	return strings.Join(stages, " ")
+}
+
+func (t *TransformPipeline) Set(s string) error {
+	fields := strings.SplitN(s, "=", 2)
+	if len(fields) != 2 {
+		return fmt.Errorf("invalid transform %q, expected NAME=ARGS", s)
+	}
+	*t = append(*t, transformStage{Name: fields[0], Args: fields[1]})
+	return nil
+}
+
+// Wrap the image reader with every stage in order. Nothing is read until
+// the returned reader is used. Each stage is logged to logger unless nil.
+// Closing the returned closer stops the plugins of plugin stages.
+func (t *TransformPipeline) Apply(r io.ReaderAt, size int64, logger *log.Logger) (io.ReaderAt, int64, io.Closer, error) {
+	var plugins multiCloser
+	if t == nil {
+		return r, size, plugins, nil
+	}
+	for _, stage := range *t {
+		var err error
+		r, size, err = stage.apply(r, size)
+		if err != nil {
+			plugins.Close()
+			return nil, 0, nil, fmt.Errorf("transform %s: %w", stage.Name, err)
+		}
+		if plugin, ok := r.(*pluginReader); ok {
+			plugins = append(plugins, plugin.plugin)
+		}
+		orDiscard(logger).Printf("Applied transform %s=%s, %d bytes remaining", stage.Name, stage.Args, size)
+	}
+	return r, size, plugins, nil
+}
+
+func (s transformStage) apply(r io.ReaderAt, size int64) (io.ReaderAt, int64, error) {
+	switch s.Name {
+	case "offset":
+		offset, err := strconv.ParseInt(s.Args, 0, 64)
+		if err != nil || offset < 0 || offset > size {
+			return nil, 0, fmt.Errorf("invalid offset %q", s.Args)
+		}
+		return io.NewSectionReader(r, offset, size-offset), size - offset, nil
+
+	case "length":
+		length, err := strconv.ParseInt(s.Args, 0, 64)
+		if err != nil || length < 0 {
+			return nil, 0, fmt.Errorf("invalid length %q", s.Args)
+		}
+		if length > size {
+			length = size
+		}
+		return io.NewSectionReader(r, 0, length), length, nil
+
+	case "byteswap":
+		word, err := strconv.Atoi(s.Args)
+		if err != nil || (word != 2 && word != 4 && word != 8) {
+			return nil, 0, fmt.Errorf("invalid word size %q, expected 2, 4 or 8", s.Args)
+		}
+		return &byteswapReader{r: r, word: int64(word)}, size, nil
+
+	case "deinterleave":
+		ways, stride, err := parseInterleave(s.Args)
+		if err != nil {
+			return nil, 0, err
+		}
+		d, err := newDeinterleaver(r, size, ways, stride)
+		if err != nil {
+			return nil, 0, err
+		}
+		return d, d.Size(), nil
+
+	case "descramble":
+		descrambler, unitSize, err := parseDescrambler(s.Args)
+		if err != nil {
+			return nil, 0, err
+		}
+		return &descrambledReader{r: r, unitSize: int64(unitSize), descramble: descrambler}, size, nil
+
+	case "strip":
+		unit, keep, err := parseInterleave(s.Args)
+		if err != nil || keep < 1 || keep > unit {
+			return nil, 0, fmt.Errorf("invalid strip %q, expected UNIT:KEEP", s.Args)
+		}
+		units := size / int64(unit)
+		stripped := units*int64(keep) + minInt64(size%int64(unit), int64(keep))
+		return &stripReader{r: r, unit: int64(unit), keep: int64(keep)}, stripped, nil
+
+	case "plugin":
+		return newPluginReader(r, size, s.Args)
+	}
+
+	return nil, 0, fmt.Errorf("unknown transform, expected offset, length, byteswap, deinterleave, descramble, strip or plugin")
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,168 @@
+package yaffs
+
+import (
+	"fmt"
+	"path"
+	"sort"
+)
+
+// Newest known state of an object. Objects whose header page is lost are
+// synthesized from the extra header information in their tags, directories
+// that are only known as a parent ID are synthesized as placeholders.
+type Object struct {
+	ID          uint32
+	SeqNumber   uint32
+	Header      *ObjectHeader
+	Synthesized bool
+}
+
+type Tree struct {
+	Objects map[uint32]*Object
+}
+
+var PseudoDirectoryNames = map[uint32]string{
+	YAFFS_OBJECTID_ROOT:       "",
+	YAFFS_OBJECTID_LOSTNFOUND: "lost+found",
+	YAFFS_OBJECTID_UNLINKED:   "unlinked",
+	YAFFS_OBJECTID_DELETED:    "deleted",
+}
+
+func newTree() *Tree {
+	return &Tree{Objects: make(map[uint32]*Object)}
+}
+
+// Record a header, replacing older or synthesized states of the object
+func (t *Tree) AddHeader(objectID, seqNumber uint32, header *ObjectHeader) {
+	existing, ok := t.Objects[objectID]
+	if ok && !existing.Synthesized && existing.SeqNumber > seqNumber {
+		return
+	}
+	t.Objects[objectID] = &Object{ID: objectID, SeqNumber: seqNumber, Header: header}
+}
+
+// Record an object from the extra header information in the tags of a
+// header chunk whose page could not be parsed
+func (t *Tree) AddTags(spare *Yaffs2Spare) {
+	if !spare.ExtraValid {
+		return
+	}
+	if _, ok := t.Objects[spare.ObjectID]; ok {
+		return
+	}
+	header := SynthesizeHeader(ObjectType(spare.ObjType), spare.ParentID, fmt.Sprintf("object-%d", spare.ObjectID))
+	t.Objects[spare.ObjectID] = &Object{ID: spare.ObjectID, SeqNumber: spare.SeqNumber, Header: header, Synthesized: true}
+}
+
+// Create placeholder directories under lost+found for every parent ID
+// without a known object, so their children keep their relative structure
+func (t *Tree) SynthesizeParents() []*Object {
+	var placeholders []*Object
+	for _, id := range t.SortedIDs() {
+		object := t.Objects[id]
+		parentID := object.Header.ParentObjectID
+		if _, ok := PseudoDirectoryNames[parentID]; ok {
+			continue
+		}
+		if _, ok := t.Objects[parentID]; ok {
+			continue
+		}
+		header := SynthesizeHeader(YAFFS_OBJECT_TYPE_DIRECTORY, YAFFS_OBJECTID_LOSTNFOUND, fmt.Sprintf("dir-%d", parentID))
+		placeholder := &Object{ID: parentID, Header: header, Synthesized: true}
+		t.Objects[parentID] = placeholder
+		placeholders = append(placeholders, placeholder)
+	}
+	return placeholders
+}
+
+func (t *Tree) SortedIDs() []uint32 {
+	var ids []uint32
+	for id := range t.Objects {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Path of a possibly outdated header version, using its own name and parent
+func (t *Tree) HeaderPath(header *ObjectHeader) string {
+	return path.Join(t.Path(header.ParentObjectID), CToGoString(header.Name[:]))
+}
+
+// Absolute path of an object, resolved through its parents
+func (t *Tree) Path(objectID uint32) string {
+	var elements []string
+	seen := make(map[uint32]bool)
+
+	for !seen[objectID] {
+		seen[objectID] = true
+
+		if name, ok := PseudoDirectoryNames[objectID]; ok {
+			elements = append(elements, name)
+			break
+		}
+
+		object, ok := t.Objects[objectID]
+		if !ok {
+			// Unknown parent, only happens before SynthesizeParents
+			elements = append(elements, fmt.Sprintf("dir-%d", objectID), PseudoDirectoryNames[YAFFS_OBJECTID_LOSTNFOUND])
+			break
+		}
+		elements = append(elements, CToGoString(object.Header.Name[:]))
+		objectID = object.Header.ParentObjectID
+	}
+
+	for i, j := 0, len(elements)-1; i < j; i, j = i+1, j-1 {
+		elements[i], elements[j] = elements[j], elements[i]
+	}
+	return path.Join(append([]string{"/"}, elements...)...)
+}
+
+// Live objects by parent ID, sorted by name
+func (t *Tree) Children() map[uint32][]uint32 {
+	children := make(map[uint32][]uint32)
+	for _, id := range t.SortedIDs() {
+		if t.Deleted(id) {
+			continue
+		}
+		parentID := t.Objects[id].Header.ParentObjectID
+		children[parentID] = append(children[parentID], id)
+	}
+	for _, ids := range children {
+		sort.SliceStable(ids, func(i, j int) bool {
+			return CToGoString(t.Objects[ids[i]].Header.Name[:]) < CToGoString(t.Objects[ids[j]].Header.Name[:])
+		})
+	}
+	return children
+}
+
+// Whether an object currently lives below the unlinked or deleted pseudo
+// directories
+func (t *Tree) Deleted(objectID uint32) bool {
+	seen := make(map[uint32]bool)
+	for !seen[objectID] {
+		seen[objectID] = true
+		if objectID == YAFFS_OBJECTID_UNLINKED || objectID == YAFFS_OBJECTID_DELETED {
+			return true
+		}
+		object, ok := t.Objects[objectID]
+		if !ok {
+			return false
+		}
+		objectID = object.Header.ParentObjectID
+	}
+	return false
+}
+
+func SynthesizeHeader(objectType ObjectType, parentID uint32, name string) *ObjectHeader {
+	header := &ObjectHeader{
+		ObjectType:     objectType,
+		ParentObjectID: parentID,
+		Checksum:       [2]byte{0xFF, 0xFF},
+		FileSizeHigh:   [4]byte{0xFF, 0xFF, 0xFF, 0xFF},
+	}
+	if objectType == YAFFS_OBJECT_TYPE_DIRECTORY {
+		header.Mode = 040755
+	}
+	copy(header.Name[:YAFFS_MAX_NAME_LENGTH], name)
+	return header
+}
@@ -0,0 +1,74 @@
+package yaffs
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Read a TSK yaffs2 config as written by TSKConfig or used by Autopsy.
+// Tags at contiguous offsets become a spare skip, anything else a layout.
+func ReadTSKConfig(path string) (*Settings, *SpareLayout, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	values := make(map[string]int)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, nil, fmt.Errorf("%s:%d: expected key = value", path, line)
+		}
+		key = strings.TrimSpace(key)
+		n, err := strconv.ParseUint(strings.TrimSpace(value), 0, 32)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s:%d: invalid value for %s: %v", path, line, key, err)
+		}
+		values[key] = int(n)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	for _, key := range []string{"flash_page_size", "flash_spare_size", "spare_seq_num_offset", "spare_obj_id_offset", "spare_chunk_id_offset"} {
+		if _, ok := values[key]; !ok {
+			return nil, nil, fmt.Errorf("%s: missing %s", path, key)
+		}
+	}
+
+	settings := &Settings{
+		PageSize:  values["flash_page_size"],
+		SpareSize: values["flash_spare_size"],
+		SpareSkip: values["spare_seq_num_offset"],
+	}
+
+	layout := &SpareLayout{
+		SeqNumberOffset: values["spare_seq_num_offset"],
+		ObjectIDOffset:  values["spare_obj_id_offset"],
+		ChunkIDOffset:   values["spare_chunk_id_offset"],
+	}
+	// TSK does not read the byte count, assume it follows the chunk ID
+	layout.NumberBytesOffset = layout.ChunkIDOffset + 4
+	if n, ok := values["spare_nbytes_offset"]; ok {
+		layout.NumberBytesOffset = n
+	}
+
+	if layout.Size() > settings.SpareSize {
+		return nil, nil, fmt.Errorf("%s: spare offsets exceed spare size %d", path, settings.SpareSize)
+	}
+	if layout.ObjectIDOffset == layout.SeqNumberOffset+4 &&
+		layout.ChunkIDOffset == layout.SeqNumberOffset+8 &&
+		layout.NumberBytesOffset == layout.SeqNumberOffset+12 {
+		return settings, nil, nil
+	}
+	return settings, layout, nil
+}
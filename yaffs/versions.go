@@ -0,0 +1,80 @@
+package yaffs
+
+import (
+	"bytes"
+	"io"
+	"sort"
+)
+
+// State of a file as of one of its on-flash headers: the header and the
+// newest data chunk per chunk ID written before it
+type FileVersion struct {
+	Number int // 1 for the oldest version
+	Chunk  *ScanChunk
+	Header *ObjectHeader
+	Chunks map[uint32]*ScanChunk
+}
+
+// Chunks of an object in the order YAFFS wrote them: by sequence number,
+// within a block by position. The image is sorted once for all objects.
+func (r *ScanResult) objectLog(objectID uint32) []*ScanChunk {
+	r.logOnce.Do(func() {
+		chunks := make([]*ScanChunk, len(r.Chunks))
+		for k := range r.Chunks {
+			chunks[k] = &r.Chunks[k]
+		}
+		sort.SliceStable(chunks, func(i, j int) bool {
+			return chunks[i].Spare.SeqNumber < chunks[j].Spare.SeqNumber
+		})
+		r.logs = make(map[uint32][]*ScanChunk)
+		for _, chunk := range chunks {
+			r.logs[chunk.Spare.ObjectID] = append(r.logs[chunk.Spare.ObjectID], chunk)
+		}
+	})
+	return r.logs[objectID]
+}
+
+// Replay the log of a file and record its state at every header
+func (r *ScanResult) FileVersions(objectID uint32) ([]*FileVersion, error) {
+	objectID, _, err := r.FileObject(objectID)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []*FileVersion
+	current := make(map[uint32]*ScanChunk)
+
+	for _, chunk := range r.objectLog(objectID) {
+		if chunk.Spare.ChunkID != 0 {
+			current[chunk.Spare.ChunkID] = chunk
+			continue
+		}
+
+		header, err := r.ChunkHeader(chunk)
+		if err != nil {
+			return nil, err
+		}
+		// Headers the scan rejected as corrupt are no version either
+		if header.ObjectType != YAFFS_OBJECT_TYPE_FILE || !bytes.Equal(header.Checksum[:], []byte{0xFF, 0xFF}) {
+			continue
+		}
+
+		chunks := make(map[uint32]*ScanChunk, len(current))
+		for id, c := range current {
+			chunks[id] = c
+		}
+		versions = append(versions, &FileVersion{Number: len(versions) + 1, Chunk: chunk, Header: header, Chunks: chunks})
+	}
+
+	return versions, nil
+}
+
+func (r *ScanResult) WriteVersion(w io.Writer, version *FileVersion) (int64, error) {
+	return r.WriteChunks(w, version.Header.FileSize(r.ByteOrder), version.Chunks)
+}
+
+func (r *ScanResult) VersionContent(version *FileVersion) ([]byte, error) {
+	var buf bytes.Buffer
+	_, err := r.WriteVersion(&buf, version)
+	return buf.Bytes(), err
+}
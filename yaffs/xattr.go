@@ -0,0 +1,66 @@
+package yaffs
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Extended attribute stored after the object header in its chunk
+type Xattr struct {
+	Name  string
+	Value []byte
+}
+
+// Newest header chunk of an object, chosen like the tree chooses headers
+func (r *ScanResult) HeaderChunk(objectID uint32) *ScanChunk {
+	var newest *ScanChunk
+	for k := range r.Chunks {
+		chunk := &r.Chunks[k]
+		if chunk.Spare.ObjectID != objectID || chunk.Spare.ChunkID != 0 {
+			continue
+		}
+		if newest == nil || chunk.Spare.SeqNumber >= newest.Spare.SeqNumber {
+			newest = chunk
+		}
+	}
+	return newest
+}
+
+// Extended attributes of an object, none for synthesized objects
+func (r *ScanResult) Xattrs(objectID uint32) []Xattr {
+	object, ok := r.Tree.Objects[objectID]
+	if !ok || object.Synthesized {
+		return nil
+	}
+	chunk := r.HeaderChunk(objectID)
+	if chunk == nil {
+		return nil
+	}
+	page, err := r.ChunkPage(chunk)
+	if err != nil {
+		return nil
+	}
+	return parseXattrs(page[binary.Size(ObjectHeader{}):], r.ByteOrder)
+}
+
+// YAFFS stores attributes as records of a 32 bit record size, including
+// the size itself, a NUL terminated name and the value. Erased space reads
+// as a negative size and ends the list.
+func parseXattrs(buf []byte, byteOrder binary.ByteOrder) []Xattr {
+	var xattrs []Xattr
+	pos := 0
+	for pos+4 <= len(buf) {
+		size := int(int32(byteOrder.Uint32(buf[pos:])))
+		if size <= 4 || pos+size >= len(buf) {
+			break
+		}
+		record := buf[pos+4 : pos+size]
+		end := bytes.IndexByte(record, 0)
+		if end <= 0 {
+			break
+		}
+		xattrs = append(xattrs, Xattr{Name: string(record[:end]), Value: record[end+1:]})
+		pos += size
+	}
+	return xattrs
+}
@@ -0,0 +1,144 @@
+package yaffs
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// YAFFS1 geometry, fixed by the small page NAND it was written for
+const (
+	YAFFS1_PAGE_SIZE       = 512
+	YAFFS1_SPARE_SIZE      = 16
+	YAFFS1_PAGES_PER_BLOCK = 32
+)
+
+// Positions of the eight yaffs_tags bytes tb0 to tb7 within yaffs_spare,
+// interleaved with the status bytes and the data ECC
+var yaffs1TagBytes = [8]int{0, 1, 2, 3, 6, 7, 11, 12}
+
+const (
+	YAFFS1_PAGE_STATUS_OFFSET  = 4
+	YAFFS1_BLOCK_STATUS_OFFSET = 5
+)
+
+// Settings of a YAFFS1 image
+func Yaffs1Settings() *Settings {
+	return &Settings{
+		PageSize:      YAFFS1_PAGE_SIZE,
+		SpareSize:     YAFFS1_SPARE_SIZE,
+		ByteOrder:     binary.LittleEndian,
+		PagesPerBlock: YAFFS1_PAGES_PER_BLOCK,
+		Yaffs1:        true,
+	}
+}
+
+// Decoder for yaffs_tags in a 16 byte yaffs_spare, as laid out by the
+// bitfields of little endian hosts:
+//
+//	chunk_id:20 serial_number:2 n_bytes_lsb:10
+//	obj_id:18 ecc:12 n_bytes_msb:2
+//
+// Single bit errors in the tags are corrected with their ECC, tags beyond
+// correction are returned flagged TAGS_ECC_UNFIXED. Tags on blocks marked
+// bad are invalid. Spare skip and byte order are ignored, the layout is
+// fixed.
+type Yaffs1TagsDecoder struct{}
+
+func (Yaffs1TagsDecoder) DecodeSpare(spareBuf []byte, spareSkip int, byteOrder binary.ByteOrder) (*Yaffs2Spare, error) {
+	if len(spareBuf) < YAFFS1_SPARE_SIZE || checkBlockEmpty(spareBuf) {
+		return nil, nil
+	}
+	if yaffs1BlockBad(spareBuf) {
+		return nil, nil
+	}
+
+	var tags [8]byte
+	for i, offset := range yaffs1TagBytes {
+		tags[i] = spareBuf[offset]
+	}
+	result := correctYaffs1Tags(&tags)
+
+	low, high := binary.LittleEndian.Uint32(tags[0:4]), binary.LittleEndian.Uint32(tags[4:8])
+	spare := &Yaffs2Spare{
+		ObjectID:    high & 0x3FFFF,
+		ChunkID:     low & 0xFFFFF,
+		NumberBytes: low>>22 | high>>30<<10,
+		Serial:      uint8(low>>20) & 3,
+		// Zeroed when the chunk is deleted, tolerating one flipped bit
+		Deleted: bits.OnesCount8(spareBuf[YAFFS1_PAGE_STATUS_OFFSET]) < 7,
+		TagsECC: result,
+	}
+	return spare, nil
+}
+
+// Bad block markers have more than one bit of the block status cleared
+func yaffs1BlockBad(spareBuf []byte) bool {
+	return len(spareBuf) > YAFFS1_BLOCK_STATUS_OFFSET && bits.OnesCount8(spareBuf[YAFFS1_BLOCK_STATUS_OFFSET]) < 7
+}
+
+// yaffs_calc_tags_ecc: the one based numbers of all set bits of the tags,
+// xored, with the 12 bit ECC field itself cleared
+func yaffs1TagsECC(tags [8]byte) uint32 {
+	high := binary.LittleEndian.Uint32(tags[4:8]) &^ (0xFFF << 18)
+	binary.LittleEndian.PutUint32(tags[4:8], high)
+
+	var ecc uint32
+	bit := uint32(0)
+	for _, b := range tags {
+		for mask := byte(1); mask != 0; mask <<= 1 {
+			bit++
+			if b&mask != 0 {
+				ecc ^= bit
+			}
+		}
+	}
+	return ecc
+}
+
+// yaffs_check_tags_ecc: flip the bit the ECC points to if it is off by one
+// bit
+func correctYaffs1Tags(tags *[8]byte) TagsECCResult {
+	stored := binary.LittleEndian.Uint32(tags[4:8]) >> 18 & 0xFFF
+	syndrome := stored ^ yaffs1TagsECC(*tags)
+	switch {
+	case syndrome == 0:
+		return TAGS_ECC_OK
+	case syndrome <= 64:
+		syndrome--
+		tags[syndrome/8] ^= 1 << (syndrome & 7)
+		return TAGS_ECC_FIXED
+	}
+	return TAGS_ECC_UNFIXED
+}
+
+// YAFFS1 has no sequence numbers. Of two live copies of a chunk, written
+// around an interrupted rewrite, the one whose serial number follows the
+// other's is newer.
+func serialNewer(a, b *Yaffs2Spare) bool {
+	return (b.Serial+1)&3 == a.Serial
+}
+
+// Without sequence numbers YAFFS1 marks replaced chunks deleted in their
+// page status. Live duplicates are decided by serial number, truncation
+// deletes chunks as well.
+func markObsoleteYaffs1(chunks []ScanChunk) {
+	current := make(map[chunkKey]*ScanChunk)
+	for k := range chunks {
+		chunk := &chunks[k]
+		if chunk.Spare.Deleted {
+			chunk.Obsolete = true
+			continue
+		}
+		key := chunkKey{ObjectID: chunk.Spare.ObjectID, ChunkID: chunk.Spare.ChunkID}
+		existing, ok := current[key]
+		switch {
+		case !ok:
+			current[key] = chunk
+		case serialNewer(chunk.Spare, existing.Spare):
+			existing.Obsolete = true
+			current[key] = chunk
+		default:
+			chunk.Obsolete = true
+		}
+	}
+}